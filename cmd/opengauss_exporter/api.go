@@ -0,0 +1,108 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"opengauss_exporter/pkg/exporter"
+	"strings"
+)
+
+// targetsAPIPath is the path of the runtime targets api
+const targetsAPIPath = "/api/v1/targets"
+
+// targetsAPI add and remove targets of the running exporter: POST adds the target of the body, DELETE removes the
+// target of the dsn of the body and GET lists the targets added. Requests are authenticated by a bearer token
+type targetsAPI struct {
+	token    []byte
+	exporter func() *exporter.Exporter // current exporter
+}
+
+// targetView is a target listed by the api, without password
+type targetView struct {
+	DSN   string `json:"dsn"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// readAPIToken returns the token of the file, white spaces trimmed
+func readAPIToken(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token := bytes.TrimSpace(content)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("empty api token file %s", path)
+	}
+	return token, nil
+}
+
+func (a *targetsAPI) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), a.token) == 1
+}
+
+func (a *targetsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet {
+		ex := a.exporter()
+		views := make([]targetView, 0)
+		for _, target := range ex.RuntimeTargets() {
+			views = append(views, targetView{DSN: exporter.ShadowDSN(target.DSN), Alias: target.Alias})
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		_ = json.NewEncoder(w).Encode(views)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// the body is a json target, decoded as yaml to share the keys of the config
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := &exporter.Target{}
+	if err := yaml.Unmarshal(body, target); err != nil || target.DSN == "" {
+		http.Error(w, "body must be a target with a dsn", http.StatusBadRequest)
+		return
+	}
+
+	// the exporter is not held while connecting, so scrapes and reloads go on
+	ex := a.exporter()
+	if r.Method == http.MethodPost {
+		err = ex.AddTarget(target)
+	} else {
+		err = ex.RemoveTarget(target.DSN)
+	}
+	var connectErr *exporter.ErrorConnectToServer
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, exporter.ErrTargetExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, exporter.ErrTargetNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &connectErr):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, exporter.RedactText(err.Error()), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+const (
+	// healthcheckCommand is the subcommand probing a running exporter
+	healthcheckCommand = "healthcheck"
+	// readyzPath answers 200 once the exporter serves metrics
+	readyzPath = "/readyz"
+)
+
+// healthcheckURL returns the readiness url of the exporter listening on listenAddress,
+// the unspecified and wildcard hosts are probed on the loopback
+func healthcheckURL(listenAddress string, https bool) (string, error) {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %s: %s", listenAddress, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + readyzPath, nil
+}
+
+// healthcheck request the readiness url of the local exporter, returns an error unless it answers 200 within timeout.
+// With tls the server certificate is not verified as it is issued for the external name, and it is presented as the
+// client certificate when the exporter requires one
+func healthcheck(args *Args) error {
+	url, err := healthcheckURL(*args.ListenAddress, *args.TLSCertFile != "")
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{}
+	if *args.TLSCertFile != "" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+		if *args.TLSClientCAFile != "" {
+			cert, err := tls.LoadX509KeyPair(*args.TLSCertFile, *args.TLSKeyFile)
+			if err != nil {
+				return fmt.Errorf("fail to load tls cert: %s", err)
+			}
+			transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	client := &http.Client{Timeout: *args.HealthcheckTimeout, Transport: transport}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+	return nil
+}
+
+// readyzHandler answers 200, it is served once the exporter is set up
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	_, _ = w.Write([]byte("ok"))
+}
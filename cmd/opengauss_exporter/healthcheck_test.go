@@ -0,0 +1,69 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_healthcheckURL(t *testing.T) {
+	tests := []struct {
+		listenAddress string
+		https         bool
+		want          string
+		wantErr       bool
+	}{
+		{listenAddress: ":9187", want: "http://localhost:9187/readyz"},
+		{listenAddress: "0.0.0.0:9187", https: true, want: "https://localhost:9187/readyz"},
+		{listenAddress: "[::]:9187", want: "http://localhost:9187/readyz"},
+		{listenAddress: "10.0.0.1:9187", want: "http://10.0.0.1:9187/readyz"},
+		{listenAddress: "[fe80::1]:9187", want: "http://[fe80::1]:9187/readyz"},
+		{listenAddress: "9187", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := healthcheckURL(tt.listenAddress, tt.https)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("healthcheckURL(%s) error = %v, wantErr %v", tt.listenAddress, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("healthcheckURL(%s) = %s, want %s", tt.listenAddress, got, tt.want)
+		}
+	}
+}
+
+func Test_healthcheck(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc(readyzPath, readyzHandler)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	var (
+		listenAddress = strings.TrimPrefix(srv.URL, "http://")
+		empty         string
+		timeout       = time.Second
+	)
+	args := &Args{ListenAddress: &listenAddress, TLSCertFile: &empty, TLSKeyFile: &empty, TLSClientCAFile: &empty,
+		HealthcheckTimeout: &timeout}
+	if err := healthcheck(args); err != nil {
+		t.Errorf("healthcheck() error = %v", err)
+	}
+
+	srv.Close()
+	if err := healthcheck(args); err == nil {
+		t.Errorf("healthcheck() of a stopped exporter succeeded")
+	}
+
+	unready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unready.Close()
+	listenAddress = strings.TrimPrefix(unready.URL, "http://")
+	if err := healthcheck(args); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("healthcheck() error = %v, want the status", err)
+	}
+}
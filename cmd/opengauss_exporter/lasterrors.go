@@ -0,0 +1,48 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"opengauss_exporter/pkg/exporter"
+	"time"
+)
+
+// errorsAPIPath is the path of the last errors of the targets and queries
+const errorsAPIPath = "/api/v1/errors"
+
+// errorsHandler serve the last errors as json, the messages are redacted
+func errorsHandler(lastErrors func() []exporter.LastError) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		errs := lastErrors()
+		if errs == nil {
+			errs = []exporter.LastError{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		_ = json.NewEncoder(w).Encode(errs)
+	}
+}
+
+// writeLastErrors write the last errors as a html table of the landing page
+func writeLastErrors(w io.Writer, errs []exporter.LastError) {
+	_, _ = fmt.Fprintf(w, "<h2>Last errors</h2>")
+	if len(errs) == 0 {
+		_, _ = fmt.Fprintf(w, "<p>No errors</p>")
+		return
+	}
+	_, _ = fmt.Fprintf(w, "<table border='1'><tr><th>Server</th><th>Query</th><th>Class</th><th>Status</th><th>Time</th><th>Message</th></tr>")
+	for _, e := range errs {
+		status := "recovered"
+		if e.Failing {
+			status = "failing"
+		}
+		_, _ = fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>",
+			html.EscapeString(e.Server), html.EscapeString(e.Query), e.Class, status, e.Time.Format(time.RFC3339),
+			html.EscapeString(e.Message))
+	}
+	_, _ = fmt.Fprintf(w, "</table><p><a href='%s'>JSON</a></p>", errorsAPIPath)
+}
@@ -0,0 +1,39 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"opengauss_exporter/pkg/exporter"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_errorsHandler(t *testing.T) {
+	errs := []exporter.LastError{{Server: "localhost:5432", Query: "pg_lock", Class: "permission",
+		Message: "pq: permission denied for relation <pg_locks>", Time: time.Now(), Failing: true}}
+	rec := httptest.NewRecorder()
+	errorsHandler(func() []exporter.LastError { return errs })(rec, httptest.NewRequest("GET", errorsAPIPath, nil))
+	var got []exporter.LastError
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Query != "pg_lock" || got[0].Class != "permission" {
+		t.Errorf("errorsHandler() got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	errorsHandler(func() []exporter.LastError { return nil })(rec, httptest.NewRequest("GET", errorsAPIPath, nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("errorsHandler() without errors got %s", body)
+	}
+
+	var buf bytes.Buffer
+	writeLastErrors(&buf, errs)
+	if !strings.Contains(buf.String(), "&lt;pg_locks&gt;") || !strings.Contains(buf.String(), "failing") {
+		t.Errorf("writeLastErrors() got %s", buf.String())
+	}
+}
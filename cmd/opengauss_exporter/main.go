@@ -4,28 +4,40 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v2"
+	"net"
 	"net/http"
 	"opengauss_exporter/pkg/exporter"
 	"opengauss_exporter/pkg/version"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
 var (
 	defaultPGURL = "postgresql:///?sslmode=disable"
 	ogExporter   *exporter.Exporter
+	notifier     *exporter.Notifier
 	ReloadLock   sync.Mutex
 	args         = &Args{}
+	// scrapes of each exporter in flight and the exporters replaced by a reload, closed once their scrapes return.
+	// Guarded by ReloadLock
+	inFlight = make(map[*exporter.Exporter]int)
+	retired  = make(map[*exporter.Exporter]bool)
 )
 
 // General generic options
@@ -34,6 +46,7 @@ type Args struct {
 	Version                *bool   `short:"v" long:"version" description:"Displays mtk version"`
 	DbURL                  *string `short:"d" long:"url" description:"openGauss database target url" env:"OG_EXPORTER_URL"`
 	ConfigPath             *string `short:"c" long:"config" description:"path to config dir or file" env:"OG_EXPORTER_CONFIG"`
+	HelpFile               *string `long:"metric-help-file" description:"path to help overrides of metrics" env:"OG_EXPORTER_METRIC_HELP_FILE"`
 	ConstLabels            *string `short:"l" long:"label" description:"constant lables:comma separated list of label=value pair" env:"OG_EXPORTER_LABEL"`
 	ServerTags             *string `short:"t" long:"tags" description:"tags,comma separated list of server tag" env:"OG_EXPORTER_TAG"`
 	DisableCache           *bool   `long:"disable-cache" description:"force not using cache" env:"OG_EXPORTER_DISABLE_CACHE"`
@@ -47,6 +60,62 @@ type Args struct {
 	ExplainOnly            *bool   `long:"explain" description:"explain server planned queries"`
 	DisableSettingsMetrics *bool
 	TimeToString           *bool
+	MaxRows                *int
+	MaxSeries              *int
+	Parallel               *int
+	ScrapeTimeout          *time.Duration
+	ServerScrapeTimeout    *time.Duration
+	CancelOnTimeout        *bool
+	DBDriver               *string
+	SingleConnection       *bool
+	AdaptiveTTL            *bool
+	MaxConnections         *int
+	StrictStartup          *bool
+	ForceServerVersion     *string
+	URLFile                *string
+	DecryptCommand         *string
+	CheckConfig            *bool
+	HealthcheckTimeout     *time.Duration
+	GenerateDashboard      *bool
+	GenerateRules          *bool
+	ListMetrics            *string
+	ExplainAnalyze         *bool
+	ExplainMaxRows         *int
+	TLSCertFile            *string
+	TLSKeyFile             *string
+	TLSClientCAFile        *string
+	WatchInterval          *time.Duration
+	LeaderElection         *bool
+	LeaderElectionKey      *int64
+	Sidecar                *bool
+	SidecarWaitTimeout     *time.Duration
+	CMCollector            *bool
+	CMCommand              *string
+	DiskUsageCollector     *bool
+	StatementHistory       *bool
+	StatementHistoryTop    *int
+	IndexHygiene           *bool
+	IndexHygieneInterval   *time.Duration
+	IndexUnusedWindow      *time.Duration
+	ApplicationName        *string
+	StatementTimeout       *time.Duration
+	IdleTxTimeout          *time.Duration
+	SessionSetup           *[]string
+	ConnectTimeout         *time.Duration
+	DialTimeout            *time.Duration
+	HandshakeTimeout       *time.Duration
+	ConnectBackoff         *time.Duration
+	ConnectBackoffMax      *time.Duration
+	WebhookURL             *string
+	WebhookTimeout         *time.Duration
+	ExpireAfterScrapes     *int
+	SelfMetricPath         *string
+	DisableRuntimeMetrics  *bool
+	MockFixtures           *string
+	Record                 *string
+	APITokenFile           *string
+	TargetFile             *string
+	defaultURL             bool // no target url given, the default url is used
 }
 
 // RetrieveTargetURL  priority: cli-args > env  > env file path
@@ -55,6 +124,13 @@ func (a *Args) RetrieveTargetURL() []string {
 	if a.DbURL != nil && *a.DbURL != "" {
 		log.Infof("retrieve target url %s from command line", exporter.ShadowDSN(*a.DbURL))
 		dsn = *a.DbURL
+	} else if a.URLFile != nil && *a.URLFile != "" {
+		res, err := a.readURLFile()
+		if err != nil {
+			log.Fatalf("fail retrieving target url from %s: %s", *a.URLFile, err)
+		}
+		log.Infof("retrieve target url %s from file %s", exporter.ShadowDSN(res), *a.URLFile)
+		dsn = res
 	} else {
 		if res := os.Getenv("PG_EXPORTER_URL"); res != "" {
 			log.Infof("retrieve target url %s from PG_EXPORTER_URL", exporter.ShadowDSN(res))
@@ -62,14 +138,50 @@ func (a *Args) RetrieveTargetURL() []string {
 		} else if res := os.Getenv("DATA_SOURCE_NAME"); res != "" {
 			log.Infof("retrieve target url %s from DATA_SOURCE_NAME", exporter.ShadowDSN(res))
 			dsn = res
+		} else if a.Sidecar != nil && *a.Sidecar {
+			dsn = sidecarDSN(os.Getenv, fileExists)
+			log.Infof("retrieve target url %s from sidecar environment", exporter.ShadowDSN(dsn))
 		} else {
 			log.Warnf("fail retrieving target url, fallback on default url: %s", defaultPGURL)
 			dsn = defaultPGURL
+			a.defaultURL = true
 		}
 		a.DbURL = &dsn
 
 	}
-	return strings.Split(dsn, ",")
+	return splitTargetURLs(dsn)
+}
+
+// targetStart matches the start of a target url, either a URL or the first keyword of a key=value dsn
+var targetStart = regexp.MustCompile(`^\s*(postgres(ql)?://|[A-Za-z_]+\s*=)`)
+
+// splitTargetURLs split comma separated target urls.
+// A comma not followed by the start of a url separates the hosts of a multi-host url, e.g. host=a,b or postgres://a,b/postgres
+func splitTargetURLs(s string) []string {
+	var urls []string
+	for _, segment := range strings.Split(s, ",") {
+		if len(urls) == 0 || targetStart.MatchString(segment) {
+			urls = append(urls, segment)
+			continue
+		}
+		urls[len(urls)-1] += "," + segment
+	}
+	return urls
+}
+
+// readURLFile read comma or newline separated target urls from file, which may be encrypted
+func (a *Args) readURLFile() (string, error) {
+	content, err := exporter.ReadSecretFile(*a.URLFile)
+	if err != nil {
+		return "", err
+	}
+	urls := strings.FieldsFunc(string(content), func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for i := range urls {
+		urls[i] = strings.TrimSpace(urls[i])
+	}
+	return strings.Join(urls, ","), nil
 }
 
 // RetrieveConfig  priority: cli-args > env  > env file path
@@ -102,14 +214,18 @@ func initArgs(args *Args) {
 		Default("").
 		Envar("OG_EXPORTER_CONFIG").
 		String()
+	args.HelpFile = kingpin.Flag("metric-help-file", "path to a yaml file overriding the help text and documentation url of metrics by name.").
+		Default("").
+		Envar("OG_EXPORTER_METRIC_HELP_FILE").
+		String()
 	args.ConstLabels = kingpin.Flag("constantLabels", "A list of label=value separated by comma(,).").
 		Default("").
 		Envar("OG_EXPORTER_CONSTANT_LABELS").
 		String()
-	// args.ServerTags = kingpin.Flag("tags", "tags,comma separated list of server tag").
-	// 	Default("").
-	// 	Envar("OG_EXPORTER_TAG").
-	// 	String()
+	args.ServerTags = kingpin.Flag("tags", "tags,comma separated list of server tag").
+		Default("").
+		Envar("OG_EXPORTER_TAG").
+		String()
 	args.DisableCache = kingpin.Flag("disable-cache", "force not using cache").
 		Default("false").
 		Envar("OG_EXPORTER_DISABLE_CACHE").
@@ -139,12 +255,42 @@ func initArgs(args *Args) {
 		Envar("OG_EXPORTER_WEB_TELEMETRY_PATH").
 		String()
 
+	args.SelfMetricPath = kingpin.Flag("web.self-telemetry-path", "Path under which to expose the exporter's own metrics instead of with the database metrics, e.g. /metrics/self.").
+		Default("").
+		Envar("OG_EXPORTER_WEB_SELF_TELEMETRY_PATH").
+		String()
+
+	args.DisableRuntimeMetrics = kingpin.Flag("disable-runtime-metrics", "Do not expose the Go runtime and process metrics of the exporter.").
+		Default("false").
+		Envar("OG_EXPORTER_DISABLE_RUNTIME_METRICS").
+		Bool()
+
+	args.TLSCertFile = kingpin.Flag("web.tls-cert-file", "Path to the TLS certificate, serve https if given.").
+		Default("").
+		Envar("OG_EXPORTER_WEB_TLS_CERT_FILE").
+		String()
+	args.TLSKeyFile = kingpin.Flag("web.tls-key-file", "Path to the TLS private key.").
+		Default("").
+		Envar("OG_EXPORTER_WEB_TLS_KEY_FILE").
+		String()
+	args.TLSClientCAFile = kingpin.Flag("web.tls-client-ca-file", "Path to the CA certificate, client certificates are required and verified against it if given.").
+		Default("").
+		Envar("OG_EXPORTER_WEB_TLS_CLIENT_CA_FILE").
+		String()
+
 	args.TimeToString = kingpin.Flag("time-to-string", "convert database timestamp to date string.").
 		Default("false").
 		Envar("OG_EXPORTER_WEB_TELEMETRY_PATH").
 		Bool()
 	args.DryRun = kingpin.Flag("dry-run", "dry run and print default configs and user config").
 		Bool()
+	args.GenerateDashboard = kingpin.Flag("generate-dashboard", "print a Grafana dashboard of the default and user queries and exit").
+		Bool()
+	args.GenerateRules = kingpin.Flag("generate-rules", "print the Prometheus rules of the alerts of the default and user queries and exit").
+		Bool()
+	args.ListMetrics = kingpin.Flag("list-metrics", "print the catalog of the metrics of the default and user queries in the format (markdown or json) and exit").
+		PlaceHolder("FORMAT").
+		Enum("markdown", "json")
 
 	args.DisableSettingsMetrics = kingpin.Flag("disable-settings-metrics",
 		"Do not include pg_settings metrics.").
@@ -154,15 +300,291 @@ func initArgs(args *Args) {
 
 	args.ExplainOnly = kingpin.Flag("explain", "explain server planned queries").
 		Bool()
+	args.ExplainAnalyze = kingpin.Flag("explain-analyze", "run EXPLAIN ANALYZE with --explain, the queries are executed").
+		Bool()
+	args.ExplainMaxRows = kingpin.Flag("explain-max-rows", "rows read by a query with --explain-analyze, 0 means no limit.").
+		Default("1000").
+		Int()
+
+	args.MaxRows = kingpin.Flag("max-rows", "max rows converted for a single query, 0 means no limit.").
+		Default("0").
+		Envar("OG_EXPORTER_MAX_ROWS").
+		Int()
+
+	args.MaxSeries = kingpin.Flag("max-series", "max distinct label combinations of a single query, the excess is dropped, 0 means no limit.").
+		Default("0").
+		Envar("OG_EXPORTER_MAX_SERIES").
+		Int()
+
+	args.Parallel = kingpin.Flag("parallel", "number of queries executed concurrently on a server.").
+		Default("2").
+		Envar("OG_EXPORTER_PARALLEL").
+		Int()
+
+	args.ScrapeTimeout = kingpin.Flag("scrape-timeout", "time budget of a scrape divided among pending queries, queries exceed the budget are skipped. 0 means no limit.").
+		Default("0s").
+		Envar("OG_EXPORTER_SCRAPE_TIMEOUT").
+		Duration()
+
+	args.ServerScrapeTimeout = kingpin.Flag("server-scrape-timeout", "deadline of the scrape of each server, a server still scraped shortly after is abandoned without its samples. 0 means the scrape-timeout only.").
+		Default("0s").
+		Envar("OG_EXPORTER_SERVER_SCRAPE_TIMEOUT").
+		Duration()
+
+	args.CancelOnTimeout = kingpin.Flag("cancel-on-timeout", "cancel the statements exceeding their timeout on the server with pg_cancel_backend, from a separate control connection.").
+		Default("true").
+		Envar("OG_EXPORTER_CANCEL_ON_TIMEOUT").
+		Bool()
+
+	args.DBDriver = kingpin.Flag("db-driver", "database driver of the connections to the servers, e.g. pgx when built with the pgx build tag.").
+		Default(exporter.DefaultDriver).
+		Envar("OG_EXPORTER_DB_DRIVER").
+		String()
+
+	args.SingleConnection = kingpin.Flag("single-connection", "hold at most one session per server, the queries are executed one at a time.").
+		Default("false").
+		Envar("OG_EXPORTER_SINGLE_CONNECTION").
+		Bool()
+
+	args.AdaptiveTTL = kingpin.Flag("adaptive-ttl", "set the ttl of the queries slightly below the interval between the scrapes of each client, bounded by their min_ttl and max_ttl.").
+		Default("false").
+		Envar("OG_EXPORTER_ADAPTIVE_TTL").
+		Bool()
+
+	args.MaxConnections = kingpin.Flag("max-connections", "max number of connections held to all servers and databases, the others wait for one to be closed. 0 means no limit.").
+		Default("0").
+		Envar("OG_EXPORTER_MAX_CONNECTIONS").
+		Int()
+
+	args.StrictStartup = kingpin.Flag("strict-startup", "connect to every server and prepare all enabled queries at start-up, fail if any of them is broken.").
+		Default("false").
+		Envar("OG_EXPORTER_STRICT_STARTUP").
+		Bool()
+
+	args.ForceServerVersion = kingpin.Flag("force-server-version", "openGauss version gating the queries instead of the version detected from the server, e.g. 3.0.0 for an unrecognized derivative.").
+		Default("").
+		Envar("OG_EXPORTER_FORCE_SERVER_VERSION").
+		String()
+
+	args.URLFile = kingpin.Flag("url-file", "file containing comma or newline separated target urls, may be encrypted, see --decrypt-command.").
+		Default("").
+		Envar("OG_EXPORTER_URL_FILE").
+		String()
+
+	args.TargetFile = kingpin.Flag("target-file", "file in the Prometheus file_sd format (JSON or YAML) listing target urls, reconciled every watch-interval.").
+		Default("").
+		Envar("OG_EXPORTER_TARGET_FILE").
+		String()
+
+	args.DecryptCommand = kingpin.Flag("decrypt-command", "command decrypting the encrypted url, config and target files (age or binary), reading the file on stdin and writing the plaintext to stdout, e.g. \"age -d -i key.txt\".").
+		Default("").
+		Envar("OG_EXPORTER_DECRYPT_COMMAND").
+		String()
+
+	args.WatchInterval = kingpin.Flag("watch-interval", "interval to check mounted secret files (url file, tls certs) for changes and apply them live, 0 to disable.").
+		Default("30s").
+		Envar("OG_EXPORTER_WATCH_INTERVAL").
+		Duration()
+
+	args.LeaderElection = kingpin.Flag("leader-election", "run as one of several replicas, only the replica holding the advisory lock on a server queries it.").
+		Default("false").
+		Envar("OG_EXPORTER_LEADER_ELECTION").
+		Bool()
+
+	args.LeaderElectionKey = kingpin.Flag("leader-election-key", "advisory lock key replicas compete for, replicas of the same deployment must share it.").
+		Default(strconv.FormatInt(exporter.DefaultLeaderElectionKey, 10)).
+		Envar("OG_EXPORTER_LEADER_ELECTION_KEY").
+		Int64()
+
+	args.Sidecar = kingpin.Flag("sidecar", "run next to the database: derive the url from GS_*/PG* env vars and socket paths, wait for the database before start.").
+		Default("false").
+		Envar("OG_EXPORTER_SIDECAR").
+		Bool()
+
+	args.SidecarWaitTimeout = kingpin.Flag("sidecar-wait-timeout", "max time to wait for the database to accept connections in sidecar mode.").
+		Default("5m").
+		Envar("OG_EXPORTER_SIDECAR_WAIT_TIMEOUT").
+		Duration()
+
+	args.CMCollector = kingpin.Flag("cm-collector", "expose the state of the cluster manager (cm_ctl query) of openGauss HA clusters, cm_ctl must be available on the exporter host.").
+		Default("false").
+		Envar("OG_EXPORTER_CM_COLLECTOR").
+		Bool()
+
+	args.CMCommand = kingpin.Flag("cm-command", "command run by the cm collector, its output is the text of cm_ctl query or a json document.").
+		Default(strings.Join(exporter.DefaultCMCommand, " ")).
+		Envar("OG_EXPORTER_CM_COMMAND").
+		String()
+
+	args.DiskUsageCollector = kingpin.Flag("disk-usage-collector", "expose the usage of the data directory, pg_xlog and the log directory, the filesystem usage needs the exporter to run on the database host.").
+		Default("false").
+		Envar("OG_EXPORTER_DISK_USAGE_COLLECTOR").
+		Bool()
+
+	args.StatementHistory = kingpin.Flag("statement-history-collector", "expose the statements of statement_history finished since the last scrape, aggregated by the fingerprint of their sql without literals.").
+		Default("false").
+		Envar("OG_EXPORTER_STATEMENT_HISTORY_COLLECTOR").
+		Bool()
+
+	args.StatementHistoryTop = kingpin.Flag("statement-history-top", "number of fingerprints with the most execution time exposed by the statement history collector on every scrape.").
+		Default(strconv.Itoa(exporter.DefaultStatementHistoryTop)).
+		Envar("OG_EXPORTER_STATEMENT_HISTORY_TOP").
+		Int()
+
+	args.IndexHygiene = kingpin.Flag("index-hygiene-collector", "expose the indexes of every database without scans over the unused window and the duplicate indexes.").
+		Default("false").
+		Envar("OG_EXPORTER_INDEX_HYGIENE_COLLECTOR").
+		Bool()
+
+	args.IndexHygieneInterval = kingpin.Flag("index-hygiene-interval", "interval between the queries of the indexes by the index hygiene collector, the last result is emitted in between.").
+		Default(exporter.DefaultIndexHygieneInterval.String()).
+		Envar("OG_EXPORTER_INDEX_HYGIENE_INTERVAL").
+		Duration()
+
+	args.IndexUnusedWindow = kingpin.Flag("index-unused-window", "time without scans seen by the exporter after which an index is unused.").
+		Default(exporter.DefaultIndexUnusedWindow.String()).
+		Envar("OG_EXPORTER_INDEX_UNUSED_WINDOW").
+		Duration()
+
+	args.ApplicationName = kingpin.Flag("application-name", "application_name of exporter connections, unless the url sets one.").
+		Default(exporter.DefaultApplicationName).
+		Envar("OG_EXPORTER_APPLICATION_NAME").
+		String()
+
+	args.StatementTimeout = kingpin.Flag("statement-timeout", "statement_timeout of exporter sessions, 0 to keep the server default.").
+		Default("0s").
+		Envar("OG_EXPORTER_STATEMENT_TIMEOUT").
+		Duration()
+
+	args.IdleTxTimeout = kingpin.Flag("idle-in-transaction-timeout", "idle_in_transaction_session_timeout of exporter sessions, 0 to keep the server default.").
+		Default("0s").
+		Envar("OG_EXPORTER_IDLE_IN_TRANSACTION_TIMEOUT").
+		Duration()
+
+	args.SessionSetup = kingpin.Flag("session-setup-sql", "statement executed on every new connection, e.g. SET ROLE monitor. Repeatable, executed in order.").
+		Envar("OG_EXPORTER_SESSION_SETUP_SQL").
+		Strings()
+
+	args.ConnectTimeout = kingpin.Flag("connect-timeout", "connect_timeout of urls lacking one, rounded up to seconds. 0 to wait indefinitely.").
+		Default("10s").
+		Envar("OG_EXPORTER_CONNECT_TIMEOUT").
+		Duration()
+
+	args.DialTimeout = kingpin.Flag("dial-timeout", "timeout of the TCP connect of new connections, 0 for no limit besides the connect timeout.").
+		Default("0s").
+		Envar("OG_EXPORTER_DIAL_TIMEOUT").
+		Duration()
+
+	args.HandshakeTimeout = kingpin.Flag("tls-handshake-timeout", "timeout of the TLS handshake and startup of new connections, 0 for no limit besides the connect timeout.").
+		Default("0s").
+		Envar("OG_EXPORTER_TLS_HANDSHAKE_TIMEOUT").
+		Duration()
+
+	args.ConnectBackoff = kingpin.Flag("connect-backoff", "delay before connecting again to a target that failed to connect, doubled on every failure. The target is reported down meanwhile, 0 to retry on every scrape.").
+		Default(exporter.DefaultConnectBackoff.String()).
+		Envar("OG_EXPORTER_CONNECT_BACKOFF").
+		Duration()
+
+	args.ConnectBackoffMax = kingpin.Flag("connect-backoff-max", "maximum delay before connecting again to a target that failed to connect.").
+		Default(exporter.DefaultMaxConnectBackoff.String()).
+		Envar("OG_EXPORTER_CONNECT_BACKOFF_MAX").
+		Duration()
+
+	args.WebhookURL = kingpin.Flag("webhook-url", "url the events of targets down and up, queries failing and recovered, and config reloads are posted to as JSON.").
+		Default("").
+		Envar("OG_EXPORTER_WEBHOOK_URL").
+		String()
+
+	args.WebhookTimeout = kingpin.Flag("webhook-timeout", "timeout of posting an event to the webhook.").
+		Default("5s").
+		Envar("OG_EXPORTER_WEBHOOK_TIMEOUT").
+		Duration()
+
+	args.ExpireAfterScrapes = kingpin.Flag("expire-after-scrapes", "drop cached samples, cached series and connections of disappeared databases and queries not seen within this many scrapes, 0 to keep them until restart.").
+		Default(strconv.Itoa(exporter.DefaultExpireAfterScrapes)).
+		Envar("OG_EXPORTER_EXPIRE_AFTER_SCRAPES").
+		Int()
+
+	args.MockFixtures = kingpin.Flag("mock-fixtures", "serve metrics from the recorded result sets of this dir instead of connecting to the database.").
+		Default("").
+		Envar("OG_EXPORTER_MOCK_FIXTURES").
+		String()
+	args.Record = kingpin.Flag("record", "write the result sets of the queries to fixture files in this dir during scrapes, replayable with --mock-fixtures.").
+		Default("").
+		Envar("OG_EXPORTER_RECORD").
+		String()
+
+	args.APITokenFile = kingpin.Flag("web.api-token-file", "file containing the bearer token of the targets api on /api/v1/targets, the api is disabled if not set.").
+		Default("").
+		Envar("OG_EXPORTER_WEB_API_TOKEN_FILE").
+		String()
+
+	args.CheckConfig = kingpin.Flag("check-config", "check the config, print its errors and lint warnings and exit").
+		Bool()
+
+	// serving is the default, healthcheck probes the exporter serving with the same web flags
+	kingpin.Command("serve", "serve the metrics (default)").Default()
+	args.HealthcheckTimeout = kingpin.Command(healthcheckCommand, "request "+readyzPath+" of the exporter listening on --web.listen-address, exit 0 if it is ready, 1 otherwise. For container health checks").
+		Flag("timeout", "timeout of the request.").
+		Default("3s").
+		Duration()
 
 	log.AddFlags(kingpin.CommandLine)
 }
 
-func newOgExporter(args *Args) (*exporter.Exporter, error) {
+// checkConfig print the errors and the lint warnings of the config, returns an error if the config is invalid
+func checkConfig(args *Args) error {
+	args.RetrieveConfig()
+	if *args.ConfigPath == "" {
+		return fmt.Errorf("no config given, set --config")
+	}
+	warnings, err := exporter.LintConfig(*args.ConfigPath)
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	var errs exporter.ConfigErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Printf("error: %s\n", e)
+		}
+		return fmt.Errorf("%d errors in config %s", len(errs), *args.ConfigPath)
+	}
+	return err
+}
+
+// explainQueries print the cost of the enabled queries on the targets, and their sequential scans of large relations
+func explainQueries(args *Args, ogExporter *exporter.Exporter) error {
+	defer ogExporter.Close()
+	results, err := ogExporter.Explain(context.Background(), exporter.ExplainOptions{
+		Analyze: *args.ExplainAnalyze,
+		MaxRows: *args.ExplainMaxRows,
+	})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tQUERY\tCOST\tROWS\tTIME(ms)\tSEQ SCANS")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\terror: %s\n", r.Server, r.Query, r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.0f\t%.3f\t%s\n", r.Server, r.Query, r.Cost, r.Rows, r.Time, strings.Join(r.SeqScans, ", "))
+	}
+	return w.Flush()
+}
+
+func newOgExporter(args *Args, opts ...exporter.Opt) (*exporter.Exporter, error) {
 	dsn := args.RetrieveTargetURL()
-	ex, err := exporter.NewExporter(
-		exporter.WithDNS(dsn),
+	// the default url is not scraped when the config defines targets
+	dsnOpt := exporter.WithDNS(dsn)
+	if args.defaultURL {
+		dsnOpt = exporter.WithFallbackDNS(dsn)
+	}
+	ex, err := exporter.NewExporter(append([]exporter.Opt{
+		dsnOpt,
 		exporter.WithConfig(*args.ConfigPath),
+		exporter.WithHelpFile(*args.HelpFile),
 		exporter.WithConstLabels(*args.ConstLabels),
 		exporter.WithCacheDisabled(*args.DisableCache),
 		// exporter.WithFailFast(*args.FailFast),
@@ -171,46 +593,295 @@ func newOgExporter(args *Args) (*exporter.Exporter, error) {
 		exporter.WithExcludeDatabases(*args.ExcludeDatabase),
 		exporter.WithDisableSettingsMetrics(*args.DisableSettingsMetrics),
 		exporter.WithTimeToString(*args.TimeToString),
-		// exporter.WithTags(*args.ServerTags),
-	)
+		exporter.WithMaxRows(*args.MaxRows),
+		exporter.WithMaxSeries(*args.MaxSeries),
+		exporter.WithParallel(*args.Parallel),
+		exporter.WithScrapeTimeout(*args.ScrapeTimeout),
+		exporter.WithServerScrapeTimeout(*args.ServerScrapeTimeout),
+		exporter.WithCancelOnTimeout(*args.CancelOnTimeout),
+		exporter.WithDriver(*args.DBDriver),
+		exporter.WithSingleConnection(*args.SingleConnection),
+		exporter.WithAdaptiveTTL(*args.AdaptiveTTL),
+		exporter.WithMaxConnections(*args.MaxConnections),
+		exporter.WithStrictStartup(*args.StrictStartup),
+		exporter.WithForceServerVersion(*args.ForceServerVersion),
+		exporter.WithLeaderElection(leaderElectionKey(args)),
+		exporter.WithCollectors(extraCollectors(args)...),
+		exporter.WithSessionParams(exporter.SessionParams(*args.ApplicationName, *args.StatementTimeout, *args.IdleTxTimeout)),
+		exporter.WithSessionSetup(*args.SessionSetup),
+		exporter.WithConnectTimeouts(*args.ConnectTimeout, *args.DialTimeout, *args.HandshakeTimeout),
+		exporter.WithConnectBackoff(*args.ConnectBackoff, *args.ConnectBackoffMax),
+		exporter.WithExpireAfterScrapes(*args.ExpireAfterScrapes),
+		exporter.WithSeparateSelfMetrics(*args.SelfMetricPath != ""),
+		exporter.WithMockFixtures(*args.MockFixtures),
+		exporter.WithRecord(*args.Record),
+		exporter.WithTargetFile(*args.TargetFile),
+		exporter.WithNotifier(notifier),
+		exporter.WithTags(*args.ServerTags),
+	}, opts...)...)
 	return ex, err
 
 }
 
+// leaderElectionKey returns the advisory lock key, 0 if leader election disabled
+func leaderElectionKey(args *Args) int64 {
+	if !*args.LeaderElection {
+		return 0
+	}
+	return *args.LeaderElectionKey
+}
+
+// extraCollectors returns the optional collectors enabled by flags
+func extraCollectors(args *Args) []exporter.Collector {
+	var collectors []exporter.Collector
+	if *args.CMCollector {
+		collectors = append(collectors, exporter.NewCMCollector(strings.Fields(*args.CMCommand)))
+	}
+	if *args.DiskUsageCollector {
+		collectors = append(collectors, exporter.NewDiskUsageCollector())
+	}
+	if *args.StatementHistory {
+		collectors = append(collectors, exporter.NewStatementHistoryCollector(*args.StatementHistoryTop))
+	}
+	if *args.IndexHygiene {
+		collectors = append(collectors, exporter.NewIndexHygieneCollector(*args.IndexHygieneInterval, *args.IndexUnusedWindow))
+	}
+	return collectors
+}
+
+// reloadedCollector collect the current exporter, replaced on every reload. It is unchecked as the metrics change
+// with the config
+type reloadedCollector struct {
+	self bool            // collect the SelfCollector of the exporter
+	ctx  context.Context // context of the scrape request, the queries are canceled when it is done
+}
+
+func (c reloadedCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c reloadedCollector) Collect(ch chan<- prometheus.Metric) {
+	e := acquireExporter()
+	defer releaseExporter(e)
+	switch {
+	case c.self:
+		e.SelfCollector().Collect(ch)
+	case c.ctx != nil:
+		e.CollectContext(c.ctx, ch)
+	default:
+		e.Collect(ch)
+	}
+}
+
+// criticalPath and fullPath are the sub-paths of the metrics path serving the critical tier of the queries and all of them
+const (
+	criticalPath = "critical"
+	fullPath     = "full"
+)
+
+// scrapeHandler serve the metrics of gatherer and of the current exporter, the queries of the exporter are
+// canceled when the client disconnects. Only the queries selected by sel are run, all of them if nil
+func scrapeHandler(gatherer prometheus.Gatherer, sel exporter.QuerySelector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			// the scrape jobs of a Prometheus server scrape different paths
+			ctx = exporter.WithScrapeClient(ctx, host+r.URL.Path)
+		}
+		if sel != nil {
+			ctx = exporter.WithQuerySelector(ctx, sel)
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(reloadedCollector{ctx: ctx})
+		promhttp.HandlerFor(prometheus.Gatherers{gatherer, registry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// groupHandler serve the metrics of the queries of the group named by the last segment of the path, the groups are
+// those of the current exporter
+func groupHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := strings.TrimPrefix(r.URL.Path, prefix)
+		ReloadLock.Lock()
+		groups := ogExporter.QueryGroups()
+		ReloadLock.Unlock()
+		if !exporter.Contains(groups, group) {
+			http.NotFound(w, r)
+			return
+		}
+		scrapeHandler(prometheus.Gatherers{}, exporter.GroupQueries(group)).ServeHTTP(w, r)
+	})
+}
+
+// registerMetricHandlers register the exporter into the default registry and serve it on the metrics path.
+// With a self metrics path, the exporter's own metrics and the runtime metrics are served there from a separate registry
+func registerMetricHandlers(router *http.ServeMux, args *Args) {
+	// replace the default runtime collectors by the ones working on every platform
+	prometheus.Unregister(prometheus.NewGoCollector())
+	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	runtimeCollectors := []prometheus.Collector{prometheus.NewGoCollector(), exporter.NewProcessCollector()}
+	// the critical tier serves the metrics of its queries only, to be scraped often
+	router.Handle(path.Join(*args.MetricPath, criticalPath), scrapeHandler(prometheus.Gatherers{}, exporter.CriticalQueries))
+	// the other sub-paths serve the groups of the queries, unless the metrics are served on the root
+	if groupPrefix := strings.TrimSuffix(*args.MetricPath, "/") + "/"; groupPrefix != "/" && groupPrefix != *args.MetricPath {
+		router.Handle(groupPrefix, groupHandler(groupPrefix))
+	}
+	if *args.SelfMetricPath == "" {
+		if !*args.DisableRuntimeMetrics {
+			prometheus.MustRegister(runtimeCollectors...)
+		}
+		full := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, scrapeHandler(prometheus.DefaultGatherer, nil))
+		router.Handle(*args.MetricPath, full)
+		router.Handle(path.Join(*args.MetricPath, fullPath), full)
+		return
+	}
+	self := prometheus.NewRegistry()
+	self.MustRegister(reloadedCollector{self: true})
+	if !*args.DisableRuntimeMetrics {
+		self.MustRegister(runtimeCollectors...)
+	}
+	full := promhttp.InstrumentMetricHandler(self, scrapeHandler(prometheus.DefaultGatherer, nil))
+	router.Handle(*args.MetricPath, full)
+	router.Handle(path.Join(*args.MetricPath, fullPath), full)
+	router.Handle(*args.SelfMetricPath, promhttp.HandlerFor(self, promhttp.HandlerOpts{}))
+}
+
+// currentExporter returns the exporter, read under ReloadLock as Reload replaces it
+func currentExporter() *exporter.Exporter {
+	ReloadLock.Lock()
+	defer ReloadLock.Unlock()
+	return ogExporter
+}
+
+// acquireExporter returns the current exporter, not closed by a reload until released by releaseExporter
+func acquireExporter() *exporter.Exporter {
+	ReloadLock.Lock()
+	defer ReloadLock.Unlock()
+	inFlight[ogExporter]++
+	return ogExporter
+}
+
+// releaseExporter release an exporter of acquireExporter, it is closed if it was replaced and this is its last scrape
+func releaseExporter(e *exporter.Exporter) {
+	ReloadLock.Lock()
+	defer ReloadLock.Unlock()
+	if inFlight[e]--; inFlight[e] > 0 {
+		return
+	}
+	delete(inFlight, e)
+	if retired[e] {
+		delete(retired, e)
+		e.Close()
+	}
+}
+
+// retireExporter close an exporter replaced by a reload, once its scrapes in flight return. The caller holds ReloadLock
+func retireExporter(e *exporter.Exporter) {
+	if inFlight[e] > 0 {
+		retired[e] = true
+		return
+	}
+	e.Close()
+}
+
 func Reload() error {
 	ReloadLock.Lock()
 	defer ReloadLock.Unlock()
 	log.Debugf("reload request received, launch new exporter instance")
 
-	// create a new exporter
-	newExporter, err := newOgExporter(args)
+	// create a new exporter, keeping the targets added at runtime
+	var opts []exporter.Opt
+	if ogExporter != nil {
+		opts = append(opts, exporter.WithTargets(ogExporter.RuntimeTargets()))
+	}
+	newExporter, err := newOgExporter(args, opts...)
 	// if launch new exporter failed, do nothing
 	if err != nil {
 		log.Errorf("fail to reload exporter: %s", err.Error())
+		notifier.Notify(exporter.Event{Type: exporter.EventReloadFailure, Message: err.Error()})
 		return err
 	}
 
 	log.Debugf("shutdown old exporter instance")
-	// if older one exists, close and unregister it
-	// if ogExporter != nil {
-	// 	// DO NOT MANUALLY CLOSE OLD EXPORTER INSTANCE because the stupid implementation of sql.DB
-	// 	// there connection will be automatically released after 1 min
-	// 	prometheus.Unregister(ogExporter)
-	//
-	// }
-	// prometheus.MustRegister(newExporter)
+	// the old exporter releases its leader locks for the new one to take them over, and is closed once its scrapes
+	// return, so its sessions do not outlive rotated credentials
+	oldExporter := ogExporter
 	ogExporter = newExporter
+	if oldExporter != nil {
+		oldExporter.ReleaseLeadership()
+		retireExporter(oldExporter)
+	}
 	log.Infof("server reloaded")
+	notifier.Notify(exporter.Event{Type: exporter.EventReloadSuccess})
 	return nil
 }
 
+// watchFiles reload exporter when secret files changed, reconcile the targets when the target file changed,
+// reload tls certificates when cert files changed
+func watchFiles(args *Args, tlsReloader *tlsReloader, stop <-chan struct{}) {
+	if *args.WatchInterval <= 0 {
+		return
+	}
+	if *args.URLFile != "" {
+		go newFileWatcher([]string{*args.URLFile}, func() {
+			log.Infof("secret files changed, reloading")
+			if err := Reload(); err != nil {
+				log.Errorf("fail to reload on secret files change, the old secrets are still used: %s", err)
+			}
+		}).run(*args.WatchInterval, stop)
+	}
+	if *args.TargetFile != "" {
+		go newFileWatcher([]string{*args.TargetFile}, func() {
+			ReloadLock.Lock()
+			defer ReloadLock.Unlock()
+			if err := ogExporter.ReloadTargetFile(); err != nil {
+				log.Errorf("fail to reload target file: %s", err)
+			}
+		}).run(*args.WatchInterval, stop)
+	}
+	if tlsReloader != nil {
+		go newFileWatcher(tlsReloader.files(), func() {
+			if err := tlsReloader.reload(); err != nil {
+				log.Errorf("fail to reload tls certificates: %s", err)
+				return
+			}
+			log.Infof("tls certificates reloaded")
+		}).run(*args.WatchInterval, stop)
+	}
+}
+
 func runApp(args *Args) {
 	// 命令行参数
 	initArgs(args)
+	// hide passwords in every log line
+	log.AddHook(exporter.NewRedactHook())
 
-	kingpin.Parse()
+	command := kingpin.Parse()
+	exporter.SetDecryptCommand(strings.Fields(*args.DecryptCommand))
+
+	if command == healthcheckCommand {
+		if err := healthcheck(args); err != nil {
+			fmt.Fprintf(os.Stderr, "unhealthy: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *args.CheckConfig {
+		if err := checkConfig(args); err != nil {
+			log.Fatalf("check config: %s", err)
+		}
+		return
+	}
+
+	if *args.WebhookURL != "" {
+		notifier = exporter.NewNotifier(*args.WebhookURL, *args.WebhookTimeout)
+		defer notifier.Close()
+	}
 
 	var err error
+	if *args.Sidecar {
+		if err = waitForDatabase(args.RetrieveTargetURL(), *args.SidecarWaitTimeout); err != nil {
+			log.Fatalf("sidecar: %s", err)
+		}
+	}
 	ogExporter, err = newOgExporter(args)
 	if err != nil {
 		log.Errorf("fail to reload exporter: %s", err.Error())
@@ -233,16 +904,53 @@ func runApp(args *Args) {
 		fmt.Println(string(buf))
 		return
 	}
-	prometheus.MustRegister(ogExporter)
+	if *args.GenerateDashboard {
+		buf, err := exporter.GenerateDashboard(ogExporter.GetMetricsList(), "openGauss Exporter")
+		if err != nil {
+			log.Fatalf("fail to generate dashboard: %s", err)
+		}
+		fmt.Println(string(buf))
+		return
+	}
+	if *args.GenerateRules {
+		buf, err := exporter.GenerateRules(ogExporter.GetMetricsList())
+		if err != nil {
+			log.Fatalf("fail to generate rules: %s", err)
+		}
+		fmt.Print(string(buf))
+		return
+	}
+	if *args.ExplainOnly {
+		if err := explainQueries(args, ogExporter); err != nil {
+			log.Fatalf("fail to explain queries: %s", err)
+		}
+		return
+	}
+	if *args.ListMetrics != "" {
+		buf, err := exporter.FormatMetricsCatalog(exporter.MetricsCatalog(ogExporter.GetMetricsList()), *args.ListMetrics)
+		if err != nil {
+			log.Fatalf("fail to list metrics: %s", err)
+		}
+		fmt.Println(strings.TrimSuffix(string(buf), "\n"))
+		return
+	}
 	defer ogExporter.Close()
 
 	router := http.NewServeMux()
-	router.Handle(*args.MetricPath, promhttp.Handler())
+	registerMetricHandlers(router, args)
 	// basic information
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-		_, _ = w.Write([]byte(`<html><head><title>PG Exporter</title></head><body><h1>PG Exporter</h1><p><a href='` + *args.MetricPath + `'>Metrics</a></p></body></html>`))
+		_, _ = w.Write([]byte(`<html><head><title>PG Exporter</title></head><body><h1>PG Exporter</h1><p><a href='` + *args.MetricPath + `'>Metrics</a></p>`))
+		writeLastErrors(w, currentExporter().LastErrors())
+		_, _ = w.Write([]byte(`</body></html>`))
 	})
+	// last errors of the targets and queries
+	router.HandleFunc(errorsAPIPath, errorsHandler(func() []exporter.LastError {
+		return currentExporter().LastErrors()
+	}))
+	// readiness, see the healthcheck command
+	router.HandleFunc(readyzPath, readyzHandler)
 	// version report
 	router.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
@@ -250,6 +958,30 @@ func runApp(args *Args) {
 		_, _ = w.Write([]byte(payload))
 	})
 
+	// query cost profile
+	router.HandleFunc("/debug/queries", func(w http.ResponseWriter, r *http.Request) {
+		profiles := currentExporter().QueryProfiles()
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			_ = json.NewEncoder(w).Encode(profiles)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		_ = exporter.WriteQueryProfiles(w, profiles)
+	})
+
+	// targets api, served with a token only
+	if *args.APITokenFile != "" {
+		token, err := readAPIToken(*args.APITokenFile)
+		if err != nil {
+			log.Fatalf("fail to read api token: %s", err)
+		}
+		router.Handle(targetsAPIPath, &targetsAPI{
+			token:    token,
+			exporter: currentExporter,
+		})
+	}
+
 	// reload interface
 	router.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
@@ -261,19 +993,38 @@ func runApp(args *Args) {
 		}
 	})
 
-	log.Infof("og_exporter start, listen on http://%s%s", *args.ListenAddress, *args.MetricPath)
+	scheme := "http"
+	if *args.TLSCertFile != "" {
+		scheme = "https"
+	}
+	log.Infof("og_exporter start, listen on %s://%s%s", scheme, *args.ListenAddress, *args.MetricPath)
 
+	tlsReloader, err := newTLSReloader(*args.TLSCertFile, *args.TLSKeyFile, *args.TLSClientCAFile)
+	if err != nil {
+		log.Fatalf("fail to setup tls: %s", err)
+	}
 	srv := &http.Server{
 		Addr:        *args.ListenAddress,
 		Handler:     router,
 		ReadTimeout: 5 * time.Second,
+		ErrorLog:    log.NewErrorLogger(),
 	}
+	if tlsReloader != nil {
+		srv.TLSConfig = tlsReloader.tlsConfig()
+	}
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	watchFiles(args, tlsReloader, stopWatch)
 	go func() {
 		// service connections
-		// if err := srv.ListenAndServeTLS("server.crt", "server.key"); err != nil && err != http.ErrServerClosed {
-		// 	logrus.Fatalf("listen: %s\n", err)
-		// }
-		if err = srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsReloader != nil {
+			// certificates are loaded into TLSConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
@@ -287,7 +1038,9 @@ func runApp(args *Args) {
 			switch sig {
 			case syscall.SIGHUP:
 				log.Infof("signal %s received, reloading", sig)
-				_ = Reload()
+				if err := Reload(); err != nil {
+					log.Errorf("fail to reload on signal %s: %s", sig, err)
+				}
 			default:
 				log.Infof("signal %s received, forcefully terminating", sig)
 				closeChan <- struct{}{}
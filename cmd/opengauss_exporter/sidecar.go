@@ -0,0 +1,101 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/common/log"
+	"opengauss_exporter/pkg/exporter"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sidecarSocketDirs are the well-known unix socket directories of openGauss
+var sidecarSocketDirs = []string{"/var/run/opengauss", "/var/run/postgresql", "/tmp"}
+
+// sidecarDSN derive the dsn of the database running next to the exporter from well-known env vars and socket paths
+func sidecarDSN(getenv func(string) string, exists func(string) bool) string {
+	lookup := func(keys ...string) string {
+		for _, key := range keys {
+			if v := getenv(key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	port := lookup("GS_PORT", "PGPORT")
+	if port == "" {
+		port = "5432"
+	}
+	host := lookup("GS_HOST", "PGHOST")
+	if host == "" {
+		host = "127.0.0.1"
+		for _, dir := range sidecarSocketDirs {
+			if exists(filepath.Join(dir, ".s.PGSQL."+port)) {
+				host = dir
+				break
+			}
+		}
+	}
+	settings := [][2]string{
+		{"host", host},
+		{"port", port},
+		{"user", lookup("GS_USERNAME", "GS_USER", "PGUSER")},
+		{"password", lookup("GS_PASSWORD", "PGPASSWORD")},
+		{"dbname", lookup("GS_DB", "PGDATABASE")},
+	}
+	var pairs []string
+	for _, kv := range settings {
+		if kv[1] == "" {
+			continue
+		}
+		value := kv[1]
+		if strings.ContainsAny(value, ` '\`) {
+			value = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+		}
+		pairs = append(pairs, kv[0]+"="+value)
+	}
+	if getenv("PGSSLMODE") == "" {
+		pairs = append(pairs, "sslmode=disable")
+	}
+	return strings.Join(pairs, " ")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// waitForDatabase wait until every dsn accepts connections or timeout
+func waitForDatabase(dsnList []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, dsn := range dsnList {
+		connDSN, err := exporter.ConnectionDSN(dsn)
+		if err != nil {
+			return err
+		}
+		db, err := sql.Open("postgres", connDSN)
+		if err != nil {
+			return err
+		}
+		for {
+			if err = db.PingContext(ctx); err == nil {
+				break
+			}
+			log.Infof("waiting for database %s: %s", exporter.ShadowDSN(dsn), err)
+			select {
+			case <-ctx.Done():
+				_ = db.Close()
+				return fmt.Errorf("database %s not ready in %s: %s", exporter.ShadowDSN(dsn), timeout, err)
+			case <-time.After(time.Second):
+			}
+		}
+		_ = db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"testing"
+)
+
+func Test_sidecarDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		sockets []string
+		want    string
+	}{
+		{
+			name: "default",
+			want: "host=127.0.0.1 port=5432 sslmode=disable",
+		},
+		{
+			name:    "socket",
+			env:     map[string]string{"GS_PORT": "5433", "GS_USERNAME": "gaussdb", "GS_PASSWORD": "Secret'123"},
+			sockets: []string{"/tmp/.s.PGSQL.5433"},
+			want:    `host=/tmp port=5433 user=gaussdb password='Secret\'123' sslmode=disable`,
+		},
+		{
+			name: "pg env",
+			env:  map[string]string{"PGHOST": "db", "PGUSER": "omm", "PGDATABASE": "postgres", "PGSSLMODE": "require"},
+			want: "host=db port=5432 user=omm dbname=postgres",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string {
+				return tt.env[key]
+			}
+			exists := func(path string) bool {
+				for _, socket := range tt.sockets {
+					if socket == path {
+						return true
+					}
+				}
+				return false
+			}
+			if got := sidecarDSN(getenv, exists); got != tt.want {
+				t.Errorf("sidecarDSN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// tlsReloader hold tls config of the web endpoint, certificates are reloaded without restarting the listener
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	m            sync.RWMutex
+	cfg          *tls.Config
+}
+
+// newTLSReloader load tls config of the web endpoint.
+// nil is returned if certFile is empty, client certificates are required and verified against clientCAFile if given
+func newTLSReloader(certFile, keyFile, clientCAFile string) (*tlsReloader, error) {
+	if certFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("client ca file is given without tls cert file")
+		}
+		return nil, nil
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("tls key file is required with tls cert file")
+	}
+	r := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload load certificates from files, the current config is kept on error
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("fail to load tls cert: %s", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("fail to read client ca file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in client ca file %s", r.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	r.m.Lock()
+	r.cfg = cfg
+	r.m.Unlock()
+	return nil
+}
+
+// files returns the files to watch
+func (r *tlsReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *tlsReloader) current() *tls.Config {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.cfg
+}
+
+// tlsConfig returns config for http.Server, each handshake uses the latest loaded config
+func (r *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
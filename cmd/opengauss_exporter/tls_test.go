@@ -0,0 +1,134 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert issue a certificate signed by parent, self-signed if parent is nil
+func testCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func Test_newTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ca, caKey, caPEM, _ := testCert(t, "ca", true, nil, nil)
+	_, _, serverPEM, serverKeyPEM := testCert(t, "server", false, ca, caKey)
+	_, _, clientPEM, clientKeyPEM := testCert(t, "client", false, ca, caKey)
+	caFile, serverFile, serverKeyFile := write("ca.crt", caPEM), write("server.crt", serverPEM), write("server.key", serverKeyPEM)
+
+	if r, err := newTLSReloader("", "", ""); r != nil || err != nil {
+		t.Errorf("newTLSReloader() without cert = %v, %v, want nil", r, err)
+	}
+	if _, err := newTLSReloader("", "", caFile); err == nil {
+		t.Error("newTLSReloader() with client ca only, want error")
+	}
+	if _, err := newTLSReloader(serverFile, "", ""); err == nil {
+		t.Error("newTLSReloader() without key, want error")
+	}
+
+	r, err := newTLSReloader(serverFile, serverKeyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	srv.TLS = r.tlsConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverName string
+	get := func(certs []tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		serverName = resp.TLS.PeerCertificates[0].Subject.CommonName
+		return resp.Body.Close()
+	}
+	if err := get(nil); err == nil {
+		t.Error("request without client certificate, want error")
+	}
+	if err := get([]tls.Certificate{clientCert}); err != nil || serverName != "server" {
+		t.Errorf("request with client certificate: %v, server %s", err, serverName)
+	}
+
+	// rotate server certificate
+	_, _, serverPEM, serverKeyPEM = testCert(t, "server2", false, ca, caKey)
+	watcher := newFileWatcher(r.files(), nil)
+	write("server.crt", serverPEM)
+	write("server.key", serverKeyPEM)
+	if !watcher.changed() {
+		t.Error("fileWatcher.changed() = false, want true")
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get([]tls.Certificate{clientCert}); err != nil || serverName != "server2" {
+		t.Errorf("request after reload: %v, server %s", err, serverName)
+	}
+	if watcher.changed() {
+		t.Error("fileWatcher.changed() = true, want false")
+	}
+}
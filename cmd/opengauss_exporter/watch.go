@@ -0,0 +1,63 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package main
+
+import (
+	"crypto/sha256"
+	"github.com/prometheus/common/log"
+	"io/ioutil"
+	"time"
+)
+
+// fileWatcher detect content changes of files, e.g. mounted kubernetes secrets.
+// Content is compared instead of mtime, as secrets are updated by swapping symlinks
+type fileWatcher struct {
+	files    []string
+	sums     map[string][sha256.Size]byte
+	onChange func()
+}
+
+func newFileWatcher(files []string, onChange func()) *fileWatcher {
+	w := &fileWatcher{
+		files:    files,
+		sums:     make(map[string][sha256.Size]byte, len(files)),
+		onChange: onChange,
+	}
+	w.changed()
+	return w
+}
+
+// changed returns whether any file changed since last check. unreadable files are ignored until readable again
+func (w *fileWatcher) changed() bool {
+	var changed bool
+	for _, file := range w.files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Debugf("watch file %s err %s", file, err)
+			continue
+		}
+		sum := sha256.Sum256(content)
+		if old, ok := w.sums[file]; ok && old != sum {
+			log.Infof("file %s changed", file)
+			changed = true
+		}
+		w.sums[file] = sum
+	}
+	return changed
+}
+
+// run check files every interval until stop closed
+func (w *fileWatcher) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// adaptiveTTLRatio is the ratio of the adaptive ttl of the queries to the scrape interval of the client, below 1 so
+// every scrape of the client refreshes the queries while the scrapes of the other clients in between hit the cache
+const adaptiveTTLRatio = 0.9
+
+// scrapeClientExpiry is how long the last scrape of a client is remembered
+const scrapeClientExpiry = time.Hour
+
+// WithAdaptiveTTL set the ttl of the queries slightly below the interval between the scrapes of each client,
+// bounded by the min_ttl and max_ttl of the queries. The ttl of the queries is used until a client scraped twice
+func WithAdaptiveTTL(b bool) Opt {
+	return func(e *Exporter) {
+		e.adaptiveTTL = b
+	}
+}
+
+// ServerWithAdaptiveTTL set the ttl of the queries run on the server from the scrape interval, see WithAdaptiveTTL
+func ServerWithAdaptiveTTL(b bool) ServerOpt {
+	return func(s *Server) {
+		s.adaptiveTTL = b
+	}
+}
+
+type scrapeClientKey struct{}
+
+// WithScrapeClient returns a context whose scrapes are made for client, e.g. the host and path of an HTTP request.
+// The interval between the scrapes of a client sets the ttl of the queries, see WithAdaptiveTTL
+func WithScrapeClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, scrapeClientKey{}, client)
+}
+
+type scrapeIntervalKey struct{}
+
+// scrapeIntervals remember the last scrape of each client of a server. The zero value is ready to use
+type scrapeIntervals struct {
+	m    sync.Mutex
+	last map[string]time.Time // last scrape by client
+}
+
+// observe records a scrape of client at t and returns the interval since its previous scrape, 0 for its first one.
+// The clients that did not scrape for scrapeClientExpiry are forgotten
+func (i *scrapeIntervals) observe(client string, t time.Time) time.Duration {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if i.last == nil {
+		i.last = make(map[string]time.Time)
+	}
+	for c, last := range i.last {
+		if t.Sub(last) > scrapeClientExpiry {
+			delete(i.last, c)
+		}
+	}
+	var interval time.Duration
+	if last, ok := i.last[client]; ok {
+		interval = t.Sub(last)
+	}
+	i.last[client] = t
+	return interval
+}
+
+// withScrapeInterval returns ctx carrying the interval since the previous scrape of its client, with adaptive ttl
+func (s *Server) withScrapeInterval(ctx context.Context) context.Context {
+	client, ok := ctx.Value(scrapeClientKey{}).(string)
+	if !s.adaptiveTTL || !ok {
+		return ctx
+	}
+	interval := s.scrapeIntervals.observe(client, time.Now())
+	if interval <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, scrapeIntervalKey{}, interval)
+}
+
+// effectiveTTL returns the ttl of the query in the scrape of ctx, in seconds: slightly below the scrape interval of
+// the client with adaptive ttl, multiplied by the ttl multiplier of the server and bounded by min_ttl and max_ttl.
+// Queries that are not cached keep their ttl
+func (s *Server) effectiveTTL(ctx context.Context, queryInstance *QueryInstance) float64 {
+	interval, ok := ctx.Value(scrapeIntervalKey{}).(time.Duration)
+	if !ok || queryInstance.TTL <= 0 {
+		return s.queryTTL(queryInstance)
+	}
+	ttl := interval.Seconds() * adaptiveTTLRatio
+	if s.ttlMultiplier > 0 {
+		ttl *= s.ttlMultiplier
+	}
+	if queryInstance.MinTTL > 0 && ttl < queryInstance.MinTTL {
+		ttl = queryInstance.MinTTL
+	}
+	if queryInstance.MaxTTL > 0 && ttl > queryInstance.MaxTTL {
+		ttl = queryInstance.MaxTTL
+	}
+	return ttl
+}
@@ -0,0 +1,53 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_scrapeIntervals_observe(t *testing.T) {
+	var intervals scrapeIntervals
+	begin := time.Now()
+	assert.Equal(t, time.Duration(0), intervals.observe("prometheus/metrics", begin))
+	assert.Equal(t, 15*time.Second, intervals.observe("prometheus/metrics", begin.Add(15*time.Second)))
+	assert.Equal(t, time.Duration(0), intervals.observe("prometheus/metrics/critical", begin.Add(20*time.Second)))
+	assert.Equal(t, 5*time.Second, intervals.observe("prometheus/metrics/critical", begin.Add(25*time.Second)))
+	// the clients that stopped scraping are forgotten
+	intervals.observe("other/metrics", begin.Add(2*scrapeClientExpiry))
+	assert.Len(t, intervals.last, 1)
+}
+
+func TestServer_effectiveTTL(t *testing.T) {
+	s := &Server{adaptiveTTL: true}
+	q := &QueryInstance{TTL: 60}
+	assert.Equal(t, 60.0, s.effectiveTTL(context.Background(), q))
+
+	ctx := WithScrapeClient(context.Background(), "prometheus/metrics")
+	assert.Equal(t, 60.0, s.effectiveTTL(s.withScrapeInterval(ctx), q), "first scrape of the client")
+	s.scrapeIntervals.last["prometheus/metrics"] = time.Now().Add(-15 * time.Second)
+	ctx = s.withScrapeInterval(ctx)
+	assert.InDelta(t, 13.5, s.effectiveTTL(ctx, q), 0.1)
+
+	assert.Equal(t, 30.0, s.effectiveTTL(ctx, &QueryInstance{TTL: 60, MinTTL: 30}))
+	assert.Equal(t, 10.0, s.effectiveTTL(ctx, &QueryInstance{TTL: 60, MaxTTL: 10}))
+	assert.Equal(t, -1.0, s.effectiveTTL(ctx, &QueryInstance{TTL: -1}), "not cached")
+	s.ttlMultiplier = 4
+	assert.InDelta(t, 54.0, s.effectiveTTL(ctx, q), 0.4)
+
+	// the ttl of the queries is kept without adaptive ttl
+	s = &Server{}
+	ctx = WithScrapeClient(context.Background(), "prometheus/metrics")
+	s.withScrapeInterval(ctx)
+	assert.Equal(t, 60.0, s.effectiveTTL(s.withScrapeInterval(ctx), q))
+}
+
+func TestQueryInstance_Check_adaptiveTTL(t *testing.T) {
+	q := &QueryInstance{Name: "pg_lock", MinTTL: 60, MaxTTL: 30, Queries: []*Query{{SQL: "SELECT 1"}}}
+	assert.Error(t, q.Check())
+	q = &QueryInstance{Name: "pg_lock", MinTTL: 10, MaxTTL: 30, Queries: []*Query{{SQL: "SELECT 1"}}}
+	assert.NoError(t, q.Check())
+}
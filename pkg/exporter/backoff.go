@@ -0,0 +1,149 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const (
+	// DefaultConnectBackoff is the delay before connecting again to a target that failed to connect
+	DefaultConnectBackoff = 10 * time.Second
+	// DefaultMaxConnectBackoff caps the delay, doubled on every failure
+	DefaultMaxConnectBackoff = 5 * time.Minute
+)
+
+// WithConnectBackoff delay the connections to a target that failed to connect by initial, doubled on every
+// consecutive failure up to max. Scrapes do not wait for the target meanwhile, 0 disables it
+func WithConnectBackoff(initial, max time.Duration) Opt {
+	return func(e *Exporter) {
+		e.connectBackoff, e.backoffMax = initial, max
+	}
+}
+
+// connectFailure is the connection failures of a target
+type connectFailure struct {
+	count   int               // consecutive failures
+	retryAt time.Time         // no connection until then
+	err     error             // last failure
+	labels  prometheus.Labels // labels of the server of the target, nil if it could not be created
+}
+
+// connectBackoff remember the targets that failed to connect and back off their retries exponentially
+type connectBackoff struct {
+	initial  time.Duration
+	max      time.Duration
+	failures map[string]*connectFailure
+	now      func() time.Time
+}
+
+func newConnectBackoff(initial, max time.Duration) *connectBackoff {
+	if max < initial {
+		max = initial
+	}
+	return &connectBackoff{
+		initial:  initial,
+		max:      max,
+		failures: make(map[string]*connectFailure),
+		now:      time.Now,
+	}
+}
+
+// check returns the last error of dsn while its retries are backed off, nil if it can connect
+func (b *connectBackoff) check(dsn string) error {
+	if b == nil {
+		return nil
+	}
+	failure, ok := b.failures[dsn]
+	if !ok {
+		return nil
+	}
+	wait := failure.retryAt.Sub(b.now())
+	if wait <= 0 {
+		return nil
+	}
+	return &backoffError{err: failure.err, count: failure.count, wait: wait}
+}
+
+// backoffError is returned instead of connecting to a target whose retries are backed off
+type backoffError struct {
+	err   error // last failure
+	count int
+	wait  time.Duration
+}
+
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("%s (failed %d times, next attempt in %s)", e.err, e.count, e.wait.Round(time.Second))
+}
+
+func (e *backoffError) Unwrap() error {
+	return e.err
+}
+
+// fail record a connection failure of dsn, labels are the labels of its server
+func (b *connectBackoff) fail(dsn string, labels prometheus.Labels, err error) {
+	if b == nil {
+		return
+	}
+	failure, ok := b.failures[dsn]
+	if !ok {
+		failure = &connectFailure{}
+		b.failures[dsn] = failure
+	}
+	delay := b.initial
+	for i := 0; i < failure.count && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	failure.count++
+	failure.retryAt = b.now().Add(delay)
+	failure.err = err
+	if labels != nil {
+		failure.labels = labels
+	}
+}
+
+// reset forget the failures of dsn, it connects on the next attempt
+func (b *connectBackoff) reset(dsn string) {
+	if b == nil {
+		return
+	}
+	delete(b.failures, dsn)
+}
+
+// labels returns the labels of the server of dsn that failed to connect, nil if unknown
+func (b *connectBackoff) labels(dsn string) prometheus.Labels {
+	if b == nil {
+		return nil
+	}
+	if failure, ok := b.failures[dsn]; ok {
+		return failure.labels
+	}
+	return nil
+}
+
+// targetLabels returns the labels of the server of dsn, connected or not, nil if unknown
+func (s *Servers) targetLabels(dsn string) prometheus.Labels {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if server, ok := s.servers[dsn]; ok {
+		return server.labels
+	}
+	return s.backoff.labels(dsn)
+}
+
+// targetUpMetric returns whether the target of labels could be connected by the last scrape
+func (e *Exporter) targetUpMetric(labels prometheus.Labels, up bool) prometheus.Metric {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(e.namespace, "exporter", "target_up"),
+		"Whether the last scrape connected to the target (1 for yes, 0 for no, also while its connection retries are backed off).",
+		nil, labels)
+	var value float64
+	if up {
+		value = 1
+	}
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+}
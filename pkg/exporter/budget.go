@@ -0,0 +1,208 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// budgetIdleTime is how long a connection within the budget is kept idle, its slot is then given back to the other pools
+const budgetIdleTime = 5 * time.Second
+
+// WithMaxConnections cap the connections opened by the exporter to all servers and databases, 0 means no limit.
+// The connections beyond the cap wait for a connection to be closed
+func WithMaxConnections(n int) Opt {
+	return func(e *Exporter) {
+		e.maxConnections = n
+	}
+}
+
+// ServerWithConnectionBudget open the connections of the server within budget, shared by the servers of the exporter
+func ServerWithConnectionBudget(budget *connBudget) ServerOpt {
+	return func(s *Server) {
+		s.connBudget = budget
+	}
+}
+
+// connBudget is the number of connections the servers of an exporter may hold at the same time. nil is safe to use
+type connBudget struct {
+	slots  chan struct{}
+	m      sync.Mutex
+	waits  int           // connections that waited for a slot
+	waited time.Duration // time waited for a slot
+}
+
+func newConnBudget(n int) *connBudget {
+	if n <= 0 {
+		return nil
+	}
+	return &connBudget{slots: make(chan struct{}, n)}
+}
+
+// acquire a slot for a new connection, waiting for one to be released until ctx is done
+func (b *connBudget) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	begin := time.Now()
+	defer func() {
+		b.m.Lock()
+		b.waits++
+		b.waited += time.Since(begin)
+		b.m.Unlock()
+	}()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for one of the %d connections of the budget: %w", cap(b.slots), ctx.Err())
+	}
+}
+
+// release the slot of a closed connection
+func (b *connBudget) release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
+
+// collect emit the size and usage of the budget
+func (b *connBudget) collect(ch chan<- prometheus.Metric, namespace string, labels prometheus.Labels) {
+	if b == nil {
+		return
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "connection_budget"),
+		"Max number of connections the exporter holds to all servers.", nil, labels), prometheus.GaugeValue, float64(cap(b.slots)))
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "connections_open"),
+		"Number of connections the exporter holds to all servers.", nil, labels), prometheus.GaugeValue, float64(len(b.slots)))
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "connection_budget_waits_total"),
+		"Total number of connections that waited for a connection of the budget to be closed.", nil, labels), prometheus.CounterValue, float64(b.waits))
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "connection_budget_wait_seconds_total"),
+		"Total time connections waited for a connection of the budget to be closed.", nil, labels), prometheus.CounterValue, b.waited.Seconds())
+}
+
+// openPool open the connection pool of connector, within the connection budget of the server if any
+func (s *Server) openPool(connector driver.Connector) *sql.DB {
+	if s.connBudget != nil {
+		connector = &budgetConnector{Connector: connector, budget: s.connBudget}
+	}
+	if s.cancelOnTimeout {
+		connector = &backendConnector{Connector: connector}
+	}
+	db := sql.OpenDB(connector)
+	if s.connBudget != nil {
+		// an idle pool must not starve the other pools
+		db.SetConnMaxIdleTime(budgetIdleTime)
+	}
+	return db
+}
+
+// budgetConnector open connections within a connection budget
+type budgetConnector struct {
+	driver.Connector
+	budget *connBudget
+}
+
+func (c *budgetConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := c.budget.acquire(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		c.budget.release()
+		return nil, err
+	}
+	return &budgetConn{forwardConn: forwardConn{Conn: conn}, budget: c.budget}, nil
+}
+
+// budgetConn release its slot of the budget when closed
+type budgetConn struct {
+	forwardConn
+	budget *connBudget
+	once   sync.Once
+}
+
+func (c *budgetConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.budget.release)
+	return err
+}
+
+// forwardConn wraps a connection, forwarding its optional interfaces. driver.ErrSkip makes database/sql fall back
+// to Prepare if the connection lacks them
+type forwardConn struct {
+	driver.Conn
+}
+
+var (
+	_ driver.QueryerContext     = (*forwardConn)(nil)
+	_ driver.ExecerContext      = (*forwardConn)(nil)
+	_ driver.ConnPrepareContext = (*forwardConn)(nil)
+	_ driver.ConnBeginTx        = (*forwardConn)(nil)
+	_ driver.Pinger             = (*forwardConn)(nil)
+	_ driver.SessionResetter    = (*forwardConn)(nil)
+	_ driver.Validator          = (*forwardConn)(nil)
+)
+
+func (c *forwardConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *forwardConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *forwardConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *forwardConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() // nolint: staticcheck
+}
+
+func (c *forwardConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *forwardConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *forwardConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_connBudget(t *testing.T) {
+	assert.Nil(t, newConnBudget(0))
+	budget := newConnBudget(1)
+	first, firstMock, err := sqlmock.NewWithDSN("budget_first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, secondMock, err := sqlmock.NewWithDSN("budget_second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	firstMock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(1))
+	firstMock.ExpectClose()
+	secondMock.ExpectQuery("SELECT 2").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(2))
+	secondMock.ExpectClose()
+
+	s := &Server{connBudget: budget}
+	db1 := s.openPool(dsnConnector{dsn: "budget_first", drv: first.Driver()})
+	db2 := s.openPool(dsnConnector{dsn: "budget_second", drv: second.Driver()})
+	var c int
+	assert.NoError(t, db1.QueryRow("SELECT 1").Scan(&c))
+	assert.Equal(t, 1, len(budget.slots), "the idle connection holds its slot")
+
+	// the connection of the other pool waits for the slot
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = db2.QueryRowContext(ctx, "SELECT 2").Scan(&c)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = db1.Close()
+	}()
+	assert.NoError(t, db2.QueryRow("SELECT 2").Scan(&c))
+	assert.Equal(t, 2, c)
+	assert.NoError(t, db2.Close())
+	assert.NoError(t, firstMock.ExpectationsWereMet())
+	assert.NoError(t, secondMock.ExpectationsWereMet())
+	assert.Equal(t, 0, len(budget.slots))
+
+	assert.Equal(t, 2, budget.waits)
+	ch := make(chan prometheus.Metric, 10)
+	budget.collect(ch, "og", nil)
+	assert.Len(t, ch, 4)
+}
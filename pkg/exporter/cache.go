@@ -0,0 +1,38 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectCache emit the number and estimated size of cached query results of the server
+func (s *Server) collectCache(ch chan<- prometheus.Metric) {
+	s.cacheMtx.Lock()
+	entries, bytes := len(s.metricCache), 0
+	for _, cached := range s.metricCache {
+		bytes += cached.bytes
+	}
+	s.cacheMtx.Unlock()
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(s.namespace, "exporter", "cache_entries"),
+		"Number of query results cached on the server.", nil, s.labels), prometheus.GaugeValue, float64(entries))
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(s.namespace, "exporter", "cache_bytes"),
+		"Estimated bytes of the query results cached on the server.", nil, s.labels), prometheus.GaugeValue, float64(bytes))
+}
+
+// metricsBytes estimate the memory size of metrics: label names and values, and a value per sample
+func metricsBytes(metrics []prometheus.Metric) int {
+	var size int
+	for _, metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+		for _, label := range m.Label {
+			size += len(label.GetName()) + len(label.GetValue())
+		}
+		size += 8
+	}
+	return size
+}
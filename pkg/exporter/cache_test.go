@@ -0,0 +1,48 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_collectCache(t *testing.T) {
+	desc := prometheus.NewDesc("pg_lock_count", "locks", []string{"datname", "mode"}, nil)
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, "postgres", "AccessShareLock"),
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 2, "omm", "ExclusiveLock"),
+	}
+	s := &Server{
+		namespace: "og",
+		labels:    prometheus.Labels{serverLabelName: "localhost:5432"},
+		metricCache: map[string]cachedMetrics{
+			"pg_lock": {metrics: metrics, lastScrape: time.Now(), bytes: metricsBytes(metrics)},
+		},
+	}
+	// datname + postgres + mode + AccessShareLock + 8, datname + omm + mode + ExclusiveLock + 8
+	assert.Equal(t, 42+35, metricsBytes(metrics))
+
+	ch := make(chan prometheus.Metric, 2)
+	s.collectCache(ch)
+	close(ch)
+	values := make(map[string]float64)
+	for metric := range ch {
+		m := &dto.Metric{}
+		assert.NoError(t, metric.Write(m))
+		values[metric.Desc().String()] = m.GetGauge().GetValue()
+	}
+	assert.Len(t, values, 2)
+	for desc, value := range values {
+		switch {
+		case strings.Contains(desc, "og_exporter_cache_entries"):
+			assert.Equal(t, 1.0, value)
+		case strings.Contains(desc, "og_exporter_cache_bytes"):
+			assert.Equal(t, 77.0, value)
+		}
+	}
+}
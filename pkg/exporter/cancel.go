@@ -0,0 +1,179 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+)
+
+const (
+	// backendPIDSQL query the backend of a connection, canceled by cancelBackendSQL
+	backendPIDSQL    = "SELECT pg_backend_pid()"
+	cancelBackendSQL = "SELECT pg_cancel_backend($1)"
+	// cancelTimeout bounds the cancellation of a statement on the server
+	cancelTimeout = 5 * time.Second
+)
+
+// WithCancelOnTimeout cancel the statements of the queries exceeding their timeout on the server too,
+// with pg_cancel_backend from a separate control connection
+func WithCancelOnTimeout(b bool) Opt {
+	return func(e *Exporter) {
+		e.cancelOnTimeout = b
+	}
+}
+
+// ServerWithCancelOnTimeout cancel the statements of the queries of the server exceeding their timeout
+// with pg_cancel_backend, see WithCancelOnTimeout
+func ServerWithCancelOnTimeout(b bool) ServerOpt {
+	return func(s *Server) {
+		s.cancelOnTimeout = b
+	}
+}
+
+// cancelableRows are the rows of a statement canceled on the server when its context is done before the rows are
+// closed. Closing them returns their connection to the pool, discarded if the statement was canceled
+type cancelableRows struct {
+	*sql.Rows
+	conn     *sql.Conn
+	stop     chan struct{}
+	canceled chan bool
+}
+
+func (r *cancelableRows) Close() error {
+	err := r.Rows.Close()
+	close(r.stop)
+	if <-r.canceled {
+		// a late cancel request must not interrupt the next statement of the connection
+		_ = r.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	_ = r.conn.Close()
+	return err
+}
+
+// cancelable returns whether the statements run within ctx are canceled on the server when it is done
+func (s *Server) cancelable(ctx context.Context) bool {
+	_, ok := ctx.Deadline()
+	return ok && s.cancelOnTimeout && !s.singleConnection && s.mockResults == nil
+}
+
+// queryCancelable execute query on a connection of db whose backend is canceled with pg_cancel_backend when ctx is
+// done before the rows are closed, as the cancellation of ctx alone may leave the statement running on the server
+func (s *Server) queryCancelable(ctx context.Context, db *sql.DB, query string, args ...interface{}) (rowSource, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := backendPID(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	r := &cancelableRows{conn: conn, stop: make(chan struct{}), canceled: make(chan bool, 1)}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.stop:
+		}
+		// the statement may still run on the server once ctx is done, even if the driver returned
+		r.canceled <- ctx.Err() != nil && s.cancelBackend(pid, ctx.Err())
+	}()
+	if r.Rows, err = conn.QueryContext(ctx, query, args...); err != nil {
+		close(r.stop)
+		if <-r.canceled {
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		_ = conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// backendConnector open connections caching their backend pid, see backendPID
+type backendConnector struct {
+	driver.Connector
+}
+
+func (c *backendConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &backendConn{forwardConn: forwardConn{Conn: conn}}, nil
+}
+
+// backendConn is a connection whose backend pid is cached once queried, it is gone with the connection
+type backendConn struct {
+	forwardConn
+	pid int64
+}
+
+// backendPID returns the backend pid of conn, queried once per connection of the pools opened with backendConnector
+func backendPID(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var cached *backendConn
+	_ = conn.Raw(func(driverConn interface{}) error {
+		cached, _ = driverConn.(*backendConn)
+		return nil
+	})
+	if cached != nil && cached.pid != 0 {
+		return cached.pid, nil
+	}
+	var pid int64
+	if err := conn.QueryRowContext(ctx, backendPIDSQL).Scan(&pid); err != nil {
+		return 0, err
+	}
+	if cached != nil {
+		// conn is not shared, neither is its driver connection
+		cached.pid = pid
+	}
+	return pid, nil
+}
+
+// cancelBackend cancel the statement of backend pid from the control connection, returns whether it was requested
+func (s *Server) cancelBackend(pid int64, reason error) bool {
+	db, err := s.controlConn()
+	if err != nil {
+		s.log().Warnf("Error opening control connection to %q: %s", s, RedactText(err.Error()))
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	var canceled bool
+	if err := db.QueryRowContext(ctx, cancelBackendSQL, pid).Scan(&canceled); err != nil {
+		s.log().Warnf("Error canceling backend %d on %q: %s", pid, s, err)
+		return false
+	}
+	s.log().Debugf("Canceled backend %d on %q: %s", pid, s, reason)
+	return true
+}
+
+// controlConn returns the pool of the control connection of the server, opened on first use. It is apart from the
+// pool of the queries, which is busy with the statements to cancel, and outside the connection budget, so a cancel
+// does not wait for the connections of these statements to be closed
+func (s *Server) controlConn() (*sql.DB, error) {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	if s.controlDB != nil {
+		return s.controlDB, nil
+	}
+	db, err := s.openDBWith(s.dsn, sql.OpenDB)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	s.controlDB = db
+	return db, nil
+}
+
+// closeControlConn close the control connection, if open
+func (s *Server) closeControlConn() {
+	s.controlMtx.Lock()
+	defer s.controlMtx.Unlock()
+	if s.controlDB != nil {
+		_ = s.controlDB.Close()
+		s.controlDB = nil
+	}
+}
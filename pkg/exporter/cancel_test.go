@@ -0,0 +1,120 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestServer_queryCancelable(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	control, controlMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db, controlDB: control, cancelOnTimeout: true, labels: prometheus.Labels{serverLabelName: "localhost:5432"}}
+	defer s.closeControlConn()
+	assert.False(t, s.cancelable(context.Background()), "no deadline")
+
+	// a statement within its timeout is not canceled
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.True(t, s.cancelable(ctx))
+	mock.ExpectQuery(backendPIDSQL).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4242))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	rows, err := s.queryCancelable(ctx, db, "SELECT 1")
+	if assert.NoError(t, err) {
+		assert.True(t, rows.Next())
+		assert.NoError(t, rows.Close())
+	}
+
+	// a statement exceeding its timeout is canceled on the server
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	mock.ExpectQuery(backendPIDSQL).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4343))
+	mock.ExpectQuery("SELECT pg_sleep(10)").WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"pg_sleep"}))
+	controlMock.ExpectQuery(cancelBackendSQL).WithArgs(4343).WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+	_, err = s.queryCancelable(ctx, db, "SELECT pg_sleep(10)")
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, controlMock.ExpectationsWereMet())
+}
+
+func Test_backendPID(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("backend_pid", sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	db := sql.OpenDB(&backendConnector{Connector: dsnConnector{dsn: "backend_pid", drv: mockDB.Driver()}})
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	// queried on the first use of the connection only
+	mock.ExpectQuery(backendPIDSQL).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4242))
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+		pid, err := backendPID(ctx, conn)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4242), pid)
+		assert.NoError(t, conn.Close())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// queried again for a new connection
+	conn, err := db.Conn(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = conn.Close()
+	mock.ExpectQuery(backendPIDSQL).WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(4343))
+	conn, err = db.Conn(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	pid, err := backendPID(ctx, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4343), pid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServer_controlConn(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("host=control_conn", sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	RegisterDriver("control_mock", testDriver{drv: mockDB.Driver()})
+	defer func() {
+		driversMtx.Lock()
+		delete(drivers, "control_mock")
+		driversMtx.Unlock()
+	}()
+	mock.ExpectQuery(cancelBackendSQL).WithArgs(4343).WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+
+	// the budget is full of the connections of the statements to cancel
+	budget := newConnBudget(1)
+	assert.NoError(t, budget.acquire(context.Background()))
+	s := &Server{dsn: "host=control_conn", driver: "control_mock", connBudget: budget, cancelOnTimeout: true,
+		labels: prometheus.Labels{serverLabelName: "localhost:5432"}}
+	defer s.closeControlConn()
+	assert.True(t, s.cancelBackend(4343, context.DeadlineExceeded))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,105 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetricInfo describe a metric family emitted by the queries of the config
+type MetricInfo struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Labels   []string `json:"labels"`
+	Help     string   `json:"help"`
+	Queries  []string `json:"queries"`  // queries emitting the metric
+	Files    []string `json:"files"`    // config files of the queries, built-in for the default ones
+	Versions []string `json:"versions"` // version ranges of the servers the metric is collected from
+}
+
+// MetricsCatalog returns the metric families the enabled queries can emit, sorted by name.
+// A metric emitted by several queries is listed once
+func MetricsCatalog(queries map[string]*QueryInstance) []*MetricInfo {
+	metrics := make(map[string]*MetricInfo)
+	for _, key := range sortedQueryKeys(queries) {
+		q := queries[key]
+		if q.Status == statusDisable {
+			continue
+		}
+		var versions []string
+		for _, query := range q.Queries {
+			if query.Status != statusDisable {
+				versions = append(versions, query.SupportedVersions)
+			}
+		}
+		for _, col := range q.Metrics {
+			if col.Usage == LABEL || col.Usage == DISCARD {
+				continue
+			}
+			name := q.columnMetricName(col)
+			info, ok := metrics[name]
+			if !ok {
+				info = &MetricInfo{Name: name, Type: metricType(col), Labels: append([]string{}, q.LabelNames...), Help: col.help()}
+				metrics[name] = info
+			}
+			info.Queries = appendMissing(info.Queries, q.Name)
+			info.Files = appendMissing(info.Files, configFileName(q.Path))
+			for _, version := range versions {
+				info.Versions = appendMissing(info.Versions, version)
+			}
+		}
+	}
+	catalog := make([]*MetricInfo, 0, len(metrics))
+	for _, info := range metrics {
+		catalog = append(catalog, info)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+	return catalog
+}
+
+// FormatMetricsCatalog render the catalog as a markdown table or a json document
+func FormatMetricsCatalog(catalog []*MetricInfo, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(catalog, "", "  ")
+	case "markdown":
+		var b bytes.Buffer
+		b.WriteString("| Metric | Type | Labels | Help | Queries | Versions |\n")
+		b.WriteString("|--------|------|--------|------|---------|----------|\n")
+		for _, info := range catalog {
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s |\n", info.Name, info.Type,
+				markdownCell(strings.Join(info.Labels, ", ")), markdownCell(info.Help),
+				markdownCell(strings.Join(info.Queries, ", ")), markdownCell(strings.Join(info.Versions, ", ")))
+		}
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported catalog format %s, should be markdown or json", format)
+}
+
+// metricType returns the Prometheus type of the metric of a column
+func metricType(col *Column) string {
+	switch col.Usage {
+	case COUNTER:
+		return "counter"
+	case HISTOGRAM:
+		return "histogram"
+	}
+	return "gauge"
+}
+
+// markdownCell escape the pipes and line breaks of a table cell
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func appendMissing(values []string, value string) []string {
+	if Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -0,0 +1,69 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMetricsCatalog(t *testing.T) {
+	queries, err := ParseConfig([]byte(`pg_lock:
+  query:
+  - sql: SELECT datname, count FROM pg_locks
+    version: '>=1.0.0'
+  - sql: SELECT datname, count FROM pg_locks
+    version: '<1.0.0'
+    status: disable
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+    description: Number of | locks
+pg_lock_v2:
+  name: pg_lock
+  query:
+  - sql: SELECT datname, count, waits FROM pg_locks
+    version: '>=2.0.0'
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+  - name: waits
+    usage: COUNTER
+pg_off:
+  status: disable
+  query:
+  - sql: SELECT 1 AS one
+  metrics:
+  - name: one
+    usage: GAUGE
+`), "catalog.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	catalog := MetricsCatalog(queries)
+	assert.Equal(t, []*MetricInfo{
+		{Name: "pg_lock_count", Type: "gauge", Labels: []string{"datname"}, Help: "Number of | locks",
+			Queries: []string{"pg_lock"}, Files: []string{"catalog.yaml"}, Versions: []string{">=1.0.0", ">=2.0.0"}},
+		{Name: "pg_lock_waits", Type: "counter", Labels: []string{"datname"}, Help: "",
+			Queries: []string{"pg_lock"}, Files: []string{"catalog.yaml"}, Versions: []string{">=2.0.0"}},
+	}, catalog)
+
+	buf, err := FormatMetricsCatalog(catalog, "markdown")
+	assert.NoError(t, err)
+	assert.Equal(t, "| Metric | Type | Labels | Help | Queries | Versions |\n"+
+		"|--------|------|--------|------|---------|----------|\n"+
+		"| `pg_lock_count` | gauge | datname | Number of \\| locks | pg_lock | >=1.0.0, >=2.0.0 |\n"+
+		"| `pg_lock_waits` | counter | datname |  | pg_lock | >=2.0.0 |\n", string(buf))
+
+	buf, err = FormatMetricsCatalog(catalog[1:], "json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"name": "pg_lock_waits", "type": "counter", "labels": ["datname"], "help": "",
+		"queries": ["pg_lock"], "files": ["catalog.yaml"], "versions": [">=2.0.0"]}]`, string(buf))
+
+	_, err = FormatMetricsCatalog(catalog, "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// checksumsCollectorName is the name of the built-in collector of the data checksum failures
+const checksumsCollectorName = "pg_checksum_failures"
+
+const (
+	// checksumColumnsSQL query whether pg_stat_database has the checksum columns, openGauss lacks them
+	checksumColumnsSQL = `SELECT count(*) = 2 FROM pg_attribute
+WHERE attrelid = 'pg_catalog.pg_stat_database'::regclass AND attname IN ('checksum_failures', 'checksum_last_failure')`
+	// checksumFailuresSQL query the checksum failures by database, the row of the shared objects has no datname.
+	// The columns are NULL if data checksums are disabled
+	checksumFailuresSQL = `SELECT coalesce(datname, 'shared'), checksum_failures::float,
+	extract(epoch FROM checksum_last_failure)::float
+FROM pg_stat_database WHERE checksum_failures IS NOT NULL`
+)
+
+func init() {
+	RegisterCollector(newChecksumsCollector())
+}
+
+// checksumsCollector collect the data checksum failures of pg_stat_database on the servers providing them,
+// the availability of the columns is detected once per server and version
+type checksumsCollector struct {
+	m         sync.Mutex
+	supported map[string]bool // whether the server has the columns, by server and version
+}
+
+func newChecksumsCollector() *checksumsCollector {
+	return &checksumsCollector{supported: make(map[string]bool)}
+}
+
+func (c *checksumsCollector) Name() string {
+	return checksumsCollectorName
+}
+
+// Enabled checksum failures are server level metrics
+func (c *checksumsCollector) Enabled(info ServerInfo) bool {
+	return info.Master
+}
+
+func (c *checksumsCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	supported, err := c.detect(ctx, db, info)
+	if err != nil || !supported {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, checksumFailuresSQL)
+	if err != nil {
+		return fmt.Errorf("Error retrieving checksum failures on %q: %s", info.Server, err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	failuresDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "stat_database", "checksum_failures"),
+		"Number of data page checksum failures detected in the database", []string{"datname"}, info.Labels)
+	lastFailureDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "stat_database", "checksum_last_failure_seconds"),
+		"Time of the last data page checksum failure detected in the database, as a unix timestamp", []string{"datname"}, info.Labels)
+	for rows.Next() {
+		var (
+			datname     string
+			failures    float64
+			lastFailure sql.NullFloat64
+		)
+		if err := rows.Scan(&datname, &failures, &lastFailure); err != nil {
+			return fmt.Errorf("Error retrieving checksum failures on %q: %s", info.Server, err)
+		}
+		ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, failures, datname)
+		if lastFailure.Valid {
+			ch <- prometheus.MustNewConstMetric(lastFailureDesc, prometheus.GaugeValue, lastFailure.Float64, datname)
+		}
+	}
+	return rows.Err()
+}
+
+// detect returns whether pg_stat_database of the server has the checksum columns, queried once per server and version
+func (c *checksumsCollector) detect(ctx context.Context, db *sql.DB, info ServerInfo) (bool, error) {
+	key := info.Server + " " + info.Version.String()
+	c.m.Lock()
+	supported, ok := c.supported[key]
+	c.m.Unlock()
+	if ok {
+		return supported, nil
+	}
+	if err := db.QueryRowContext(ctx, checksumColumnsSQL).Scan(&supported); err != nil {
+		return false, fmt.Errorf("Error detecting checksum columns on %q: %s", info.Server, err)
+	}
+	if !supported {
+		loggerOr(info.Logger).Debugf("pg_stat_database of %q has no checksum columns, checksum failures are not collected", info.Server)
+	}
+	c.m.Lock()
+	c.supported[key] = supported
+	c.m.Unlock()
+	return supported, nil
+}
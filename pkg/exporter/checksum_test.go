@@ -0,0 +1,42 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_checksumsCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newChecksumsCollector()
+	info := ServerInfo{Server: "localhost:5432", Namespace: "og", Master: true, Version: semver.MustParse("3.0.0")}
+	assert.True(t, c.Enabled(info))
+	assert.False(t, c.Enabled(ServerInfo{}))
+
+	// openGauss lacks the columns, they are detected once
+	mock.ExpectQuery("FROM pg_attribute").WillReturnRows(sqlmock.NewRows([]string{"supported"}).AddRow(false))
+	ch := make(chan prometheus.Metric, 10)
+	assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+	assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+	assert.Len(t, ch, 0)
+
+	// a server providing them
+	info.Server = "other:5432"
+	mock.ExpectQuery("FROM pg_attribute").WillReturnRows(sqlmock.NewRows([]string{"supported"}).AddRow(true))
+	mock.ExpectQuery("FROM pg_stat_database").WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "checksum_failures", "checksum_last_failure"}).
+			AddRow("shared", 0, nil).
+			AddRow("postgres", 2, 1600000000))
+	assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+	assert.Len(t, ch, 3)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,217 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// cmCollectorName is the name of the cluster manager collector
+const cmCollectorName = "cm"
+
+// DefaultCMCommand query the cluster manager about the cluster and the instances
+var DefaultCMCommand = []string{"cm_ctl", "query", "-Cv"}
+
+// roles reported by cm_ctl query, lower case
+var cmRoles = map[string]bool{
+	"primary":        true,
+	"standby":        true,
+	"pending":        true,
+	"down":           true,
+	"unknown":        true,
+	"secondary":      true,
+	"deleted":        true,
+	"main":           true, // Main Standby
+	"cascade":        true, // Cascade Standby
+	"stateleader":    true, // etcd
+	"statefollower":  true,
+	"statecandidate": true,
+}
+
+// cmInstance is an instance managed by CM
+type cmInstance struct {
+	Node     string `json:"node"`
+	NodeName string `json:"node_name"`
+	Instance string `json:"instance"`
+	Type     string `json:"type"`  // cmserver, datanode, etcd, gtm, coordinator
+	Role     string `json:"role"`  // Primary, Standby, Pending ...
+	State    string `json:"state"` // Normal, Need repair ...
+}
+
+// cmStatus is the parsed output of cm_ctl query
+type cmStatus struct {
+	ClusterState string       `json:"cluster_state"`
+	Instances    []cmInstance `json:"instances"`
+}
+
+// cmCollector run cm_ctl query on the exporter host to expose the state of openGauss HA clusters managed by CM.
+// Role changes of instances observed between scrapes are counted as switchovers/failovers
+type cmCollector struct {
+	command []string
+	m       sync.Mutex
+	roles   map[string]string  // last role of instances, by server and instance
+	changes map[string]float64 // role changes of instances, by server and instance
+}
+
+// NewCMCollector returns the collector of the cluster manager state, run command instead of DefaultCMCommand if given.
+// The command output is either the text of cm_ctl query or a JSON document
+// {"cluster_state": "Normal", "instances": [{"node", "node_name", "instance", "type", "role", "state"}]}
+func NewCMCollector(command []string) Collector {
+	if len(command) == 0 {
+		command = DefaultCMCommand
+	}
+	return &cmCollector{
+		command: command,
+		roles:   make(map[string]string),
+		changes: make(map[string]float64),
+	}
+}
+
+func (c *cmCollector) Name() string {
+	return cmCollectorName
+}
+
+// Enabled the cluster state is a server level metric
+func (c *cmCollector) Enabled(info ServerInfo) bool {
+	return info.Master
+}
+
+func (c *cmCollector) Collect(ctx context.Context, _ *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	upDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "cm", "up"),
+		"Whether the last cluster manager query succeeded", nil, info.Labels)
+	out, err := exec.CommandContext(ctx, c.command[0], c.command[1:]...).Output()
+	var status *cmStatus
+	if err == nil {
+		status, err = parseCMOutput(out)
+	}
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return fmt.Errorf("cm query %v: %s", c.command, err)
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+
+	if status.ClusterState != "" {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "cm", "cluster_state"),
+			"State of the cluster reported by the cluster manager", []string{"state"}, info.Labels),
+			prometheus.GaugeValue, 1, status.ClusterState)
+	}
+
+	instanceLabels := []string{"node", "node_name", "instance", "type"}
+	infoDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "cm", "instance_info"),
+		"Role and state of an instance reported by the cluster manager", append(instanceLabels, "role", "state"), info.Labels)
+	primaryDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "cm", "instance_primary"),
+		"Whether the instance is the primary", instanceLabels, info.Labels)
+	changesDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "cm", "instance_role_changes_total"),
+		"Role changes (switchover/failover) of the instance observed by the exporter", instanceLabels, info.Labels)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, inst := range status.Instances {
+		labels := []string{inst.Node, inst.NodeName, inst.Instance, inst.Type}
+		key := strings.Join(append([]string{info.Server}, labels...), "\x00")
+		if last, ok := c.roles[key]; ok && last != inst.Role {
+			c.changes[key]++
+		}
+		c.roles[key] = inst.Role
+		primary := 0.0
+		if strings.EqualFold(inst.Role, "Primary") {
+			primary = 1
+		}
+		ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1, append(labels, inst.Role, inst.State)...)
+		ch <- prometheus.MustNewConstMetric(primaryDesc, prometheus.GaugeValue, primary, labels...)
+		ch <- prometheus.MustNewConstMetric(changesDesc, prometheus.CounterValue, c.changes[key], labels...)
+	}
+	return nil
+}
+
+// parseCMOutput parse the JSON document or text output of cm_ctl query
+func parseCMOutput(out []byte) (*cmStatus, error) {
+	out = bytes.TrimSpace(out)
+	status := &cmStatus{}
+	if bytes.HasPrefix(out, []byte("{")) {
+		if err := json.Unmarshal(out, status); err != nil {
+			return nil, fmt.Errorf("malformed json: %s", err)
+		}
+		return status, nil
+	}
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "---"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			// [  Datanode State   ] -> datanode
+			section = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(strings.Trim(line, "[]")), " State"))
+			section = strings.Join(strings.Fields(section), "")
+			continue
+		}
+		if section == "cluster" || section == "" {
+			if kv := strings.SplitN(line, ":", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "cluster_state" {
+				status.ClusterState = strings.TrimSpace(kv[1])
+			}
+			continue
+		}
+		// several instances may be printed on a line, separated by |
+		for _, segment := range strings.Split(line, "|") {
+			if inst, ok := parseCMInstance(strings.Fields(segment)); ok {
+				inst.Type = section
+				status.Instances = append(status.Instances, inst)
+			}
+		}
+	}
+	if len(status.Instances) == 0 && status.ClusterState == "" {
+		return nil, fmt.Errorf("no cluster state found in output")
+	}
+	return status, nil
+}
+
+// parseCMInstance parse the fields of an instance: node node_name [ip] instance [data_path] [P|S] role state...
+func parseCMInstance(fields []string) (cmInstance, bool) {
+	if len(fields) < 3 || !isDigits(fields[0]) {
+		// header line
+		return cmInstance{}, false
+	}
+	inst := cmInstance{Node: fields[0], NodeName: fields[1]}
+	for i := 2; i < len(fields); i++ {
+		if inst.Instance == "" && isDigits(fields[i]) {
+			inst.Instance = fields[i]
+			continue
+		}
+		if !cmRoles[strings.ToLower(fields[i])] {
+			continue
+		}
+		inst.Role = fields[i]
+		rest := fields[i+1:]
+		// two-word roles, e.g. Cascade Standby
+		if (strings.EqualFold(inst.Role, "Main") || strings.EqualFold(inst.Role, "Cascade")) && len(rest) > 0 {
+			inst.Role += " " + rest[0]
+			rest = rest[1:]
+		}
+		inst.State = strings.Join(rest, " ")
+		return inst, true
+	}
+	return cmInstance{}, false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const cmQueryOutput = `[  CMServer State   ]
+
+node        instance state
+-----------------------------
+1  node1 1    Primary
+2  node2 2    Standby
+
+[    Cluster State   ]
+
+cluster_state   : Normal
+redistributing  : No
+balanced        : Yes
+
+[  Datanode State   ]
+
+node       node_ip         instance             state            | node       node_ip         instance             state
+-----------------------------------------------------------------------------------------------------------------------
+1  node1 192.168.0.11    6001 /data/dn P Primary Normal | 2  node2 192.168.0.12    6002 /data/dn S Cascade Standby Need repair(Disconnected)
+`
+
+func Test_parseCMOutput(t *testing.T) {
+	status, err := parseCMOutput([]byte(cmQueryOutput))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Normal", status.ClusterState)
+	assert.Equal(t, []cmInstance{
+		{Node: "1", NodeName: "node1", Instance: "1", Type: "cmserver", Role: "Primary"},
+		{Node: "2", NodeName: "node2", Instance: "2", Type: "cmserver", Role: "Standby"},
+		{Node: "1", NodeName: "node1", Instance: "6001", Type: "datanode", Role: "Primary", State: "Normal"},
+		{Node: "2", NodeName: "node2", Instance: "6002", Type: "datanode", Role: "Cascade Standby", State: "Need repair(Disconnected)"},
+	}, status.Instances)
+
+	status, err = parseCMOutput([]byte(`{"cluster_state": "Degraded", "instances": [
+		{"node": "1", "node_name": "node1", "instance": "6001", "type": "datanode", "role": "Standby", "state": "Normal"}]}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Degraded", status.ClusterState)
+		assert.Len(t, status.Instances, 1)
+	}
+
+	_, err = parseCMOutput([]byte("cm_ctl: command not found"))
+	assert.Error(t, err)
+	_, err = parseCMOutput([]byte("{"))
+	assert.Error(t, err)
+}
+
+func Test_cmCollector_Collect(t *testing.T) {
+	collect := func(c Collector) (map[string]float64, error) {
+		ch := make(chan prometheus.Metric, 100)
+		err := c.Collect(context.Background(), nil, ServerInfo{Server: "localhost:5432", Namespace: "og", Master: true}, ch)
+		close(ch)
+		values := make(map[string]float64)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			key := metric.Desc().String()
+			for _, l := range m.Label {
+				if l.GetName() == "instance" {
+					key = l.GetValue()
+				}
+			}
+			if m.Counter != nil {
+				values[key] = m.GetCounter().GetValue()
+			}
+		}
+		return values, err
+	}
+	c := NewCMCollector([]string{"echo", `{"instances": [{"node": "1", "instance": "6001", "role": "Primary"}]}`}).(*cmCollector)
+	assert.True(t, c.Enabled(ServerInfo{Master: true}))
+	assert.False(t, c.Enabled(ServerInfo{}))
+	values, err := collect(c)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"6001": 0}, values)
+
+	// failover
+	c.command = []string{"echo", `{"instances": [{"node": "1", "instance": "6001", "role": "Standby"}]}`}
+	values, err = collect(c)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"6001": 1}, values)
+
+	c.command = []string{"false"}
+	_, err = collect(c)
+	assert.Error(t, err)
+}
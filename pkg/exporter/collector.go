@@ -0,0 +1,34 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCollector create the collection engine for embedding into other Go services.
+// It is registered into the registry given by WithRegistry, the caller registers it otherwise.
+//
+//	collector, err := exporter.NewCollector(
+//		exporter.WithDNS([]string{dsn}),
+//		exporter.WithRegistry(registry),
+//		exporter.WithLogger(logger),
+//		exporter.WithContext(ctx),
+//	)
+func NewCollector(opts ...Opt) (prometheus.Collector, error) {
+	e, err := NewExporter(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.Check(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if e.registry != nil {
+		if err = e.registry.Register(e); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
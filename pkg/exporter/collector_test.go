@@ -0,0 +1,71 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	commonlog "github.com/prometheus/common/log"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// collectorFixtures returns the dir of the fixtures of a mock server
+func collectorFixtures(t *testing.T) string {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "fixtures.yaml"), []byte("- sql: SELECT 1\n  columns: [one]\n  rows: [[1]]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// gatherCollector returns the metric families gathered from a collector of a mock server registered into a new
+// registry, and the output of its logger
+func gatherCollector(t *testing.T, ctx context.Context) (map[string]bool, string) {
+	var logs bytes.Buffer
+	registry := prometheus.NewRegistry()
+	c, err := NewCollector(WithDNS([]string{"postgres://monitor@localhost:5432/postgres?sslmode=disable"}),
+		WithMockFixtures(collectorFixtures(t)), WithRegistry(registry), WithLogger(commonlog.NewLogger(&logs)), WithContext(ctx))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer c.(*Exporter).Close()
+	// registered into the registry
+	assert.IsType(t, prometheus.AlreadyRegisteredError{}, registry.Register(c))
+	mfs, err := registry.Gather()
+	assert.NoError(t, err)
+	names := make(map[string]bool, len(mfs))
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	assert.True(t, registry.Unregister(c))
+	return names, logs.String()
+}
+
+func TestNewCollector(t *testing.T) {
+	names, logs := gatherCollector(t, context.Background())
+	assert.True(t, names["up"])
+	assert.True(t, names["exporter_query_executions_total"])
+	// the exporter logs with its logger, the logger of the package is unchanged
+	assert.Contains(t, logs, `Established new database connection to \"localhost:5432\"`)
+	assert.Equal(t, commonlog.Base(), log)
+
+	// the queries are canceled with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	names, _ = gatherCollector(t, ctx)
+	assert.True(t, names["up"])
+	assert.False(t, names["exporter_query_executions_total"])
+
+	// registration conflicts fail NewCollector
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up", Help: "Whether the last scrape succeeded."}))
+	_, err := NewCollector(WithDNS([]string{"postgres://monitor@localhost:5432/postgres?sslmode=disable"}),
+		WithMockFixtures(collectorFixtures(t)), WithRegistry(registry), WithLogger(commonlog.NewNopLogger()))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `fqName: "up"`)
+	}
+}
@@ -14,13 +14,43 @@ const (
 	HISTOGRAM    = "HISTOGRAM"
 	MappedMETRIC = "MAPPEDMETRIC"
 	DURATION     = "DURATION"
+	DELTA        = "DELTA"     // Use the increase of this cumulative column since the previous scrape as a gauge
+	TIMESTAMP    = "TIMESTAMP" // Use this timestamp column as a gauge of Unix seconds
+	BOOL         = "BOOL"      // Use this boolean column as a gauge of 1 or 0
 )
 
+// NULL value handling of a metric column
+const (
+	NullNaN  = "nan"  // emit NaN sample, the default when not set
+	NullSkip = "skip" // skip the sample
+	NullZero = "zero" // emit 0
+)
+
+var NullValuePolicy = map[string]bool{
+	NullNaN:  true,
+	NullSkip: true,
+	NullZero: true,
+}
+
+// handling of a value of a metric column out of its min and max
+const (
+	OutOfRangeClamp = "clamp" // emit the exceeded bound, the default when not set
+	OutOfRangeDrop  = "drop"  // skip the sample
+)
+
+var OutOfRangePolicy = map[string]bool{
+	OutOfRangeClamp: true,
+	OutOfRangeDrop:  true,
+}
+
 var ColumnUsage = map[string]bool{
-	DISCARD: true,
-	LABEL:   true,
-	COUNTER: true,
-	GAUGE:   true,
+	DISCARD:   true,
+	LABEL:     true,
+	COUNTER:   true,
+	GAUGE:     true,
+	DELTA:     true,
+	TIMESTAMP: true,
+	BOOL:      true,
 }
 
 type Column struct {
@@ -28,8 +58,30 @@ type Column struct {
 	Desc           string               `yaml:"description,omitempty"`
 	Usage          string               `yaml:"usage,omitempty"`
 	Rename         string               `yaml:"rename,omitempty"`
+	NullValue      string               `yaml:"null_value,omitempty"`   // how to handle NULL value: nan, skip, zero
+	Min            *float64             `yaml:"min,omitempty"`          // lowest sane value, e.g. 0 for an age
+	Max            *float64             `yaml:"max,omitempty"`          // highest sane value, e.g. 1 for a ratio
+	OutOfRange     string               `yaml:"out_of_range,omitempty"` // how to handle a value out of min and max: clamp, drop
+	Expr           string               `yaml:"expr,omitempty"`         // compute the value from other columns, e.g. a / (a + b)
+	Extract        *Extract             `yaml:"extract,omitempty"`      // capture the value from the text of another column
+	Label          *LabelTransform      `yaml:"label,omitempty"`        // normalize the values of a label column
+	DocURL         string               `yaml:"doc_url,omitempty"`      // documentation of the metric, appended to the help text
+	Template       string               `yaml:"template,omitempty"`     // reference to the columns of a template, see configTemplates
+	Alerts         []*Alert             `yaml:"alerts,omitempty"`       // alerting rules on the value, see GenerateRules
+	expression     *expression          `yaml:"-"`
 	DisCard        bool                 `yaml:"-"`
 	Histogram      bool                 `yaml:"-"` // Should metric be treated as a histogram?
 	PrometheusDesc *prometheus.Desc     `yaml:"-"`
 	PrometheusType prometheus.ValueType `yaml:"-"`
 }
+
+// bound returns value within the min and max of the column and whether it was out of them, NaN is kept
+func (c *Column) bound(value float64) (float64, bool) {
+	switch {
+	case c.Min != nil && value < *c.Min:
+		return *c.Min, true
+	case c.Max != nil && value > *c.Max:
+		return *c.Max, true
+	}
+	return value, false
+}
@@ -3,33 +3,35 @@
 package exporter
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"github.com/prometheus/common/log"
+	commonlog "github.com/prometheus/common/log"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
+	"sort"
 	"strings"
 )
 
+// LoadConfig load the queries of the config file or of the yaml files of the config dir, logging with the logger of
+// the package
 func LoadConfig(configPath string) (queries map[string]*QueryInstance, err error) {
+	return loadConfigPath(configPath, log)
+}
+
+// loadConfigPath load the queries of configPath like LoadConfig, logging with logger
+func loadConfigPath(configPath string, logger commonlog.Logger) (queries map[string]*QueryInstance, err error) {
 	stat, err := os.Stat(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("invalid config path: %s: %w", configPath, err)
 	}
 	if stat.IsDir() { // recursively iterate conf files if a dir is given
-		files, err := ioutil.ReadDir(configPath)
+		logger.Debugf("load config from dir: %s", configPath)
+		confFiles, err := configFiles(configPath)
 		if err != nil {
-			return nil, fmt.Errorf("fail reading config dir: %s: %w", configPath, err)
-		}
-
-		log.Debugf("load config from dir: %s", configPath)
-		confFiles := make([]string, 0)
-		for _, conf := range files {
-			if !strings.HasSuffix(conf.Name(), ".yaml") && !conf.IsDir() { // depth = 1
-				continue // skip non yaml files
-			}
-			confFiles = append(confFiles, path.Join(configPath, conf.Name()))
+			return nil, err
 		}
 
 		// make global config map and assign priority according to config file alphabetic orders
@@ -37,8 +39,8 @@ func LoadConfig(configPath string) (queries map[string]*QueryInstance, err error
 		queries = make(map[string]*QueryInstance)
 		var queryCount, configCount int
 		for _, confPath := range confFiles {
-			if singleQueries, err := LoadConfig(confPath); err != nil {
-				log.Warnf("skip config %s due to error: %s", confPath, err.Error())
+			if singleQueries, err := loadConfigPath(confPath, logger); err != nil {
+				logger.Warnf("skip config %s due to error: %s", confPath, err.Error())
 			} else {
 				configCount++
 				for name, query := range singleQueries {
@@ -50,41 +52,164 @@ func LoadConfig(configPath string) (queries map[string]*QueryInstance, err error
 				}
 			}
 		}
-		log.Debugf("load %d of %d queries from %d config files", len(queries), queryCount, configCount)
+		logger.Debugf("load %d of %d queries from %d config files", len(queries), queryCount, configCount)
+		if err := checkMetricNameCollisions(queries); err != nil {
+			return nil, err
+		}
 		return queries, nil
 	}
 
 	// single file case: recursive exit condition
-	content, err := ioutil.ReadFile(configPath)
+	queries, warnings, err := loadConfigFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("fail reading config file %s: %w", configPath, err)
+		return nil, err
 	}
-	queries, err = ParseConfig(content, stat.Name())
-	if err != nil {
+	for _, warning := range warnings {
+		logger.Warnf("config lint: %s", warning)
+	}
+	if err := checkMetricNameCollisions(queries); err != nil {
 		return nil, err
 	}
-	log.Debugf("load %d queries from %s, ", len(queries), configPath)
+	logger.Debugf("load %d queries from %s, ", len(queries), configPath)
 	return queries, nil
 
 }
 
-// ParseConfig turn config content into QueryInstance struct
+// configFiles returns the yaml files and the sub dirs of the config dir, in alphabetic order
+func configFiles(configPath string) ([]string, error) {
+	files, err := ioutil.ReadDir(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("fail reading config dir: %s: %w", configPath, err)
+	}
+	confFiles := make([]string, 0)
+	for _, conf := range files {
+		if !strings.HasSuffix(conf.Name(), ".yaml") && !conf.IsDir() { // depth = 1
+			continue // skip non yaml files
+		}
+		confFiles = append(confFiles, path.Join(configPath, conf.Name()))
+	}
+	return confFiles, nil
+}
+
+// configFileState is a config file, the sha256 of its content and whether it failed to load
+type configFileState struct {
+	path   string
+	hash   string
+	failed bool
+}
+
+// configFileStates returns the state of the config file, or of every file of the config dir recursively,
+// the files failing to load are skipped by LoadConfig
+func configFileStates(configPath string) []configFileState {
+	if stat, err := os.Stat(configPath); err == nil && stat.IsDir() {
+		files, err := configFiles(configPath)
+		if err != nil {
+			return nil
+		}
+		var states []configFileState
+		for _, file := range files {
+			states = append(states, configFileStates(file)...)
+		}
+		return states
+	}
+	content, err := ReadSecretFile(configPath)
+	if err != nil {
+		return []configFileState{{path: configPath, failed: true}}
+	}
+	state := configFileState{path: configPath, hash: fmt.Sprintf("%x", sha256.Sum256(content))}
+	queries, err := ParseConfig(content, configPath)
+	if err == nil {
+		err = checkMetricNameCollisions(queries)
+	}
+	state.failed = err != nil
+	return []configFileState{state}
+}
+
+// ParseConfig turn config content into QueryInstance struct.
+// The errors of all queries are returned as ConfigErrors, located by path and line
 func ParseConfig(content []byte, path string) (queries map[string]*QueryInstance, err error) {
 	queries = make(map[string]*QueryInstance)
-	if err = yaml.Unmarshal(content, &queries); err != nil {
-		return nil, fmt.Errorf("malformed config: %w", err)
+	// the targets are a list, parsed by ParseTargets
+	if err = yaml.Unmarshal(newConfigLines(content).without(configTargetsKey), &queries); err != nil {
+		return nil, yamlErrors(path, err)
+	}
+	var errs ConfigErrors
+	templates, err := parseTemplates(content)
+	if err != nil {
+		errs.addAll("", err)
+	}
+	delete(queries, configTemplatesKey)
+	if _, err := ParseTargets(content, ""); err != nil {
+		errs.addAll("", err)
 	}
 
 	// parse additional fields
-	for name, query := range queries {
+	for _, name := range sortedQueryKeys(queries) {
+		query := queries[name]
 		query.Path = path
 		if query.Name == "" {
 			query.Name = name
 		}
+		if err := expandTemplates(query, templates); err != nil {
+			errs.addAll(name, err)
+			continue
+		}
 		if err := query.Check(); err != nil {
-			return nil, err
+			errs.addAll(name, err)
 		}
-
+	}
+	if len(errs) > 0 {
+		errs.locate(path, content)
+		return nil, errs
 	}
 	return
 }
+
+// checkMetricNameCollisions report metrics emitted by different queries with the same name but different labels,
+// which produce inconsistent metric families on scrape.
+func checkMetricNameCollisions(queries map[string]*QueryInstance) error {
+	type emitter struct {
+		key   string
+		query *QueryInstance
+	}
+	keys := sortedQueryKeys(queries)
+
+	emitters := make(map[string]emitter)
+	var collisions []string
+	for _, key := range keys {
+		query := queries[key]
+		for _, metricName := range query.metricNames() {
+			first, ok := emitters[metricName]
+			if !ok {
+				emitters[metricName] = emitter{key: key, query: query}
+				continue
+			}
+			if first.key == key || reflect.DeepEqual(first.query.LabelNames, query.LabelNames) {
+				continue
+			}
+			collisions = append(collisions, fmt.Sprintf("metric %s of query %s (file %s, labels %v) collides with query %s (file %s, labels %v)",
+				metricName, key, configFileName(query.Path), query.LabelNames, first.key, configFileName(first.query.Path), first.query.LabelNames))
+		}
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("duplicate metric names with different labels: %s", strings.Join(collisions, "; "))
+	}
+	return nil
+}
+
+// sortedQueryKeys returns the config keys of queries in order
+func sortedQueryKeys(queries map[string]*QueryInstance) []string {
+	keys := make([]string, 0, len(queries))
+	for key := range queries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func configFileName(path string) string {
+	if path == "" {
+		return "built-in"
+	}
+	return path
+}
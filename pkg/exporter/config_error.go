@@ -0,0 +1,322 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is an invalid item of a config file, located by file, line, query, column and field when known
+type ConfigError struct {
+	Path   string
+	Line   int
+	Query  string
+	Column string
+	Field  string // yaml key, items of the query list are written query[i].key
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d", e.Line)
+		}
+		b.WriteString(": ")
+	}
+	if e.Query != "" {
+		fmt.Fprintf(&b, "query %s: ", e.Query)
+	}
+	if e.Column != "" {
+		fmt.Fprintf(&b, "column %s: ", e.Column)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, "%s: ", e.Field)
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors are all the errors found in a config, instead of the first one only
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add record the error of field of a column, column and field are optional
+func (e *ConfigErrors) add(query, column, field string, err error) {
+	*e = append(*e, &ConfigError{Query: query, Column: column, Field: field, Err: err})
+}
+
+// addAll record the errors of err, found in the query of the config key query if not empty
+func (e *ConfigErrors) addAll(query string, err error) {
+	var errs ConfigErrors
+	if errors.As(err, &errs) {
+		for _, err := range errs {
+			if query != "" {
+				err.Query = query
+			}
+		}
+		*e = append(*e, errs...)
+		return
+	}
+	e.add(query, "", "", err)
+}
+
+// err returns nil if there is no error
+func (e ConfigErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// locate set the file and line of the errors found in content
+func (e ConfigErrors) locate(path string, content []byte) {
+	lines := newConfigLines(content)
+	for _, err := range e {
+		err.Path = path
+		if err.Line == 0 {
+			err.Line = lines.locate(err.Query, err.Column, err.Field)
+		}
+	}
+}
+
+var yamlLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// yamlErrors turn the decoding errors of yaml into located errors, yaml reports every type error of the document
+func yamlErrors(path string, err error) ConfigErrors {
+	messages := []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+	errs := make(ConfigErrors, 0, len(messages))
+	for _, message := range messages {
+		e := &ConfigError{Path: path}
+		if m := yamlLineRegex.FindStringSubmatch(message); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			message = m[2]
+		}
+		e.Err = fmt.Errorf("malformed config: %s", message)
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// configLines locate the items of a config file by their keys, as yaml.v2 doesn't keep the position of values.
+// It follows the indentation of block style yaml, the usual style of config files
+type configLines struct {
+	lines []string
+}
+
+// configScope is a mapping of the config: lines [start, end), start is the line of its key or list item
+type configScope struct {
+	start, end int
+}
+
+var queryItemRegex = regexp.MustCompile(`^(\w+)\[(\d+)\]\.(.+)$`)
+
+func newConfigLines(content []byte) *configLines {
+	return &configLines{lines: strings.Split(string(content), "\n")}
+}
+
+// locate returns the 1-based line of field of column of query, the line of the closest parent if not found.
+// Without query, field is located from the top level keys, e.g. targets[0].dsn
+func (l *configLines) locate(query, column, field string) int {
+	scope := configScope{start: -1, end: len(l.lines)}
+	if query != "" {
+		start := l.find(scope, query)
+		if start < 0 {
+			return 0
+		}
+		scope = l.scope(start)
+	}
+	if column != "" {
+		metrics := l.find(scope, "metrics")
+		if metrics < 0 {
+			return scope.start + 1
+		}
+		item := -1
+		for _, i := range l.items(l.scope(metrics)) {
+			if name := l.find(l.scope(i), "name"); name >= 0 && l.value(name) == column {
+				item = i
+				break
+			}
+		}
+		if item < 0 {
+			return scope.start + 1
+		}
+		scope = l.scope(item)
+	}
+	if field == "" {
+		return scope.start + 1
+	}
+	if m := queryItemRegex.FindStringSubmatch(field); m != nil {
+		list := l.find(scope, m[1])
+		if list < 0 {
+			return scope.start + 1
+		}
+		items := l.items(l.scope(list))
+		i, _ := strconv.Atoi(m[2])
+		if i >= len(items) {
+			return list + 1
+		}
+		scope, field = l.scope(items[i]), m[3]
+	}
+	// keys of nested mappings are joined by dots, e.g. topn.by
+	for {
+		key, rest := field, ""
+		if i := strings.IndexByte(field, '.'); i >= 0 {
+			key, rest = field[:i], field[i+1:]
+		}
+		i := l.find(scope, key)
+		if i < 0 {
+			return scope.start + 1
+		}
+		if rest == "" {
+			return i + 1
+		}
+		scope, field = l.scope(i), rest
+	}
+}
+
+// find returns the line of key among the direct children of scope, -1 if not found
+func (l *configLines) find(scope configScope, key string) int {
+	indent := -1
+	if scope.start >= 0 && isListItem(l.lines[scope.start]) {
+		// the first key of a list item is on the line of the item
+		indent = keyIndent(l.lines[scope.start])
+		if l.key(scope.start) == key {
+			return scope.start
+		}
+	}
+	for i := scope.start + 1; i < scope.end; i++ {
+		if isBlank(l.lines[i]) {
+			continue
+		}
+		if indent < 0 {
+			indent = keyIndent(l.lines[i])
+		}
+		if keyIndent(l.lines[i]) == indent && l.key(i) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// scope returns the lines of the value of the key or list item at line start
+func (l *configLines) scope(start int) configScope {
+	item := isListItem(l.lines[start])
+	base := indentOf(l.lines[start])
+	end := start + 1
+	for ; end < len(l.lines); end++ {
+		line := l.lines[end]
+		if isBlank(line) {
+			continue
+		}
+		indent := indentOf(line)
+		// list items may be at the same indentation as their key
+		if indent < base || indent == base && (item || !isListItem(line)) {
+			break
+		}
+	}
+	return configScope{start: start, end: end}
+}
+
+// items returns the lines of the items of the list in scope
+func (l *configLines) items(scope configScope) []int {
+	var items []int
+	indent := -1
+	for i := scope.start + 1; i < scope.end; i++ {
+		line := l.lines[i]
+		if isBlank(line) || !isListItem(line) {
+			continue
+		}
+		if indent < 0 {
+			indent = indentOf(line)
+		}
+		if indentOf(line) == indent {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// key returns the key of the line, without quotes
+func (l *configLines) key(i int) string {
+	line := strings.TrimSpace(l.lines[i])
+	line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return ""
+	}
+	return strings.Trim(line[:colon], `"'`)
+}
+
+// value returns the scalar value of the line, without quotes and comment
+func (l *configLines) value(i int) string {
+	line := strings.TrimSpace(l.lines[i])
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return ""
+	}
+	value := line[colon+1:]
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = value[:comment]
+	}
+	return strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+func isBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+func isListItem(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "-" || strings.HasPrefix(line, "- ")
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// keyIndent returns the column of the key of the line, after the dash of a list item
+func keyIndent(line string) int {
+	indent := indentOf(line)
+	if isListItem(line) {
+		rest := strings.TrimSpace(line)[1:]
+		indent += 1 + len(rest) - len(strings.TrimLeft(rest, " "))
+	}
+	return indent
+}
+
+// without returns the content with the lines of the top level key blanked, keeping the line numbers of the others
+func (l *configLines) without(key string) []byte {
+	start := l.find(configScope{start: -1, end: len(l.lines)}, key)
+	if start < 0 {
+		return []byte(strings.Join(l.lines, "\n"))
+	}
+	lines := append([]string{}, l.lines...)
+	scope := l.scope(start)
+	for i := scope.start; i < scope.end; i++ {
+		lines[i] = ""
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
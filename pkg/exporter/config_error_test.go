@@ -0,0 +1,100 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseConfig_errors(t *testing.T) {
+	content := []byte(`pg_lock:
+  name: pg_locks
+  scope: instance
+  query:
+  - sql: SELECT datname, count FROM pg_locks
+    version: '>=1.0.0'
+  - sql: SELECT datname, count FROM pg_locks
+    version: 'not a range'
+  metrics:
+  - name: datname
+    usage: LABEL
+  # counted locks
+  - name: count
+    usage: GAUGE
+    null_value: ignore
+    expr: count +
+pg_database:
+  query:
+  - sql: SELECT datname FROM pg_database
+  metrics:
+    - name: datname
+      usage: TAG
+`)
+	queries, err := ParseConfig(content, "conf/lock.yaml")
+	assert.Nil(t, queries)
+	errs, ok := err.(ConfigErrors)
+	if !assert.True(t, ok, "%T", err) {
+		return
+	}
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	assert.Len(t, messages, 5)
+	assert.Equal(t, "conf/lock.yaml:22: query pg_database: column datname: usage: unsupported usage: TAG", messages[0])
+	assert.Equal(t, "conf/lock.yaml:3: query pg_lock: scope: unsupported scope: instance", messages[1])
+	assert.Contains(t, messages[2], "conf/lock.yaml:8: query pg_lock: query[1].version: ")
+	assert.Equal(t, "conf/lock.yaml:15: query pg_lock: column count: null_value: unsupported null_value: ignore", messages[3])
+	assert.Contains(t, messages[4], "conf/lock.yaml:16: query pg_lock: column count: expr: ")
+	assert.Equal(t, 22, errs[0].Line)
+	assert.Equal(t, "usage", errs[0].Field)
+}
+
+func TestParseConfig_yamlErrors(t *testing.T) {
+	_, err := ParseConfig([]byte(`pg_lock:
+  ttl: ten
+  timeout: one
+`), "lock.yaml")
+	assert.EqualError(t, err, "lock.yaml:2: malformed config: cannot unmarshal !!str `ten` into float64; "+
+		"lock.yaml:3: malformed config: cannot unmarshal !!str `one` into float64")
+
+	_, err = ParseConfig([]byte("pg_lock:\n  ttl: [\n"), "lock.yaml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "lock.yaml:")
+	assert.Contains(t, err.Error(), "malformed config: ")
+}
+
+func Test_configLines_locate(t *testing.T) {
+	lines := newConfigLines([]byte(`templates:
+  labels:
+  - name: datname
+pg_lock:
+  query:
+  - sql: SELECT 1
+    exec:
+      command: [true]
+  metrics:
+  - template: labels
+  - name: count
+    usage: GAUGE
+`))
+	tests := []struct {
+		query, column, field string
+		want                 int
+	}{
+		{"", "", "templates", 1},
+		{"pg_lock", "", "", 4},
+		{"pg_lock", "", "query[0].exec", 7},
+		{"pg_lock", "", "query[0].sql", 6},
+		{"pg_lock", "", "query[3].sql", 5},
+		{"pg_lock", "count", "", 11},
+		{"pg_lock", "count", "usage", 12},
+		{"pg_lock", "count", "expr", 11},
+		{"pg_lock", "datname", "usage", 4},
+		{"pg_missing", "", "", 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, lines.locate(tt.query, tt.column, tt.field), "%v", tt)
+	}
+}
@@ -3,7 +3,14 @@
 package exporter
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -150,3 +157,143 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_checkMetricNameCollisions(t *testing.T) {
+	content := []byte(`pg_lock:
+  query:
+  - sql: SELECT datname, count FROM pg_locks
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+pg_lock_mode:
+  name: pg_lock
+  query:
+  - sql: SELECT mode, count FROM pg_locks
+  metrics:
+  - name: mode
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+`)
+	queries, err := ParseConfig(content, "lock.yaml")
+	assert.NoError(t, err)
+	err = checkMetricNameCollisions(queries)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "metric pg_lock_count of query pg_lock_mode (file lock.yaml, labels [mode])")
+
+	queries["pg_lock_mode"].Metrics[0].Name = "datname"
+	assert.NoError(t, queries["pg_lock_mode"].Check())
+	assert.NoError(t, checkMetricNameCollisions(queries))
+}
+
+func TestParseConfig_templates(t *testing.T) {
+	content := []byte(`templates:
+  db_labels:
+  - name: datname
+    usage: LABEL
+  - name: usename
+    usage: LABEL
+pg_activity:
+  query:
+  - sql: SELECT datname, usename, count(*) AS count FROM pg_stat_activity GROUP BY 1, 2
+  metrics:
+  - template: db_labels
+  - name: count
+    usage: GAUGE
+pg_locks:
+  query:
+  - sql: SELECT datname, usename, count(*) AS count FROM pg_locks GROUP BY 1, 2
+  metrics:
+  - template: db_labels
+  - name: count
+    usage: GAUGE
+`)
+	queries, err := ParseConfig(content, "templates.yaml")
+	assert.NoError(t, err)
+	assert.Len(t, queries, 2)
+	assert.NotContains(t, queries, "templates")
+	for _, name := range []string{"pg_activity", "pg_locks"} {
+		q := queries[name]
+		if assert.Len(t, q.Metrics, 3) {
+			assert.Equal(t, "datname", q.Metrics[0].Name)
+			assert.Equal(t, "usename", q.Metrics[1].Name)
+			assert.Equal(t, "count", q.Metrics[2].Name)
+		}
+		assert.Equal(t, []string{"datname", "usename"}, q.LabelNames)
+	}
+	// columns are copied
+	assert.False(t, queries["pg_activity"].Metrics[0] == queries["pg_locks"].Metrics[0])
+
+	_, err = ParseConfig([]byte(`pg_activity:
+  query:
+  - sql: SELECT 1 AS count
+  metrics:
+  - template: missing
+`), "templates.yaml")
+	assert.EqualError(t, err, "templates.yaml:4: query pg_activity: metrics: undefined template missing")
+
+	_, err = ParseConfig([]byte(`templates:
+  db_labels:
+  - name: datname
+    usage: LABEL
+pg_activity:
+  query:
+  - sql: SELECT 1 AS count
+  metrics:
+  - template: db_labels
+    name: count
+`), "templates.yaml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a template reference has no other field")
+}
+
+func Test_configFileStates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	good := []byte("app_orders:\n  query:\n    - name: app_orders\n      sql: SELECT 1 AS orders\n      version: '>=0.0.0'\n" +
+		"  metrics:\n    - name: orders\n      description: orders\n      usage: GAUGE\n")
+	bad := []byte("app_broken:\n  query: SELECT 1\n  metrics: not a list\n")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{"a.yaml": good, "b.yaml": bad, "notes.txt": good, "sub/c.yaml": good} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(content []byte) string { return fmt.Sprintf("%x", sha256.Sum256(content)) }
+	assert.Equal(t, []configFileState{
+		{path: filepath.Join(dir, "a.yaml"), hash: hash(good)},
+		{path: filepath.Join(dir, "b.yaml"), hash: hash(bad), failed: true},
+		{path: filepath.Join(dir, "sub/c.yaml"), hash: hash(good)},
+	}, configFileStates(dir))
+	assert.Equal(t, []configFileState{{path: filepath.Join(dir, "missing.yaml"), failed: true}},
+		configFileStates(filepath.Join(dir, "missing.yaml")))
+
+	e := &Exporter{namespace: "og", configFiles: configFileStates(dir)}
+	e.setupInternalMetrics()
+	assert.Equal(t, 3, testutil.CollectAndCount(e.configFileError))
+	assert.Equal(t, 0.0, testutil.ToFloat64(e.configFileError.WithLabelValues(filepath.Join(dir, "a.yaml"), hash(good))))
+	assert.Equal(t, 1.0, testutil.ToFloat64(e.configFileError.WithLabelValues(filepath.Join(dir, "b.yaml"), hash(bad))))
+}
+
+func TestLoadConfig_statio(t *testing.T) {
+	queries, err := LoadConfig("../../og_exporter_default.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	version := semver.MustParse("3.0.0")
+	for _, name := range []string{"pg_statio_tables", "pg_statio_indexes"} {
+		if assert.Contains(t, queries, name) {
+			assert.Equal(t, defaultMonList[name].Queries[0].SQL, queries[name].Queries[0].SQL)
+			assert.Equal(t, 300.0, queries[name].TTL)
+			assert.Nil(t, queries[name].getServerQuery(version, nil), "only run on the targets tagged statio")
+			assert.NotNil(t, queries[name].getServerQuery(version, []string{"primary", "statio"}))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	dashboardPanelWidth  = 12 // two panels per line, the grid is 24 wide
+	dashboardPanelHeight = 8
+)
+
+// grafanaDashboard is the subset of the Grafana dashboard model used by generated dashboards
+type grafanaDashboard struct {
+	Title         string           `json:"title"`
+	UID           string           `json:"uid"`
+	Tags          []string         `json:"tags"`
+	Editable      bool             `json:"editable"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          grafanaTimeRange `json:"time"`
+	Refresh       string           `json:"refresh"`
+	Templating    struct {
+		List []grafanaVariable `json:"list"`
+	} `json:"templating"`
+	Panels []*grafanaPanel `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaVariable struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	Query      string `json:"query"`
+	Datasource string `json:"datasource,omitempty"`
+	Multi      bool   `json:"multi,omitempty"`
+	IncludeAll bool   `json:"includeAll,omitempty"`
+	Refresh    int    `json:"refresh,omitempty"`
+}
+
+type grafanaPanel struct {
+	ID          int              `json:"id"`
+	Type        string           `json:"type"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Datasource  string           `json:"datasource,omitempty"`
+	GridPos     grafanaGridPos   `json:"gridPos"`
+	Collapsed   bool             `json:"collapsed,omitempty"`
+	FieldConfig *grafanaFields   `json:"fieldConfig,omitempty"`
+	Targets     []*grafanaTarget `json:"targets,omitempty"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaFields struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+}
+
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+// GenerateDashboard returns the Grafana dashboard JSON of the enabled queries:
+// a row per query and a panel per metric column, the unit is derived from the usage and the suffix of the metric
+func GenerateDashboard(queries map[string]*QueryInstance, title string) ([]byte, error) {
+	d := &grafanaDashboard{
+		Title:         title,
+		UID:           "opengauss-exporter",
+		Tags:          []string{"opengauss", "generated"},
+		Editable:      true,
+		SchemaVersion: 27,
+		Time:          grafanaTimeRange{From: "now-6h", To: "now"},
+		Refresh:       "1m",
+	}
+	d.Templating.List = []grafanaVariable{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		{Name: "instance", Label: "Instance", Type: "query", Datasource: "$datasource",
+			Query: "label_values(instance)", Multi: true, IncludeAll: true, Refresh: 2},
+	}
+	var y int
+	for _, key := range sortedQueryKeys(queries) {
+		q := queries[key]
+		if q.Status == statusDisable {
+			continue
+		}
+		var panels []*grafanaPanel
+		for _, col := range q.Metrics {
+			if col.Usage == LABEL || col.Usage == DISCARD {
+				continue
+			}
+			panels = append(panels, dashboardPanel(q, col))
+		}
+		if len(panels) == 0 {
+			continue
+		}
+		d.Panels = append(d.Panels, &grafanaPanel{Type: "row", Title: q.Name, Description: q.Desc,
+			GridPos: grafanaGridPos{H: 1, W: 2 * dashboardPanelWidth, Y: y}})
+		y++
+		for i, panel := range panels {
+			panel.GridPos = grafanaGridPos{H: dashboardPanelHeight, W: dashboardPanelWidth,
+				X: i % 2 * dashboardPanelWidth, Y: y + i/2*dashboardPanelHeight}
+		}
+		y += (len(panels) + 1) / 2 * dashboardPanelHeight
+		d.Panels = append(d.Panels, panels...)
+	}
+	for i, panel := range d.Panels {
+		panel.ID = i + 1
+	}
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// dashboardPanel returns the time series panel of a metric column, counters are graphed as rates
+func dashboardPanel(q *QueryInstance, col *Column) *grafanaPanel {
+	name := q.columnMetricName(col)
+	counter := col.Usage == COUNTER || strings.HasSuffix(name, "_total")
+	expr := fmt.Sprintf(`%s{instance=~"$instance"}`, name)
+	if counter {
+		expr = fmt.Sprintf("rate(%s[$__rate_interval])", expr)
+	}
+	legend := []string{"{{instance}}"}
+	for _, label := range q.LabelNames {
+		legend = append(legend, fmt.Sprintf("{{%s}}", label))
+	}
+	panel := &grafanaPanel{
+		Type:        "timeseries",
+		Title:       name,
+		Description: col.help(),
+		Datasource:  "$datasource",
+		FieldConfig: &grafanaFields{},
+		Targets:     []*grafanaTarget{{Expr: expr, LegendFormat: strings.Join(legend, " "), RefID: "A"}},
+	}
+	panel.FieldConfig.Defaults.Unit = dashboardUnit(name, counter)
+	return panel
+}
+
+// dashboardUnit returns the Grafana unit of a metric by its suffix, counters are rates per second
+func dashboardUnit(name string, counter bool) string {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		if counter {
+			return "Bps"
+		}
+		return "bytes"
+	case strings.HasSuffix(name, "_seconds"):
+		if counter {
+			return "percentunit" // seconds per second
+		}
+		return "s"
+	case strings.HasSuffix(name, "_milliseconds"):
+		return "ms"
+	case strings.HasSuffix(name, "_ratio"):
+		return "percentunit"
+	case strings.HasSuffix(name, "_percent"):
+		return "percent"
+	case counter:
+		return "ops"
+	}
+	return "short"
+}
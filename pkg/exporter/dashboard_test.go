@@ -0,0 +1,74 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	queries, err := ParseConfig([]byte(`pg_database:
+  desc: Database statistics
+  query:
+  - sql: SELECT datname, size_bytes, xact_commit, blk_read_time FROM pg_stat_database
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: size_bytes
+    usage: GAUGE
+    description: Disk space used by the database
+  - name: xact_commit
+    usage: COUNTER
+  - name: blk_read_time_seconds
+    usage: COUNTER
+pg_disabled:
+  status: disable
+  query:
+  - sql: SELECT 1 AS one
+  metrics:
+  - name: one
+    usage: GAUGE
+pg_info:
+  query:
+  - sql: SELECT version() AS version
+  metrics:
+  - name: version
+    usage: LABEL
+`), "dashboard.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	buf, err := GenerateDashboard(queries, "openGauss")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var d grafanaDashboard
+	if !assert.NoError(t, json.Unmarshal(buf, &d)) {
+		return
+	}
+	assert.Equal(t, "openGauss", d.Title)
+	if !assert.Len(t, d.Panels, 4) {
+		return
+	}
+	row := d.Panels[0]
+	assert.Equal(t, "row", row.Type)
+	assert.Equal(t, "pg_database", row.Title)
+	assert.Equal(t, 1, row.ID)
+
+	size, commit, readTime := d.Panels[1], d.Panels[2], d.Panels[3]
+	assert.Equal(t, "pg_database_size_bytes", size.Title)
+	assert.Equal(t, "Disk space used by the database", size.Description)
+	assert.Equal(t, "bytes", size.FieldConfig.Defaults.Unit)
+	assert.Equal(t, `pg_database_size_bytes{instance=~"$instance"}`, size.Targets[0].Expr)
+	assert.Equal(t, "{{instance}} {{datname}}", size.Targets[0].LegendFormat)
+	assert.Equal(t, grafanaGridPos{H: 8, W: 12, X: 0, Y: 1}, size.GridPos)
+
+	assert.Equal(t, `rate(pg_database_xact_commit{instance=~"$instance"}[$__rate_interval])`, commit.Targets[0].Expr)
+	assert.Equal(t, "ops", commit.FieldConfig.Defaults.Unit)
+	assert.Equal(t, grafanaGridPos{H: 8, W: 12, X: 12, Y: 1}, commit.GridPos)
+
+	assert.Equal(t, "percentunit", readTime.FieldConfig.Defaults.Unit)
+	assert.Equal(t, grafanaGridPos{H: 8, W: 12, X: 0, Y: 9}, readTime.GridPos)
+}
@@ -8,8 +8,10 @@ package exporter
 
 var (
 	pgLock = &QueryInstance{
-		Name: "pg_lock",
-		Desc: "OpenGauss lock distribution by mode",
+		Name:   "pg_lock",
+		Desc:   "OpenGauss lock distribution by mode",
+		Scope:  scopeCluster,
+		Groups: []string{"locks"},
 		Queries: []*Query{
 			{
 				SupportedVersions: ">=0.0.0",
@@ -32,8 +34,11 @@ WHERE database IS NOT NULL GROUP BY database, mode) cnt USING (database, mode);`
 		},
 	}
 	pgStatReplication = &QueryInstance{
-		Name: "pg_stat_replication",
-		Desc: "",
+		Name:   "pg_stat_replication",
+		Desc:   "",
+		Scope:  scopeCluster,
+		Tier:   TierCritical,
+		Groups: []string{"replication"},
 		Queries: []*Query{
 			{
 				Name: "pg_stat_replication",
@@ -96,8 +101,10 @@ FROM pg_stat_replication`,
 		},
 	}
 	pgStatActivity = &QueryInstance{
-		Name: "pg_stat_activity",
-		Desc: "OpenGauss backend activity group by state",
+		Name:  "pg_stat_activity",
+		Desc:  "OpenGauss backend activity group by state",
+		Scope: scopeCluster,
+		Tier:  TierCritical,
 		Queries: []*Query{
 			{
 				SQL: `SELECT datname,
@@ -132,8 +139,9 @@ FROM (SELECT d.oid AS database, d.datname, a.state
 		},
 	}
 	pgDatabase = &QueryInstance{
-		Name: "pg_database",
-		Desc: "OpenGauss Database size",
+		Name:  "pg_database",
+		Desc:  "OpenGauss Database size",
+		Scope: scopeCluster,
 		Queries: []*Query{
 			{
 				SQL:               `SELECT pg_database.datname, pg_database_size(pg_database.datname) as size_bytes FROM pg_database where datname NOT IN ('template0','template1')`,
@@ -146,8 +154,9 @@ FROM (SELECT d.oid AS database, d.datname, a.state
 		},
 	}
 	pgStatBgWriter = &QueryInstance{
-		Name: "pg_stat_bgwriter",
-		Desc: "OpenGauss background writer metrics",
+		Name:  "pg_stat_bgwriter",
+		Desc:  "OpenGauss background writer metrics",
+		Scope: scopeCluster,
 		Queries: []*Query{
 			{
 				SQL: `SELECT checkpoints_timed,
@@ -180,8 +189,10 @@ FROM pg_stat_bgwriter`,
 		},
 	}
 	pgStatDatabase = &QueryInstance{
-		Name: "pg_stat_database",
-		Desc: "OpenGauss database statistics",
+		Name:  "pg_stat_database",
+		Desc:  "OpenGauss database statistics",
+		Scope: scopeCluster,
+		Tier:  TierCritical,
 		Queries: []*Query{
 			{
 				SQL:               "select * from pg_stat_database where datname NOT IN ('template0','template1')",
@@ -211,8 +222,9 @@ FROM pg_stat_bgwriter`,
 		},
 	}
 	pgStatDatabaseConflicts = &QueryInstance{
-		Name: "pg_stat_database_conflicts",
-		Desc: "OpenGauss database statistics conflicts",
+		Name:  "pg_stat_database_conflicts",
+		Desc:  "OpenGauss database statistics conflicts",
+		Scope: scopeCluster,
 		Queries: []*Query{
 			{
 				SQL:               "select * from pg_stat_database_conflicts where datname NOT IN ('template0','template1')",
@@ -232,6 +244,288 @@ FROM pg_stat_bgwriter`,
 )
 
 var (
+	pgThreadWaitStatus = &QueryInstance{
+		Name:   "pg_thread_wait_status",
+		Desc:   "OpenGauss sessions group by wait status",
+		Scope:  scopeCluster,
+		Groups: []string{"locks"},
+		Queries: []*Query{
+			{
+				SQL: `SELECT coalesce(db_name, '')    AS datname,
+       wait_status,
+       coalesce(wait_event, '') AS wait_event,
+       coalesce(lockmode, '')   AS lockmode,
+       count(*)                 AS count
+FROM pg_thread_wait_status
+GROUP BY 1, 2, 3, 4`,
+				SupportedVersions: ">=1.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of the database the session is connected to"},
+			{Name: "wait_status", Usage: LABEL, Desc: "wait status of the session, none if not waiting"},
+			{Name: "wait_event", Usage: LABEL, Desc: "event the session is waiting for, e.g. the LWLock name"},
+			{Name: "lockmode", Usage: LABEL, Desc: "mode of the lock the session is waiting for"},
+			{Name: "count", Usage: GAUGE, Desc: "number of sessions in this wait status"},
+		},
+	}
+	pgPreparedXacts = &QueryInstance{
+		Name:  "pg_prepared_xacts",
+		Desc:  "OpenGauss prepared transactions (two-phase commit) by database",
+		Scope: scopeCluster,
+		Queries: []*Query{
+			{
+				SQL: `SELECT d.datname,
+       count(p.gid)                                                   AS count,
+       coalesce(max(extract(epoch FROM now() - p.prepared)), 0)::float AS max_age_seconds
+FROM pg_database d
+         LEFT JOIN pg_prepared_xacts p ON p.database = d.datname
+WHERE d.datname NOT IN ('template0','template1')
+GROUP BY d.datname`,
+				SupportedVersions: ">=0.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of this database"},
+			{Name: "count", Usage: GAUGE, Desc: "number of prepared transactions, orphaned ones block vacuum"},
+			{Name: "max_age_seconds", Usage: GAUGE, Desc: "age in seconds of the oldest prepared transaction"},
+		},
+	}
+	pgInstanceTime = &QueryInstance{
+		Name:  "pg_instance_time",
+		Desc:  "OpenGauss time model of the instance",
+		Scope: scopeCluster,
+		Queries: []*Query{
+			{
+				SQL:               `SELECT lower(stat_name) AS stat_name, value::float / 1000000 AS seconds_total FROM dbe_perf.instance_time`,
+				SupportedVersions: ">=1.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "stat_name", Usage: LABEL, Desc: "component of the time model, e.g. db_time, cpu_time, execution_time, parse_time, pl_execution_time"},
+			{Name: "seconds_total", Usage: COUNTER, Desc: "time spent in the component since the instance started, in seconds"},
+		},
+	}
+	pgDolphinSettings = &QueryInstance{
+		Name:      "pg_dolphin_settings",
+		Desc:      "OpenGauss settings of the dolphin (B-compatibility) extension",
+		Extension: "dolphin",
+		Queries: []*Query{
+			{
+				SQL: `SELECT current_database() AS datname, name,
+       CASE vartype WHEN 'bool' THEN (setting = 'on')::int::float ELSE setting::float END AS value
+FROM pg_settings
+WHERE name LIKE 'dolphin.%' AND vartype IN ('bool', 'integer', 'real')`,
+				SupportedVersions: ">=3.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of the B-compatibility database"},
+			{Name: "name", Usage: LABEL, Desc: "name of the dolphin setting, e.g. dolphin.b_compatibility_mode"},
+			{Name: "value", Usage: GAUGE, Desc: "value of the setting, 1 or 0 for booleans"},
+		},
+	}
+	pgDolphinObjects = &QueryInstance{
+		Name:      "pg_dolphin_objects",
+		Desc:      "OpenGauss user objects of a B-compatibility database by kind",
+		Extension: "dolphin",
+		Queries: []*Query{
+			{
+				SQL: `WITH user_namespace AS (
+  SELECT n.oid FROM pg_namespace n
+  WHERE (n.nspname = 'public' OR n.oid >= 16384) AND n.nspname NOT LIKE 'pg\_%'
+    AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_namespace'::regclass AND d.objid = n.oid AND d.deptype = 'e')
+)
+SELECT current_database() AS datname, kind, count(*) AS count FROM (
+  SELECT CASE c.relkind WHEN 'r' THEN 'table' WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized_view'
+           WHEN 'i' THEN 'index' WHEN 'S' THEN 'sequence' WHEN 'f' THEN 'foreign_table' ELSE 'other' END AS kind
+  FROM pg_class c WHERE c.relnamespace IN (SELECT oid FROM user_namespace)
+  UNION ALL
+  SELECT 'function' FROM pg_proc p WHERE p.pronamespace IN (SELECT oid FROM user_namespace)
+) objects
+GROUP BY kind`,
+				SupportedVersions: ">=3.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of the B-compatibility database"},
+			{Name: "kind", Usage: LABEL, Desc: "kind of object: table, view, materialized_view, index, sequence, foreign_table, function or other"},
+			{Name: "count", Usage: GAUGE, Desc: "number of user objects of this kind, the objects of extensions excluded"},
+		},
+	}
+	pgDolphinSessions = &QueryInstance{
+		Name:      "pg_dolphin_sessions",
+		Desc:      "OpenGauss sessions connected to a B-compatibility database by protocol",
+		Extension: "dolphin",
+		Queries: []*Query{
+			{
+				SQL: `SELECT current_database() AS datname,
+       CASE WHEN lower(coalesce(connection_info, '') || coalesce(application_name, '')) ~ '(mysql|mariadb)'
+            THEN 'mysql' ELSE 'postgresql' END AS protocol,
+       count(*) AS count
+FROM pg_stat_activity
+WHERE datname = current_database()
+GROUP BY 1, 2`,
+				SupportedVersions: ">=3.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of the B-compatibility database"},
+			{Name: "protocol", Usage: LABEL, Desc: "protocol of the sessions, mysql for the clients of the dolphin MySQL protocol, else postgresql"},
+			{Name: "count", Usage: GAUGE, Desc: "number of sessions connected to the database with this protocol"},
+		},
+	}
+	pgBadBlock = &QueryInstance{
+		Name:  "pg_bad_block",
+		Desc:  "OpenGauss bad blocks detected when reading data files, by relation file",
+		Scope: scopeCluster,
+		Queries: []*Query{
+			{
+				SQL: `SELECT coalesce(d.datname, b.databaseid::text)   AS datname,
+       coalesce(t.spcname, b.tablespaceid::text) AS tablespace,
+       b.relfilenode::text                       AS relfilenode,
+       b.forknum::text                           AS forknum,
+       b.error_count,
+       b.first_time                              AS first_time_seconds,
+       b.last_time                               AS last_time_seconds
+FROM gs_stat_bad_block b
+         LEFT JOIN pg_database d ON d.oid = b.databaseid
+         LEFT JOIN pg_tablespace t ON t.oid = b.tablespaceid`,
+				SupportedVersions: ">=1.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of the database of the file"},
+			{Name: "tablespace", Usage: LABEL, Desc: "Name of the tablespace of the file"},
+			{Name: "relfilenode", Usage: LABEL, Desc: "File node of the relation"},
+			{Name: "forknum", Usage: LABEL, Desc: "Fork of the relation: 0 main, 1 free space map, 2 visibility map"},
+			{Name: "error_count", Usage: COUNTER, Desc: "number of bad blocks read from the file since the last reset of the statistics",
+				Alerts: []*Alert{{Threshold: 0, Severity: "critical", Summary: "bad blocks read from a data file, the storage may be corrupted"}}},
+			{Name: "first_time_seconds", Usage: TIMESTAMP, Desc: "time of the first bad block read from the file, as a unix timestamp"},
+			{Name: "last_time_seconds", Usage: TIMESTAMP, Desc: "time of the last bad block read from the file, as a unix timestamp"},
+		},
+	}
+	pgIOTimingDatabase = &QueryInstance{
+		Name:   "pg_io_timing_database",
+		Desc:   "OpenGauss time spent reading and writing data file blocks by database, when track_io_timing is on",
+		Scope:  scopeCluster,
+		Groups: []string{"io"},
+		Queries: []*Query{
+			{
+				SQL: `SELECT datname,
+       blk_read_time / 1000  AS read_seconds_total,
+       blk_write_time / 1000 AS write_seconds_total
+FROM pg_stat_database
+WHERE datname NOT IN ('template0','template1')
+  AND current_setting('track_io_timing') = 'on'`,
+				SupportedVersions: ">=0.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL, Desc: "Name of this database"},
+			{Name: "read_seconds_total", Usage: COUNTER, Desc: "Time spent reading data file blocks by backends in this database, in seconds"},
+			{Name: "write_seconds_total", Usage: COUNTER, Desc: "Time spent writing data file blocks by backends in this database, in seconds"},
+		},
+	}
+	pgIOTimingRelation = &QueryInstance{
+		Name:   "pg_io_timing_relation",
+		Desc:   "OpenGauss time spent reading and writing the data files of the relations with the most I/O time, when track_io_timing is on",
+		Scope:  scopeDatabase,
+		TopN:   &TopN{By: "io_seconds_total", N: 20},
+		Groups: []string{"io"},
+		Queries: []*Query{
+			{
+				SQL: `SELECT n.nspname                                       AS schemaname,
+       c.relname,
+       sum(f.readtim) / 1000000                        AS read_seconds_total,
+       sum(f.writetim) / 1000000                       AS write_seconds_total,
+       (sum(f.readtim) + sum(f.writetim)) / 1000000    AS io_seconds_total,
+       sum(f.phyblkrd)                                 AS blocks_read_total,
+       sum(f.phyblkwrt)                                AS blocks_written_total
+FROM dbe_perf.file_iostat f
+         JOIN pg_class c ON c.relfilenode = f.filenum
+         JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE f.dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+  AND current_setting('track_io_timing') = 'on'
+GROUP BY n.nspname, c.relname`,
+				SupportedVersions: ">=1.0.0",
+			},
+		},
+		Metrics: []*Column{
+			{Name: "schemaname", Usage: LABEL, Desc: "Name of the schema of the relation"},
+			{Name: "relname", Usage: LABEL, Desc: "Name of the relation"},
+			{Name: "read_seconds_total", Usage: COUNTER, Desc: "Time spent reading the data files of the relation, in seconds"},
+			{Name: "write_seconds_total", Usage: COUNTER, Desc: "Time spent writing the data files of the relation, in seconds"},
+			{Name: "io_seconds_total", Usage: COUNTER, Desc: "Time spent reading and writing the data files of the relation, in seconds, ranking the relations"},
+			{Name: "blocks_read_total", Usage: COUNTER, Desc: "Number of blocks read from the data files of the relation"},
+			{Name: "blocks_written_total", Usage: COUNTER, Desc: "Number of blocks written to the data files of the relation"},
+		},
+	}
+	pgStatioTables = &QueryInstance{
+		Name:   "pg_statio_tables",
+		Desc:   "OpenGauss blocks of the tables with the most block accesses read from disk and hit in the buffer cache, on the targets tagged statio",
+		Scope:  scopeDatabase,
+		TopN:   &TopN{By: "blks_accessed_total", N: 20},
+		Groups: []string{"io"},
+		TTL:    300,
+		Queries: []*Query{
+			{
+				SQL: `SELECT schemaname,
+       relname,
+       coalesce(heap_blks_read, 0)                                  AS heap_blks_read_total,
+       coalesce(heap_blks_hit, 0)                                   AS heap_blks_hit_total,
+       coalesce(idx_blks_read, 0)                                   AS idx_blks_read_total,
+       coalesce(idx_blks_hit, 0)                                    AS idx_blks_hit_total,
+       coalesce(toast_blks_read, 0) + coalesce(tidx_blks_read, 0)   AS toast_blks_read_total,
+       coalesce(toast_blks_hit, 0) + coalesce(tidx_blks_hit, 0)     AS toast_blks_hit_total,
+       coalesce(heap_blks_read, 0) + coalesce(heap_blks_hit, 0) +
+       coalesce(idx_blks_read, 0) + coalesce(idx_blks_hit, 0)       AS blks_accessed_total
+FROM pg_statio_user_tables`,
+				SupportedVersions: ">=1.0.0",
+				Tags:              []string{"statio"},
+			},
+		},
+		Metrics: []*Column{
+			{Name: "schemaname", Usage: LABEL, Desc: "Name of the schema of the table"},
+			{Name: "relname", Usage: LABEL, Desc: "Name of the table"},
+			{Name: "heap_blks_read_total", Usage: COUNTER, Desc: "Number of blocks of the table read from disk"},
+			{Name: "heap_blks_hit_total", Usage: COUNTER, Desc: "Number of blocks of the table hit in the buffer cache"},
+			{Name: "idx_blks_read_total", Usage: COUNTER, Desc: "Number of blocks of all indexes of the table read from disk"},
+			{Name: "idx_blks_hit_total", Usage: COUNTER, Desc: "Number of blocks of all indexes of the table hit in the buffer cache"},
+			{Name: "toast_blks_read_total", Usage: COUNTER, Desc: "Number of blocks of the TOAST table and its index read from disk"},
+			{Name: "toast_blks_hit_total", Usage: COUNTER, Desc: "Number of blocks of the TOAST table and its index hit in the buffer cache"},
+			{Name: "blks_accessed_total", Usage: COUNTER, Desc: "Number of blocks of the table and its indexes read or hit, ranking the tables"},
+		},
+	}
+	pgStatioIndexes = &QueryInstance{
+		Name:   "pg_statio_indexes",
+		Desc:   "OpenGauss blocks of the indexes with the most block accesses read from disk and hit in the buffer cache, on the targets tagged statio",
+		Scope:  scopeDatabase,
+		TopN:   &TopN{By: "blks_accessed_total", N: 20},
+		Groups: []string{"io"},
+		TTL:    300,
+		Queries: []*Query{
+			{
+				SQL: `SELECT schemaname,
+       relname,
+       indexrelname,
+       coalesce(idx_blks_read, 0)                             AS blks_read_total,
+       coalesce(idx_blks_hit, 0)                              AS blks_hit_total,
+       coalesce(idx_blks_read, 0) + coalesce(idx_blks_hit, 0) AS blks_accessed_total
+FROM pg_statio_user_indexes`,
+				SupportedVersions: ">=1.0.0",
+				Tags:              []string{"statio"},
+			},
+		},
+		Metrics: []*Column{
+			{Name: "schemaname", Usage: LABEL, Desc: "Name of the schema of the index"},
+			{Name: "relname", Usage: LABEL, Desc: "Name of the table of the index"},
+			{Name: "indexrelname", Usage: LABEL, Desc: "Name of the index"},
+			{Name: "blks_read_total", Usage: COUNTER, Desc: "Number of blocks of the index read from disk"},
+			{Name: "blks_hit_total", Usage: COUNTER, Desc: "Number of blocks of the index hit in the buffer cache"},
+			{Name: "blks_accessed_total", Usage: COUNTER, Desc: "Number of blocks of the index read or hit, ranking the indexes"},
+		},
+	}
 	defaultMonList = map[string]*QueryInstance{
 		"pg_lock":                    pgLock,
 		"pg_stat_replication":        pgStatReplication,
@@ -240,5 +534,16 @@ var (
 		"pg_bgwriter":                pgStatBgWriter,
 		"pg_stat_database":           pgStatDatabase,
 		"pg_stat_database_conflicts": pgStatDatabaseConflicts,
+		"pg_thread_wait_status":      pgThreadWaitStatus,
+		"pg_prepared_xacts":          pgPreparedXacts,
+		"pg_instance_time":           pgInstanceTime,
+		"pg_dolphin_settings":        pgDolphinSettings,
+		"pg_dolphin_objects":         pgDolphinObjects,
+		"pg_dolphin_sessions":        pgDolphinSessions,
+		"pg_bad_block":               pgBadBlock,
+		"pg_io_timing_database":      pgIOTimingDatabase,
+		"pg_io_timing_relation":      pgIOTimingRelation,
+		"pg_statio_tables":           pgStatioTables,
+		"pg_statio_indexes":          pgStatioIndexes,
 	}
 )
@@ -0,0 +1,77 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"strings"
+	"sync"
+)
+
+// deltaTracker hold the values of DELTA and COUNTER columns seen by the previous execution of each query.
+// All values of a query are replaced after every execution, series that disappeared are dropped with them.
+// Queries not executed any more, e.g. in a dropped database, are dropped by expire.
+type deltaTracker struct {
+	m      sync.Mutex
+	values map[string]map[string]float64
+	seen   map[string]int64 // scrape of the last update, by query
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		values: make(map[string]map[string]float64),
+		seen:   make(map[string]int64),
+	}
+}
+
+func deltaQueryKey(metricName, datname string) string {
+	return metricName + "\x00" + datname
+}
+
+func deltaSeriesKey(columnName string, labels []string) string {
+	return columnName + "\x00" + strings.Join(labels, "\x00")
+}
+
+// previous returns the values seen by the previous execution of the query. nil tracker has no values
+func (t *deltaTracker) previous(queryKey string) map[string]float64 {
+	if t == nil {
+		return nil
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.values[queryKey]
+}
+
+// update replace the values of the query with the ones seen by the current execution of scrape
+func (t *deltaTracker) update(queryKey string, values map[string]float64, scrape int64) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	t.values[queryKey] = values
+	t.seen[queryKey] = scrape
+	t.m.Unlock()
+}
+
+// expire drop the values of queries whose last update is expired
+func (t *deltaTracker) expire(expired func(seen int64) bool) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for queryKey, seen := range t.seen {
+		if expired(seen) {
+			delete(t.values, queryKey)
+			delete(t.seen, queryKey)
+		}
+	}
+}
+
+// delta returns the increase from previous to value. A decrease means the cumulative value was reset,
+// the increase since the reset is the value itself.
+func delta(previous, value float64) float64 {
+	if value < previous {
+		return value
+	}
+	return value - previous
+}
@@ -0,0 +1,119 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_delta(t *testing.T) {
+	assert.Equal(t, float64(5), delta(10, 15))
+	assert.Equal(t, float64(0), delta(10, 10))
+	// reset
+	assert.Equal(t, float64(3), delta(10, 3))
+}
+
+func Test_Server_queryMetric_delta(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	queryInstance := &QueryInstance{
+		Name:    "wdr_sql",
+		Queries: []*Query{{SQL: "SELECT unique_sql_id, n_calls"}},
+		Metrics: []*Column{
+			{Name: "unique_sql_id", Usage: LABEL},
+			{Name: "n_calls", Usage: DELTA},
+		},
+	}
+	assert.NoError(t, queryInstance.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		deltas: newDeltaTracker(),
+	}
+	scrape := func(rows *sqlmock.Rows) map[string]float64 {
+		mock.ExpectQuery("SELECT unique_sql_id").WillReturnRows(rows)
+		metrics, errs, err := s.queryMetric("wdr_sql", queryInstance)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		values := make(map[string]float64)
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			assert.NotNil(t, m.Gauge)
+			for _, l := range m.Label {
+				if l.GetName() == "unique_sql_id" {
+					values[l.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+		return values
+	}
+	newRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"unique_sql_id", "n_calls"})
+	}
+
+	// first scrape has nothing to compare with
+	assert.Empty(t, scrape(newRows().AddRow("1", 10).AddRow("2", 100)))
+	assert.Equal(t, map[string]float64{"1": 5, "2": 0}, scrape(newRows().AddRow("1", 15).AddRow("2", 100)))
+	// reset of 1, 2 disappeared, 3 is new
+	assert.Equal(t, map[string]float64{"1": 4}, scrape(newRows().AddRow("1", 4).AddRow("3", 7)))
+	// 2 came back, compared with nothing
+	assert.Equal(t, map[string]float64{"1": 1, "3": 1}, scrape(newRows().AddRow("1", 5).AddRow("2", 120).AddRow("3", 8)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_Server_queryMetric_counterReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	queryInstance := &QueryInstance{
+		Name:    "pg_stat_database",
+		Queries: []*Query{{SQL: "SELECT datname, xact_commit"}},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL},
+			{Name: "xact_commit", Usage: COUNTER},
+		},
+	}
+	assert.NoError(t, queryInstance.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		stats:  newQueryStats(),
+		deltas: newDeltaTracker(),
+	}
+	scrape := func(rows *sqlmock.Rows) []float64 {
+		mock.ExpectQuery("SELECT datname").WillReturnRows(rows)
+		metrics, errs, err := s.queryMetric("pg_stat_database", queryInstance)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		var values []float64
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			values = append(values, m.GetCounter().GetValue())
+		}
+		return values
+	}
+	newRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"datname", "xact_commit"})
+	}
+
+	assert.Equal(t, []float64{10, 100}, scrape(newRows().AddRow("omm", 10).AddRow("postgres", 100)))
+	assert.Equal(t, []float64{15, 100}, scrape(newRows().AddRow("omm", 15).AddRow("postgres", 100)))
+	assert.Equal(t, 0, s.stats.stats["pg_stat_database"].counterResets)
+	// the values are kept as is after pg_stat_reset, the reset is counted once for the query
+	assert.Equal(t, []float64{1, 2}, scrape(newRows().AddRow("omm", 1).AddRow("postgres", 2)))
+	assert.Equal(t, 1, s.stats.stats["pg_stat_database"].counterResets)
+	assert.Equal(t, []float64{3, 2}, scrape(newRows().AddRow("omm", 3).AddRow("postgres", 2)))
+	assert.Equal(t, 1, s.stats.stats["pg_stat_database"].counterResets)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
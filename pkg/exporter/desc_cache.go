@@ -0,0 +1,55 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"sync"
+)
+
+// descCache hold prometheus.Desc of a server across scrapes.
+// The key is built from query name, column name and label names.
+// It is reset when the server version changed, a config reload creates new servers.
+type descCache struct {
+	m     sync.RWMutex
+	descs map[string]*prometheus.Desc
+}
+
+func newDescCache() *descCache {
+	return &descCache{
+		descs: make(map[string]*prometheus.Desc),
+	}
+}
+
+func descCacheKey(queryName, columnName string, labelNames []string) string {
+	return queryName + "\x00" + columnName + "\x00" + strings.Join(labelNames, ",")
+}
+
+// getOrCreate returns cached desc, build it with newFn if not found. nil cache always build a new one
+func (c *descCache) getOrCreate(key string, newFn func() *prometheus.Desc) *prometheus.Desc {
+	if c == nil {
+		return newFn()
+	}
+	c.m.RLock()
+	desc, ok := c.descs[key]
+	c.m.RUnlock()
+	if ok {
+		return desc
+	}
+	desc = newFn()
+	c.m.Lock()
+	c.descs[key] = desc
+	c.m.Unlock()
+	return desc
+}
+
+// reset drop all cached desc
+func (c *descCache) reset() {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	c.descs = make(map[string]*prometheus.Desc)
+	c.m.Unlock()
+}
@@ -0,0 +1,171 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	commonlog "github.com/prometheus/common/log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerWithDialTimeouts bound the TCP connect and the handshake (TLS and startup) of new connections separately,
+// 0 means no bound besides connect_timeout of the dsn
+func ServerWithDialTimeouts(dial, handshake time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.dialTimeout = dial
+		s.handshakeTimeout = handshake
+	}
+}
+
+// dialConnector open connections of dsn with the dial and handshake timeouts,
+// dialing is aborted when the context of Connect is done.
+// The hosts of a multi-host dsn are tried in order, the first one accepting the connection
+// and satisfying the target_session_attrs is used
+type dialConnector struct {
+	dsn              string
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	logger           commonlog.Logger
+}
+
+func (c *dialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsns, attrs, err := hostDSNs(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for i, dsn := range dsns {
+		conn, err := c.connect(ctx, dsn, attrs)
+		if err == nil {
+			if i > 0 {
+				loggerOr(c.logger).Debugf("Connected to host %d of %s", i+1, ShadowDSN(c.dsn))
+			}
+			return conn, nil
+		}
+		if len(dsns) == 1 || ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("could not connect to any host: %s", strings.Join(errs, "; "))
+}
+
+// connect open a connection of dsn, the connection is closed if it doesn't satisfy the target_session_attrs
+func (c *dialConnector) connect(ctx context.Context, dsn, attrs string) (driver.Conn, error) {
+	d := &timeoutDialer{ctx: ctx, timeout: c.dialTimeout, handshakeTimeout: c.handshakeTimeout}
+	conn, err := pq.DialOpen(d, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = d.established()
+	if err == nil {
+		err = checkSessionAttrs(ctx, conn, attrs)
+	}
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *dialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// timeoutDialer implement pq.Dialer and pq.DialerContext
+type timeoutDialer struct {
+	ctx              context.Context
+	timeout          time.Duration
+	handshakeTimeout time.Duration
+	conn             *handshakeConn
+}
+
+func (d *timeoutDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *timeoutDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+// DialContext dial the server, the connection to send cancel requests is also dialed after the connection is established
+func (d *timeoutDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.ctx != nil {
+		// pq dials with a background context
+		var cancel context.CancelFunc
+		ctx, cancel = mergeContext(ctx, d.ctx)
+		defer cancel()
+	}
+	dialer := net.Dialer{Timeout: d.timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if d.ctx == nil || d.handshakeTimeout <= 0 {
+		return conn, nil
+	}
+	d.conn = &handshakeConn{Conn: conn, deadline: time.Now().Add(d.handshakeTimeout)}
+	if err := conn.SetDeadline(d.conn.deadline); err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, err
+	}
+	return d.conn, nil
+}
+
+// established clear the handshake deadline once the connection is ready
+func (d *timeoutDialer) established() error {
+	d.ctx = nil
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.established()
+}
+
+// handshakeConn keep the handshake deadline until the connection is established,
+// the deadline set by connect_timeout of the driver applies if earlier
+type handshakeConn struct {
+	net.Conn
+	m        sync.Mutex
+	deadline time.Time // zero once established
+}
+
+func (c *handshakeConn) SetDeadline(t time.Time) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if !c.deadline.IsZero() && (t.IsZero() || t.After(c.deadline)) {
+		t = c.deadline
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *handshakeConn) established() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.deadline = time.Time{}
+	return c.Conn.SetDeadline(time.Time{})
+}
+
+// mergeContext returns a context done when either ctx or other is done
+func mergeContext(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-other.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return merged, func() {
+		close(stop)
+		cancel()
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// silentServer accept connections and never answer, like a hung server
+func silentServer(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		m     sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			m.Lock()
+			conns = append(conns, conn)
+			m.Unlock()
+		}
+	}()
+	return l.Addr().String(), func() {
+		l.Close()
+		m.Lock()
+		defer m.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func Test_dialConnector_handshakeTimeout(t *testing.T) {
+	addr, stop := silentServer(t)
+	defer stop()
+	host, port, _ := net.SplitHostPort(addr)
+	c := &dialConnector{
+		dsn:              fmt.Sprintf("host=%s port=%s user=gaussdb sslmode=disable", host, port),
+		handshakeTimeout: 200 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := c.Connect(context.Background())
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func Test_dialConnector_canceled(t *testing.T) {
+	addr, stop := silentServer(t)
+	defer stop()
+	host, port, _ := net.SplitHostPort(addr)
+	c := &dialConnector{dsn: fmt.Sprintf("host=%s port=%s user=gaussdb sslmode=disable", host, port)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Connect(ctx)
+	assert.Error(t, err)
+}
+
+func Test_handshakeConn_SetDeadline(t *testing.T) {
+	addr, stop := silentServer(t)
+	defer stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &handshakeConn{Conn: conn, deadline: time.Now().Add(100 * time.Millisecond)}
+	// the driver resetting the deadline doesn't remove the handshake deadline
+	assert.NoError(t, c.SetDeadline(time.Time{}))
+	_, err = c.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.NoError(t, c.established())
+	assert.True(t, c.deadline.IsZero())
+	c.Close()
+}
+
+func TestServer_connParams(t *testing.T) {
+	s := &Server{sessionParams: map[string]string{"application_name": "opengauss_exporter"}}
+	assert.Equal(t, s.sessionParams, s.connParams())
+	s.connectTimeout = 1500 * time.Millisecond
+	assert.Equal(t, map[string]string{"application_name": "opengauss_exporter", "connect_timeout": "2"}, s.connParams())
+	got, err := sessionDSN("host=localhost connect_timeout=30", s.connParams())
+	assert.NoError(t, err)
+	assert.Contains(t, got, "connect_timeout=30")
+}
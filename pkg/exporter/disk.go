@@ -0,0 +1,127 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"path/filepath"
+)
+
+// diskUsageCollectorName is the name of the disk usage collector
+const diskUsageCollectorName = "disk_usage"
+
+// NewDiskUsageCollector returns the collector of the disk usage of the database directories
+func NewDiskUsageCollector() Collector {
+	return diskUsageCollector{}
+}
+
+// diskUsageCollector collect the usage of the data directory, pg_xlog and the log directory,
+// so disk-full incidents are predictable.
+// The size of pg_xlog is read by SQL when the user may call pg_ls_dir, the filesystem usage is read by statfs
+// when the exporter runs on the database host
+type diskUsageCollector struct{}
+
+// diskDirectory is a directory of the database
+type diskDirectory struct {
+	name string // data, xlog or log
+	path string
+}
+
+func (diskUsageCollector) Name() string {
+	return diskUsageCollectorName
+}
+
+// Enabled disk usage are server level metrics
+func (diskUsageCollector) Enabled(info ServerInfo) bool {
+	return info.Master
+}
+
+func (diskUsageCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	directories, err := databaseDirectories(ctx, db)
+	if err != nil {
+		return fmt.Errorf("Error retrieving directories on %q: %s", info.Server, err)
+	}
+	if len(directories) == 0 {
+		// data_directory is only visible to superusers
+		loggerOr(info.Logger).Debugf("data_directory is not visible on %q, skip disk usage", info.Server)
+		return nil
+	}
+	labels := []string{"directory", "path"}
+
+	var xlogSize float64
+	err = db.QueryRowContext(ctx, `SELECT coalesce(sum((pg_stat_file('pg_xlog/' || f)).size), 0) FROM pg_ls_dir('pg_xlog') AS f`).Scan(&xlogSize)
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "disk", "directory_size_bytes"),
+			"Size of the files in the directory", labels, info.Labels),
+			prometheus.GaugeValue, xlogSize, "xlog", directories[1].path)
+	} else {
+		loggerOr(info.Logger).Debugf("Error retrieving pg_xlog size on %q: %s", info.Server, err)
+	}
+
+	// the local filesystem is only the one of the database if the exporter runs next to it
+	if _, err := os.Stat(filepath.Join(directories[0].path, "postmaster.pid")); err != nil {
+		return nil
+	}
+	sizeDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "disk", "filesystem_size_bytes"),
+		"Size of the filesystem holding the directory", labels, info.Labels)
+	freeDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "disk", "filesystem_free_bytes"),
+		"Free bytes of the filesystem holding the directory available to the database", labels, info.Labels)
+	usedDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "disk", "filesystem_used_bytes"),
+		"Used bytes of the filesystem holding the directory", labels, info.Labels)
+	for _, dir := range directories {
+		usage, err := filesystemUsage(dir.path)
+		if err != nil {
+			loggerOr(info.Logger).Debugf("Error reading the filesystem usage of %s on %q: %s", dir.path, info.Server, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(usage.size), dir.name, dir.path)
+		ch <- prometheus.MustNewConstMetric(freeDesc, prometheus.GaugeValue, float64(usage.free), dir.name, dir.path)
+		ch <- prometheus.MustNewConstMetric(usedDesc, prometheus.GaugeValue, float64(usage.used), dir.name, dir.path)
+	}
+	return nil
+}
+
+// databaseDirectories returns the data, xlog and log directories, nil if data_directory is not visible
+func databaseDirectories(ctx context.Context, db *sql.DB) ([]diskDirectory, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, setting FROM pg_settings WHERE name IN ('data_directory', 'log_directory')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+	settings := make(map[string]string)
+	for rows.Next() {
+		var name, setting string
+		if err := rows.Scan(&name, &setting); err != nil {
+			return nil, err
+		}
+		settings[name] = setting
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	dataDir := settings["data_directory"]
+	if dataDir == "" {
+		return nil, nil
+	}
+	directories := []diskDirectory{
+		{name: "data", path: dataDir},
+		{name: "xlog", path: filepath.Join(dataDir, "pg_xlog")},
+	}
+	if logDir := settings["log_directory"]; logDir != "" {
+		// a relative log_directory is inside the data directory
+		if !filepath.IsAbs(logDir) {
+			logDir = filepath.Join(dataDir, logDir)
+		}
+		directories = append(directories, diskDirectory{name: "log", path: logDir})
+	}
+	return directories, nil
+}
+
+// diskUsage is the usage of a filesystem in bytes
+type diskUsage struct {
+	size, free, used uint64
+}
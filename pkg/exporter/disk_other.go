@@ -0,0 +1,13 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package exporter
+
+import "errors"
+
+// filesystemUsage is not supported on this platform
+func filesystemUsage(string) (diskUsage, error) {
+	return diskUsage{}, errors.New("statfs is not supported on this platform")
+}
@@ -0,0 +1,22 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+//go:build linux || darwin
+// +build linux darwin
+
+package exporter
+
+import "syscall"
+
+// filesystemUsage returns the usage of the filesystem holding path
+func filesystemUsage(path string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskUsage{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return diskUsage{
+		size: st.Blocks * bsize,
+		free: st.Bavail * bsize,
+		used: (st.Blocks - st.Bfree) * bsize,
+	}, nil
+}
@@ -0,0 +1,75 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_diskUsageCollector_Collect(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	for _, dir := range []string{"pg_xlog", "pg_log"} {
+		if err := os.Mkdir(filepath.Join(dataDir, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect := func() map[string]int {
+		ch := make(chan prometheus.Metric, 100)
+		err := NewDiskUsageCollector().Collect(context.Background(), db, ServerInfo{Server: "localhost:5432", Namespace: "og"}, ch)
+		assert.NoError(t, err)
+		close(ch)
+		counts := make(map[string]int)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			name := strings.Split(strings.TrimPrefix(metric.Desc().String(), `Desc{fqName: "`), `"`)[0]
+			counts[name]++
+		}
+		return counts
+	}
+	settingsRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "setting"}).
+			AddRow("data_directory", dataDir).AddRow("log_directory", "pg_log")
+	}
+
+	// remote database: no postmaster.pid in the local data directory, pg_ls_dir denied
+	mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(settingsRows())
+	mock.ExpectQuery("pg_ls_dir").WillReturnError(errors.New("permission denied for function pg_ls_dir"))
+	assert.Empty(t, collect())
+
+	// colocated
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "postmaster.pid"), []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(settingsRows())
+	mock.ExpectQuery("pg_ls_dir").WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(16777216))
+	counts := collect()
+	assert.Equal(t, 1, counts["og_disk_directory_size_bytes"])
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		assert.Equal(t, 3, counts["og_disk_filesystem_free_bytes"])
+	}
+
+	// data_directory not visible
+	mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(sqlmock.NewRows([]string{"name", "setting"}))
+	assert.Empty(t, collect())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,88 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultDriver is the lib/pq driver, it supports the dial timeouts and the multi-host dsns of the exporter
+const DefaultDriver = "postgres"
+
+// Driver open the connections of the servers, selected by name with WithDriver.
+// Drivers besides the default one, e.g. pgx, are registered by RegisterDriver
+type Driver interface {
+	// Connector returns the connector of dsn, a keyword/value or URL dsn with the session parameters set
+	Connector(dsn string) (driver.Connector, error)
+}
+
+// pqDriver is the default driver
+type pqDriver struct{}
+
+func (pqDriver) Connector(dsn string) (driver.Connector, error) {
+	return pq.NewConnector(dsn)
+}
+
+var (
+	driversMtx sync.Mutex
+	drivers    = map[string]Driver{DefaultDriver: pqDriver{}}
+)
+
+// RegisterDriver register a driver selected by name. It panics if the name is registered twice
+func RegisterDriver(name string, d Driver) {
+	driversMtx.Lock()
+	defer driversMtx.Unlock()
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("driver %s registered twice", name))
+	}
+	drivers[name] = d
+}
+
+// getDriver returns the driver registered by name
+func getDriver(name string) (Driver, error) {
+	driversMtx.Lock()
+	defer driversMtx.Unlock()
+	d, ok := drivers[name]
+	if !ok {
+		names := make([]string, 0, len(drivers))
+		for name := range drivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown database driver %s, registered: %s", name, strings.Join(names, ", "))
+	}
+	return d, nil
+}
+
+// WithDriver open the connections of the servers with the driver registered by name, DefaultDriver if empty
+func WithDriver(name string) Opt {
+	return func(e *Exporter) {
+		e.driver = name
+	}
+}
+
+// ServerWithDriver open the connections of the server with the driver registered by name, see WithDriver
+func ServerWithDriver(name string) ServerOpt {
+	return func(s *Server) {
+		s.driver = name
+	}
+}
+
+// driverConnector returns the connector of dsn of a driver besides the default one. The dial timeouts of the
+// exporter do not apply, its connect_timeout and multiple hosts are left to the driver. The settings read by the
+// exporter are removed from dsn, as the server rejects them
+func (s *Server) driverConnector(dsn string) (driver.Connector, error) {
+	d, err := getDriver(s.driver)
+	if err != nil {
+		return nil, err
+	}
+	if dsn, err = ConnectionDSN(dsn); err != nil {
+		return nil, err
+	}
+	return d.Connector(dsn)
+}
@@ -0,0 +1,30 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+//go:build pgx
+// +build pgx
+
+package exporter
+
+import (
+	"database/sql/driver"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// PgxDriver is the name of the pgx driver, built in with the pgx build tag
+const PgxDriver = "pgx"
+
+func init() {
+	RegisterDriver(PgxDriver, pgxDriver{})
+}
+
+// pgxDriver open the connections with pgx: SQLSTATE of every error, binary protocol, canceled with the context
+type pgxDriver struct{}
+
+func (pgxDriver) Connector(dsn string) (driver.Connector, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return stdlib.GetConnector(*config), nil
+}
@@ -0,0 +1,59 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testDriver open the connections of a sqlmock dsn
+type testDriver struct {
+	drv driver.Driver
+}
+
+func (d testDriver) Connector(dsn string) (driver.Connector, error) {
+	return dsnConnector{dsn: dsn, drv: d.drv}, nil
+}
+
+func Test_getDriver(t *testing.T) {
+	d, err := getDriver(DefaultDriver)
+	assert.NoError(t, err)
+	assert.IsType(t, pqDriver{}, d)
+
+	_, err = getDriver("nope")
+	// drivers of the build tags, e.g. pgx, are registered too
+	assert.Regexp(t, `^unknown database driver nope, registered: (\w+, )*postgres(, \w+)*$`, err.Error())
+	_, err = NewExporter(WithDriver("nope"))
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { RegisterDriver(DefaultDriver, pqDriver{}) })
+}
+
+func TestServer_openDB_driver(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("host=driver_session_setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	RegisterDriver("mock", testDriver{drv: mockDB.Driver()})
+	defer func() {
+		driversMtx.Lock()
+		delete(drivers, "mock")
+		driversMtx.Unlock()
+	}()
+	mock.ExpectExec("SET ROLE monitor").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(1))
+
+	s := &Server{driver: "mock", sessionSetup: []string{"SET ROLE monitor"}}
+	// the driver opens the dsn without the settings of the exporter
+	db, err := s.openDB("host=driver_session_setup exporter_namespace=team_a exporter_tenant=a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var c int
+	assert.NoError(t, db.QueryRow("SELECT 1").Scan(&c))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -17,12 +17,12 @@ func parseDsn(dsn string) (map[string]string, error) {
 	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
 		connStringSettings, err = parseURLSettings(dsn)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse as URL %s err %s", dsn, err)
+			return nil, fmt.Errorf("failed to parse as URL %s err %s", ShadowDSN(dsn), err)
 		}
 	} else {
 		connStringSettings, err = parseDSNSettings(dsn)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse as DSN %s err %s", dsn, err)
+			return nil, fmt.Errorf("failed to parse as DSN %s err %s", ShadowDSN(dsn), err)
 		}
 	}
 	return connStringSettings, nil
@@ -162,8 +162,16 @@ func parseURLSettings(connString string) (map[string]string, error) {
 func genDSNString(connStringSettings map[string]string) string {
 	var kvs []string
 	for k, v := range connStringSettings {
-		kvs = append(kvs, fmt.Sprintf("%s=%v", k, v))
+		kvs = append(kvs, k+"="+quoteDSNValue(v))
 	}
 	sort.Strings(kvs) // Makes testing easier (not a performance concern)
 	return strings.Join(kvs, " ")
 }
+
+// quoteDSNValue returns the value quoted for a key=value dsn if it is empty or contains spaces, quotes or backslashes
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\v\f\r'\\") {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
@@ -0,0 +1,23 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_genDSNString(t *testing.T) {
+	settings := map[string]string{
+		"host":             "127.0.0.1",
+		"password":         `it's a \secret`,
+		"application_name": "",
+		"user":             "gaussdb",
+	}
+	dsn := genDSNString(settings)
+	assert.Equal(t, `application_name='' host=127.0.0.1 password='it\'s a \\secret' user=gaussdb`, dsn)
+	// the dsn parses back to its settings
+	parsed, err := parseDSNSettings(dsn)
+	assert.NoError(t, err)
+	assert.Equal(t, settings, parsed)
+}
@@ -2,6 +2,19 @@
 
 package exporter
 
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"errors"
+	"github.com/lib/pq"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
 type ErrorConnectToServer struct {
 	Msg string
 }
@@ -10,3 +23,145 @@ type ErrorConnectToServer struct {
 func (e *ErrorConnectToServer) Error() string {
 	return e.Msg
 }
+
+// errorCode returns the SQLSTATE of an error of the server, whatever the driver, "" for other errors
+func errorCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	// e.g. the errors of pgx
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+	return ""
+}
+
+// transientErrorCodes are OpenGauss error codes worth retrying in the same scrape
+var transientErrorCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08003": true, // connection_does_not_exist
+	"08006": true, // connection_failure
+}
+
+// isTransientError returns whether the error is likely caused by a brief blip and worth retrying
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if code := errorCode(err); code != "" {
+		return transientErrorCodes[code]
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return strings.Contains(err.Error(), "connection reset by peer")
+}
+
+// isPermissionDenied returns whether the error is caused by insufficient privilege of the monitoring user
+func isPermissionDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if code := errorCode(err); code != "" {
+		return code == "42501" // insufficient_privilege
+	}
+	return strings.Contains(err.Error(), "permission denied")
+}
+
+// reasons of a connection failure, the reason label of the connect errors
+const (
+	connectErrorAuth               = "auth"
+	connectErrorNetwork            = "network"
+	connectErrorTimeout            = "timeout"
+	connectErrorTLS                = "tls"
+	connectErrorTooManyConnections = "too_many_connections"
+	connectErrorOther              = "other"
+)
+
+// connectErrorReasons are the reasons of a connection failure
+var connectErrorReasons = []string{connectErrorAuth, connectErrorNetwork, connectErrorTimeout, connectErrorTLS,
+	connectErrorTooManyConnections, connectErrorOther}
+
+// connectErrorMessages match the messages of the failures losing their type, e.g. of the hosts of a multi-host dsn.
+// The first match wins
+var connectErrorMessages = []struct {
+	reason  string
+	pattern string
+}{
+	{connectErrorAuth, "authentication failed"},
+	{connectErrorAuth, "no pg_hba.conf entry"},
+	{connectErrorAuth, "Invalid username/password"},
+	{connectErrorTooManyConnections, "too many clients"},
+	{connectErrorTooManyConnections, "too many connections"},
+	{connectErrorTLS, "x509:"},
+	{connectErrorTLS, "tls:"},
+	{connectErrorTLS, "SSL"},
+	{connectErrorTimeout, "timeout"},
+	{connectErrorTimeout, "deadline exceeded"},
+	{connectErrorNetwork, "connection refused"},
+	{connectErrorNetwork, "no such host"},
+	{connectErrorNetwork, "unreachable"},
+	{connectErrorNetwork, "no route to host"},
+	{connectErrorNetwork, "connection reset by peer"},
+}
+
+// connectErrorReason returns the reason of a connection failure, by the error code of the server or the type of the error
+func connectErrorReason(err error) string {
+	switch code := errorCode(err); {
+	case strings.HasPrefix(code, "28"): // invalid_authorization_specification, invalid_password
+		return connectErrorAuth
+	case code == "53300": // too_many_connections
+		return connectErrorTooManyConnections
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return connectErrorTimeout
+	}
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.Is(err, pq.ErrSSLNotSupported) || errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return connectErrorTLS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return connectErrorTimeout
+		}
+		return connectErrorNetwork
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return connectErrorNetwork
+	}
+	message := err.Error()
+	for _, m := range connectErrorMessages {
+		if strings.Contains(message, m.pattern) {
+			return m.reason
+		}
+	}
+	return connectErrorOther
+}
+
+// observeConnectError count the connection failure by its reason, the connections not attempted are not counted
+func (e *Exporter) observeConnectError(err error) {
+	var backoffErr *backoffError
+	if errors.As(err, &backoffErr) {
+		return
+	}
+	e.connectErrors.WithLabelValues(connectErrorReason(err)).Inc()
+}
@@ -0,0 +1,118 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	dto "github.com/prometheus/client_model/go"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func Test_isTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "serialization_failure", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "admin_shutdown", err: fmt.Errorf("query: %w", &pq.Error{Code: "57P01"}), want: true},
+		{name: "syntax_error", err: &pq.Error{Code: "42601"}, want: false},
+		{name: "bad_conn", err: driver.ErrBadConn, want: true},
+		{name: "connection_reset", err: fmt.Errorf("read tcp: connection reset by peer"), want: true},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: false},
+		{name: "other", err: fmt.Errorf("error"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isPermissionDenied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "insufficient_privilege", err: fmt.Errorf("query: %w", &pq.Error{Code: "42501"}), want: true},
+		{name: "syntax_error", err: &pq.Error{Code: "42601", Message: "permission denied"}, want: false},
+		{name: "message", err: fmt.Errorf("permission denied for relation pg_authid"), want: true},
+		{name: "other", err: fmt.Errorf("error"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPermissionDenied(tt.err); got != tt.want {
+				t.Errorf("isPermissionDenied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_connectErrorReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "invalid_password", err: &pq.Error{Code: "28P01"}, want: connectErrorAuth},
+		{name: "invalid_authorization_specification", err: &pq.Error{Code: "28000"}, want: connectErrorAuth},
+		{name: "too_many_connections", err: &pq.Error{Code: "53300"}, want: connectErrorTooManyConnections},
+		{name: "deadline", err: fmt.Errorf("dial: %w", context.DeadlineExceeded), want: connectErrorTimeout},
+		{name: "net timeout", err: &net.OpError{Op: "dial", Err: timeoutError{}}, want: connectErrorTimeout},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: connectErrorNetwork},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "db"}, want: connectErrorNetwork},
+		{name: "ssl not supported", err: pq.ErrSSLNotSupported, want: connectErrorTLS},
+		{name: "unknown authority", err: x509.UnknownAuthorityError{}, want: connectErrorTLS},
+		{name: "multi-host", err: fmt.Errorf("could not connect to any host: a:5432: pq: password authentication failed for user \"monitor\""),
+			want: connectErrorAuth},
+		{name: "multi-host sorry", err: fmt.Errorf("could not connect to any host: a:5432: pq: sorry, too many clients already"),
+			want: connectErrorTooManyConnections},
+		{name: "other", err: &pq.Error{Code: "3D000"}, want: connectErrorOther},
+		{name: "sqlstate", err: fmt.Errorf("connect: %w", stateError("28P01")), want: connectErrorAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectErrorReason(tt.err); got != tt.want {
+				t.Errorf("connectErrorReason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// stateError is an error of another driver with a SQLSTATE
+type stateError string
+
+func (e stateError) Error() string    { return "state " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+// timeoutError is a net.Error timing out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestExporter_observeConnectError(t *testing.T) {
+	e := &Exporter{namespace: "og"}
+	e.setupInternalMetrics()
+	e.observeConnectError(&pq.Error{Code: "28P01"})
+	e.observeConnectError(&backoffError{err: &pq.Error{Code: "28P01"}, count: 1})
+	m := &dto.Metric{}
+	if err := e.connectErrors.WithLabelValues(connectErrorAuth).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("auth connect errors = %v, want 1, the backed off attempts are not counted", got)
+	}
+}
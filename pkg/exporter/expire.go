@@ -0,0 +1,149 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sync/atomic"
+)
+
+// DefaultExpireAfterScrapes is the number of scrapes state of disappeared objects is kept
+const DefaultExpireAfterScrapes = 10
+
+// ServerWithExpireAfterScrapes drop cached samples of queries not run any more, cached series not returned by their
+// query, previous DELTA values and connections of databases not used within n scrapes, e.g. of a dropped database.
+// 0 keeps them until restart
+func ServerWithExpireAfterScrapes(n int) ServerOpt {
+	return func(s *Server) {
+		s.expireAfter = int64(n)
+	}
+}
+
+// beginScrape count a scrape of the server, returns its number
+func (s *Server) beginScrape() int64 {
+	return atomic.AddInt64(&s.scrapes, 1)
+}
+
+// currentScrape returns the number of the last scrape of the server
+func (s *Server) currentScrape() int64 {
+	return atomic.LoadInt64(&s.scrapes)
+}
+
+// expired reports whether state last refreshed by scrape seen is expired at scrape
+func (s *Server) expired(seen, scrape int64) bool {
+	return s.expireAfter > 0 && scrape-seen > s.expireAfter
+}
+
+// seenAt returns the number of the last scrape whose execution returned the i-th metric
+func (c cachedMetrics) seenAt(i int) int64 {
+	if i < len(c.seen) {
+		return c.seen[i]
+	}
+	return c.refreshed
+}
+
+// expireSeries returns the cached metrics without the series not returned by the last execution, kept since an
+// execution that failed, that are expired at scrape
+func (s *Server) expireSeries(cached cachedMetrics, scrape int64) cachedMetrics {
+	var (
+		metrics []prometheus.Metric
+		seen    []int64
+	)
+	for i, m := range cached.metrics {
+		if last := cached.seenAt(i); last == cached.refreshed || !s.expired(last, scrape) {
+			metrics = append(metrics, m)
+			seen = append(seen, last)
+		}
+	}
+	if len(metrics) < len(cached.metrics) {
+		cached.metrics, cached.seen, cached.bytes = metrics, seen, metricsBytes(metrics)
+	}
+	return cached
+}
+
+// seriesKey returns the identity of the series of a metric: its desc and label values
+func seriesKey(metric prometheus.Metric) string {
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		return metric.Desc().String()
+	}
+	key := metric.Desc().String()
+	for _, label := range m.Label {
+		key += "\x00" + label.GetName() + "=" + label.GetValue()
+	}
+	return key
+}
+
+// touchCache mark the cached metrics as emitted by the current scrape
+func (s *Server) touchCache(metric string) {
+	s.cacheMtx.Lock()
+	defer s.cacheMtx.Unlock()
+	if cached, ok := s.metricCache[metric]; ok {
+		cached.scrape = s.currentScrape()
+		s.metricCache[metric] = cached
+	}
+}
+
+// expireState drop the state not refreshed within expireAfter scrapes
+func (s *Server) expireState(scrape int64) {
+	if s.expireAfter <= 0 {
+		return
+	}
+	s.cacheMtx.Lock()
+	for metric, cached := range s.metricCache {
+		if s.expired(cached.scrape, scrape) {
+			s.log().Debugf("Expire cached metric %s on %s", metric, s)
+			delete(s.metricCache, metric)
+			continue
+		}
+		if expired := s.expireSeries(cached, scrape); len(expired.metrics) < len(cached.metrics) {
+			s.log().Debugf("Expire %d cached series of metric %s on %s", len(cached.metrics)-len(expired.metrics), metric, s)
+			s.metricCache[metric] = expired
+		}
+	}
+	s.cacheMtx.Unlock()
+
+	s.deltas.expire(func(seen int64) bool { return s.expired(seen, scrape) })
+	s.watermarks.expire(func(seen int64) bool { return s.expired(seen, scrape) })
+
+	s.databasesMtx.Lock()
+	for name, db := range s.databases {
+		if s.expired(s.databasesSeen[name], scrape) {
+			s.log().Infof("Close connection to database %s on %s, not seen for %d scrapes", name, s, s.expireAfter)
+			_ = db.Close()
+			delete(s.databases, name)
+			delete(s.databasesSeen, name)
+		}
+	}
+	s.databasesMtx.Unlock()
+}
+
+// expire close and remove the servers whose dsn was not active within after calls, e.g. of dropped databases
+// under auto-discovery. 0 keeps them
+func (s *Servers) expire(active []string, after int) {
+	if after <= 0 {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.missing == nil {
+		s.missing = make(map[string]int)
+	}
+	for _, dsn := range active {
+		delete(s.missing, dsn)
+	}
+	for dsn, server := range s.servers {
+		if Contains(active, dsn) {
+			continue
+		}
+		if s.missing[dsn]++; s.missing[dsn] > after {
+			server.log().Infof("Close connection to %q, not discovered for %d scrapes", server, after)
+			if err := server.Close(); err != nil {
+				server.log().Errorf("failed to close connection to %q: %v", server, err)
+			}
+			delete(s.servers, dsn)
+			delete(s.missing, dsn)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestServer_expireState(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectClose()
+	s := &Server{
+		expireAfter:   2,
+		metricCache:   map[string]cachedMetrics{},
+		deltas:        newDeltaTracker(),
+		databases:     map[string]*sql.DB{"dropped": db},
+		databasesSeen: map[string]int64{"dropped": 1},
+	}
+	scrape := s.beginScrape()
+	s.metricCache["pg_stat_database"] = cachedMetrics{scrape: scrape}
+	s.metricCache["pg_lock"] = cachedMetrics{scrape: scrape}
+	s.deltas.update(deltaQueryKey("wdr_sql", "dropped"), map[string]float64{"n_calls": 1}, scrape)
+	s.expireState(scrape)
+	assert.Len(t, s.metricCache, 2)
+
+	for i := 0; i < 2; i++ {
+		scrape = s.beginScrape()
+		s.touchCache("pg_stat_database")
+		s.expireState(scrape)
+	}
+	assert.Len(t, s.metricCache, 2)
+	assert.NotNil(t, s.deltas.previous(deltaQueryKey("wdr_sql", "dropped")))
+
+	scrape = s.beginScrape()
+	s.touchCache("pg_stat_database")
+	s.expireState(scrape)
+	assert.Contains(t, s.metricCache, "pg_stat_database")
+	assert.NotContains(t, s.metricCache, "pg_lock")
+	assert.Nil(t, s.deltas.previous(deltaQueryKey("wdr_sql", "dropped")))
+	assert.Empty(t, s.databases)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServer_expireState_series(t *testing.T) {
+	s := &Server{expireAfter: 2, metricCache: map[string]cachedMetrics{}, deltas: newDeltaTracker()}
+	desc := prometheus.NewDesc("pg_database_size_bytes", "size", []string{"datname"}, nil)
+	size := func(datname string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, datname)
+	}
+	s.beginScrape()
+	assert.Len(t, s.cacheMetrics("pg_database", []prometheus.Metric{size("postgres"), size("dropped")}, nil, false, time.Now()), 2)
+
+	// an execution failing in a database keeps its series
+	s.beginScrape()
+	assert.Len(t, s.cacheMetrics("pg_database", []prometheus.Metric{size("postgres")}, nil, true, time.Now()), 2)
+	// until not returned within 2 scrapes, while the query still returns the other series
+	scrape := s.beginScrape()
+	s.touchCache("pg_database")
+	s.expireState(scrape)
+	assert.Len(t, s.metricCache["pg_database"].metrics, 2)
+	scrape = s.beginScrape()
+	s.touchCache("pg_database")
+	s.expireState(scrape)
+	if assert.Len(t, s.metricCache["pg_database"].metrics, 1) {
+		assert.Equal(t, seriesKey(size("postgres")), seriesKey(s.metricCache["pg_database"].metrics[0]))
+	}
+
+	// a successful execution drops the series it does not return
+	s.beginScrape()
+	s.cacheMetrics("pg_database", []prometheus.Metric{size("postgres"), size("dropped")}, nil, false, time.Now())
+	s.beginScrape()
+	assert.Len(t, s.cacheMetrics("pg_database", []prometheus.Metric{size("postgres")}, nil, false, time.Now()), 1)
+}
+
+func TestServer_expireState_disabled(t *testing.T) {
+	s := &Server{metricCache: map[string]cachedMetrics{"pg_lock": {}}, deltas: newDeltaTracker()}
+	for i := 0; i < 100; i++ {
+		s.expireState(s.beginScrape())
+	}
+	assert.Len(t, s.metricCache, 1)
+}
+
+func TestServers_expire(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectClose()
+	servers := NewServers()
+	servers.servers["dbname=dropped"] = &Server{db: db, labels: map[string]string{serverLabelName: "localhost:5432"}}
+	servers.servers["dbname=postgres"] = &Server{labels: map[string]string{serverLabelName: "localhost:5432"}}
+	active := []string{"dbname=postgres"}
+	servers.expire(active, 2)
+	servers.expire(active, 2)
+	assert.Len(t, servers.List(), 2)
+	servers.expire(active, 2)
+	assert.Len(t, servers.List(), 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
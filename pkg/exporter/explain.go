@@ -0,0 +1,146 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultExplainSeqScanRows is the estimated rows from which a sequential scan is flagged by Explain
+const DefaultExplainSeqScanRows = 10000
+
+// ExplainOptions control the query cost audit of Exporter.Explain
+type ExplainOptions struct {
+	Analyze     bool    // run EXPLAIN ANALYZE, the queries are executed
+	MaxRows     int     // rows read by EXPLAIN ANALYZE, 0 for no limit
+	SeqScanRows float64 // rows from which a sequential scan is flagged, DefaultExplainSeqScanRows if 0
+}
+
+// ExplainResult is the plan summary of a query on a server
+type ExplainResult struct {
+	Server   string   `json:"server"`
+	Query    string   `json:"query"`
+	Cost     float64  `json:"cost"`                // estimated total cost
+	Rows     float64  `json:"rows"`                // estimated rows, actual rows with analyze
+	Time     float64  `json:"time_ms,omitempty"`   // actual total time in milliseconds with analyze
+	SeqScans []string `json:"seq_scans,omitempty"` // relations scanned sequentially with at least SeqScanRows rows
+	Error    string   `json:"error,omitempty"`
+}
+
+// explainPlan is a node of a plan of EXPLAIN (FORMAT JSON)
+type explainPlan struct {
+	NodeType     string         `json:"Node Type"`
+	RelationName string         `json:"Relation Name"`
+	Schema       string         `json:"Schema"`
+	TotalCost    float64        `json:"Total Cost"`
+	PlanRows     float64        `json:"Plan Rows"`
+	ActualRows   *float64       `json:"Actual Rows"`
+	ActualTime   float64        `json:"Actual Total Time"`
+	Plans        []*explainPlan `json:"Plans"`
+}
+
+// Explain run EXPLAIN for the enabled SQL queries of every server, to vet the cost of a config before rollout
+func (e *Exporter) Explain(ctx context.Context, opts ExplainOptions) ([]*ExplainResult, error) {
+	var results []*ExplainResult
+	for _, dsn := range e.dsnList() {
+		server, err := e.servers.GetServer(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ShadowDSN(dsn), RedactText(err.Error()))
+		}
+		if err := e.detectServer(ctx, server); err != nil {
+			return nil, fmt.Errorf("%s: %s", server, err)
+		}
+		results = append(results, server.explain(ctx, opts)...)
+	}
+	return results, nil
+}
+
+// explain run EXPLAIN for the enabled SQL queries resolved for the server version.
+// Queries of other databases are explained in the database of the dsn
+func (s *Server) explain(ctx context.Context, opts ExplainOptions) []*ExplainResult {
+	if opts.SeqScanRows <= 0 {
+		opts.SeqScanRows = DefaultExplainSeqScanRows
+	}
+	s.mappingMtx.RLock()
+	defer s.mappingMtx.RUnlock()
+	var results []*ExplainResult
+	for _, metric := range sortQueryInstances(s.queryInstanceMap) {
+		querySQL := s.getQuerySQL(metric, s.queryInstanceMap[metric])
+		if querySQL == nil || strings.EqualFold(querySQL.Status, statusDisable) || !querySQL.isSQL() {
+			continue
+		}
+		result := &ExplainResult{Server: s.String(), Query: metric}
+		if err := s.explainQuery(ctx, querySQL, opts, result); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *Server) explainQuery(ctx context.Context, querySQL *Query, opts ExplainOptions, result *ExplainResult) error {
+	if opts.Analyze && querySQL.Timeout > 0 {
+		// the query is executed as on scrape
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, querySQL.TimeoutDuration())
+		defer cancel()
+	}
+	var out string
+	if err := s.db.QueryRowContext(ctx, explainSQL(querySQL.SQL, opts)).Scan(&out); err != nil {
+		return err
+	}
+	var plans []struct {
+		Plan *explainPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(out), &plans); err != nil {
+		return fmt.Errorf("malformed plan: %s", err)
+	}
+	if len(plans) == 0 || plans[0].Plan == nil {
+		return fmt.Errorf("no plan")
+	}
+	plan := plans[0].Plan
+	result.Cost, result.Rows = plan.TotalCost, plan.rows()
+	if opts.Analyze {
+		result.Time = plan.ActualTime
+	}
+	result.SeqScans = plan.seqScans(opts.SeqScanRows, nil)
+	return nil
+}
+
+// explainSQL returns the EXPLAIN statement of sql, analyzed queries are wrapped to read at most MaxRows rows
+func explainSQL(sql string, opts ExplainOptions) string {
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	if !opts.Analyze {
+		return "EXPLAIN (FORMAT JSON) " + sql
+	}
+	if opts.MaxRows > 0 {
+		sql = fmt.Sprintf("SELECT * FROM (%s) explained LIMIT %d", sql, opts.MaxRows)
+	}
+	return "EXPLAIN (ANALYZE, FORMAT JSON) " + sql
+}
+
+// rows returns the actual rows of the node if analyzed, the estimated rows otherwise
+func (p *explainPlan) rows() float64 {
+	if p.ActualRows != nil {
+		return *p.ActualRows
+	}
+	return p.PlanRows
+}
+
+// seqScans append the relations scanned sequentially with at least min rows, with their rows
+func (p *explainPlan) seqScans(min float64, scans []string) []string {
+	if p.NodeType == "Seq Scan" && p.RelationName != "" && (p.PlanRows >= min || p.rows() >= min) {
+		relation := p.RelationName
+		if p.Schema != "" {
+			relation = p.Schema + "." + relation
+		}
+		scans = append(scans, fmt.Sprintf("%s (%.0f rows)", relation, p.rows()))
+	}
+	for _, child := range p.Plans {
+		scans = child.seqScans(min, scans)
+	}
+	return scans
+}
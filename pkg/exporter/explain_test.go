@@ -0,0 +1,69 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestServer_explain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close() // nolint: errcheck
+	lock := &QueryInstance{
+		Name:    "pg_lock",
+		Queries: []*Query{{SQL: "SELECT datname, count(*) AS count FROM pg_locks;"}},
+		Metrics: []*Column{{Name: "datname", Usage: LABEL}, {Name: "count", Usage: GAUGE}},
+	}
+	table := &QueryInstance{
+		Name:    "pg_table",
+		Queries: []*Query{{SQL: "SELECT relname, n_live_tup FROM pg_stat_user_tables"}},
+		Metrics: []*Column{{Name: "relname", Usage: LABEL}, {Name: "n_live_tup", Usage: GAUGE}},
+	}
+	node := &QueryInstance{
+		Name:    "cm_node",
+		Queries: []*Query{{Static: StaticSource{{"a": "1"}}}},
+		Metrics: []*Column{{Name: "a", Usage: GAUGE}},
+	}
+	for _, q := range []*QueryInstance{lock, table, node} {
+		assert.NoError(t, q.Check())
+	}
+	s := &Server{
+		db:               db,
+		labels:           prometheus.Labels{"server": "localhost:5432"},
+		queryInstanceMap: map[string]*QueryInstance{"pg_lock": lock, "pg_table": table, "cm_node": node},
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (FORMAT JSON) SELECT datname, count(*) AS count FROM pg_locks")).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Aggregate",
+			"Total Cost": 12.5, "Plan Rows": 10, "Plans": [{"Node Type": "Function Scan", "Plan Rows": 1000}]}}]`))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (FORMAT JSON) SELECT relname")).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Hash Join",
+			"Total Cost": 4200, "Plan Rows": 50000, "Plans": [
+			{"Node Type": "Seq Scan", "Relation Name": "pg_class", "Schema": "pg_catalog", "Plan Rows": 50000},
+			{"Node Type": "Seq Scan", "Relation Name": "pg_namespace", "Schema": "pg_catalog", "Plan Rows": 12}]}}]`))
+	results := s.explain(context.Background(), ExplainOptions{})
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []*ExplainResult{
+		{Server: "localhost:5432", Query: "pg_lock", Cost: 12.5, Rows: 10},
+		{Server: "localhost:5432", Query: "pg_table", Cost: 4200, Rows: 50000, SeqScans: []string{"pg_catalog.pg_class (50000 rows)"}},
+	}, results)
+
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, FORMAT JSON) SELECT * FROM (SELECT datname, count(*) AS count FROM pg_locks) explained LIMIT 10")).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Aggregate",
+			"Total Cost": 12.5, "Plan Rows": 10, "Actual Rows": 3, "Actual Total Time": 0.25}}]`))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, FORMAT JSON) SELECT * FROM (SELECT relname")).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`not json`))
+	results = s.explain(context.Background(), ExplainOptions{Analyze: true, MaxRows: 10})
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, &ExplainResult{Server: "localhost:5432", Query: "pg_lock", Cost: 12.5, Rows: 3, Time: 0.25}, results[0])
+		assert.Contains(t, results[1].Error, "malformed plan")
+	}
+}
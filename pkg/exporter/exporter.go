@@ -3,16 +3,20 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
+	"github.com/blang/semver"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	commonlog "github.com/prometheus/common/log"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Exporter struct {
 	dsn                    []string
 	configPath             string   // config file path /directory
+	helpPath               string   // help overrides file path
 	disableCache           bool     // always execute query when been scrapped
 	autoDiscovery          bool     // discovery other database on primary server
 	failFast               bool     // fail fast instead fof waiting during start-up ?
@@ -23,6 +27,7 @@ type Exporter struct {
 	servers                *Servers
 	metricMap              map[string]*QueryInstance
 
+	constLabels     string               // const labels of WithConstLabels, parsed into constantLabels
 	constantLabels  prometheus.Labels    // 用户定义标签
 	duration        prometheus.Gauge     // 采集时间
 	error           prometheus.Gauge     // 采集指标时错误统计
@@ -30,27 +35,113 @@ type Exporter struct {
 	configFileError *prometheus.GaugeVec // 读取配置文件失败采集
 	totalScrapes    prometheus.Counter   // 采集次数
 	timeToString    bool
+	maxRows         int           // max rows converted for a single query
+	maxSeries       int           // max label combinations of a single query
+	parallel        int           // number of queries executed concurrently on a server
+	scrapeTimeout   time.Duration // time budget of a scrape, 0 means no limit
+	serverTimeout   time.Duration // deadline of the scrape of each server, 0 means the scrape deadline only
+	strictStartup   bool          // prepare all queries on every server at start-up
+	registry        prometheus.Registerer
+	logger          commonlog.Logger // logger of the exporter and its servers, nil is the logger of the package
+	hooks           *Hooks
+	collectors      []Collector       // extra Go-level collectors
+	leaderKey       int64             // advisory lock key of leader election, 0 means disabled
+	sessionParams   map[string]string // connection parameters of every connection
+	sessionSetup    []string          // statements executed on every new connection
+	connectTimeout  time.Duration     // connect_timeout of dsns lacking one
+	dialTimeout     time.Duration     // TCP connect timeout of new connections
+	tlsTimeout      time.Duration     // TLS handshake and startup timeout of new connections
+	expireAfter     int               // scrapes state of disappeared objects is kept
+	separateSelf    bool              // own metrics are collected by SelfCollector only
+	mockFixtures    string            // dir of the fixtures answering the queries instead of the databases
+	mockResults     MockResults       // fixtures loaded from mockFixtures
+	recorder        *Recorder         // recorder of the result sets of the queries, nil if disabled
+	fallbackDSN     []string          // dsn scraped when neither the options nor the config give any
+	targets         []*Target         // targets of the config and the runtime targets, scraped in addition to dsn
+	runtimeTargets  []*Target         // targets added by AddTarget
+	targetFile      string            // file_sd file listing targets, reconciled by ReloadTargetFile
+	fileTargets     []*Target         // targets of the target file
+	targetsMtx      sync.RWMutex      // guards dsn, targets, runtimeTargets and fileTargets, changed at runtime
+	notifier        *Notifier         // notifier of the exporter's own health, nil if disabled
+	forceVersion    string            // openGauss version gating the queries instead of the detected one
+	forcedVersion   semver.Version    // parsed forceVersion
+	driver          string            // name of the driver opening the connections of the servers
+	cancelOnTimeout bool              // statements exceeding their timeout are canceled on the server too
+	singleConn      bool              // at most one session is held on every server
+	adaptiveTTL     bool              // the ttl of the queries follows the scrape interval of each client
+	maxConnections  int               // connections held to all servers, 0 means no limit
+	connBudget      *connBudget       // budget of maxConnections shared by the servers
+	connectBackoff  time.Duration     // delay of the connections to a target that failed to connect, 0 disables it
+	backoffMax      time.Duration     // cap of the connection delay, doubled on every failure
+	ctx             context.Context   // parent context of scrapes
+	loadedAt        time.Time         // when the config was loaded
+	configFiles     []configFileState // files of the config
+
+	connectErrors *prometheus.CounterVec // connection failures of the targets by reason
+
+	targetErrors    map[string]*LastError // most recent connection error by target
+	targetErrorsMtx sync.Mutex            // guards targetErrors
+
+	abandoned    map[string]int // count of the abandoned scrapes of each dsn still running
+	abandonedMtx sync.Mutex     // guards abandoned
 }
 
 // NewExporter New Exporter
 func NewExporter(opts ...Opt) (e *Exporter, err error) {
 	e = &Exporter{
-		metricMap: defaultMonList, // default metric
+		metricMap:      copyQueries(defaultMonList), // default metric
+		ctx:            context.Background(),
+		connectBackoff: DefaultConnectBackoff,
+		backoffMax:     DefaultMaxConnectBackoff,
 	}
 	for _, opt := range opts {
 		opt(e)
 	}
 
+	if e.driver != "" {
+		if _, err := getDriver(e.driver); err != nil {
+			return nil, err
+		}
+	}
+	if e.singleConn && (e.leaderKey != 0 || e.autoDiscovery) {
+		return nil, fmt.Errorf("single connection mode is incompatible with leader election and database auto discovery, they hold sessions of their own")
+	}
+	// the labels are parsed once the logger is set
+	e.constantLabels = parseConstLabels(e.constLabels, e.log())
+	if e.forceVersion != "" {
+		if e.forcedVersion, err = semver.ParseTolerant(e.forceVersion); err != nil {
+			return nil, fmt.Errorf("invalid forced server version %s: %w", e.forceVersion, err)
+		}
+	}
 	e.initDefaultMetric()
 
 	if err := e.loadConfig(); err != nil {
 		return nil, err
 	}
+	if err := e.loadTargets(); err != nil {
+		return nil, err
+	}
+	if e.mockFixtures != "" {
+		if e.mockResults, err = LoadMockFixtures(e.mockFixtures, e.metricMap); err != nil {
+			return nil, err
+		}
+	}
 	e.setupInternalMetrics()
 	e.setupServers()
+	e.loadedAt = time.Now()
 	return e, nil
 }
 
+// copyQueries returns clones of the queries, so the default ones shared by the exporters are not changed by the
+// config, the help file or the checks of one of them
+func copyQueries(queries map[string]*QueryInstance) map[string]*QueryInstance {
+	copied := make(map[string]*QueryInstance, len(queries))
+	for name, q := range queries {
+		copied[name] = q.clone()
+	}
+	return copied
+}
+
 // initDefaultMetric init default metric
 func (e *Exporter) initDefaultMetric() {
 	for _, q := range e.metricMap {
@@ -62,12 +153,13 @@ func (e *Exporter) initDefaultMetric() {
 // 加载配置文件,配置文件里相同指标覆盖默认配置
 func (e *Exporter) loadConfig() error {
 	if e.configPath == "" {
-		return nil
+		return e.loadHelpOverrides()
 	}
-	queryList, err := LoadConfig(e.configPath)
+	queryList, err := loadConfigPath(e.configPath, e.log())
 	if err != nil {
 		return err
 	}
+	e.configFiles = configFileStates(e.configPath)
 	for name, query := range queryList {
 		var found bool
 		for defName, defQuery := range e.metricMap {
@@ -81,6 +173,53 @@ func (e *Exporter) loadConfig() error {
 			e.metricMap[name] = query
 		}
 	}
+	if err := checkMetricNameCollisions(e.metricMap); err != nil {
+		return err
+	}
+	return e.loadHelpOverrides()
+}
+
+// loadTargets add the targets of the config and of the target file to the dsn,
+// the fallback dsn is only used without any nor a target file
+func (e *Exporter) loadTargets() error {
+	if e.configPath != "" {
+		targets, err := LoadTargets(e.configPath)
+		if err != nil {
+			return err
+		}
+		e.targets = targets
+	}
+	e.targets = append(e.targets, e.runtimeTargets...)
+	for _, target := range e.targets {
+		if !Contains(e.dsn, target.DSN) {
+			e.dsn = append(e.dsn, target.DSN)
+		}
+	}
+	if e.targetFile != "" {
+		targets, err := e.loadTargetFile()
+		if err != nil {
+			return err
+		}
+		e.addFileTargets(targets)
+	}
+	if len(e.dsn) == 0 && e.targetFile == "" {
+		e.dsn = e.fallbackDSN
+	}
+	return nil
+}
+
+// loadHelpOverrides apply the help file to the metric map
+func (e *Exporter) loadHelpOverrides() error {
+	if e.helpPath == "" {
+		return nil
+	}
+	overrides, err := LoadHelpOverrides(e.helpPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range applyHelpOverrides(e.metricMap, overrides) {
+		e.log().Warnf("help file %s: no metric named %s", e.helpPath, name)
+	}
 	return nil
 }
 
@@ -127,18 +266,65 @@ func (e *Exporter) setupInternalMetrics() {
 		Namespace:   e.namespace,
 		Subsystem:   "exporter",
 		Name:        "use_config_load_error",
-		Help:        "Whether the user config file was loaded and parsed successfully (1 for error, 0 for success).",
+		Help:        "Whether each user config file was loaded and parsed successfully (1 for error, 0 for success), by file and sha256 of its content.",
 		ConstLabels: e.constantLabels,
 	}, []string{"filename", "hashsum"})
+	for _, file := range e.configFiles {
+		var failed float64
+		if file.failed {
+			failed = 1
+		}
+		e.configFileError.WithLabelValues(file.path, file.hash).Set(failed)
+	}
+	e.connectErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   e.namespace,
+		Subsystem:   "exporter",
+		Name:        "connect_errors_total",
+		Help:        "Total number of failed connections to the targets by reason: auth, network, timeout, tls, too_many_connections or other.",
+		ConstLabels: e.constantLabels,
+	}, []string{"reason"})
+	for _, reason := range connectErrorReasons {
+		e.connectErrors.WithLabelValues(reason)
+	}
 }
 
 func (e *Exporter) setupServers() {
+	e.connBudget = newConnBudget(e.maxConnections)
 	e.servers = NewServers(ServerWithLabels(e.constantLabels),
 		ServerWithNamespace(e.namespace),
 		ServerWithDisableSettingsMetrics(e.disableSettingsMetrics),
 		ServerWithDisableCache(e.disableCache),
 		ServerWithTimeToString(e.timeToString),
+		ServerWithMaxRows(e.maxRows),
+		ServerWithMaxSeries(e.maxSeries),
+		ServerWithParallel(e.parallel),
+		ServerWithHooks(e.hooks),
+		ServerWithLogger(e.logger),
+		ServerWithNotifier(e.notifier),
+		ServerWithCollectors(e.collectors...),
+		ServerWithLeaderElection(e.leaderKey),
+		ServerWithExcludeDatabases(e.excludedDatabases),
+		ServerWithSessionParams(e.sessionParams),
+		ServerWithSessionSetup(e.sessionSetup),
+		ServerWithCancelOnTimeout(e.cancelOnTimeout),
+		ServerWithSingleConnection(e.singleConn),
+		ServerWithAdaptiveTTL(e.adaptiveTTL),
+		ServerWithConnectionBudget(e.connBudget),
+		ServerWithDriver(e.driver),
+		ServerWithConnectTimeout(e.connectTimeout),
+		ServerWithDialTimeouts(e.dialTimeout, e.tlsTimeout),
+		ServerWithExpireAfterScrapes(e.expireAfter),
+		ServerWithSeparateSelfMetrics(e.separateSelf),
+		ServerWithMockResults(e.mockResults),
+		ServerWithRecorder(e.recorder),
+		ServerWithTags(e.tags),
 	)
+	if e.connectBackoff > 0 {
+		e.servers.backoff = newConnectBackoff(e.connectBackoff, e.backoffMax)
+	}
+	for _, target := range e.targets {
+		e.servers.setTargetOpts(target.DSN, target.serverOpts()...)
+	}
 }
 
 // Describe implement prometheus.Collector
@@ -167,16 +353,39 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 //				-> GetServer
 // 				-> checkMapVersions
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.scrape(ch)
+	e.CollectContext(e.ctx, ch)
+}
 
-	ch <- e.duration
-	ch <- e.totalScrapes
-	ch <- e.error
+// CollectContext collect like Collect, the queries are canceled when ctx is done, e.g. the context of the scrape
+// request, so an abandoned scrape stops querying the databases. They are canceled when the context of WithContext
+// is done too
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if ctx != e.ctx {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-e.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if e.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.scrapeTimeout)
+		defer cancel()
+	}
+	e.scrape(ctx, ch)
+
+	if !e.separateSelf {
+		e.collectSelf(ch)
+	}
 	ch <- e.up
-	e.configFileError.Collect(ch)
 }
 
-func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
+func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) {
 	// 设置采集持续时间指标
 	defer func(begun time.Time) {
 		e.duration.Set(time.Since(begun).Seconds())
@@ -184,20 +393,24 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 
 	e.totalScrapes.Inc()
 
-	dsnList := e.dsn
+	dsnList := e.dsnList()
 	if e.autoDiscovery {
-		dsnList = e.discoverDatabaseDSNs()
+		dsnList = e.discoverDatabaseDSNs(ctx)
+		// close the servers of dropped databases
+		e.servers.expire(dsnList, e.expireAfter)
 	}
 
 	var errorsCount int
 	var connectionErrorsCount int
 
-	for _, dsn := range dsnList {
-		log.Debugf(dsn)
-		if err := e.scrapeDSN(ch, dsn); err != nil {
+	for _, result := range e.scrapeServers(ctx, dsnList) {
+		for _, metric := range result.metrics {
+			ch <- metric
+		}
+		if err := result.err; err != nil {
 			errorsCount++
 
-			log.Errorf(err.Error())
+			e.log().Errorf(RedactText(err.Error()))
 
 			if _, ok := err.(*ErrorConnectToServer); ok {
 				connectionErrorsCount++
@@ -211,7 +424,7 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 	default:
 		e.up.Set(1) // Didn't fail, can mark connection as up for this scrape.
 	}
-	log.Debugf("the errorsCount %v ", errorsCount)
+	e.log().Debugf("the errorsCount %v ", errorsCount)
 	switch errorsCount {
 	case 0:
 		e.error.Set(0)
@@ -220,93 +433,258 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 	}
 }
 
-func (e *Exporter) discoverDatabaseDSNs() []string {
+func (e *Exporter) discoverDatabaseDSNs(ctx context.Context) []string {
 	result := []string{}
-	for _, dsn := range e.dsn {
+	discovered := make(map[string]bool) // dsn already in result
+	instances := make(map[string]bool)  // fingerprint of instances with a master dsn
+	add := func(dsn string) {
+		if !discovered[dsn] {
+			discovered[dsn] = true
+			result = append(result, dsn)
+		}
+	}
+	for _, dsn := range e.dsnList() {
 		parsedDSN, err := parseDsn(dsn)
 		if err != nil {
-			log.Errorf("Unable to parse DSN (%s): %v", ShadowDSN(dsn), err)
+			e.log().Errorf("Unable to parse DSN (%s): %s", ShadowDSN(dsn), RedactText(err.Error()))
 			continue
 		}
 		server, err := e.servers.GetServer(dsn)
 		if err != nil {
-			log.Errorf("Error opening connection to database (%s): %v", ShadowDSN(dsn), err)
+			e.observeConnectError(err)
+			e.notifier.observeTarget(dsn, e.servers.targetLabels(dsn)[serverLabelName], err)
+			e.observeTargetError(e.targetName(dsn), err)
+			e.log().Errorf("Error opening connection to database (%s): %s", ShadowDSN(dsn), RedactText(err.Error()))
 			continue
 		}
+		e.notifier.observeTarget(dsn, server.String(), nil)
+		e.observeTargetError(server.String(), nil)
+
+		// If autoDiscoverDatabases is true, set first dsn of each instance as master database (Default: false),
+		// instance level metrics are only emitted by the master, not once per discovered database
+		master := !instances[server.String()]
+		instances[server.String()] = true
+		if master {
+			server.master = true
+		}
 
-		// If autoDiscoverDatabases is true, set first dsn as master database (Default: false)
-		server.master = true
-
-		databaseNames, err := server.QueryDatabases()
+		databaseNames, err := server.queryDatabaseNames(ctx)
 		if err != nil {
-			log.Errorf("Error querying databases (%s): %v", ShadowDSN(dsn), err)
+			e.log().Errorf("Error querying databases (%s): %s", ShadowDSN(dsn), RedactText(err.Error()))
 			continue
 		}
-		result = append(result, genDSNString(parsedDSN))
+		// the own database of the dsn is scraped by its regenerated dsn, which runs the cluster scoped queries
+		ownDSN := genDSNString(parsedDSN)
+		e.servers.addDerived(ownDSN, dsn)
+		if ownServer, err := e.servers.GetServer(ownDSN); err == nil && master {
+			ownServer.master = true
+		}
+		add(ownDSN)
 		for _, databaseName := range databaseNames {
-			if Contains(e.excludedDatabases, databaseName) {
+			if Contains(server.excludedDatabases, databaseName) {
 				continue
 			}
 			parsedDSN["database"] = databaseName
-			result = append(result, genDSNString(parsedDSN))
+			databaseDSN := genDSNString(parsedDSN)
+			e.servers.addDerived(databaseDSN, dsn)
+			add(databaseDSN)
 		}
 	}
 	return result
 }
 
-func (e *Exporter) scrapeDSN(ch chan<- prometheus.Metric, dsn string) error {
+func (e *Exporter) scrapeDSN(ctx context.Context, ch chan<- prometheus.Metric, dsn string) (err error) {
+	// a panic may carry the dsn, recover it to keep the password out of the crash output
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic scraping %s: %s", ShadowDSN(dsn), RedactText(fmt.Sprint(r)))
+		}
+	}()
 	server, err := e.servers.GetServer(dsn)
 
 	if err != nil {
-		return &ErrorConnectToServer{fmt.Sprintf("Error opening connection to database (%s): %s", ShadowDSN(dsn), err.Error())}
+		e.observeConnectError(err)
+		labels := e.servers.targetLabels(dsn)
+		if labels != nil {
+			ch <- e.targetUpMetric(labels, false)
+		}
+		e.observeTargetError(e.targetName(dsn), err)
+		if !e.autoDiscovery {
+			e.notifier.observeTarget(dsn, labels[serverLabelName], err)
+		}
+		return &ErrorConnectToServer{fmt.Sprintf("Error opening connection to database (%s): %s", ShadowDSN(dsn), RedactText(err.Error()))}
+	}
+	ch <- e.targetUpMetric(server.labels, true)
+	e.observeTargetError(server.String(), nil)
+	// with auto-discovery, the targets are observed by discoverDatabaseDSNs
+	if !e.autoDiscovery {
+		e.notifier.observeTarget(dsn, server.String(), nil)
 	}
 
 	// Check if autoDiscoverDatabases is false, set dsn as master database (Default: false)
 	if !e.autoDiscovery {
 		server.master = true
 	}
+	return server.collectTarget(ch, func(ch chan<- prometheus.Metric) error {
+		return e.scrapeServer(ctx, ch, server)
+	})
+}
+
+// scrapeServer scrape server unless it is a follower, the maps are refreshed if its version changed
+func (e *Exporter) scrapeServer(ctx context.Context, ch chan<- prometheus.Metric, server *Server) error {
+
+	// followers do not query the database
+	if server.leader != nil {
+		leader := server.leader.isLeader(ctx, server.db)
+		ch <- server.leaderMetric(leader)
+		if !leader {
+			return nil
+		}
+	}
 
 	// Check if map versions need to be updated
-	if err := e.checkMapVersions(ch, server); err != nil {
-		log.Warnln("Proceeding with outdated query maps, as the OpenGauss version could not be determined:", err)
+	if err := e.checkMapVersions(ctx, ch, server); err != nil {
+		e.log().Warnln("Proceeding with outdated query maps, as the OpenGauss version could not be determined:", err)
+	}
+
+	return server.ScrapeContext(ctx, ch)
+}
+
+func (e *Exporter) checkMapVersions(ctx context.Context, ch chan<- prometheus.Metric, server *Server) error {
+	if err := e.detectServer(ctx, server); err != nil {
+		return err
 	}
 
-	return server.Scrape(ch)
+	versionDesc := prometheus.NewDesc(fmt.Sprintf("%s_%s", e.namespace, staticLabelName),
+		"Version string as reported by OpenGauss", []string{"version", "short_version"}, server.labels)
+
+	restartsDesc := prometheus.NewDesc(prometheus.BuildFQName(e.namespace, "instance", "restarts_total"),
+		"Total number of database restarts detected by the exporter", nil, server.labels)
+
+	if server.master {
+		// the detected state is read under the lock it is published with, as other scrapes detect it again
+		server.mappingMtx.RLock()
+		metrics := []prometheus.Metric{
+			prometheus.MustNewConstMetric(versionDesc,
+				prometheus.UntypedValue, 1, server.lastShortVersion, server.lastMapVersion.String()),
+			prometheus.MustNewConstMetric(restartsDesc, prometheus.CounterValue, float64(server.restarts)),
+			e.flavorMetric(server),
+		}
+		server.mappingMtx.RUnlock()
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+	return nil
 }
 
-func (e *Exporter) checkMapVersions(ch chan<- prometheus.Metric, server *Server) error {
-	log.Debugf("Querying OpenGauss Version on %q", server)
-	versionRow := server.db.QueryRow("SELECT version();")
-	var versionString string
-	err := versionRow.Scan(&versionString)
+// serverInfoSQL query the version, the start time and the recovery state of the server
+const serverInfoSQL = "SELECT version(), pg_postmaster_start_time(), pg_is_in_recovery();"
+
+// detectServer detect version, start time and role of server, recalculate the query maps if version changed.
+// The detections of a server are serialized, their results are published under its mappingMtx for the scrapes
+func (e *Exporter) detectServer(ctx context.Context, server *Server) error {
+	server.detectMtx.Lock()
+	defer server.detectMtx.Unlock()
+	e.log().Debugf("Querying OpenGauss Version on %q", server)
+	var (
+		versionString string
+		startTime     time.Time
+		inRecovery    bool
+	)
+	scan := func() error {
+		versionRow := server.db.QueryRowContext(ctx, serverInfoSQL)
+		return versionRow.Scan(&versionString, &startTime, &inRecovery)
+	}
+	err := scan()
+	if err == nil && !sessionAttrsSatisfied(server.sessionAttrs, inRecovery) {
+		// switchover or failover, the hosts are resolved again by new connections
+		e.log().Warnf("%s is no longer %s, reconnecting", server, server.sessionAttrs)
+		server.resetConnections()
+		err = scan()
+	}
 	if err != nil {
 		return fmt.Errorf("Error scanning version string on %q: %v ", server, err)
 	}
-	semanticVersion, err := parseVersionSem(versionString)
-	if err != nil {
-		return fmt.Errorf("Error parsing version string on %q: %v ", server, err)
+	// the cached state is reset on database restart, so the version is detected again
+	if server.checkRestart(startTime) {
+		e.log().Warnf("Database restart detected on %s, start time %s", server, startTime)
+	}
+	server.mappingMtx.Lock()
+	server.inRecovery = inRecovery
+	server.mappingMtx.Unlock()
+	server.detectExtensions(ctx)
+	// version string seldom changes, only parse it when changed
+	semanticVersion, shortVersion, flavor := server.lastMapVersion, server.lastShortVersion, server.flavor
+	if versionString != server.lastVersionString || server.queryInstanceMap == nil {
+		flavor, shortVersion, semanticVersion, err = parseServerVersion(versionString)
+		switch {
+		case e.forceVersion != "":
+			if err != nil {
+				flavor, shortVersion = flavorUnknown, ""
+			}
+			semanticVersion = e.forcedVersion
+		case err != nil:
+			return fmt.Errorf("Error parsing version string on %q: %v ", server, err)
+		}
 	}
 	// Check if semantic version changed and recalculate maps if needed.
+	server.mappingMtx.Lock()
 	if semanticVersion.NE(server.lastMapVersion) || server.queryInstanceMap == nil {
-		log.Infof("Semantic Version Changed on %s: %s -> %s", server, server.lastMapVersion, semanticVersion)
-		server.mappingMtx.Lock()
+		e.log().Infof("Semantic Version Changed on %s: %s -> %s", server, server.lastMapVersion, semanticVersion)
 		server.queryInstanceMap = e.metricMap
 		server.lastMapVersion = semanticVersion
-		server.mappingMtx.Unlock()
-
+		server.resolveQuerySQLs()
+		server.descs.reset()
 	}
-
-	versionDesc := prometheus.NewDesc(fmt.Sprintf("%s_%s", e.namespace, staticLabelName),
-		"Version string as reported by OpenGauss", []string{"version", "short_version"}, server.labels)
-
-	if server.master {
-		ch <- prometheus.MustNewConstMetric(versionDesc,
-			prometheus.UntypedValue, 1, parseVersion(versionString), semanticVersion.String())
+	server.lastVersionString, server.lastShortVersion, server.flavor = versionString, shortVersion, flavor
+	server.mappingMtx.Unlock()
+	if server.recorder != nil {
+		info := &MockFixture{
+			SQL:     serverInfoSQL,
+			Columns: []string{"version", "pg_postmaster_start_time", "pg_is_in_recovery"},
+			Rows:    [][]interface{}{recordRow([]interface{}{versionString, startTime, inRecovery})},
+		}
+		if err := server.recorder.record(server, "server", info); err != nil {
+			e.log().Warnf("Error recording server info of %s: %s", server, err)
+		}
 	}
 	return nil
 }
 
+// QueryProfiles returns the cost profile of every query on every server since start
+func (e *Exporter) QueryProfiles() []QueryProfile {
+	var profiles []QueryProfile
+	for _, server := range e.servers.List() {
+		profiles = append(profiles, server.stats.profiles(server.String())...)
+	}
+	return profiles
+}
+
+// Check validate the exporter. With strict startup, connect to every server and prepare the resolved sql
+// of every enabled query, fail if any of them can not be prepared.
 func (e *Exporter) Check() error {
+	if !e.strictStartup {
+		return nil
+	}
+	var failures []string
+	for _, dsn := range e.dsnList() {
+		server, err := e.servers.GetServer(dsn)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", ShadowDSN(dsn), RedactText(err.Error())))
+			continue
+		}
+		if err := e.detectServer(e.ctx, server); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", server, err))
+			continue
+		}
+		for _, failure := range server.prepareQueries() {
+			failures = append(failures, fmt.Sprintf("%s: %s", server, failure))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("strict startup check failed:\n%s", strings.Join(failures, "\n"))
+	}
 	return nil
 }
 
@@ -3,7 +3,11 @@
 package exporter
 
 import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	commonlog "github.com/prometheus/common/log"
 	"strings"
+	"time"
 )
 
 // ExporterOpt configures Exporter
@@ -16,6 +20,13 @@ func WithDNS(dsn []string) Opt {
 	}
 }
 
+// WithFallbackDNS set the dsn scraped when neither WithDNS nor the targets of the config give any
+func WithFallbackDNS(dsn []string) Opt {
+	return func(e *Exporter) {
+		e.fallbackDSN = dsn
+	}
+}
+
 // WithConfig add config path to Exporter
 func WithConfig(configPath string) Opt {
 	return func(e *Exporter) {
@@ -23,10 +34,17 @@ func WithConfig(configPath string) Opt {
 	}
 }
 
+// WithHelpFile add the path of help overrides to Exporter
+func WithHelpFile(helpPath string) Opt {
+	return func(e *Exporter) {
+		e.helpPath = helpPath
+	}
+}
+
 // WithConstLabels add const label to exporter. 0 length label returns nil
 func WithConstLabels(s string) Opt {
 	return func(e *Exporter) {
-		e.constantLabels = parseConstLabels(s)
+		e.constLabels = s
 	}
 }
 
@@ -85,3 +103,136 @@ func WithExcludeDatabases(excludeStr string) Opt {
 		e.excludedDatabases = strings.Split(excludeStr, ",")
 	}
 }
+
+// WithMaxRows limit the number of rows converted for a single query. 0 means no limit
+func WithMaxRows(n int) Opt {
+	return func(e *Exporter) {
+		e.maxRows = n
+	}
+}
+
+// WithMaxSeries limit the number of distinct label combinations of a single query, the excess is dropped. 0 means no limit
+func WithMaxSeries(n int) Opt {
+	return func(e *Exporter) {
+		e.maxSeries = n
+	}
+}
+
+// WithParallel set the number of queries executed concurrently on a server
+func WithParallel(n int) Opt {
+	return func(e *Exporter) {
+		e.parallel = n
+	}
+}
+
+// WithScrapeTimeout set the time budget of a scrape, divided among the pending queries. 0 means no limit
+func WithScrapeTimeout(d time.Duration) Opt {
+	return func(e *Exporter) {
+		e.scrapeTimeout = d
+	}
+}
+
+// WithStrictStartup prepare all queries on every server at start-up and fail on error
+func WithStrictStartup(b bool) Opt {
+	return func(e *Exporter) {
+		e.strictStartup = b
+	}
+}
+
+// WithRegistry set the registry NewCollector registers into
+func WithRegistry(registry prometheus.Registerer) Opt {
+	return func(e *Exporter) {
+		e.registry = registry
+	}
+}
+
+// WithLogger set the logger of the exporter and its servers, the logger of the package if nil
+func WithLogger(logger commonlog.Logger) Opt {
+	return func(e *Exporter) {
+		e.logger = logger
+	}
+}
+
+// WithContext set the parent context of scrapes, scrapes are canceled when it is done
+func WithContext(ctx context.Context) Opt {
+	return func(e *Exporter) {
+		if ctx != nil {
+			e.ctx = ctx
+		}
+	}
+}
+
+// WithHooks set hooks invoked before/after each server scrape and each query
+func WithHooks(hooks Hooks) Opt {
+	return func(e *Exporter) {
+		e.hooks = &hooks
+	}
+}
+
+// WithCollectors add Go-level collectors run on every server besides the registered ones
+func WithCollectors(collectors ...Collector) Opt {
+	return func(e *Exporter) {
+		e.collectors = append(e.collectors, collectors...)
+	}
+}
+
+// WithSessionParams set connection parameters, e.g. application_name or GUCs, on every connection to the servers
+func WithSessionParams(params map[string]string) Opt {
+	return func(e *Exporter) {
+		e.sessionParams = params
+	}
+}
+
+// WithSessionSetup execute statements on every new connection to the servers, e.g. SET ROLE monitor
+func WithSessionSetup(statements []string) Opt {
+	return func(e *Exporter) {
+		e.sessionSetup = statements
+	}
+}
+
+// WithConnectTimeouts set connect_timeout of the dsns lacking one, and bound the TCP connect and the handshake
+// of new connections separately. 0 means no limit
+func WithConnectTimeouts(connect, dial, handshake time.Duration) Opt {
+	return func(e *Exporter) {
+		e.connectTimeout = connect
+		e.dialTimeout = dial
+		e.tlsTimeout = handshake
+	}
+}
+
+// WithExpireAfterScrapes drop the state of disappeared objects, e.g. databases, not seen within n scrapes. 0 keeps it
+func WithExpireAfterScrapes(n int) Opt {
+	return func(e *Exporter) {
+		e.expireAfter = n
+	}
+}
+
+// WithSeparateSelfMetrics do not emit the exporter's own metrics with the database samples, see SelfCollector
+func WithSeparateSelfMetrics(b bool) Opt {
+	return func(e *Exporter) {
+		e.separateSelf = b
+	}
+}
+
+// WithMockFixtures answer the queries with the fixtures of dir instead of connecting to the databases, see LoadMockFixtures
+func WithMockFixtures(dir string) Opt {
+	return func(e *Exporter) {
+		e.mockFixtures = dir
+	}
+}
+
+// WithRecord write the result sets of the queries to fixture files in dir during scrapes, see Recorder. Empty disables recording
+func WithRecord(dir string) Opt {
+	return func(e *Exporter) {
+		if dir != "" {
+			e.recorder = NewRecorder(dir)
+		}
+	}
+}
+
+// WithLeaderElection only query servers when holding the advisory lock of key. 0 disables leader election
+func WithLeaderElection(key int64) Opt {
+	return func(e *Exporter) {
+		e.leaderKey = key
+	}
+}
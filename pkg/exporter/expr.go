@@ -0,0 +1,203 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// expression is an arithmetic expression over result columns of a query, e.g. blks_hit / (blks_hit + blks_read).
+// Numbers, column names, + - * / and parentheses are supported.
+type expression struct {
+	text    string
+	root    exprNode
+	columns []string // referenced column names, in order of first appearance
+}
+
+// exprNode evaluate to a value, false if an operand is NULL
+type exprNode interface {
+	eval(lookup func(name string) (float64, bool)) (float64, bool)
+}
+
+type exprNumber float64
+
+func (n exprNumber) eval(func(string) (float64, bool)) (float64, bool) {
+	return float64(n), true
+}
+
+type exprColumn string
+
+func (c exprColumn) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	return lookup(string(c))
+}
+
+type exprNegate struct {
+	operand exprNode
+}
+
+func (n exprNegate) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	v, ok := n.operand.eval(lookup)
+	return -v, ok
+}
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+func (b exprBinary) eval(lookup func(string) (float64, bool)) (float64, bool) {
+	l, ok := b.left.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.right.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		// a ratio of nothing, e.g. no block read yet, has no value
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+// parseExpression parse the expression text
+func parseExpression(text string) (*expression, error) {
+	p := &exprParser{text: text}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return nil, fmt.Errorf("unexpected %q at %d in expression %q", p.text[p.pos], p.pos, text)
+	}
+	return &expression{text: text, root: root, columns: p.columns}, nil
+}
+
+// eval evaluate the expression, false if a column is NULL or a divisor is 0
+func (e *expression) eval(lookup func(name string) (float64, bool)) (float64, bool) {
+	v, ok := e.root.eval(lookup)
+	if !ok || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+type exprParser struct {
+	text    string
+	pos     int
+	columns []string
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.text) && asciiSpace[p.text[p.pos]] == 1 {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, 0 at the end
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+// parseSum parse term (('+'|'-') term)*
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct parse unary (('*'|'/') unary)*
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parse '-' unary | number | column | '(' sum ')'
+func (p *exprParser) parseUnary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression %q", p.text)
+	case c == '-':
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNegate{operand: operand}, nil
+	case c == '(':
+		p.pos++
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d in expression %q", p.pos, p.text)
+		}
+		p.pos++
+		return node, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.text) && (p.text[p.pos] == '.' || (p.text[p.pos] >= '0' && p.text[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression %q", p.text[start:p.pos], p.text)
+		}
+		return exprNumber(v), nil
+	case isIdentByte(c, true):
+		start := p.pos
+		for p.pos < len(p.text) && isIdentByte(p.text[p.pos], false) {
+			p.pos++
+		}
+		name := p.text[start:p.pos]
+		if !Contains(p.columns, name) {
+			p.columns = append(p.columns, name)
+		}
+		return exprColumn(name), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at %d in expression %q", c, p.pos, p.text)
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,101 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_parseExpression(t *testing.T) {
+	columns := map[string]float64{"blks_hit": 90, "blks_read": 10, "zero": 0}
+	lookup := func(name string) (float64, bool) {
+		v, ok := columns[name]
+		return v, ok
+	}
+	tests := []struct {
+		expr    string
+		want    float64
+		ok      bool
+		columns []string
+	}{
+		{expr: "blks_hit / (blks_hit + blks_read)", want: 0.9, ok: true, columns: []string{"blks_hit", "blks_read"}},
+		{expr: "1 + 2 * 3", want: 7, ok: true},
+		{expr: "(1 + 2) * 3", want: 9, ok: true},
+		{expr: "10 - 4 - 3", want: 3, ok: true},
+		{expr: "-blks_read * 2.5", want: -25, ok: true, columns: []string{"blks_read"}},
+		{expr: "blks_hit / zero", ok: false, columns: []string{"blks_hit", "zero"}},
+		{expr: "blks_hit + missing", ok: false, columns: []string{"blks_hit", "missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpression(tt.expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, ok := e.eval(lookup)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.InDelta(t, tt.want, got, 1e-9)
+			}
+			assert.Equal(t, tt.columns, e.columns)
+		})
+	}
+	for _, expr := range []string{"", "a +", "(a", "a b", "a % b", "1..2"} {
+		_, err := parseExpression(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func Test_Server_queryMetric_expr(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	queryInstance := &QueryInstance{
+		Name:    "pg_stat_database",
+		Queries: []*Query{{SQL: "SELECT datname, blks_hit, blks_read"}},
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL},
+			{Name: "blks_hit", Usage: COUNTER},
+			{Name: "blks_read", Usage: COUNTER},
+			{Name: "hit_ratio", Usage: GAUGE, Expr: "blks_hit / (blks_hit + blks_read)", NullValue: NullSkip},
+		},
+	}
+	assert.NoError(t, queryInstance.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		stats:  newQueryStats(),
+	}
+	mock.ExpectQuery("SELECT datname").WillReturnRows(sqlmock.NewRows([]string{"datname", "blks_hit", "blks_read"}).
+		AddRow("postgres", 75, 25).AddRow("empty", 0, 0))
+	metrics, errs, err := s.queryMetric("pg_stat_database", queryInstance)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	ratios := map[string]float64{}
+	for _, metric := range metrics {
+		if metric.Desc().String() != queryInstance.newColumnDesc(queryInstance.Columns["hit_ratio"], s.labels).String() {
+			continue
+		}
+		m := &dto.Metric{}
+		_ = metric.Write(m)
+		ratios[m.Label[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"postgres": 0.75}, ratios)
+	assert.Len(t, metrics, 5)
+
+	mock.ExpectQuery("SELECT datname").WillReturnRows(sqlmock.NewRows([]string{"datname", "blks_hit"}).AddRow("postgres", 75))
+	_, errs, err = s.queryMetric("pg_stat_database", queryInstance)
+	assert.NoError(t, err)
+	assert.Len(t, errs, 1)
+
+	queryInstance.Metrics[3].Expr = "hit_ratio * 2"
+	assert.Error(t, queryInstance.Check())
+	queryInstance.Metrics[3].Expr = "blks_hit +"
+	assert.Error(t, queryInstance.Check())
+}
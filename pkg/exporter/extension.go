@@ -0,0 +1,66 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// extensionsSQL query the extensions installed in the database of the server
+	extensionsSQL = "SELECT extname FROM pg_extension ORDER BY extname"
+	// extensionsRefreshInterval is the interval the extensions are queried again, e.g. after CREATE EXTENSION
+	extensionsRefreshInterval = time.Minute
+)
+
+// detectExtensions refresh the extensions installed in the database of the server, at most every refresh interval.
+// The previous extensions are kept if they can not be queried
+func (s *Server) detectExtensions(ctx context.Context) {
+	if !s.extensionsDetected.IsZero() && time.Since(s.extensionsDetected) < extensionsRefreshInterval {
+		return
+	}
+	rows, err := s.db.QueryContext(ctx, extensionsSQL)
+	if err != nil {
+		s.log().Debugf("Error querying extensions on %q: %v", s, err)
+		return
+	}
+	defer rows.Close()
+	var extensions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			s.log().Debugf("Error scanning extensions on %q: %v", s, err)
+			return
+		}
+		extensions = append(extensions, name)
+	}
+	if err := rows.Err(); err != nil {
+		s.log().Debugf("Error querying extensions on %q: %v", s, err)
+		return
+	}
+	if !equalStrings(extensions, s.extensions) {
+		s.log().Infof("Extensions of %q: %v", s, extensions)
+	}
+	s.mappingMtx.Lock()
+	s.extensions, s.extensionsDetected = extensions, time.Now()
+	s.mappingMtx.Unlock()
+}
+
+// extensionSkipped returns whether the query needs an extension not installed in the database of the server
+func (s *Server) extensionSkipped(queryInstance *QueryInstance) bool {
+	return queryInstance.Extension != "" && !Contains(s.extensions, queryInstance.Extension)
+}
+
+// equalStrings returns whether a and b hold the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
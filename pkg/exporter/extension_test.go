@@ -0,0 +1,62 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestServer_detectExtensions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{db: db, labels: prometheus.Labels{serverLabelName: "localhost:5432"}}
+	q := &QueryInstance{Name: "pg_dolphin_sessions", Extension: "dolphin"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(extensionsSQL)).WillReturnRows(sqlmock.NewRows([]string{"extname"}).AddRow("plpgsql"))
+	s.detectExtensions(context.Background())
+	assert.Equal(t, []string{"plpgsql"}, s.extensions)
+	assert.True(t, s.extensionSkipped(q))
+	assert.False(t, s.extensionSkipped(&QueryInstance{Name: "pg_lock"}))
+
+	// the extensions are not queried again before the refresh interval
+	s.detectExtensions(context.Background())
+
+	s.extensionsDetected = time.Now().Add(-extensionsRefreshInterval)
+	mock.ExpectQuery(regexp.QuoteMeta(extensionsSQL)).WillReturnRows(sqlmock.NewRows([]string{"extname"}).AddRow("dolphin").AddRow("plpgsql"))
+	s.detectExtensions(context.Background())
+	assert.False(t, s.extensionSkipped(q))
+
+	// a failure keeps the extensions
+	s.extensionsDetected = time.Time{}
+	mock.ExpectQuery(regexp.QuoteMeta(extensionsSQL)).WillReturnError(errors.New("permission denied"))
+	s.detectExtensions(context.Background())
+	assert.Equal(t, []string{"dolphin", "plpgsql"}, s.extensions)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadConfig_extension(t *testing.T) {
+	queries, err := LoadConfig("../../og_exporter_default.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, name := range []string{"pg_dolphin_settings", "pg_dolphin_objects", "pg_dolphin_sessions"} {
+		if assert.Contains(t, queries, name) {
+			assert.Equal(t, "dolphin", queries[name].Extension)
+			assert.Equal(t, defaultMonList[name].Queries[0].SQL, queries[name].Queries[0].SQL)
+		}
+	}
+	// the sessions are broken down by protocol
+	if assert.Contains(t, queries, "pg_dolphin_sessions") {
+		assert.Equal(t, []string{"datname", "protocol"}, queries["pg_dolphin_sessions"].LabelNames)
+	}
+}
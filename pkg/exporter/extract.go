@@ -0,0 +1,58 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Extract compute a column from a capture of a regex applied to the text of another column of the result,
+// e.g. 12s out of "rto: 12s", instead of parsing the string in SQL. A metric column parses the capture like any
+// textual value, a label column emits it as is
+type Extract struct {
+	From  string         `yaml:"from"`            // text column of the result the regex applies to
+	Regex string         `yaml:"regex"`           // regex, the first match is used
+	Group string         `yaml:"group,omitempty"` // named group giving the value, the first group by default
+	regex *regexp.Regexp `yaml:"-"`
+	index int            `yaml:"-"` // index of the submatch giving the value
+}
+
+// Check returns the field of the extraction of column with an error, and compile the regex
+func (e *Extract) Check(column string) (string, error) {
+	if e.From == "" {
+		return "from", fmt.Errorf("from is required")
+	}
+	if e.From == column {
+		return "from", fmt.Errorf("extract refers to the column itself")
+	}
+	regex, err := regexp.Compile(e.Regex)
+	if err != nil {
+		return "regex", fmt.Errorf("invalid regex %q: %s", e.Regex, err)
+	}
+	switch {
+	case e.Group != "":
+		if e.index = regex.SubexpIndex(e.Group); e.index < 0 {
+			return "group", fmt.Errorf("regex %q has no group %s", e.Regex, e.Group)
+		}
+	case regex.NumSubexp() > 0:
+		e.index = 1
+	default:
+		// the whole match without group
+		e.index = 0
+	}
+	e.regex = regex
+	return "", nil
+}
+
+// apply returns the capture of the regex in v, false if it does not match
+func (e *Extract) apply(v string) (string, bool) {
+	if e.regex == nil {
+		return "", false
+	}
+	match := e.regex.FindStringSubmatch(v)
+	if match == nil {
+		return "", false
+	}
+	return match[e.index], true
+}
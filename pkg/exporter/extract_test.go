@@ -0,0 +1,89 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExtract_apply(t *testing.T) {
+	tests := []struct {
+		extract Extract
+		text    string
+		want    string
+		match   bool
+	}{
+		{Extract{From: "status", Regex: `rto: (\d+s)`}, "rto: 12s, rpo: 3s", "12s", true},
+		{Extract{From: "status", Regex: `rto: (?P<rto>\d+)s, rpo: (?P<rpo>\d+)s`, Group: "rpo"}, "rto: 12s, rpo: 3s", "3", true},
+		{Extract{From: "status", Regex: `\d+`}, "rto: 12s", "12", true},
+		{Extract{From: "status", Regex: `rto: (\d+s)`}, "unknown", "", false},
+	}
+	for _, tt := range tests {
+		_, err := tt.extract.Check("rto")
+		assert.NoError(t, err)
+		got, match := tt.extract.apply(tt.text)
+		assert.Equal(t, tt.want, got, tt.extract.Regex)
+		assert.Equal(t, tt.match, match, tt.extract.Regex)
+	}
+
+	field, err := (&Extract{From: "status", Regex: `(\d+`}).Check("rto")
+	assert.Equal(t, "regex", field)
+	assert.Error(t, err)
+	field, err = (&Extract{From: "status", Regex: `(\d+)`, Group: "rto"}).Check("rto")
+	assert.Equal(t, "group", field)
+	assert.Error(t, err)
+	field, err = (&Extract{From: "rto", Regex: `(\d+)`}).Check("rto")
+	assert.Equal(t, "from", field)
+	assert.Error(t, err)
+}
+
+func Test_Server_queryMetric_extract(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	queryInstance := &QueryInstance{
+		Name:    "og_recovery",
+		Queries: []*Query{{SQL: "SELECT status"}},
+		Metrics: []*Column{
+			{Name: "status", Usage: DISCARD},
+			{Name: "mode", Usage: LABEL, Extract: &Extract{From: "status", Regex: `mode: (\w+)`}},
+			{Name: "rto", Usage: GAUGE, Extract: &Extract{From: "status", Regex: `rto: (\d+s)`}, NullValue: NullSkip},
+		},
+	}
+	assert.NoError(t, queryInstance.Check())
+	assert.Empty(t, lintQuery("og_recovery", queryInstance))
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		stats:  newQueryStats(),
+	}
+	mock.ExpectQuery("SELECT status").WillReturnRows(sqlmock.NewRows([]string{"status"}).
+		AddRow("mode: sync, rto: 12s").AddRow("mode: async"))
+	metrics, errs, err := s.queryMetric("og_recovery", queryInstance)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	if assert.Len(t, metrics, 1) {
+		m := &dto.Metric{}
+		_ = metrics[0].Write(m)
+		assert.Equal(t, "sync", m.Label[0].GetValue())
+		assert.Equal(t, 12.0, m.GetGauge().GetValue())
+	}
+
+	// the text column is missing
+	mock.ExpectQuery("SELECT status").WillReturnRows(sqlmock.NewRows([]string{"other"}).AddRow("mode: sync"))
+	_, errs, err = s.queryMetric("og_recovery", queryInstance)
+	assert.NoError(t, err)
+	assert.Len(t, errs, 2)
+
+	queryInstance.Metrics[2].Expr = "1"
+	assert.Error(t, queryInstance.Check())
+	queryInstance.Metrics[2].Expr = ""
+	queryInstance.Metrics[2].Extract.From = "mode"
+	assert.Error(t, queryInstance.Check())
+}
@@ -0,0 +1,152 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+// sdMetaLabelPrefix prefix the labels of a target group reserved to the discovery, they are not exposed
+const sdMetaLabelPrefix = "__"
+
+// SDTargetGroup is a group of targets of a file in the Prometheus file_sd format, the targets are dsn
+type SDTargetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// WithTargetFile set the file_sd file listing targets scraped in addition to the dsn and the targets of the config,
+// reconciled by ReloadTargetFile
+func WithTargetFile(path string) Opt {
+	return func(e *Exporter) {
+		e.targetFile = path
+	}
+}
+
+// ParseSDFile returns the targets of content in the Prometheus file_sd format, JSON or YAML.
+// The labels of a group apply to each of its targets, the server label sets their alias
+func ParseSDFile(content []byte, path string) ([]*Target, error) {
+	var groups []*SDTargetGroup
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var targets []*Target
+	seen := make(map[string]bool)
+	for i, group := range groups {
+		for j, dsn := range group.Targets {
+			target := &Target{DSN: dsn}
+			for name, value := range group.Labels {
+				switch {
+				case strings.HasPrefix(name, sdMetaLabelPrefix):
+				case name == serverLabelName:
+					target.Alias = value
+				default:
+					if target.Labels == nil {
+						target.Labels = make(map[string]string, len(group.Labels))
+					}
+					target.Labels[name] = value
+				}
+			}
+			if _, err := target.Check(); err != nil {
+				return nil, fmt.Errorf("%s: [%d].targets[%d]: %w", path, i, j, err)
+			}
+			if seen[dsn] {
+				return nil, fmt.Errorf("%s: [%d].targets[%d]: target %s repeated", path, i, j, ShadowDSN(dsn))
+			}
+			seen[dsn] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// loadTargetFile returns the targets of the target file, decrypted if encrypted
+func (e *Exporter) loadTargetFile() ([]*Target, error) {
+	content, err := ReadSecretFile(e.targetFile)
+	if err != nil {
+		return nil, fmt.Errorf("fail reading target file %s: %w", e.targetFile, err)
+	}
+	return ParseSDFile(content, e.targetFile)
+}
+
+// addFileTargets add the targets of the target file not already scraped, must hold targetsMtx.
+// The dsn given by options, the config or AddTarget are kept as they are
+func (e *Exporter) addFileTargets(targets []*Target) {
+	for _, target := range targets {
+		if Contains(e.dsn, target.DSN) {
+			e.log().Warnf("Target %s of %s is already scraped, skipped", ShadowDSN(target.DSN), e.targetFile)
+			continue
+		}
+		e.dsn = append(e.dsn[:len(e.dsn):len(e.dsn)], target.DSN)
+		e.targets = append(e.targets, target)
+		e.fileTargets = append(e.fileTargets, target)
+		if e.servers != nil {
+			e.servers.setTargetOpts(target.DSN, target.serverOpts()...)
+		}
+	}
+}
+
+// ReloadTargetFile reconcile the scraped targets with the target file. The servers of the targets removed from
+// the file are closed, the ones of changed targets are reopened with their new settings on the next scrape.
+// The targets are kept if the file can not be read or parsed
+func (e *Exporter) ReloadTargetFile() error {
+	if e.targetFile == "" {
+		return nil
+	}
+	targets, err := e.loadTargetFile()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*Target, len(targets))
+	for _, target := range targets {
+		current[target.DSN] = target
+	}
+
+	e.targetsMtx.Lock()
+	defer e.targetsMtx.Unlock()
+	var added, removed, changed int
+	fileTargets := e.fileTargets
+	e.fileTargets = nil
+	for _, target := range fileTargets {
+		newTarget, ok := current[target.DSN]
+		switch {
+		case !ok:
+			e.dsn = removeDSN(e.dsn, target.DSN)
+			e.targets = removeTarget(e.targets, target.DSN)
+			e.servers.remove(target.DSN)
+			removed++
+			continue
+		case !reflect.DeepEqual(target, newTarget):
+			e.targets = append(removeTarget(e.targets, target.DSN), newTarget)
+			e.servers.resetTarget(target.DSN, newTarget.serverOpts()...)
+			changed++
+		}
+		e.fileTargets = append(e.fileTargets, newTarget)
+		delete(current, target.DSN)
+	}
+	var newTargets []*Target
+	for _, target := range targets {
+		if _, ok := current[target.DSN]; ok {
+			newTargets = append(newTargets, target)
+		}
+	}
+	previous := len(e.fileTargets)
+	e.addFileTargets(newTargets)
+	added = len(e.fileTargets) - previous
+	e.log().Infof("Target file %s reloaded: %d targets added, %d removed, %d changed", e.targetFile, added, removed, changed)
+	return nil
+}
+
+// removeDSN returns a copy of dsnList without dsn
+func removeDSN(dsnList []string, dsn string) []string {
+	result := make([]string, 0, len(dsnList))
+	for _, d := range dsnList {
+		if d != dsn {
+			result = append(result, d)
+		}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"sync"
+)
+
+// flavor of a server whose version string matches no version parser, with a forced version
+const flavorUnknown = "unknown"
+
+// VersionParser parse the version string of an openGauss flavor, e.g. a derivative like MogDB.
+// The queries are gated by the openGauss version the flavor version is compatible with
+type VersionParser struct {
+	Flavor string         // name of the flavor, the flavor label of the version info metric
+	Regex  *regexp.Regexp // the first submatch is the version of the flavor
+	// Compatible returns the openGauss version of a version of the flavor, nil if they are the same
+	Compatible func(version semver.Version) semver.Version
+}
+
+// parse returns the version of the flavor and the openGauss version it is compatible with
+func (p *VersionParser) parse(versionString string) (flavorVersion string, version semver.Version, ok bool) {
+	subMatches := p.Regex.FindStringSubmatch(versionString)
+	if len(subMatches) < 2 {
+		return "", semver.Version{}, false
+	}
+	version, err := semver.ParseTolerant(subMatches[1])
+	if err != nil {
+		return "", semver.Version{}, false
+	}
+	if p.Compatible != nil {
+		version = p.Compatible(version)
+	}
+	return subMatches[1], version, true
+}
+
+var (
+	versionParsersMtx sync.Mutex
+	// versionParsers are tried in order, derivatives first as their version strings may mention openGauss too
+	versionParsers = []*VersionParser{
+		{
+			Flavor: "mogdb",
+			Regex:  regexp.MustCompile(`MogDB\s+(\d+\.\d+(\.\d+)?)`),
+		},
+		{
+			// Vastbase G100 V2.2 is based on openGauss 3.0
+			Flavor: "vastbase",
+			Regex:  regexp.MustCompile(`Vastbase\s+G100\s+V(\d+\.\d+(\.\d+)?)`),
+			Compatible: func(version semver.Version) semver.Version {
+				if version.Major == 2 && version.Minor >= 2 {
+					return semver.Version{Major: 3}
+				}
+				return semver.Version{Major: 2}
+			},
+		},
+		{
+			Flavor: "opengauss",
+			Regex:  versionRegex,
+		},
+	}
+)
+
+// RegisterVersionParser register a parser of the version strings of a flavor, tried before the registered ones
+func RegisterVersionParser(p *VersionParser) {
+	versionParsersMtx.Lock()
+	defer versionParsersMtx.Unlock()
+	versionParsers = append([]*VersionParser{p}, versionParsers...)
+}
+
+// parseServerVersion returns the flavor, the flavor version and the openGauss version of a version string
+func parseServerVersion(versionString string) (flavor, flavorVersion string, version semver.Version, err error) {
+	versionParsersMtx.Lock()
+	defer versionParsersMtx.Unlock()
+	for _, p := range versionParsers {
+		if flavorVersion, version, ok := p.parse(versionString); ok {
+			return p.Flavor, flavorVersion, version, nil
+		}
+	}
+	return "", "", semver.Version{}, fmt.Errorf("Could not find a openGauss version in string: %s", versionString)
+}
+
+// WithForceServerVersion gate the queries by this openGauss version instead of the detected one,
+// for flavors whose version string is not parsed. Empty detects the version
+func WithForceServerVersion(version string) Opt {
+	return func(e *Exporter) {
+		e.forceVersion = version
+	}
+}
+
+// flavorMetric returns the version info metric of the flavor of server
+func (e *Exporter) flavorMetric(server *Server) prometheus.Metric {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(e.namespace, "server", "version_info"),
+		"Flavor and version of the server as detected, and the openGauss version its queries are gated by",
+		[]string{"flavor", "flavor_version", "version"}, server.labels)
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1,
+		server.flavor, server.lastShortVersion, server.lastMapVersion.String())
+}
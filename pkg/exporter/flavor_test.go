@@ -0,0 +1,132 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_parseServerVersion(t *testing.T) {
+	tests := []struct {
+		versionString string
+		flavor        string
+		flavorVersion string
+		version       string
+	}{
+		{
+			versionString: "PostgreSQL 9.2.4 (openGauss 3.0.0 build 02c14696) compiled at 2022-04-01 18:12:34 commit 0 last mr  on x86_64-unknown-linux-gnu, compiled by g++ (GCC) 7.3.0, 64-bit",
+			flavor:        "opengauss", flavorVersion: "3.0.0", version: "3.0.0",
+		},
+		{
+			versionString: "PostgreSQL 9.2.4 (MogDB 2.1.1 build b5f25b20) compiled at 2022-03-21 14:42:30 commit 0 last mr  on x86_64-unknown-linux-gnu, compiled by g++ (GCC) 7.3.0, 64-bit",
+			flavor:        "mogdb", flavorVersion: "2.1.1", version: "2.1.1",
+		},
+		{
+			versionString: "PostgreSQL 9.2.4 (Vastbase G100 V2.2 (Build 10)) compiled at 2022-09-21 11:11:11 commit 0 last mr  on x86_64-unknown-linux-gnu, compiled by g++ (GCC) 7.3.0, 64-bit",
+			flavor:        "vastbase", flavorVersion: "2.2", version: "3.0.0",
+		},
+	}
+	for _, tt := range tests {
+		flavor, flavorVersion, version, err := parseServerVersion(tt.versionString)
+		if assert.NoError(t, err, tt.versionString) {
+			assert.Equal(t, tt.flavor, flavor)
+			assert.Equal(t, tt.flavorVersion, flavorVersion)
+			assert.Equal(t, tt.version, version.String())
+		}
+	}
+	_, _, _, err := parseServerVersion("PostgreSQL 9.2.4 (Uqbar 1.1.0 build 1)")
+	assert.Error(t, err)
+
+	// a registered parser is tried first
+	defer func(parsers []*VersionParser) { versionParsers = parsers }(versionParsers)
+	RegisterVersionParser(&VersionParser{Flavor: "uqbar", Regex: regexp.MustCompile(`Uqbar\s+(\d+\.\d+\.\d+)`),
+		Compatible: func(semver.Version) semver.Version { return semver.Version{Major: 3} }})
+	flavor, flavorVersion, version, err := parseServerVersion("PostgreSQL 9.2.4 (Uqbar 1.1.0 build 1)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"uqbar", "1.1.0", "3.0.0"}, []string{flavor, flavorVersion, version.String()})
+}
+
+func TestExporter_detectServer_forceVersion(t *testing.T) {
+	_, err := NewExporter(WithForceServerVersion("three"))
+	assert.EqualError(t, err, `invalid forced server version three: Invalid character(s) found in major number "three"`)
+
+	e, err := NewExporter(WithForceServerVersion("3.0"), WithNamespace("pg"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{db: db, master: true, labels: prometheus.Labels{serverLabelName: "localhost:5432"},
+		metricCache: make(map[string]cachedMetrics), descs: newDescCache()}
+	mock.ExpectQuery(regexp.QuoteMeta(serverInfoSQL)).WillReturnRows(sqlmock.NewRows([]string{"version", "start", "recovery"}).
+		AddRow("PostgreSQL 9.2.4 (Uqbar 1.1.0 build 1)", time.Now(), false))
+
+	ch := make(chan prometheus.Metric, 10)
+	assert.NoError(t, e.checkMapVersions(context.Background(), ch, s))
+	close(ch)
+	assert.Equal(t, "3.0.0", s.lastMapVersion.String())
+	assert.Equal(t, flavorUnknown, s.flavor)
+
+	var found bool
+	for metric := range ch {
+		if !regexp.MustCompile(`"pg_server_version_info"`).MatchString(metric.Desc().String()) {
+			continue
+		}
+		found = true
+		m := &dto.Metric{}
+		assert.NoError(t, metric.Write(m))
+		labels := make(map[string]string)
+		for _, label := range m.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, map[string]string{"flavor": "unknown", "flavor_version": "", "version": "3.0.0", "server": "localhost:5432"}, labels)
+	}
+	assert.True(t, found)
+}
+
+func TestExporter_detectServer_concurrent(t *testing.T) {
+	e, err := NewExporter(WithNamespace("pg"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	s := &Server{db: db, master: true, labels: prometheus.Labels{serverLabelName: "localhost:5432"},
+		metricCache: make(map[string]cachedMetrics), descs: newDescCache()}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(regexp.QuoteMeta(serverInfoSQL)).WillReturnRows(sqlmock.NewRows([]string{"version", "start", "recovery"}).
+			AddRow("PostgreSQL 9.2.4 (openGauss 3.0.0 build 02c14696)", time.Unix(1, 0), i == 1))
+	}
+
+	// the scrapes detect the server while others read what it detected
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			ch := make(chan prometheus.Metric, 10)
+			assert.NoError(t, e.checkMapVersions(context.Background(), ch, s))
+			s.mappingMtx.RLock()
+			_ = s.serverInfo()
+			s.mappingMtx.RUnlock()
+		}()
+	}
+	<-done
+	<-done
+	assert.Equal(t, "3.0.0", s.lastMapVersion.String())
+	assert.Equal(t, "opengauss", s.flavor)
+}
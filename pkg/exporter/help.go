@@ -0,0 +1,82 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+)
+
+// HelpOverride replace the help text of a metric and link its documentation, keyed by metric name in the help file
+type HelpOverride struct {
+	Help   string `yaml:"help,omitempty"`
+	DocURL string `yaml:"doc_url,omitempty"`
+}
+
+// LoadHelpOverrides read the help file
+func LoadHelpOverrides(path string) (map[string]*HelpOverride, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail reading help file %s: %w", path, err)
+	}
+	overrides := make(map[string]*HelpOverride)
+	if err = yaml.Unmarshal(content, &overrides); err != nil {
+		return nil, fmt.Errorf("malformed help file %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// applyHelpOverrides set the help text and documentation url of the metric columns found in overrides,
+// it returns the names of the overrides matching no metric. The queries overridden are replaced by clones in
+// queries, as the default ones are shared by the exporters
+func applyHelpOverrides(queries map[string]*QueryInstance, overrides map[string]*HelpOverride) []string {
+	used := make(map[string]bool, len(overrides))
+	for name, query := range queries {
+		var cloned bool
+		for i, col := range query.Metrics {
+			if col.DisCard || col.Usage == LABEL || col.Usage == DISCARD {
+				continue
+			}
+			override, ok := overrides[query.columnMetricName(col)]
+			if !ok || override == nil {
+				continue
+			}
+			used[query.columnMetricName(col)] = true
+			if !cloned {
+				query, cloned = query.clone(), true
+				queries[name] = query
+			}
+			col = query.Metrics[i]
+			if override.Help != "" {
+				col.Desc = override.Help
+			}
+			if override.DocURL != "" {
+				col.DocURL = override.DocURL
+			}
+		}
+		if cloned {
+			_ = query.Check()
+		}
+	}
+	var unknown []string
+	for name := range overrides {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// help returns the help text of the column, followed by its documentation url
+func (c *Column) help() string {
+	if c.DocURL == "" {
+		return c.Desc
+	}
+	if c.Desc == "" {
+		return "See " + c.DocURL
+	}
+	return c.Desc + " See " + c.DocURL
+}
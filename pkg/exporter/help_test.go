@@ -0,0 +1,61 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_applyHelpOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	helpPath := filepath.Join(dir, "help.yaml")
+	err = ioutil.WriteFile(helpPath, []byte(`
+pg_stat_activity_count:
+  help: Sessions per database, see the connection runbook
+  doc_url: https://wiki.example.com/db/sessions
+pg_stat_activity_max_tx_duration:
+  doc_url: https://wiki.example.com/db/long-tx
+pg_unknown_metric:
+  help: nothing
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := LoadHelpOverrides(helpPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := &QueryInstance{
+		Name: "pg_stat_activity",
+		Metrics: []*Column{
+			{Name: "datname", Usage: LABEL},
+			{Name: "count", Usage: GAUGE, Desc: "number of connections"},
+			{Name: "max_tx_duration", Usage: GAUGE, Desc: "max duration in seconds"},
+		},
+	}
+	assert.NoError(t, q.Check())
+	queries := map[string]*QueryInstance{"pg_stat_activity": q}
+	unknown := applyHelpOverrides(queries, overrides)
+	assert.Equal(t, []string{"pg_unknown_metric"}, unknown)
+	// the query is replaced by an overridden clone
+	assert.Equal(t, "number of connections", q.Metrics[1].help())
+	q = queries["pg_stat_activity"]
+	assert.Equal(t, "Sessions per database, see the connection runbook See https://wiki.example.com/db/sessions", q.Metrics[1].help())
+	assert.Equal(t, "max duration in seconds See https://wiki.example.com/db/long-tx", q.Metrics[2].help())
+	assert.Equal(t, q.Metrics[1], q.Columns["count"])
+	desc := q.newColumnDesc(q.Metrics[1], prometheus.Labels{"server": "localhost:5432"})
+	assert.True(t, strings.Contains(desc.String(), "https://wiki.example.com/db/sessions"))
+
+	_, err = LoadHelpOverrides(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
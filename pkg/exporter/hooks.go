@@ -0,0 +1,68 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"time"
+)
+
+// skipReasonHook is the reason of a query skipped by Hooks.BeforeQuery
+const skipReasonHook = "hook"
+
+// ServerScrapeInfo describe a finished scrape of a server
+type ServerScrapeInfo struct {
+	Server   string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// QueryScrapeInfo describe a finished query of a server scrape
+type QueryScrapeInfo struct {
+	Server   string
+	Query    string
+	Start    time.Time
+	Duration time.Duration
+	Samples  int  // number of samples emitted
+	Cached   bool // served from cache
+	Err      error
+}
+
+// Hooks are invoked around each server scrape and each query, every field is optional.
+// Hooks may be called concurrently when queries are executed in parallel.
+type Hooks struct {
+	// BeforeServerScrape is called before scraping a server
+	BeforeServerScrape func(ctx context.Context, server string)
+	// AfterServerScrape is called after scraping a server
+	AfterServerScrape func(ctx context.Context, info ServerScrapeInfo)
+	// BeforeQuery is called before a query, the query is skipped in this scrape if it returns an error
+	BeforeQuery func(ctx context.Context, server, query string) error
+	// AfterQuery is called after a query, also for a query served from cache
+	AfterQuery func(ctx context.Context, info QueryScrapeInfo)
+}
+
+func (h *Hooks) beforeServerScrape(ctx context.Context, server string) {
+	if h != nil && h.BeforeServerScrape != nil {
+		h.BeforeServerScrape(ctx, server)
+	}
+}
+
+func (h *Hooks) afterServerScrape(ctx context.Context, info ServerScrapeInfo) {
+	if h != nil && h.AfterServerScrape != nil {
+		h.AfterServerScrape(ctx, info)
+	}
+}
+
+func (h *Hooks) beforeQuery(ctx context.Context, server, query string) error {
+	if h != nil && h.BeforeQuery != nil {
+		return h.BeforeQuery(ctx, server, query)
+	}
+	return nil
+}
+
+func (h *Hooks) afterQuery(ctx context.Context, info QueryScrapeInfo) {
+	if h != nil && h.AfterQuery != nil {
+		h.AfterQuery(ctx, info)
+	}
+}
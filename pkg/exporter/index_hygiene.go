@@ -0,0 +1,189 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// indexHygieneCollectorName is the name of the unused and duplicate index collector
+const indexHygieneCollectorName = "index_hygiene"
+
+const (
+	// DefaultIndexHygieneInterval is the default interval between the queries of the index hygiene collector
+	DefaultIndexHygieneInterval = time.Hour
+	// DefaultIndexUnusedWindow is the default time without scans after which an index is unused
+	DefaultIndexUnusedWindow = 7 * 24 * time.Hour
+)
+
+// indexHygieneSQL query the user indexes of the database with their scans, size and whether they enforce a
+// constraint. The definition key is equal for the indexes of the same table, access method, columns, operator
+// classes, expressions and predicate
+const indexHygieneSQL = `SELECT s.schemaname, s.relname, s.indexrelname, i.indisunique OR i.indisprimary,
+	coalesce(s.idx_scan, 0)::float, pg_relation_size(s.indexrelid)::float,
+	i.indrelid::text || ' ' || c.relam::text || ' ' || i.indkey::text || ' ' || i.indclass::text || ' ' ||
+	coalesce(pg_get_expr(i.indexprs, i.indrelid), '') || ' / ' || coalesce(pg_get_expr(i.indpred, i.indrelid), '')
+FROM pg_stat_user_indexes s JOIN pg_index i ON i.indexrelid = s.indexrelid JOIN pg_class c ON c.oid = s.indexrelid`
+
+// NewIndexHygieneCollector returns the collector of the unused and duplicate indexes of the databases, queried every
+// interval. An index is unused once its scans did not change for window
+func NewIndexHygieneCollector(interval, window time.Duration) Collector {
+	if interval <= 0 {
+		interval = DefaultIndexHygieneInterval
+	}
+	if window <= 0 {
+		window = DefaultIndexUnusedWindow
+	}
+	return &indexHygieneCollector{
+		interval: interval,
+		window:   window,
+		states:   make(map[string]*indexHygieneState),
+		now:      time.Now,
+	}
+}
+
+// indexHygieneCollector collect the candidates of index cleanup: the indexes without scans seen by the exporter for
+// the unused window and the indexes with the definition of another index of the table. It runs in every database,
+// at most every interval, the metrics of the last query are emitted in between. The indexes enforcing a unique or
+// primary key constraint are never unused
+type indexHygieneCollector struct {
+	m        sync.Mutex // guards states
+	interval time.Duration
+	window   time.Duration
+	states   map[string]*indexHygieneState // by server and database
+	now      func() time.Time
+}
+
+// indexHygieneState is the collection state of a database
+type indexHygieneState struct {
+	m         sync.Mutex
+	refreshed time.Time                  // time of the last query
+	scans     map[string]indexScansState // scans by index
+	metrics   []prometheus.Metric        // metrics of the last query
+}
+
+// indexScansState is the scans of an index and since when they did not change
+type indexScansState struct {
+	scans float64
+	since time.Time
+}
+
+// indexInfo is an index of the database
+type indexInfo struct {
+	schema, table, name string
+	unique              bool
+	scans, size         float64
+	definition          string
+}
+
+func (c *indexHygieneCollector) Name() string {
+	return indexHygieneCollectorName
+}
+
+// Enabled indexes are collected in every database
+func (c *indexHygieneCollector) Enabled(ServerInfo) bool {
+	return true
+}
+
+func (c *indexHygieneCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	key := info.Server + "/" + info.Database
+	c.m.Lock()
+	state, ok := c.states[key]
+	if !ok {
+		state = &indexHygieneState{scans: make(map[string]indexScansState)}
+		c.states[key] = state
+	}
+	c.m.Unlock()
+
+	state.m.Lock()
+	defer state.m.Unlock()
+	now := c.now()
+	if state.refreshed.IsZero() || now.Sub(state.refreshed) >= c.interval {
+		indexes, err := queryIndexes(ctx, db)
+		if err != nil {
+			return fmt.Errorf("Error retrieving indexes on %q: %s", info.Server, err)
+		}
+		state.metrics = c.evaluate(state, indexes, info, now)
+		state.refreshed = now
+	}
+	for _, metric := range state.metrics {
+		ch <- metric
+	}
+	return nil
+}
+
+// queryIndexes returns the user indexes of the database
+func queryIndexes(ctx context.Context, db *sql.DB) ([]indexInfo, error) {
+	rows, err := db.QueryContext(ctx, indexHygieneSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+	var indexes []indexInfo
+	for rows.Next() {
+		var index indexInfo
+		if err := rows.Scan(&index.schema, &index.table, &index.name, &index.unique, &index.scans, &index.size, &index.definition); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// evaluate update the scans of state with indexes and returns the metrics of the unused and duplicate indexes
+func (c *indexHygieneCollector) evaluate(state *indexHygieneState, indexes []indexInfo, info ServerInfo, now time.Time) []prometheus.Metric {
+	labels := []string{"datname", "schemaname", "relname", "indexrelname"}
+	unusedSizeDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "index", "unused_size_bytes"),
+		"Size of the index without scans over the unused window", labels, info.Labels)
+	unusedSecondsDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "index", "unused_seconds"),
+		"Seconds since the exporter saw a scan of the index without scans over the unused window", labels, info.Labels)
+	duplicateSizeDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "index", "duplicate_size_bytes"),
+		"Size of the index with the definition of another index of the table", append(labels, "duplicate_of"), info.Labels)
+
+	var metrics []prometheus.Metric
+	scans := make(map[string]indexScansState, len(indexes))
+	definitions := make(map[string][]indexInfo)
+	for _, index := range indexes {
+		key := index.schema + "." + index.name
+		// stats reset or the index is new, the scans are counted from now
+		previous, ok := state.scans[key]
+		if !ok || index.scans != previous.scans {
+			previous = indexScansState{scans: index.scans, since: now}
+		}
+		scans[key] = previous
+		values := []string{info.Database, index.schema, index.table, index.name}
+		if unused := now.Sub(previous.since); !index.unique && unused >= c.window {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(unusedSizeDesc, prometheus.GaugeValue, index.size, values...),
+				prometheus.MustNewConstMetric(unusedSecondsDesc, prometheus.GaugeValue, unused.Seconds(), values...))
+		}
+		definitions[index.definition] = append(definitions[index.definition], index)
+	}
+	// the dropped indexes are forgotten
+	state.scans = scans
+
+	for _, duplicates := range definitions {
+		if len(duplicates) < 2 {
+			continue
+		}
+		// the index enforcing a constraint is kept, else the first by name
+		sort.Slice(duplicates, func(i, j int) bool {
+			if duplicates[i].unique != duplicates[j].unique {
+				return duplicates[i].unique
+			}
+			return duplicates[i].name < duplicates[j].name
+		})
+		kept := duplicates[0]
+		for _, index := range duplicates[1:] {
+			metrics = append(metrics, prometheus.MustNewConstMetric(duplicateSizeDesc, prometheus.GaugeValue, index.size,
+				info.Database, index.schema, index.table, index.name, kept.name))
+		}
+	}
+	return metrics
+}
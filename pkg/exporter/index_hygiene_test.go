@@ -0,0 +1,70 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_indexHygieneCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewIndexHygieneCollector(time.Hour, 24*time.Hour).(*indexHygieneCollector)
+	now := time.Unix(1600000000, 0)
+	c.now = func() time.Time { return now }
+	info := ServerInfo{Server: "localhost:5432", Database: "appdb", Namespace: "og"}
+	assert.True(t, c.Enabled(info))
+
+	collect := func(scans float64) map[string]float64 {
+		if scans >= 0 {
+			mock.ExpectQuery("FROM pg_stat_user_indexes").WillReturnRows(sqlmock.NewRows(
+				[]string{"schemaname", "relname", "indexrelname", "unique", "idx_scan", "size", "definition"}).
+				AddRow("public", "orders", "orders_pkey", true, 0.0, 8192.0, "16384 403 1 1978 / ").
+				AddRow("public", "orders", "orders_id_idx", false, 0.0, 4096.0, "16384 403 1 1978 / ").
+				AddRow("public", "orders", "orders_customer_idx", false, scans, 2048.0, "16384 403 2 1978 / "))
+		}
+		ch := make(chan prometheus.Metric, 10)
+		assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+		close(ch)
+		values := make(map[string]float64)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			name := strings.Split(strings.TrimPrefix(metric.Desc().String(), `Desc{fqName: "og_index_`), `"`)[0]
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "indexrelname" {
+					name += " " + label.GetValue()
+				}
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+		return values
+	}
+	// the duplicate of the primary key is reported at once, the indexes are unused after the window
+	assert.Equal(t, map[string]float64{"duplicate_size_bytes orders_id_idx": 4096}, collect(0))
+	now = now.Add(30 * time.Minute)
+	assert.Equal(t, map[string]float64{"duplicate_size_bytes orders_id_idx": 4096}, collect(-1), "not queried before the interval")
+	now = now.Add(24 * time.Hour)
+	assert.Equal(t, map[string]float64{
+		"duplicate_size_bytes orders_id_idx":    4096,
+		"unused_size_bytes orders_id_idx":       4096,
+		"unused_seconds orders_id_idx":          (24*time.Hour + 30*time.Minute).Seconds(),
+		"unused_size_bytes orders_customer_idx": 2048,
+		"unused_seconds orders_customer_idx":    (24*time.Hour + 30*time.Minute).Seconds(),
+	}, collect(0))
+	// a scan resets the window
+	now = now.Add(time.Hour)
+	values := collect(5)
+	assert.NotContains(t, values, "unused_size_bytes orders_customer_idx")
+	assert.Contains(t, values, "unused_size_bytes orders_id_idx")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
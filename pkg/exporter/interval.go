@@ -0,0 +1,90 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// time part of interval, e.g. "01:02:03.456", "-00:00:05"
+	intervalTimeRegex = regexp.MustCompile(`^([-+]?)(\d+):(\d{2})(?::(\d{2}(?:\.\d+)?))?$`)
+	// seconds of interval units, same as extract(epoch from interval)
+	intervalUnitSeconds = map[string]float64{
+		"year":    365.25 * 24 * 60 * 60,
+		"years":   365.25 * 24 * 60 * 60,
+		"mon":     30 * 24 * 60 * 60,
+		"mons":    30 * 24 * 60 * 60,
+		"month":   30 * 24 * 60 * 60,
+		"months":  30 * 24 * 60 * 60,
+		"week":    7 * 24 * 60 * 60,
+		"weeks":   7 * 24 * 60 * 60,
+		"day":     24 * 60 * 60,
+		"days":    24 * 60 * 60,
+		"hour":    60 * 60,
+		"hours":   60 * 60,
+		"minute":  60,
+		"minutes": 60,
+		"mins":    60,
+		"second":  1,
+		"seconds": 1,
+		"secs":    1,
+	}
+)
+
+// parseInterval parse interval text into seconds.
+// Both postgres ("1 day 02:03:04.5") and postgres_verbose ("@ 1 day 2 hours ago") styles are supported.
+func parseInterval(v string) (float64, bool) {
+	fields := strings.Fields(strings.TrimSpace(v))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	var seconds float64
+	negative := false
+	if fields[0] == "@" {
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && fields[len(fields)-1] == "ago" {
+		negative = true
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return 0, false
+	}
+	for i := 0; i < len(fields); i++ {
+		if subMatches := intervalTimeRegex.FindStringSubmatch(fields[i]); subMatches != nil {
+			hours, _ := strconv.ParseFloat(subMatches[2], 64)
+			minutes, _ := strconv.ParseFloat(subMatches[3], 64)
+			var secs float64
+			if subMatches[4] != "" {
+				secs, _ = strconv.ParseFloat(subMatches[4], 64)
+			}
+			t := hours*60*60 + minutes*60 + secs
+			if subMatches[1] == "-" {
+				t = -t
+			}
+			seconds += t
+			continue
+		}
+		// number followed by unit
+		if i+1 >= len(fields) {
+			return 0, false
+		}
+		num, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return 0, false
+		}
+		unit, ok := intervalUnitSeconds[strings.ToLower(fields[i+1])]
+		if !ok {
+			return 0, false
+		}
+		seconds += num * unit
+		i++
+	}
+	if negative {
+		seconds = -seconds
+	}
+	return seconds, true
+}
@@ -0,0 +1,38 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"testing"
+)
+
+func Test_parseInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		v     string
+		want  float64
+		want1 bool
+	}{
+		{name: "time", v: "00:00:01.5", want: 1.5, want1: true},
+		{name: "negative_time", v: "-00:01:00", want: -60, want1: true},
+		{name: "hour_minute", v: "01:02", want: 3720, want1: true},
+		{name: "day_time", v: "1 day 02:03:04", want: 86400 + 7384, want1: true},
+		{name: "mons_days", v: "1 year 2 mons 3 days", want: 365.25*86400 + 60*86400 + 3*86400, want1: true},
+		{name: "verbose", v: "@ 1 day 2 hours ago", want: -(86400 + 7200), want1: true},
+		{name: "verbose_secs", v: "@ 3 mins 4.5 secs", want: 184.5, want1: true},
+		{name: "empty", v: "", want: 0, want1: false},
+		{name: "text", v: "streaming", want: 0, want1: false},
+		{name: "unknown_unit", v: "3 apples", want: 0, want1: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1 := parseInterval(tt.v)
+			if got != tt.want {
+				t.Errorf("parseInterval() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("parseInterval() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
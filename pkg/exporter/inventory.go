@@ -0,0 +1,59 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+)
+
+// inventoryDefaultFile is the file label of the built-in queries not overridden by a config file
+const inventoryDefaultFile = "default"
+
+// inventoryCount is the loaded queries of a config file with a status
+type inventoryCount struct {
+	file   string
+	status string
+	count  int
+}
+
+// inventory count the loaded queries by config file and status, ordered by file then status
+func inventory(metricMap map[string]*QueryInstance) []inventoryCount {
+	counts := make(map[[2]string]int)
+	for _, q := range metricMap {
+		file, status := q.Path, statusEnable
+		if file == "" {
+			file = inventoryDefaultFile
+		}
+		if q.Status == statusDisable {
+			status = statusDisable
+		}
+		counts[[2]string{file, status}]++
+	}
+	var result []inventoryCount
+	for key, count := range counts {
+		result = append(result, inventoryCount{file: key[0], status: key[1], count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].file != result[j].file {
+			return result[i].file < result[j].file
+		}
+		return result[i].status < result[j].status
+	})
+	return result
+}
+
+// collectInventory emit the loaded queries by config file and status, and when the config was loaded, so exporters
+// running without their config files or with a stale config are told apart
+func (e *Exporter) collectInventory(ch chan<- prometheus.Metric) {
+	queriesDesc := prometheus.NewDesc(prometheus.BuildFQName(e.namespace, "exporter", "config_queries"),
+		"Number of queries loaded by config file (default for the built-in ones) and status: enable or disable.",
+		[]string{"file", "status"}, e.constantLabels)
+	for _, c := range inventory(e.metricMap) {
+		ch <- prometheus.MustNewConstMetric(queriesDesc, prometheus.GaugeValue, float64(c.count), c.file, c.status)
+	}
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(
+		prometheus.BuildFQName(e.namespace, "exporter", "config_last_reload_success_timestamp_seconds"),
+		"Timestamp of the last successful load of the config, at start-up or reload.", nil, e.constantLabels),
+		prometheus.GaugeValue, float64(e.loadedAt.UnixNano())/1e9)
+}
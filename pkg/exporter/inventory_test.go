@@ -0,0 +1,49 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_inventory(t *testing.T) {
+	metricMap := map[string]*QueryInstance{
+		"pg_lock":        {Name: "pg_lock", Status: statusEnable},
+		"pg_database":    {Name: "pg_database", Status: statusDisable},
+		"pg_settings":    {Name: "pg_settings", Status: statusEnable},
+		"app_orders":     {Name: "app_orders", Status: statusEnable, Path: "/etc/og_exporter/app.yaml"},
+		"app_customers":  {Name: "app_customers", Status: statusEnable, Path: "/etc/og_exporter/app.yaml"},
+		"app_deprecated": {Name: "app_deprecated", Status: statusDisable, Path: "/etc/og_exporter/app.yaml"},
+	}
+	assert.Equal(t, []inventoryCount{
+		{file: "/etc/og_exporter/app.yaml", status: statusDisable, count: 1},
+		{file: "/etc/og_exporter/app.yaml", status: statusEnable, count: 2},
+		{file: inventoryDefaultFile, status: statusDisable, count: 1},
+		{file: inventoryDefaultFile, status: statusEnable, count: 2},
+	}, inventory(metricMap))
+
+	loadedAt := time.Unix(1650000000, 0)
+	e := &Exporter{namespace: "og", loadedAt: loadedAt, metricMap: metricMap}
+	ch := make(chan prometheus.Metric, 10)
+	e.collectInventory(ch)
+	close(ch)
+	var (
+		queries   float64
+		timestamp float64
+	)
+	for metric := range ch {
+		m := &dto.Metric{}
+		assert.NoError(t, metric.Write(m))
+		if len(m.Label) == 0 {
+			timestamp = m.GetGauge().GetValue()
+			continue
+		}
+		queries += m.GetGauge().GetValue()
+	}
+	assert.Equal(t, float64(len(metricMap)), queries)
+	assert.Equal(t, float64(loadedAt.Unix()), timestamp)
+}
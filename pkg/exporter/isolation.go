@@ -0,0 +1,125 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// scrapeAbandonGrace is the time a server whose scrape deadline passed is given to return the samples it has,
+// its scrape is abandoned after
+const scrapeAbandonGrace = time.Second
+
+// WithServerScrapeTimeout set the deadline of the scrape of each server, within the deadline of the whole scrape.
+// A server still scraped at its deadline is abandoned without its samples, 0 means the deadline of the whole scrape only
+func WithServerScrapeTimeout(d time.Duration) Opt {
+	return func(e *Exporter) {
+		e.serverTimeout = d
+	}
+}
+
+// serverScrape is the result of the scrape of a dsn
+type serverScrape struct {
+	metrics []prometheus.Metric
+	err     error
+}
+
+// scrapeServers scrape the dsns concurrently, each in its own goroutine with its own deadline, so a hung or
+// panicking server does not stall or take down the others. The results are in the order of the dsns
+func (e *Exporter) scrapeServers(ctx context.Context, dsnList []string) []serverScrape {
+	results := make([]serverScrape, len(dsnList))
+	done := make(chan struct{}, len(dsnList))
+	for i, dsn := range dsnList {
+		go func(i int, dsn string) {
+			results[i] = e.scrapeIsolated(ctx, dsn)
+			done <- struct{}{}
+		}(i, dsn)
+	}
+	for range dsnList {
+		<-done
+	}
+	return results
+}
+
+// scrapeIsolated scrape dsn in its own goroutine, its samples are returned once it is done. A scrape still running
+// scrapeAbandonGrace after its deadline is abandoned, its samples are dropped until it returns. The dsn fails to
+// scrape until then, so abandoned scrapes of a hung server do not pile up, the scrapes of a server that return
+// may overlap
+func (e *Exporter) scrapeIsolated(ctx context.Context, dsn string) serverScrape {
+	if e.isAbandoned(dsn) {
+		return serverScrape{err: fmt.Errorf("scrape of %s skipped, its abandoned scrape is still running", ShadowDSN(dsn))}
+	}
+	if e.serverTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.serverTimeout)
+		defer cancel()
+	}
+	// finished and abandoned are guarded by e.abandonedMtx
+	var finished, abandoned bool
+	result := make(chan serverScrape, 1)
+	go func() {
+		var r serverScrape
+		ch := make(chan prometheus.Metric)
+		collected := make(chan struct{})
+		go func() {
+			for metric := range ch {
+				r.metrics = append(r.metrics, metric)
+			}
+			close(collected)
+		}()
+		r.err = e.scrapeDSN(ctx, ch, dsn)
+		close(ch)
+		<-collected
+		e.abandonedMtx.Lock()
+		finished = true
+		if abandoned {
+			e.unmarkAbandoned(dsn)
+		}
+		e.abandonedMtx.Unlock()
+		result <- r
+	}()
+	select {
+	case r := <-result:
+		return r
+	case <-ctx.Done():
+	}
+	grace := time.NewTimer(scrapeAbandonGrace)
+	defer grace.Stop()
+	select {
+	case r := <-result:
+		return r
+	case <-grace.C:
+		e.abandonedMtx.Lock()
+		if !finished {
+			abandoned = true
+			e.markAbandoned(dsn)
+		}
+		e.abandonedMtx.Unlock()
+		return serverScrape{err: fmt.Errorf("scrape of %s abandoned: %w", ShadowDSN(dsn), ctx.Err())}
+	}
+}
+
+// isAbandoned returns true if an abandoned scrape of dsn is still running
+func (e *Exporter) isAbandoned(dsn string) bool {
+	e.abandonedMtx.Lock()
+	defer e.abandonedMtx.Unlock()
+	return e.abandoned[dsn] > 0
+}
+
+// markAbandoned count an abandoned scrape of dsn, the caller holds e.abandonedMtx
+func (e *Exporter) markAbandoned(dsn string) {
+	if e.abandoned == nil {
+		e.abandoned = make(map[string]int)
+	}
+	e.abandoned[dsn]++
+}
+
+// unmarkAbandoned uncount an abandoned scrape of dsn that returned, the caller holds e.abandonedMtx
+func (e *Exporter) unmarkAbandoned(dsn string) {
+	if e.abandoned[dsn]--; e.abandoned[dsn] <= 0 {
+		delete(e.abandoned, dsn)
+	}
+}
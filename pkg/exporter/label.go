@@ -0,0 +1,67 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// invalidUTF8Replacement replace invalid UTF-8 sequences of label values, they are rejected by prometheus
+const invalidUTF8Replacement = "\uFFFD"
+
+// LabelTransform normalize the values of a label column before they are emitted,
+// e.g. to keep raw application_name or query text from producing unbounded label values.
+type LabelTransform struct {
+	Trim      bool            `yaml:"trim,omitempty"`       // remove leading and trailing white space
+	Lowercase bool            `yaml:"lowercase,omitempty"`  // convert to lower case
+	Replace   []*LabelReplace `yaml:"replace,omitempty"`    // regex replacements, applied in order
+	MaxLength int             `yaml:"max_length,omitempty"` // truncate to max_length characters, 0 means no limit
+}
+
+// LabelReplace replace all matches of Regex by With, which can refer to groups as $1
+type LabelReplace struct {
+	Regex string         `yaml:"regex"`
+	With  string         `yaml:"with"`
+	regex *regexp.Regexp `yaml:"-"`
+}
+
+// Check validate the transform and compile the regex
+func (t *LabelTransform) Check() error {
+	if t.MaxLength < 0 {
+		return fmt.Errorf("invalid max_length %d", t.MaxLength)
+	}
+	for _, r := range t.Replace {
+		regex, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid replace regex %q: %s", r.Regex, err)
+		}
+		r.regex = regex
+	}
+	return nil
+}
+
+// apply transform the label value. nil transform only replaces invalid UTF-8
+func (t *LabelTransform) apply(v string) string {
+	v = strings.ToValidUTF8(v, invalidUTF8Replacement)
+	if t == nil {
+		return v
+	}
+	if t.Trim {
+		v = strings.TrimSpace(v)
+	}
+	if t.Lowercase {
+		v = strings.ToLower(v)
+	}
+	for _, r := range t.Replace {
+		if r.regex != nil {
+			v = r.regex.ReplaceAllString(v, r.With)
+		}
+	}
+	if t.MaxLength > 0 && utf8.RuneCountInString(v) > t.MaxLength {
+		v = string([]rune(v)[:t.MaxLength])
+	}
+	return v
+}
@@ -0,0 +1,74 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLabelTransform_apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform *LabelTransform
+		value     string
+		want      string
+	}{
+		{name: "nil", transform: nil, value: " App ", want: " App "},
+		{name: "invalid_utf8", transform: nil, value: "a\xffb", want: "a\uFFFDb"},
+		{name: "trim_lowercase", transform: &LabelTransform{Trim: true, Lowercase: true}, value: " PgAdmin ", want: "pgadmin"},
+		{
+			name:      "replace",
+			transform: &LabelTransform{Replace: []*LabelReplace{{Regex: `\d+`, With: "N"}, {Regex: `^(\w+)-.*`, With: "$1"}}},
+			value:     "worker-12 pid 345",
+			want:      "worker",
+		},
+		{name: "max_length", transform: &LabelTransform{MaxLength: 3}, value: "数据库连接", want: "数据库"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.transform != nil {
+				assert.NoError(t, tt.transform.Check())
+			}
+			assert.Equal(t, tt.want, tt.transform.apply(tt.value))
+		})
+	}
+	assert.Error(t, (&LabelTransform{MaxLength: -1}).Check())
+	assert.Error(t, (&LabelTransform{Replace: []*LabelReplace{{Regex: "("}}}).Check())
+}
+
+func Test_Server_queryMetric_labelTransform(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	queryInstance := &QueryInstance{
+		Name:    "pg_stat_activity",
+		Queries: []*Query{{SQL: "SELECT application_name, count"}},
+		Metrics: []*Column{
+			{Name: "application_name", Usage: LABEL, Label: &LabelTransform{Trim: true, Lowercase: true, MaxLength: 8}},
+			{Name: "count", Usage: GAUGE},
+		},
+	}
+	assert.NoError(t, queryInstance.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+	}
+	mock.ExpectQuery("SELECT application_name").WillReturnRows(sqlmock.NewRows([]string{"application_name", "count"}).
+		AddRow(" JDBC Driver 42 ", 3))
+	metrics, _, err := s.queryMetric("pg_stat_activity", queryInstance)
+	assert.NoError(t, err)
+	if assert.Len(t, metrics, 1) {
+		m := &dto.Metric{}
+		_ = metrics[0].Write(m)
+		assert.Equal(t, "jdbc dri", m.Label[0].GetValue())
+	}
+
+	queryInstance.Metrics[1].Label = &LabelTransform{Trim: true}
+	assert.Error(t, queryInstance.Check())
+}
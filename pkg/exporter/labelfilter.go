@@ -0,0 +1,100 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"strings"
+)
+
+// filterLabels returns the labels emitted and the labels dropped by the keep_labels or drop_labels of the query.
+// labels are the label names of the query
+func (q *QueryInstance) filterLabels(labels []string) (kept, dropped []string, field string, err error) {
+	if len(q.KeepLabels) > 0 && len(q.DropLabels) > 0 {
+		return labels, nil, "keep_labels", fmt.Errorf("keep_labels and drop_labels are exclusive")
+	}
+	field, names := "drop_labels", q.DropLabels
+	if len(q.KeepLabels) > 0 {
+		field, names = "keep_labels", q.KeepLabels
+	}
+	for _, name := range names {
+		if !Contains(labels, name) {
+			return labels, nil, field, fmt.Errorf("%s is not a label of the query", name)
+		}
+	}
+	if len(names) == 0 {
+		return labels, nil, "", nil
+	}
+	for _, label := range labels {
+		if Contains(names, label) == (field == "keep_labels") {
+			kept = append(kept, label)
+		} else {
+			dropped = append(dropped, label)
+		}
+	}
+	if q.DatnameTag && Contains(dropped, datnameLabel) {
+		return labels, nil, field, fmt.Errorf("the %s label of a database scoped query can not be dropped", datnameLabel)
+	}
+	return kept, dropped, "", nil
+}
+
+// sumSeries sum the samples of the same metric and the same labels, as left by the dropped labels of a query.
+// labelNames are the variable labels of the metrics, NaN are ignored unless every sample is NaN
+func sumSeries(metrics []prometheus.Metric, labelNames []string) []prometheus.Metric {
+	type series struct {
+		metric    prometheus.Metric
+		valueType prometheus.ValueType
+		value     float64
+		labels    []string
+		samples   int
+	}
+	result := make([]prometheus.Metric, 0, len(metrics))
+	var keys []string
+	sums := make(map[string]*series)
+	for _, metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			result = append(result, metric)
+			continue
+		}
+		value, valueType, ok := sampleValue(m)
+		if !ok {
+			// histograms are kept as is
+			result = append(result, metric)
+			continue
+		}
+		values := make(map[string]string, len(m.Label))
+		for _, pair := range m.Label {
+			values[pair.GetName()] = pair.GetValue()
+		}
+		labels := make([]string, len(labelNames))
+		for i, name := range labelNames {
+			labels[i] = values[name]
+		}
+		key := fmt.Sprintf("%p\xff%s", metric.Desc(), strings.Join(labels, "\xff"))
+		s, ok := sums[key]
+		if !ok {
+			sums[key] = &series{metric: metric, valueType: valueType, value: value, labels: labels, samples: 1}
+			keys = append(keys, key)
+			continue
+		}
+		s.samples++
+		if math.IsNaN(s.value) {
+			s.value = value
+		} else if !math.IsNaN(value) {
+			s.value += value
+		}
+	}
+	for _, key := range keys {
+		s := sums[key]
+		if s.samples == 1 {
+			result = append(result, s.metric)
+			continue
+		}
+		result = append(result, prometheus.MustNewConstMetric(s.metric.Desc(), s.valueType, s.value, s.labels...))
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig_dropLabels(t *testing.T) {
+	queries, err := ParseConfig([]byte(`pg_stat_activity:
+  query:
+  - sql: SELECT usename, client_addr, state, count(*) AS count FROM pg_stat_activity GROUP BY 1, 2, 3
+  drop_labels: [client_addr]
+  metrics:
+  - name: usename
+    usage: LABEL
+  - name: client_addr
+    usage: LABEL
+  - name: state
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+`), "activity.yaml")
+	if assert.NoError(t, err) {
+		q := queries["pg_stat_activity"]
+		assert.Equal(t, []string{"usename", "state"}, q.LabelNames)
+		assert.Equal(t, []string{"client_addr"}, q.DroppedLabels)
+	}
+
+	tests := []struct {
+		q    *QueryInstance
+		want string
+	}{
+		{q: &QueryInstance{KeepLabels: []string{"usename"}, DropLabels: []string{"state"}}, want: "keep_labels and drop_labels are exclusive"},
+		{q: &QueryInstance{DropLabels: []string{"count"}}, want: "count is not a label of the query"},
+		{q: &QueryInstance{KeepLabels: []string{"state"}, DatnameTag: true}, want: "the datname label of a database scoped query can not be dropped"},
+	}
+	for _, tt := range tests {
+		_, _, field, err := tt.q.filterLabels([]string{"usename", "state", "datname"})
+		assert.EqualError(t, err, tt.want)
+		assert.NotEmpty(t, field)
+	}
+	kept, dropped, _, err := (&QueryInstance{KeepLabels: []string{"state"}}).filterLabels([]string{"usename", "state"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"state"}, kept)
+	assert.Equal(t, []string{"usename"}, dropped)
+}
+
+func Test_Server_queryMetric_dropLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	q := &QueryInstance{
+		Name:       "pg_stat_activity",
+		Queries:    []*Query{{SQL: "SELECT usename, client_addr, count, xact FROM pg_stat_activity"}},
+		KeepLabels: []string{"usename"},
+		Metrics: []*Column{
+			{Name: "usename", Usage: LABEL},
+			{Name: "client_addr", Usage: LABEL},
+			{Name: "count", Usage: GAUGE},
+			{Name: "xact", Usage: DELTA},
+		},
+	}
+	assert.NoError(t, q.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		stats:  newQueryStats(),
+		descs:  newDescCache(),
+		deltas: newDeltaTracker(),
+	}
+	samples := func() []string {
+		metrics, errs, err := s.queryMetric("pg_stat_activity", q)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		var samples []string
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			value, _, _ := sampleValue(m)
+			var labels []string
+			for _, label := range m.Label {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			name := strings.Split(metric.Desc().String(), `"`)[1]
+			samples = append(samples, name+"{"+strings.Join(labels, ",")+"} "+strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		sort.Strings(samples)
+		return samples
+	}
+	columns := []string{"usename", "client_addr", "count", "xact"}
+
+	mock.ExpectQuery("SELECT usename").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("app", "10.0.0.1", 2, 10).AddRow("app", "10.0.0.2", 3, 20).AddRow("admin", "10.0.0.3", 1, nil))
+	assert.Equal(t, []string{
+		"pg_stat_activity_count{server=localhost:5432,usename=admin} 1",
+		"pg_stat_activity_count{server=localhost:5432,usename=app} 5",
+	}, samples())
+
+	// the deltas are computed by client_addr before being summed
+	mock.ExpectQuery("SELECT usename").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("app", "10.0.0.1", 2, 15).AddRow("app", "10.0.0.2", 3, 22).AddRow("admin", "10.0.0.3", 1, nil))
+	assert.Equal(t, []string{
+		"pg_stat_activity_count{server=localhost:5432,usename=admin} 1",
+		"pg_stat_activity_count{server=localhost:5432,usename=app} 5",
+		"pg_stat_activity_xact{server=localhost:5432,usename=admin} NaN",
+		"pg_stat_activity_xact{server=localhost:5432,usename=app} 7",
+	}, samples())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,182 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxErrorMessageLength is the max length of a kept error message, in bytes
+const maxErrorMessageLength = 1024
+
+// classes of an error, the value of the error class metrics is the index in errorClasses
+const (
+	errorClassNone       = "none"       // the last attempt succeeded
+	errorClassTimeout    = "timeout"    // the deadline of the query or the statement_timeout expired
+	errorClassCanceled   = "canceled"   // the scrape was abandoned by its client
+	errorClassPermission = "permission" // insufficient privilege or authentication failure
+	errorClassConnection = "connection" // the connection to the server failed or was lost
+	errorClassUndefined  = "undefined"  // missing relation, column, function or schema, e.g. after an upgrade
+	errorClassSyntax     = "syntax"     // other syntax or access rule violation of the sql
+	errorClassServer     = "server"     // any other error of the server
+	errorClassConversion = "conversion" // values or columns of the result could not be converted
+	errorClassOther      = "other"
+)
+
+// errorClasses are the classes of an error, by value of the error class metrics
+var errorClasses = []string{errorClassNone, errorClassTimeout, errorClassCanceled, errorClassPermission,
+	errorClassConnection, errorClassUndefined, errorClassSyntax, errorClassServer, errorClassConversion, errorClassOther}
+
+// errorClassHelp describe the values of the error class metrics
+var errorClassHelp = func() string {
+	values := make([]string, len(errorClasses))
+	for i, class := range errorClasses {
+		values[i] = fmt.Sprintf("%d %s", i, class)
+	}
+	return strings.Join(values, ", ")
+}()
+
+// undefinedErrorCodes are the SQLSTATE of the objects missing on the server
+var undefinedErrorCodes = map[string]bool{
+	"42P01": true, // undefined_table
+	"42703": true, // undefined_column
+	"42883": true, // undefined_function
+	"42704": true, // undefined_object
+	"3F000": true, // invalid_schema_name
+}
+
+// errorClass returns the class of err, errorClassNone if nil
+func errorClass(err error) string {
+	code := errorCode(err)
+	switch {
+	case err == nil:
+		return errorClassNone
+	case errors.Is(err, context.Canceled):
+		return errorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), code == "57014": // query_canceled, by statement_timeout or cancel-on-timeout
+		return errorClassTimeout
+	case isPermissionDenied(err), strings.HasPrefix(code, "28"):
+		return errorClassPermission
+	case undefinedErrorCodes[code]:
+		return errorClassUndefined
+	case strings.HasPrefix(code, "42"):
+		return errorClassSyntax
+	case strings.HasPrefix(code, "08"), code == "53300", isTransientError(err):
+		return errorClassConnection
+	case code != "":
+		return errorClassServer
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || connectErrorReason(err) != connectErrorOther {
+		return errorClassConnection
+	}
+	return errorClassOther
+}
+
+// queryErrorClass returns the class of the result of a query execution failed with err, errorClassConversion if
+// only nonFatalErrors occurred
+func queryErrorClass(err error, nonFatalErrors []error) string {
+	if err == nil && len(nonFatalErrors) > 0 {
+		return errorClassConversion
+	}
+	return errorClass(err)
+}
+
+// errorClassValue returns the value of class in the error class metrics
+func errorClassValue(class string) float64 {
+	for i, c := range errorClasses {
+		if c == class {
+			return float64(i)
+		}
+	}
+	return float64(len(errorClasses) - 1)
+}
+
+// LastError is the most recent error of a target or of a query on a server, the message is redacted
+type LastError struct {
+	Server  string    `json:"server"`
+	Query   string    `json:"query,omitempty"` // empty for the errors of the target
+	Class   string    `json:"class"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Failing bool      `json:"failing"` // whether the last attempt failed, the error is kept once it succeeds again
+}
+
+// newLastError returns the last error of err of class, the message is redacted and truncated
+func newLastError(err error, class string) *LastError {
+	message := RedactText(err.Error())
+	if len(message) > maxErrorMessageLength {
+		message = strings.ToValidUTF8(message[:maxErrorMessageLength], "") + "..."
+	}
+	return &LastError{Class: class, Message: message, Time: time.Now(), Failing: true}
+}
+
+// observeTargetError record the result of the last connection to the target named server, err nil if it succeeded
+func (e *Exporter) observeTargetError(server string, err error) {
+	e.targetErrorsMtx.Lock()
+	defer e.targetErrorsMtx.Unlock()
+	if e.targetErrors == nil {
+		e.targetErrors = make(map[string]*LastError)
+	}
+	if err == nil {
+		if last, ok := e.targetErrors[server]; ok {
+			last.Failing = false
+		}
+		return
+	}
+	last := newLastError(err, errorClass(err))
+	last.Server = server
+	e.targetErrors[server] = last
+}
+
+// targetName returns the name of the target of dsn in the errors, the server label or the dsn without password
+func (e *Exporter) targetName(dsn string) string {
+	if labels := e.servers.targetLabels(dsn); labels[serverLabelName] != "" {
+		return labels[serverLabelName]
+	}
+	return ShadowDSN(dsn)
+}
+
+// collectTargetErrors emit the class of the error of the last connection to every target that failed once
+func (e *Exporter) collectTargetErrors(ch chan<- prometheus.Metric) {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(e.namespace, "exporter", "target_error_class"),
+		"Class of the error of the last connection to the target: "+errorClassHelp+".",
+		[]string{serverLabelName}, e.constantLabels)
+	e.targetErrorsMtx.Lock()
+	defer e.targetErrorsMtx.Unlock()
+	for server, last := range e.targetErrors {
+		class := errorClassNone
+		if last.Failing {
+			class = last.Class
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, errorClassValue(class), server)
+	}
+}
+
+// LastErrors returns the most recent errors of the targets and of the queries of the servers, sorted by server and
+// query
+func (e *Exporter) LastErrors() []LastError {
+	var result []LastError
+	e.targetErrorsMtx.Lock()
+	for _, last := range e.targetErrors {
+		result = append(result, *last)
+	}
+	e.targetErrorsMtx.Unlock()
+	for _, server := range e.servers.List() {
+		result = append(result, server.stats.lastErrors(server.String())...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Server != result[j].Server {
+			return result[i].Server < result[j].Server
+		}
+		return result[i].Query < result[j].Query
+	})
+	return result
+}
@@ -0,0 +1,113 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/prometheus/client_golang/prometheus"
+	commonlog "github.com/prometheus/common/log"
+	"sync"
+)
+
+// DefaultLeaderElectionKey is the advisory lock key replicas compete for by default
+const DefaultLeaderElectionKey int64 = 0x6f675f6578 // "og_ex"
+
+// leaderElection elect a leader among exporter replicas scraping the same server by a session advisory lock.
+// The lock is held by a dedicated connection, it is released by the database when the leader dies
+type leaderElection struct {
+	key    int64
+	m      sync.Mutex
+	conn   *sql.Conn
+	closed bool // the lock is released for good, e.g. by the exporter replaced on reload
+	logger commonlog.Logger
+}
+
+// ServerWithLeaderElection only scrape the server if holding the advisory lock of key. 0 disables leader election
+func ServerWithLeaderElection(key int64) ServerOpt {
+	return func(s *Server) {
+		if key != 0 {
+			s.leader = &leaderElection{key: key}
+		}
+	}
+}
+
+// isLeader returns whether this replica is the leader, try to acquire the lock if not.
+// nil election is always the leader
+func (l *leaderElection) isLeader(ctx context.Context, db *sql.DB) bool {
+	if l == nil {
+		return true
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed {
+		return false
+	}
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true
+		}
+		l.log().Warnf("leader connection lost, lock %d released", l.key)
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		l.log().Errorf("leader election: fail to get connection: %s", err)
+		return false
+	}
+	var acquired bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		l.log().Errorf("leader election: fail to acquire lock %d: %s", l.key, err)
+	}
+	if err != nil || !acquired {
+		_ = conn.Close()
+		return false
+	}
+	l.log().Infof("leader election: lock %d acquired, became leader", l.key)
+	l.conn = conn
+	return true
+}
+
+// close release the lock, it is not acquired again. Closing the connection only returns the session to the pool,
+// so the lock is released explicitly, or the session is discarded if it fails
+func (l *leaderElection) close() {
+	if l == nil {
+		return
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.closed = true
+	if l.conn == nil {
+		return
+	}
+	var released bool
+	if err := l.conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key).Scan(&released); err != nil || !released {
+		l.log().Warnf("leader election: fail to release lock %d, discard its session: %v", l.key, err)
+		_ = l.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	} else {
+		l.log().Infof("leader election: lock %d released", l.key)
+	}
+	_ = l.conn.Close()
+	l.conn = nil
+}
+
+// ReleaseLeadership release the advisory locks of leader election held by the exporter, so the exporter replacing
+// it on reload becomes the leader. The servers of the exporter are followers afterwards
+func (e *Exporter) ReleaseLeadership() {
+	for _, server := range e.servers.List() {
+		server.leader.close()
+	}
+}
+
+// leaderMetric returns the role metric of the server
+func (s *Server) leaderMetric(leader bool) prometheus.Metric {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(s.namespace, "exporter", "leader"),
+		"Whether this exporter replica is the leader executing queries on the server (1 for leader).", nil, s.labels)
+	var v float64
+	if leader {
+		v = 1
+	}
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+}
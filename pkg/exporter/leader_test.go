@@ -0,0 +1,148 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func Test_leaderElection_isLeader(t *testing.T) {
+	assert.True(t, (*leaderElection)(nil).isLeader(context.Background(), nil))
+
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	l := &leaderElection{key: DefaultLeaderElectionKey}
+	// follower
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(DefaultLeaderElectionKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+	assert.False(t, l.isLeader(context.Background(), db))
+	assert.Nil(t, l.conn)
+	// acquired
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(DefaultLeaderElectionKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	assert.True(t, l.isLeader(context.Background(), db))
+	// still holding the lock
+	mock.ExpectPing()
+	assert.True(t, l.isLeader(context.Background(), db))
+	assert.NotNil(t, l.conn)
+	// released, and not acquired again
+	mock.ExpectQuery("SELECT pg_advisory_unlock").WithArgs(DefaultLeaderElectionKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_advisory_unlock"}).AddRow(true))
+	l.close()
+	assert.Nil(t, l.conn)
+	assert.False(t, l.isLeader(context.Background(), db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// advisoryLocks are the session advisory locks of a fake server, by key
+type advisoryLocks struct {
+	m    sync.Mutex
+	held map[int64]*advisoryConn
+}
+
+// advisoryDriver open sessions of a fake server only answering the advisory lock functions. As on the server, a
+// lock is released with its session, not when the connection returns to the pool
+type advisoryDriver struct {
+	locks *advisoryLocks
+}
+
+func (d advisoryDriver) Connector(dsn string) (driver.Connector, error) {
+	return dsnConnector{dsn: dsn, drv: d}, nil
+}
+
+func (d advisoryDriver) Open(string) (driver.Conn, error) {
+	return &advisoryConn{locks: d.locks}, nil
+}
+
+type advisoryConn struct {
+	locks *advisoryLocks
+}
+
+func (c *advisoryConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("advisory: prepare is not supported")
+}
+
+func (c *advisoryConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("advisory: transactions are not supported")
+}
+
+func (c *advisoryConn) Ping(context.Context) error {
+	return nil
+}
+
+func (c *advisoryConn) Close() error {
+	c.locks.m.Lock()
+	defer c.locks.m.Unlock()
+	for key, holder := range c.locks.held {
+		if holder == c {
+			delete(c.locks.held, key)
+		}
+	}
+	return nil
+}
+
+func (c *advisoryConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	key := args[0].Value.(int64)
+	c.locks.m.Lock()
+	defer c.locks.m.Unlock()
+	holder, held := c.locks.held[key]
+	var result bool
+	switch query {
+	case "SELECT pg_try_advisory_lock($1)":
+		if !held {
+			c.locks.held[key] = c
+		}
+		result = !held || holder == c
+	case "SELECT pg_advisory_unlock($1)":
+		if result = holder == c; result {
+			delete(c.locks.held, key)
+		}
+	default:
+		return nil, errors.New("advisory: unexpected query " + query)
+	}
+	return &mockRows{columns: []string{"result"}, rows: [][]driver.Value{{result}}}, nil
+}
+
+func TestExporter_ReleaseLeadership(t *testing.T) {
+	locks := &advisoryLocks{held: make(map[int64]*advisoryConn)}
+	RegisterDriver("advisory", advisoryDriver{locks: locks})
+	defer func() {
+		driversMtx.Lock()
+		delete(drivers, "advisory")
+		driversMtx.Unlock()
+	}()
+	dsn := "postgres://monitor@localhost:5432/postgres?sslmode=disable"
+	server := func() (*Exporter, *Server) {
+		e, err := NewExporter(WithDNS([]string{dsn}), WithDriver("advisory"), WithLeaderElection(DefaultLeaderElectionKey))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		s, err := e.servers.GetServer(dsn)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return e, s
+	}
+	ctx := context.Background()
+	old, oldServer := server()
+	defer old.Close()
+	assert.True(t, oldServer.leader.isLeader(ctx, oldServer.db))
+
+	// the exporter replacing the old one on reload is a follower while the old one holds the lock
+	reloaded, reloadedServer := server()
+	defer reloaded.Close()
+	assert.False(t, reloadedServer.leader.isLeader(ctx, reloadedServer.db))
+
+	old.ReleaseLeadership()
+	assert.False(t, oldServer.leader.isLeader(ctx, oldServer.db))
+	assert.True(t, reloadedServer.leader.isLeader(ctx, reloadedServer.db))
+}
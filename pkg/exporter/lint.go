@@ -0,0 +1,320 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// selectListEnd are the keywords ending the SELECT list
+var selectListEnd = map[string]bool{
+	"from": true, "into": true, "where": true, "group": true, "having": true, "window": true,
+	"order": true, "limit": true, "offset": true, "union": true, "intersect": true, "except": true,
+}
+
+// notAlias are the words ending a SELECT item that are not an implicit alias
+var notAlias = map[string]bool{
+	"end": true, "null": true, "true": true, "false": true, "and": true, "or": true, "not": true, "is": true,
+	"isnull": true, "notnull": true, "in": true, "like": true, "ilike": true, "between": true, "then": true,
+	"else": true, "when": true, "zone": true, "precision": true, "varying": true, "asc": true, "desc": true,
+}
+
+var (
+	explicitAliasRegex = regexp.MustCompile(`(?is)\sas\s+("(?:[^"]|"")+"|[a-z_][\w$]*)$`)
+	implicitAliasRegex = regexp.MustCompile(`(?is)^(.*[\w)"'])\s+("(?:[^"]|"")+"|[a-z_][\w$]*)$`)
+	identifierRegex    = regexp.MustCompile(`(?is)^(?:(?:"(?:[^"]|"")+"|[a-z_][\w$]*)\s*\.\s*)*("(?:[^"]|"")+"|[a-z_][\w$]*)$`)
+	functionRegex      = regexp.MustCompile(`(?is)^(?:(?:"(?:[^"]|"")+"|[a-z_][\w$]*)\s*\.\s*)*("(?:[^"]|"")+"|[a-z_][\w$]*)\s*\(`)
+	castRegex          = regexp.MustCompile(`(?is)::\s*[\w\s"]+(?:\([\d\s,]*\))?(?:\[\])*$`)
+	distinctRegex      = regexp.MustCompile(`(?is)^(?:all|distinct)\s`)
+	distinctOnRegex    = regexp.MustCompile(`(?is)^on\s*\(`)
+)
+
+// lintQuery cross-reference the SELECT lists of query with its metrics: selected columns without mapping,
+// mapped columns not selected and queries without metric column. key is the config key of query
+func lintQuery(key string, q *QueryInstance) ConfigErrors {
+	var warnings ConfigErrors
+	if len(q.Metrics) > 0 && len(q.MetricNames) == 0 {
+		warnings.add(key, "", "metrics", fmt.Errorf("no metric column, the query emits no values"))
+	}
+	for i, query := range q.Queries {
+		if !query.isSQL() || query.SQL == "" {
+			continue
+		}
+		columns, ok := selectColumns(query.SQL)
+		if !ok {
+			continue
+		}
+		selected := make(map[string]bool, len(columns))
+		for _, name := range columns {
+			selected[name] = true
+			if _, ok := q.Columns[name]; !ok {
+				warnings.add(key, "", fmt.Sprintf("query[%d].sql", i),
+					fmt.Errorf("selected column %s has no mapping in metrics, it is exposed as an untyped metric", name))
+			}
+		}
+		for _, column := range q.Metrics {
+			if column.expression == nil && column.Extract == nil && !selected[column.Name] {
+				warnings.add(key, column.Name, "", fmt.Errorf("not selected by query[%d]", i))
+			}
+		}
+	}
+	return warnings
+}
+
+// lintQueries lint the queries of a config, sorted by config key
+func lintQueries(queries map[string]*QueryInstance) ConfigErrors {
+	var warnings ConfigErrors
+	for _, key := range sortedQueryKeys(queries) {
+		warnings = append(warnings, lintQuery(key, queries[key])...)
+	}
+	return warnings
+}
+
+// LintConfig load the config file or dir at configPath like LoadConfig, returns the lint warnings of the queries.
+// Unlike LoadConfig, the errors of every config file are returned
+func LintConfig(configPath string) (warnings ConfigErrors, err error) {
+	queries, warnings, errs := lintConfig(configPath)
+	if len(errs) == 0 {
+		if err := checkMetricNameCollisions(queries); err != nil {
+			errs.add("", "", "", err)
+		}
+	}
+	return warnings, errs.err()
+}
+
+func lintConfig(configPath string) (queries map[string]*QueryInstance, warnings, errs ConfigErrors) {
+	stat, err := os.Stat(configPath)
+	if err != nil {
+		errs.add("", "", "", fmt.Errorf("invalid config path: %s: %w", configPath, err))
+		return nil, nil, errs
+	}
+	if !stat.IsDir() {
+		queries, warnings, err = loadConfigFile(configPath)
+		if err != nil {
+			errs.addAll("", err)
+		}
+		return queries, warnings, errs
+	}
+	files, err := configFiles(configPath)
+	if err != nil {
+		errs.add("", "", "", err)
+		return nil, nil, errs
+	}
+	queries = make(map[string]*QueryInstance)
+	for _, file := range files {
+		fileQueries, fileWarnings, fileErrs := lintConfig(file)
+		warnings, errs = append(warnings, fileWarnings...), append(errs, fileErrs...)
+		for name, query := range fileQueries {
+			queries[name] = query
+		}
+	}
+	return queries, warnings, errs
+}
+
+// loadConfigFile parse the config file at configPath, decrypted if encrypted, returns its queries and lint warnings
+func loadConfigFile(configPath string) (queries map[string]*QueryInstance, warnings ConfigErrors, err error) {
+	content, err := ReadSecretFile(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail reading config file %s: %w", configPath, err)
+	}
+	queries, err = ParseConfig(content, configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings = lintQueries(queries)
+	warnings.locate(configPath, content)
+	return queries, warnings, nil
+}
+
+// selectColumns returns the names of the columns of the top level SELECT list of sql,
+// false if they can not be told, e.g. SELECT *. Names follows the rules of the server:
+// the alias, the column or the function name, ?column? otherwise
+func selectColumns(sql string) ([]string, bool) {
+	items, ok := selectList(stripSQLComments(sql))
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		name, ok := selectItemName(item)
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, true
+}
+
+// selectList returns the items of the first top level SELECT list of sql, the SELECT of a WITH query
+func selectList(sql string) ([]string, bool) {
+	var (
+		items        []string
+		depth        int
+		start, end   = -1, len(sql)
+		itemStart    int
+		isIdentifier = func(c byte) bool {
+			return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		}
+	)
+scan:
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(sql, i)
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+		if depth != 0 {
+			continue
+		}
+		switch {
+		case c == ',' && start >= 0:
+			items = append(items, sql[itemStart:i])
+			itemStart = i + 1
+		case c == ';' && start >= 0:
+			end = i
+			break scan
+		case isIdentifier(c) && (i == 0 || !isIdentifier(sql[i-1]) && sql[i-1] != '.'):
+			j := i
+			for j < len(sql) && isIdentifier(sql[j]) {
+				j++
+			}
+			word := strings.ToLower(sql[i:j])
+			if start < 0 && word == "select" {
+				start, itemStart = j, j
+			} else if start >= 0 && selectListEnd[word] {
+				end = i
+				break scan
+			}
+			i = j - 1
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+	items = append(items, sql[itemStart:end])
+	first := strings.TrimSpace(items[0])
+	if distinctRegex.MatchString(first) {
+		first = strings.TrimSpace(first[strings.IndexAny(first, " \t\r\n"):])
+		// DISTINCT ON (expressions)
+		if distinctOnRegex.MatchString(first) {
+			rest := strings.TrimSpace(first[2:])
+			closing := matchingParen(rest, 0)
+			if closing < 0 {
+				return nil, false
+			}
+			first = rest[closing+1:]
+		}
+		items[0] = first
+	}
+	return items, true
+}
+
+// selectItemName returns the name of the column of a SELECT item
+func selectItemName(item string) (string, bool) {
+	item = strings.TrimSpace(item)
+	if item == "" || item == "*" || strings.HasSuffix(item, ".*") {
+		return "", false
+	}
+	if m := explicitAliasRegex.FindStringSubmatch(item); m != nil {
+		return identifierName(m[1]), true
+	}
+	if m := implicitAliasRegex.FindStringSubmatch(item); m != nil && !notAlias[strings.ToLower(m[2])] {
+		return identifierName(m[2]), true
+	}
+	for castRegex.MatchString(item) {
+		item = strings.TrimSpace(castRegex.ReplaceAllString(item, ""))
+	}
+	if m := identifierRegex.FindStringSubmatch(item); m != nil {
+		return identifierName(m[1]), true
+	}
+	if m := functionRegex.FindStringSubmatch(item); m != nil {
+		closing := matchingParen(item, len(m[0])-1)
+		rest := strings.ToLower(strings.TrimSpace(item[closing+1:]))
+		if closing >= 0 && (rest == "" || strings.HasPrefix(rest, "filter") || strings.HasPrefix(rest, "over")) {
+			return identifierName(m[1]), true
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(item), "case") {
+		return "case", true
+	}
+	return "?column?", true
+}
+
+// identifierName returns the name of a SQL identifier, unquoted identifiers are folded to lower case
+func identifierName(identifier string) string {
+	if strings.HasPrefix(identifier, `"`) {
+		return strings.ReplaceAll(identifier[1:len(identifier)-1], `""`, `"`)
+	}
+	return strings.ToLower(identifier)
+}
+
+// matchingParen returns the index of the parenthesis closing the one at open, -1 if none
+func matchingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"':
+			i = skipQuoted(s, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// skipQuoted returns the index of the quote closing the one at i, doubled quotes are escaped
+func skipQuoted(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if s[j] != quote {
+			continue
+		}
+		if j+1 < len(s) && s[j+1] == quote {
+			j++
+			continue
+		}
+		return j
+	}
+	return len(s) - 1
+}
+
+// stripSQLComments replace the comments of sql by a space
+func stripSQLComments(sql string) string {
+	var b strings.Builder
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			j := skipQuoted(sql, i)
+			b.WriteString(sql[i : j+1])
+			i = j
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			b.WriteByte(' ')
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(sql[i])
+		}
+	}
+	return b.String()
+}
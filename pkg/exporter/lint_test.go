@@ -0,0 +1,97 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_selectColumns(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+		ok   bool
+	}{
+		{"SELECT datname, count(*) FROM pg_locks GROUP BY datname", []string{"datname", "count"}, true},
+		{"select a.DatName, sum(x) AS \"Total\", 1 one, now() - query_start AS age_seconds from a", []string{"datname", "Total", "one", "age_seconds"}, true},
+		{"SELECT extract(epoch FROM now()), 'a,b', x::float8, y::double precision, z + 1, CASE WHEN a THEN 1 ELSE 0 END FROM t",
+			[]string{"extract", "?column?", "x", "y", "?column?", "case"}, true},
+		{"SELECT DISTINCT ON (a, b) a, b AS c -- comment, d\n/* e, */ FROM t", []string{"a", "c"}, true},
+		{"WITH s AS (SELECT x, y FROM t) SELECT x, count(*) FILTER (WHERE y) AS n FROM s UNION SELECT 1, 2", []string{"x", "n"}, true},
+		{"SELECT pg_is_in_recovery()::int AS recovery;", []string{"recovery"}, true},
+		{"SELECT * FROM pg_stat_database", nil, false},
+		{"SELECT d.* FROM pg_stat_database d", nil, false},
+		{"SHOW max_connections", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := selectColumns(tt.sql)
+		assert.Equal(t, tt.ok, ok, tt.sql)
+		assert.Equal(t, tt.want, got, tt.sql)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	content := []byte(`pg_lock:
+  query:
+  - sql: SELECT datname, mode, count(*) AS count FROM pg_locks GROUP BY 1, 2
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+  - name: waiting
+    usage: GAUGE
+  - name: ratio
+    usage: GAUGE
+    expr: count / 2
+pg_info:
+  query:
+  - sql: SELECT version() AS version
+  metrics:
+  - name: version
+    usage: LABEL
+pg_static:
+  query:
+  - static:
+    - {a: 1}
+  metrics:
+  - name: a
+    usage: GAUGE
+`)
+	file := filepath.Join(dir, "lock.yaml")
+	if !assert.NoError(t, ioutil.WriteFile(file, content, 0600)) {
+		return
+	}
+	warnings, err := LintConfig(dir)
+	assert.NoError(t, err)
+	var messages []string
+	for _, w := range warnings {
+		messages = append(messages, w.Error())
+	}
+	assert.Equal(t, []string{
+		file + ":17: query pg_info: metrics: no metric column, the query emits no values",
+		file + ":3: query pg_lock: query[0].sql: selected column mode has no mapping in metrics, it is exposed as an untyped metric",
+		file + ":9: query pg_lock: column waiting: not selected by query[0]",
+	}, messages)
+
+	// the lint warnings don't fail the load
+	queries, err := LoadConfig(file)
+	assert.NoError(t, err)
+	assert.Len(t, queries, 3)
+
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("pg_bad:\n  scope: instance\n"), 0600)) {
+		return
+	}
+	_, err = LintConfig(dir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad.yaml:2: query pg_bad: scope: unsupported scope: instance")
+}
@@ -0,0 +1,38 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	commonlog "github.com/prometheus/common/log"
+)
+
+// log is the logger of the package functions, exporters and their servers log with the logger of WithLogger
+var log = commonlog.Base()
+
+// loggerOr returns logger, the logger of the package if nil
+func loggerOr(logger commonlog.Logger) commonlog.Logger {
+	if logger == nil {
+		return log
+	}
+	return logger
+}
+
+// log returns the logger of the exporter
+func (e *Exporter) log() commonlog.Logger {
+	return loggerOr(e.logger)
+}
+
+// log returns the logger of the server
+func (s *Server) log() commonlog.Logger {
+	return loggerOr(s.logger)
+}
+
+// log returns the logger of the notifier
+func (n *Notifier) log() commonlog.Logger {
+	return loggerOr(n.logger)
+}
+
+// log returns the logger of the server holding the lock
+func (l *leaderElection) log() commonlog.Logger {
+	return loggerOr(l.logger)
+}
@@ -0,0 +1,149 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// logicalReplicationCollectorName is the name of the built-in logical replication collector
+const logicalReplicationCollectorName = "logical_replication"
+
+// lsnHistoryLimit is the number of WAL positions kept per server to estimate the lag in seconds
+const lsnHistoryLimit = 720
+
+// confirmed_flush of pg_replication_slots is available since openGauss 2.0.0
+var logicalReplicationMinVersion = semver.MustParse("2.0.0")
+
+func init() {
+	RegisterCollector(newLogicalReplicationCollector())
+}
+
+// lsnSample is the WAL position of a server at a scrape
+type lsnSample struct {
+	time time.Time
+	lsn  float64
+}
+
+// logicalReplicationCollector collect the lag of logical replication slots behind the current WAL position.
+// The lag in seconds is estimated from the WAL positions seen by previous scrapes:
+// it is the time since the WAL position passed the confirmed_flush of the slot
+type logicalReplicationCollector struct {
+	m       sync.Mutex
+	history map[string][]lsnSample // WAL positions by server, oldest first
+	now     func() time.Time
+}
+
+func newLogicalReplicationCollector() *logicalReplicationCollector {
+	return &logicalReplicationCollector{
+		history: make(map[string][]lsnSample),
+		now:     time.Now,
+	}
+}
+
+func (c *logicalReplicationCollector) Name() string {
+	return logicalReplicationCollectorName
+}
+
+// Enabled logical slots are decoded on the primary
+func (c *logicalReplicationCollector) Enabled(info ServerInfo) bool {
+	return info.Master && !info.InRecovery && info.Version.GTE(logicalReplicationMinVersion)
+}
+
+func (c *logicalReplicationCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	rows, err := db.QueryContext(ctx, `SELECT slot_name, coalesce(plugin, ''), coalesce(database, ''), active,
+	pg_xlog_location_diff(pg_current_xlog_location(), '0/0')::float,
+	pg_xlog_location_diff(confirmed_flush, '0/0')::float,
+	pg_xlog_location_diff(restart_lsn, '0/0')::float
+FROM pg_replication_slots WHERE slot_type = 'logical'`)
+	if err != nil {
+		return fmt.Errorf("Error retrieving logical replication slots on %q: %s", info.Server, err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	labels := []string{"slot_name", "plugin", "datname"}
+	activeDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "logical_replication", "slot_active"),
+		"Whether the logical replication slot is in use", labels, info.Labels)
+	flushLagDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "logical_replication", "confirmed_flush_lag_bytes"),
+		"Bytes of WAL the consumer of the slot has not confirmed yet", labels, info.Labels)
+	restartLagDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "logical_replication", "restart_lag_bytes"),
+		"Bytes of WAL retained by the slot", labels, info.Labels)
+	lagSecondsDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "logical_replication", "lag_seconds"),
+		"Estimated seconds the consumer of the slot is behind, from the WAL positions seen by the exporter", labels, info.Labels)
+
+	var history []lsnSample
+	for rows.Next() {
+		var (
+			slotName, plugin, datname string
+			active                    bool
+			current                   float64
+			confirmed, restart        sql.NullFloat64
+		)
+		if err := rows.Scan(&slotName, &plugin, &datname, &active, &current, &confirmed, &restart); err != nil {
+			return fmt.Errorf("Error retrieving logical replication slots on %q: %s", info.Server, err)
+		}
+		if history == nil {
+			history = c.observe(info.Server, current)
+		}
+		values := []string{slotName, plugin, datname}
+		ch <- prometheus.MustNewConstMetric(activeDesc, prometheus.GaugeValue, boolToFloat64(active), values...)
+		if restart.Valid {
+			ch <- prometheus.MustNewConstMetric(restartLagDesc, prometheus.GaugeValue, current-restart.Float64, values...)
+		}
+		if confirmed.Valid {
+			ch <- prometheus.MustNewConstMetric(flushLagDesc, prometheus.GaugeValue, current-confirmed.Float64, values...)
+			ch <- prometheus.MustNewConstMetric(lagSecondsDesc, prometheus.GaugeValue, lagSeconds(history, confirmed.Float64), values...)
+		}
+	}
+	return rows.Err()
+}
+
+// observe record the current WAL position of the server, returns a copy of the history
+func (c *logicalReplicationCollector) observe(server string, lsn float64) []lsnSample {
+	c.m.Lock()
+	defer c.m.Unlock()
+	history := append(c.history[server], lsnSample{time: c.now(), lsn: lsn})
+	if len(history) > lsnHistoryLimit {
+		history = history[len(history)-lsnHistoryLimit:]
+	}
+	c.history[server] = history
+	return append([]lsnSample(nil), history...)
+}
+
+// lagSeconds returns the seconds since the WAL position passed lsn, the last sample is the current position.
+// The time lsn was passed is interpolated between the samples around it.
+// If lsn is older than the whole history, the age of the oldest sample is a lower bound
+func lagSeconds(history []lsnSample, lsn float64) float64 {
+	if len(history) == 0 {
+		return 0
+	}
+	now := history[len(history)-1].time
+	i := len(history) - 1
+	for i >= 0 && history[i].lsn > lsn {
+		i--
+	}
+	switch {
+	case i == len(history)-1:
+		// not behind
+		return 0
+	case i < 0:
+		return now.Sub(history[0].time).Seconds()
+	}
+	before, after := history[i], history[i+1]
+	ratio := (lsn - before.lsn) / (after.lsn - before.lsn)
+	passed := before.time.Add(time.Duration(ratio * float64(after.time.Sub(before.time))))
+	return now.Sub(passed).Seconds()
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_lagSeconds(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	history := []lsnSample{
+		{time: start, lsn: 100},
+		{time: start.Add(10 * time.Second), lsn: 200},
+		{time: start.Add(20 * time.Second), lsn: 300},
+	}
+	assert.Equal(t, float64(0), lagSeconds(history, 300))
+	assert.Equal(t, float64(5), lagSeconds(history, 250))
+	assert.Equal(t, float64(15), lagSeconds(history, 150))
+	assert.Equal(t, float64(20), lagSeconds(history, 100))
+	// older than the history
+	assert.Equal(t, float64(20), lagSeconds(history, 50))
+	assert.Equal(t, float64(0), lagSeconds(nil, 50))
+}
+
+func Test_logicalReplicationCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newLogicalReplicationCollector()
+	now := time.Unix(1600000000, 0)
+	c.now = func() time.Time { return now }
+	info := ServerInfo{Server: "localhost:5432", Namespace: "og", Master: true, Version: semver.MustParse("2.1.0")}
+	assert.True(t, c.Enabled(info))
+	assert.False(t, c.Enabled(ServerInfo{Master: true, Version: semver.MustParse("1.1.0")}))
+	assert.False(t, c.Enabled(ServerInfo{Master: true, InRecovery: true, Version: semver.MustParse("2.1.0")}))
+
+	collect := func(current, confirmed float64) map[string]float64 {
+		mock.ExpectQuery("FROM pg_replication_slots").WillReturnRows(sqlmock.NewRows(
+			[]string{"slot_name", "plugin", "database", "active", "current", "confirmed_flush", "restart_lsn"}).
+			AddRow("slot1", "mppdb_decoding", "postgres", true, current, confirmed, 0))
+		ch := make(chan prometheus.Metric, 10)
+		assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+		close(ch)
+		values := make(map[string]float64)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			name := strings.Split(strings.TrimPrefix(metric.Desc().String(), `Desc{fqName: "og_logical_replication_`), `"`)[0]
+			values[name] = m.GetGauge().GetValue()
+		}
+		return values
+	}
+	assert.Equal(t, map[string]float64{"slot_active": 1, "confirmed_flush_lag_bytes": 0, "restart_lag_bytes": 1000, "lag_seconds": 0},
+		collect(1000, 1000))
+	now = now.Add(15 * time.Second)
+	values := collect(2000, 1000)
+	assert.Equal(t, float64(1000), values["confirmed_flush_lag_bytes"])
+	assert.Equal(t, float64(15), values["lag_seconds"])
+	now = now.Add(15 * time.Second)
+	values = collect(3000, 1500)
+	assert.Equal(t, 22.5, values["lag_seconds"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
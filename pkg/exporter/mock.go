@@ -0,0 +1,232 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mockVersion is the version reported by the mock server unless a fixture answers the server info query
+const mockVersion = "(openGauss 2.1.0 build 590b0f8e) compiled at 2021-09-30 14:29:04 commit 0 last mr   on x86_64-unknown-linux-gnu, compiled by g++ (GCC) 7.3.0, 64-bit"
+
+// MockFixture is a recorded result set, answering the sql of a query of the config by its name, or the given sql
+type MockFixture struct {
+	Query   string          `yaml:"query,omitempty"` // name of the query of the config, all its sql are answered
+	SQL     string          `yaml:"sql,omitempty"`   // sql answered, white space is not significant
+	Columns []string        `yaml:"columns,omitempty"`
+	Rows    [][]interface{} `yaml:"rows,omitempty"`
+	Error   string          `yaml:"error,omitempty"` // fail the query with the error instead
+}
+
+// MockResults are the fixtures of a mock server by sql
+type MockResults map[string]*MockFixture
+
+// ServerWithMockResults answer the queries with the fixtures instead of connecting to the database
+func ServerWithMockResults(results MockResults) ServerOpt {
+	return func(s *Server) {
+		s.mockResults = results
+	}
+}
+
+// LoadMockFixtures load the fixtures of the yaml files of dir, each a list of MockFixture.
+// Fixtures of a query answer every sql of the query in queries
+func LoadMockFixtures(dir string, queries map[string]*QueryInstance) (MockResults, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixture found in %s", dir)
+	}
+	results := MockResults{
+		normalizeSQL(serverInfoSQL): {
+			Columns: []string{"version", "pg_postmaster_start_time", "pg_is_in_recovery"},
+			Rows:    [][]interface{}{{mockVersion, time.Now(), false}},
+		},
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("fail reading fixture file %s: %w", file, err)
+		}
+		var fixtures []*MockFixture
+		if err := yaml.Unmarshal(content, &fixtures); err != nil {
+			return nil, fmt.Errorf("malformed fixture file %s: %w", file, err)
+		}
+		for i, fixture := range fixtures {
+			if err := results.add(fixture, queries); err != nil {
+				return nil, fmt.Errorf("fixture %d of %s: %w", i, file, err)
+			}
+		}
+	}
+	return results, nil
+}
+
+// add index the fixture by the sql it answers
+func (r MockResults) add(fixture *MockFixture, queries map[string]*QueryInstance) error {
+	for i, row := range fixture.Rows {
+		if len(row) != len(fixture.Columns) {
+			return fmt.Errorf("row %d has %d values for %d columns", i, len(row), len(fixture.Columns))
+		}
+	}
+	switch {
+	case fixture.SQL != "" && fixture.Query != "":
+		return fmt.Errorf("query and sql are exclusive")
+	case fixture.SQL != "":
+		r[normalizeSQL(fixture.SQL)] = fixture
+	case fixture.Query != "":
+		var found bool
+		for key, q := range queries {
+			if key != fixture.Query && q.Name != fixture.Query {
+				continue
+			}
+			for _, query := range q.Queries {
+				if query.isSQL() {
+					r[normalizeSQL(query.SQL)] = fixture
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("no sql query %s in config", fixture.Query)
+		}
+	default:
+		return fmt.Errorf("query or sql is required")
+	}
+	return nil
+}
+
+// query returns the rows of the fixture answering sql
+func (r MockResults) query(sql string) (driver.Rows, error) {
+	fixture, ok := r[normalizeSQL(sql)]
+	if !ok {
+		return nil, fmt.Errorf("mock: no fixture for query: %s", strings.Join(strings.Fields(sql), " "))
+	}
+	if fixture.Error != "" {
+		return nil, errors.New(fixture.Error)
+	}
+	rows := &mockRows{columns: fixture.Columns, rows: make([][]driver.Value, len(fixture.Rows))}
+	for i, row := range fixture.Rows {
+		rows.rows[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			rows.rows[i][j] = mockValue(v)
+		}
+	}
+	return rows, nil
+}
+
+// mockValue convert a yaml value to a driver value, RFC 3339 strings are times as written by Recorder
+func mockValue(v interface{}) driver.Value {
+	switch v := v.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+		return v
+	case nil, int64, float64, bool, []byte, time.Time:
+		return v
+	case int:
+		return int64(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// normalizeSQL returns sql with single spaces and without the trailing semicolon
+func normalizeSQL(sql string) string {
+	return strings.TrimSuffix(strings.Join(strings.Fields(sql), " "), ";")
+}
+
+// mockConnector open connections answering queries with fixtures, statements are accepted and do nothing
+type mockConnector struct {
+	results MockResults
+}
+
+func (c *mockConnector) Connect(context.Context) (driver.Conn, error) {
+	return &mockConn{results: c.results}, nil
+}
+
+func (c *mockConnector) Driver() driver.Driver {
+	return mockDriver{}
+}
+
+type mockDriver struct{}
+
+func (mockDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("mock: connections are opened by the connector")
+}
+
+type mockConn struct {
+	results MockResults
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockStmt{conn: c, query: query}, nil
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("mock: transactions are not supported")
+}
+
+func (c *mockConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return c.results.query(query)
+}
+
+func (c *mockConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.ResultNoRows, nil
+}
+
+type mockStmt struct {
+	conn  *mockConn
+	query string
+}
+
+func (s *mockStmt) Close() error {
+	return nil
+}
+
+func (s *mockStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.ResultNoRows, nil
+}
+
+func (s *mockStmt) Query([]driver.Value) (driver.Rows, error) {
+	return s.conn.results.query(s.query)
+}
+
+type mockRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *mockRows) Columns() []string {
+	return r.columns
+}
+
+func (r *mockRows) Close() error {
+	return nil
+}
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMockFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	lock := &QueryInstance{
+		Name: "pg_lock",
+		Queries: []*Query{
+			{SQL: "SELECT datname, count FROM pg_locks", SupportedVersions: ">=2.0.0"},
+			{SQL: "SELECT datname, 0 AS count FROM pg_database", SupportedVersions: "<2.0.0"},
+		},
+		Metrics: []*Column{{Name: "datname", Usage: LABEL}, {Name: "count", Usage: GAUGE}},
+	}
+	assert.NoError(t, lock.Check())
+	queries := map[string]*QueryInstance{"pg_lock": lock}
+
+	_, err = LoadMockFixtures(dir, queries)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fixtures.yaml"), []byte(`
+- query: pg_lock
+  columns: [datname, count]
+  rows:
+  - [postgres, 3]
+  - [template1, 1.5]
+- sql: |
+    SELECT 1
+  error: permission denied
+`), 0600))
+	results, err := LoadMockFixtures(dir, queries)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s, err := NewServer("postgres://localhost:5432/postgres", ServerWithMockResults(results))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close() // nolint: errcheck
+	var version string
+	assert.NoError(t, s.db.QueryRow(serverInfoSQL).Scan(&version, new(interface{}), new(bool)))
+	assert.Equal(t, mockVersion, version)
+	_, err = s.db.Exec("SET application_name = 'test'")
+	assert.NoError(t, err)
+	_, err = s.db.Query("SELECT 1;")
+	assert.EqualError(t, err, "permission denied")
+	_, err = s.db.Query("SELECT 2")
+	assert.EqualError(t, err, "mock: no fixture for query: SELECT 2")
+
+	s.queryInstanceMap = queries
+	ch := make(chan prometheus.Metric, 10)
+	assert.Len(t, s.queryMetrics(context.Background(), ch), 0)
+	close(ch)
+	assert.Len(t, ch, 2)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fixtures.yaml"), []byte(`
+- query: pg_missing
+`), 0600))
+	_, err = LoadMockFixtures(dir, queries)
+	assert.EqualError(t, err, "fixture 0 of "+filepath.Join(dir, "fixtures.yaml")+": no sql query pg_missing in config")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fixtures.yaml"), []byte(`
+- sql: SELECT 1
+  columns: [a, b]
+  rows: [[1]]
+`), 0600))
+	_, err = LoadMockFixtures(dir, queries)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "row 0 has 1 values for 2 columns")
+}
@@ -0,0 +1,157 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// dsnHost is one of the hosts of a dsn
+type dsnHost struct {
+	host string
+	port string
+}
+
+func (h dsnHost) String() string {
+	host, port := h.host, h.port
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5432"
+	}
+	return host + ":" + port
+}
+
+// splitHosts returns the hosts of comma separated host and port settings, like libpq:
+// a single port applies to every host, otherwise there is a port per host
+func splitHosts(settings map[string]string) ([]dsnHost, error) {
+	hosts := strings.Split(settings["host"], ",")
+	ports := strings.Split(settings["port"], ",")
+	if len(ports) > 1 && len(ports) != len(hosts) {
+		return nil, fmt.Errorf("could not match %d port numbers to %d hosts", len(ports), len(hosts))
+	}
+	result := make([]dsnHost, len(hosts))
+	for i, host := range hosts {
+		result[i] = dsnHost{host: strings.TrimSpace(host), port: strings.TrimSpace(ports[0])}
+		if len(ports) > 1 {
+			result[i].port = strings.TrimSpace(ports[i])
+		}
+	}
+	return result, nil
+}
+
+// isMultiHost reports whether the settings list several hosts
+func isMultiHost(settings map[string]string) bool {
+	return strings.Contains(settings["host"], ",") || strings.Contains(settings["port"], ",")
+}
+
+// target_session_attrs values, the connection is only kept if the host satisfies them
+const (
+	sessionAttrsAny       = "any"
+	sessionAttrsReadWrite = "read-write"
+	sessionAttrsReadOnly  = "read-only"
+	sessionAttrsPrimary   = "primary"
+	sessionAttrsStandby   = "standby"
+)
+
+var validSessionAttrs = []string{sessionAttrsAny, sessionAttrsReadWrite, sessionAttrsReadOnly, sessionAttrsPrimary, sessionAttrsStandby}
+
+// hostDSNs returns a dsn per host of dsn, in order, and its target_session_attrs.
+// The dsn itself is returned if it has a single host and no target_session_attrs nor exporter settings, which the driver doesn't know
+func hostDSNs(dsn string) ([]string, string, error) {
+	settings, err := parseDsn(dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	attrs, ok := settings["target_session_attrs"]
+	if ok && !Contains(validSessionAttrs, attrs) {
+		return nil, "", fmt.Errorf("invalid target_session_attrs %q, must be one of %s", attrs, strings.Join(validSessionAttrs, ", "))
+	}
+	delete(settings, "target_session_attrs")
+	exporterOnly := removeExporterSettings(settings)
+	if attrs == sessionAttrsAny {
+		attrs = ""
+	}
+	if !isMultiHost(settings) {
+		if ok || exporterOnly {
+			dsn = genDSNString(settings)
+		}
+		return []string{dsn}, attrs, nil
+	}
+	hosts, err := splitHosts(settings)
+	if err != nil {
+		return nil, "", err
+	}
+	dsns := make([]string, len(hosts))
+	for i, h := range hosts {
+		hostSettings := make(map[string]string, len(settings))
+		for k, v := range settings {
+			hostSettings[k] = v
+		}
+		hostSettings["host"], hostSettings["port"] = h.host, h.port
+		for _, k := range []string{"host", "port"} {
+			if hostSettings[k] == "" {
+				delete(hostSettings, k)
+			}
+		}
+		dsns[i] = genDSNString(hostSettings)
+	}
+	return dsns, attrs, nil
+}
+
+// checkSessionAttrs returns an error if the session of conn doesn't satisfy attrs
+func checkSessionAttrs(ctx context.Context, conn driver.Conn, attrs string) error {
+	var query, want string
+	switch attrs {
+	case "":
+		return nil
+	case sessionAttrsReadWrite, sessionAttrsReadOnly:
+		query, want = "SHOW transaction_read_only", "off"
+		if attrs == sessionAttrsReadOnly {
+			want = "on"
+		}
+	default:
+		query, want = "SELECT pg_is_in_recovery()", "false"
+		if attrs == sessionAttrsStandby {
+			want = "true"
+		}
+	}
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return fmt.Errorf("driver does not support target_session_attrs")
+	}
+	rows, err := queryer.QueryContext(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+	values := make([]driver.Value, 1)
+	if err := rows.Next(values); err != nil {
+		return err
+	}
+	if got := fmt.Sprint(values[0]); got != want {
+		return fmt.Errorf("session is not %s", attrs)
+	}
+	return nil
+}
+
+// sessionAttrsSatisfied reports whether a server in recovery or not satisfies attrs
+func sessionAttrsSatisfied(attrs string, inRecovery bool) bool {
+	switch attrs {
+	case sessionAttrsReadWrite, sessionAttrsPrimary:
+		return !inRecovery
+	case sessionAttrsReadOnly, sessionAttrsStandby:
+		return inRecovery
+	}
+	return true
+}
+
+// resetConnections close the idle connections of the server, new connections resolve the hosts again
+func (s *Server) resetConnections() {
+	s.db.SetMaxIdleConns(0)
+	s.db.SetMaxIdleConns(s.maxConns())
+}
@@ -0,0 +1,144 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_hostDSNs(t *testing.T) {
+	tests := []struct {
+		name      string
+		dsn       string
+		want      []string
+		wantAttrs string
+		wantErr   bool
+	}{
+		{
+			name: "single host",
+			dsn:  "postgres://gaussdb@127.0.0.1:5432/postgres",
+			want: []string{"postgres://gaussdb@127.0.0.1:5432/postgres"},
+		},
+		{
+			name: "url",
+			dsn:  "postgres://gaussdb@10.0.0.1:5432,10.0.0.2:5433/postgres?sslmode=disable",
+			want: []string{
+				"database=postgres host=10.0.0.1 port=5432 sslmode=disable user=gaussdb",
+				"database=postgres host=10.0.0.2 port=5433 sslmode=disable user=gaussdb",
+			},
+		},
+		{
+			name: "single port",
+			dsn:  "host=10.0.0.1,10.0.0.2 port=5432 user=gaussdb",
+			want: []string{
+				"host=10.0.0.1 port=5432 user=gaussdb",
+				"host=10.0.0.2 port=5432 user=gaussdb",
+			},
+		},
+		{
+			name: "default port",
+			dsn:  "host=10.0.0.1,10.0.0.2",
+			want: []string{"host=10.0.0.1", "host=10.0.0.2"},
+		},
+		{
+			name: "target_session_attrs",
+			dsn:  "postgres://gaussdb@10.0.0.1,10.0.0.2/postgres?target_session_attrs=read-write",
+			want: []string{
+				"database=postgres host=10.0.0.1 user=gaussdb",
+				"database=postgres host=10.0.0.2 user=gaussdb",
+			},
+			wantAttrs: "read-write",
+		},
+		{
+			name:      "single host target_session_attrs",
+			dsn:       "host=10.0.0.1 target_session_attrs=standby",
+			want:      []string{"host=10.0.0.1"},
+			wantAttrs: "standby",
+		},
+		{
+			name: "target_session_attrs any",
+			dsn:  "host=10.0.0.1 target_session_attrs=any",
+			want: []string{"host=10.0.0.1"},
+		},
+		{
+			name:    "invalid target_session_attrs",
+			dsn:     "host=10.0.0.1 target_session_attrs=writable",
+			wantErr: true,
+		},
+		{
+			name:    "ports mismatch",
+			dsn:     "host=10.0.0.1,10.0.0.2,10.0.0.3 port=5432,5433",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, attrs, err := hostDSNs(tt.dsn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("hostDSNs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantAttrs, attrs)
+		})
+	}
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+	return port
+}
+
+func Test_dialConnector_failover(t *testing.T) {
+	c := &dialConnector{
+		dsn: fmt.Sprintf("host=127.0.0.1,127.0.0.1 port=%s,%s user=gaussdb sslmode=disable", closedPort(t), closedPort(t)),
+	}
+	_, err := c.Connect(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not connect to any host")
+	assert.Len(t, strings.Split(err.Error(), "; "), 2)
+}
+
+func Test_checkSessionAttrs(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("session_attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	conn, err := mockDB.Driver().Open("session_attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	assert.NoError(t, checkSessionAttrs(ctx, conn, ""))
+
+	mock.ExpectQuery("SHOW transaction_read_only").WillReturnRows(sqlmock.NewRows([]string{"transaction_read_only"}).AddRow("off"))
+	assert.NoError(t, checkSessionAttrs(ctx, conn, sessionAttrsReadWrite))
+	mock.ExpectQuery("SHOW transaction_read_only").WillReturnRows(sqlmock.NewRows([]string{"transaction_read_only"}).AddRow("off"))
+	assert.EqualError(t, checkSessionAttrs(ctx, conn, sessionAttrsReadOnly), "session is not read-only")
+	mock.ExpectQuery("SELECT pg_is_in_recovery()").WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery"}).AddRow(true))
+	assert.EqualError(t, checkSessionAttrs(ctx, conn, sessionAttrsPrimary), "session is not primary")
+	mock.ExpectQuery("SELECT pg_is_in_recovery()").WillReturnRows(sqlmock.NewRows([]string{"pg_is_in_recovery"}).AddRow(true))
+	assert.NoError(t, checkSessionAttrs(ctx, conn, sessionAttrsStandby))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_sessionAttrsSatisfied(t *testing.T) {
+	assert.True(t, sessionAttrsSatisfied("", true))
+	assert.True(t, sessionAttrsSatisfied(sessionAttrsReadWrite, false))
+	assert.False(t, sessionAttrsSatisfied(sessionAttrsPrimary, true))
+	assert.True(t, sessionAttrsSatisfied(sessionAttrsStandby, true))
+	assert.False(t, sessionAttrsSatisfied(sessionAttrsReadOnly, false))
+}
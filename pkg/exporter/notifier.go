@@ -0,0 +1,207 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	commonlog "github.com/prometheus/common/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// types of the events posted by the notifier
+const (
+	EventTargetDown     = "target_down"
+	EventTargetUp       = "target_up"
+	EventQueryFailing   = "query_failing"
+	EventQueryRecovered = "query_recovered"
+	EventReloadSuccess  = "config_reload_success"
+	EventReloadFailure  = "config_reload_failure"
+)
+
+const (
+	// notifierQueryFailures is the consecutive failures of a query before it is notified failing
+	notifierQueryFailures = 3
+	// notifierQueueSize is the events waiting to be posted, the events beyond are dropped
+	notifierQueueSize = 100
+)
+
+// Event is the JSON body posted to the webhook
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Target  string    `json:"target,omitempty"` // dsn of the target, password hidden
+	Server  string    `json:"server,omitempty"`
+	Query   string    `json:"query,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Notifier post the events of the exporter's own health to a webhook: targets down and up again, queries failing
+// and recovered, config reloads. Events are posted one at a time in the background, a state change is notified once.
+// A nil Notifier does nothing
+type Notifier struct {
+	url    string
+	client *http.Client
+	events chan Event
+	done   chan struct{}
+
+	logger commonlog.Logger
+
+	mtx      sync.Mutex
+	closed   bool
+	down     map[string]bool // targets notified down, by dsn
+	failures map[string]int  // consecutive failures of the queries, by server and query
+}
+
+// NotifierOpt configures the Notifier
+type NotifierOpt func(*Notifier)
+
+// NotifierWithLogger set the logger of the notifier, the logger of the package if nil
+func NotifierWithLogger(logger commonlog.Logger) NotifierOpt {
+	return func(n *Notifier) {
+		n.logger = logger
+	}
+}
+
+// NewNotifier returns a Notifier posting to url, each post times out after timeout. Close it to stop posting
+func NewNotifier(url string, timeout time.Duration, opts ...NotifierOpt) *Notifier {
+	n := &Notifier{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		events:   make(chan Event, notifierQueueSize),
+		done:     make(chan struct{}),
+		down:     make(map[string]bool),
+		failures: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	go n.run()
+	return n
+}
+
+// WithNotifier post the events of the exporter to n, nil disables it
+func WithNotifier(n *Notifier) Opt {
+	return func(e *Exporter) {
+		e.notifier = n
+	}
+}
+
+// ServerWithNotifier post the failures of the queries of the server to n
+func ServerWithNotifier(n *Notifier) ServerOpt {
+	return func(s *Server) {
+		s.notifier = n
+	}
+}
+
+// Notify queue the event to be posted, it is dropped if too many events are waiting
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Message = RedactText(event.Message)
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.events <- event:
+	default:
+		n.log().Warnf("Notifier queue full, event %s dropped", event.Type)
+	}
+}
+
+// Close post the queued events and stop the notifier
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mtx.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.events)
+	}
+	n.mtx.Unlock()
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for event := range n.events {
+		if err := n.post(event); err != nil {
+			n.log().Warnf("Error posting event %s to webhook: %s", event.Type, RedactText(err.Error()))
+		}
+	}
+}
+
+func (n *Notifier) post(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// observeTarget notify the target of dsn down when it fails to connect, and up again when it connects
+func (n *Notifier) observeTarget(dsn, server string, err error) {
+	if n == nil {
+		return
+	}
+	n.mtx.Lock()
+	changed := n.down[dsn] != (err != nil)
+	if err != nil {
+		n.down[dsn] = true
+	} else {
+		delete(n.down, dsn)
+	}
+	n.mtx.Unlock()
+	if !changed {
+		return
+	}
+	event := Event{Type: EventTargetUp, Target: ShadowDSN(dsn), Server: server}
+	if err != nil {
+		event.Type, event.Message = EventTargetDown, err.Error()
+	}
+	n.Notify(event)
+}
+
+// observeQuery notify a query failing after notifierQueryFailures consecutive failures, at once if permanent,
+// and recovered when it succeeds again
+func (n *Notifier) observeQuery(server, query string, err error, permanent bool) {
+	if n == nil {
+		return
+	}
+	key := server + "/" + query
+	n.mtx.Lock()
+	failures := n.failures[key]
+	if err != nil {
+		if permanent && failures < notifierQueryFailures {
+			failures = notifierQueryFailures - 1
+		}
+		n.failures[key] = failures + 1
+	} else {
+		delete(n.failures, key)
+	}
+	n.mtx.Unlock()
+	switch {
+	case err != nil && failures+1 == notifierQueryFailures:
+		n.Notify(Event{Type: EventQueryFailing, Server: server, Query: query, Message: err.Error()})
+	case err == nil && failures >= notifierQueryFailures:
+		n.Notify(Event{Type: EventQueryRecovered, Server: server, Query: query})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	commonlog "github.com/prometheus/common/log"
+	"sync"
+)
+
+// ServerInfo describe the server a Collector runs on
+type ServerInfo struct {
+	Server     string            // server fingerprint, host:port
+	Database   string            // database of the connection
+	Namespace  string            // prefix of metrics
+	Labels     prometheus.Labels // constant labels of the server
+	Version    semver.Version    // semantic version of the database
+	Master     bool              // server level metrics are only collected on master
+	InRecovery bool              // the database is a standby
+	Extensions []string          // extensions installed in the database
+	Logger     commonlog.Logger  // logger of the exporter
+}
+
+// Collector is a Go-level extension for metrics can't be expressed as a single SQL statement,
+// e.g. multi-step logic or joining several views in Go.
+// Built-in collectors are registered by RegisterCollector, extra collectors are added by WithCollectors
+type Collector interface {
+	// Name is the unique name of the collector
+	Name() string
+	// Enabled returns whether the collector runs on the server
+	Enabled(info ServerInfo) bool
+	// Collect emit metrics of the server into ch
+	Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error
+}
+
+var (
+	collectorsMtx        sync.Mutex
+	registeredCollectors []Collector
+)
+
+// RegisterCollector register a collector run on every server. It panics if the name is registered twice
+func RegisterCollector(c Collector) {
+	collectorsMtx.Lock()
+	defer collectorsMtx.Unlock()
+	for _, registered := range registeredCollectors {
+		if registered.Name() == c.Name() {
+			panic(fmt.Sprintf("collector %s registered twice", c.Name()))
+		}
+	}
+	registeredCollectors = append(registeredCollectors, c)
+}
+
+// getRegisteredCollectors returns a copy of the registered collectors
+func getRegisteredCollectors() []Collector {
+	collectorsMtx.Lock()
+	defer collectorsMtx.Unlock()
+	return append([]Collector(nil), registeredCollectors...)
+}
+
+// ServerWithCollectors add collectors run on the server besides the registered ones
+func ServerWithCollectors(collectors ...Collector) ServerOpt {
+	return func(s *Server) {
+		s.collectors = append(s.collectors, collectors...)
+	}
+}
+
+// serverInfo returns the info passed to collectors
+func (s *Server) serverInfo() ServerInfo {
+	database, _ := s.dsnDatabase()
+	return ServerInfo{
+		Server:     s.String(),
+		Database:   database,
+		Namespace:  s.namespace,
+		Labels:     s.labels,
+		Version:    s.lastMapVersion,
+		Master:     s.master,
+		InRecovery: s.inRecovery,
+		Extensions: s.extensions,
+		Logger:     s.log(),
+	}
+}
+
+// collectorDisabled returns whether the collector is disabled by options
+func (s *Server) collectorDisabled(c Collector) bool {
+	return s.disableSettingsMetrics && (c.Name() == settingsCollectorName || c.Name() == settingsChangesCollectorName) ||
+		Contains(s.disabledCollectors, c.Name())
+}
+
+// runCollectors run all enabled collectors, returns the names of failed collectors
+func (s *Server) runCollectors(ctx context.Context, ch chan<- prometheus.Metric) []string {
+	info := s.serverInfo()
+	var failed []string
+	for _, c := range s.collectors {
+		if s.collectorDisabled(c) || !c.Enabled(info) {
+			continue
+		}
+		if err := c.Collect(ctx, s.db, info, ch); err != nil {
+			s.log().Errorf("collector %s on %s err %s", c.Name(), s, err)
+			failed = append(failed, c.Name())
+		}
+	}
+	return failed
+}
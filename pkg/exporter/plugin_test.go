@@ -0,0 +1,76 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testCollector struct {
+	name     string
+	standby  bool // only enabled on standby
+	err      error
+	collects int
+}
+
+func (c *testCollector) Name() string {
+	return c.name
+}
+
+func (c *testCollector) Enabled(info ServerInfo) bool {
+	return !c.standby || info.InRecovery
+}
+
+func (c *testCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	c.collects++
+	return c.err
+}
+
+func Test_Server_runCollectors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	primary := &testCollector{name: "primary"}
+	standby := &testCollector{name: "standby", standby: true}
+	broken := &testCollector{name: "broken", err: fmt.Errorf("broken")}
+	s := &Server{
+		db:         db,
+		master:     true,
+		labels:     prometheus.Labels{"server": "localhost:5432"},
+		collectors: append(getRegisteredCollectors(), primary, standby, broken),
+	}
+	mock.ExpectQuery("FROM pg_attribute").WillReturnRows(sqlmock.NewRows([]string{"supported"}).AddRow(false))
+	mock.ExpectQuery("SELECT name, setting").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "setting", "unit", "short_desc", "vartype"}).
+			AddRow("max_connections", "100", "", "Sets the maximum number of concurrent connections.", "integer"))
+	mock.ExpectQuery("SELECT name, coalesce").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "setting", "reset_val"}).AddRow("max_connections", "100", "100"))
+	ch := make(chan prometheus.Metric, 10)
+	assert.Equal(t, []string{"broken"}, s.runCollectors(context.Background(), ch))
+	assert.Len(t, ch, 3)
+	assert.Equal(t, 1, primary.collects)
+	assert.Equal(t, 0, standby.collects)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// settings disabled
+	s.disableSettingsMetrics = true
+	s.inRecovery = true
+	assert.Equal(t, []string{"broken"}, s.runCollectors(context.Background(), ch))
+	assert.Len(t, ch, 3)
+	assert.Equal(t, 1, standby.collects)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRegisterCollector(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterCollector(settingsCollector{})
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// NewProcessCollector returns the collector of the exporter process resources.
+// The collector of client_golang reads /proc on Linux and supports Windows, on macOS and the BSDs the cpu time,
+// resident memory, max resident memory and file descriptors are read by residentMemory, getrusage and /dev/fd instead
+func NewProcessCollector() prometheus.Collector {
+	return newProcessCollector()
+}
+
+// residentMemory returns the current resident memory size of the exporter process in bytes, read from
+// /proc/self/statm where procfs is mounted, else from the rss reported by ps in kilobytes
+func residentMemory() (float64, error) {
+	if data, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		return parseStatm(data, os.Getpagesize())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ps", "-o", "rss=", "-p", strconv.Itoa(os.Getpid())).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ps: %s", err)
+	}
+	rss, err := strconv.ParseFloat(string(bytes.TrimSpace(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse rss of ps %q: %s", out, err)
+	}
+	return rss * 1024, nil
+}
+
+// parseStatm returns the resident memory in bytes of a statm file, its second field in pages
+func parseStatm(data []byte, pageSize int) (float64, error) {
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed statm %q", data)
+	}
+	pages, err := strconv.ParseFloat(string(fields[1]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed statm %q: %s", data, err)
+	}
+	return pages * float64(pageSize), nil
+}
+
+// collectConnections emit the connection pool statistics of the server and its database connections
+func (s *Server) collectConnections(ch chan<- prometheus.Metric) {
+	openDesc := prometheus.NewDesc(prometheus.BuildFQName(s.namespace, "exporter", "db_open_connections"),
+		"Number of established connections to the database, both in use and idle.", []string{"database"}, s.labels)
+	inUseDesc := prometheus.NewDesc(prometheus.BuildFQName(s.namespace, "exporter", "db_in_use_connections"),
+		"Number of connections to the database currently in use.", []string{"database"}, s.labels)
+	emit := func(database string, stats sql.DBStats) {
+		ch <- prometheus.MustNewConstMetric(openDesc, prometheus.GaugeValue, float64(stats.OpenConnections), database)
+		ch <- prometheus.MustNewConstMetric(inUseDesc, prometheus.GaugeValue, float64(stats.InUse), database)
+	}
+	if s.db != nil {
+		emit("", s.db.Stats())
+	}
+	s.databasesMtx.Lock()
+	defer s.databasesMtx.Unlock()
+	for name, db := range s.databases {
+		emit(name, db.Stats())
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package exporter
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// newProcessCollector returns the process collector of client_golang, reading /proc or the Windows API
+func newProcessCollector() prometheus.Collector {
+	return prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{})
+}
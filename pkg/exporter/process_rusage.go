@@ -0,0 +1,83 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// rusageProcessCollector collect the resources of the exporter process by getrusage and ps, where /proc is not available
+type rusageProcessCollector struct {
+	cpuTotal *prometheus.Desc
+	rss      *prometheus.Desc
+	maxRSS   *prometheus.Desc
+	openFDs  *prometheus.Desc
+	maxFDs   *prometheus.Desc
+}
+
+func newProcessCollector() prometheus.Collector {
+	return &rusageProcessCollector{
+		cpuTotal: prometheus.NewDesc("process_cpu_seconds_total",
+			"Total user and system CPU time spent in seconds.", nil, nil),
+		rss: prometheus.NewDesc("process_resident_memory_bytes",
+			"Resident memory size in bytes.", nil, nil),
+		maxRSS: prometheus.NewDesc("process_max_resident_memory_bytes",
+			"Maximum resident memory size in bytes.", nil, nil),
+		openFDs: prometheus.NewDesc("process_open_fds",
+			"Number of open file descriptors.", nil, nil),
+		maxFDs: prometheus.NewDesc("process_max_fds",
+			"Maximum number of open file descriptors.", nil, nil),
+	}
+}
+
+func (c *rusageProcessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpuTotal
+	ch <- c.rss
+	ch <- c.maxRSS
+	ch <- c.openFDs
+	ch <- c.maxFDs
+}
+
+func (c *rusageProcessCollector) Collect(ch chan<- prometheus.Metric) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err == nil {
+		cpu := float64(usage.Utime.Sec+usage.Stime.Sec) + float64(usage.Utime.Usec+usage.Stime.Usec)/1e6
+		ch <- prometheus.MustNewConstMetric(c.cpuTotal, prometheus.CounterValue, cpu)
+		// ru_maxrss is in bytes on macOS, in kilobytes on the BSDs
+		maxRSS := float64(usage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			maxRSS *= 1024
+		}
+		ch <- prometheus.MustNewConstMetric(c.maxRSS, prometheus.GaugeValue, maxRSS)
+	}
+	if rss, err := residentMemory(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.rss, prometheus.GaugeValue, rss)
+	}
+	if fds, err := openFDs(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.openFDs, prometheus.GaugeValue, float64(fds))
+	}
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.maxFDs, prometheus.GaugeValue, float64(limit.Cur))
+	}
+}
+
+// openFDs count the entries of /dev/fd, less the one opened to read it
+func openFDs() (int, error) {
+	d, err := os.Open("/dev/fd")
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close() // nolint: errcheck
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	return len(names) - 1, nil
+}
@@ -0,0 +1,27 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"testing"
+)
+
+func Test_parseStatm(t *testing.T) {
+	rss, err := parseStatm([]byte("5241 1530 862 1 0 2102 0\n"), 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1530*4096), rss)
+	_, err = parseStatm([]byte("5241"), 4096)
+	assert.EqualError(t, err, `malformed statm "5241"`)
+}
+
+func Test_residentMemory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("neither /proc nor ps on windows")
+	}
+	rss, err := residentMemory()
+	if assert.NoError(t, err) {
+		assert.True(t, rss > 0)
+	}
+}
@@ -31,19 +31,38 @@ func CheckStatus(s string) (string, error) {
 
 // QueryInstance hold the information of how to fetch metric and parse them
 type QueryInstance struct {
-	Name        string             `yaml:"name,omitempty"`     // actual query name, used as metric prefix
-	Desc        string             `yaml:"desc,omitempty"`     // description of this metric query
-	Queries     []*Query           `yaml:"query,omitempty"`    // 采集SQL
-	Metrics     []*Column          `yaml:"metrics,omitempty"`  // metric definition list
-	Status      string             `yaml:"status,omitempty"`   // enable/disable status. For the entire collection of indicators 针对整个采集指标
-	TTL         float64            `yaml:"ttl,omitempty"`      // caching ttl in seconds
-	Priority    int                `yaml:"priority,omitempty"` // 权重,暂时不用
-	Timeout     float64            `yaml:"timeout,omitempty"`  // query execution timeout in seconds
-	Path        string             `yaml:"-"`                  // where am I from ?
-	Columns     map[string]*Column `yaml:"-"`                  // column map
-	ColumnNames []string           `yaml:"-"`                  // column names in origin orders
-	LabelNames  []string           `yaml:"-"`                  // column (name) that used as label, sequences matters
-	MetricNames []string           `yaml:"-"`                  // column (name) that used as metric
+	Name              string             `yaml:"name,omitempty"`               // actual query name, used as metric prefix
+	Desc              string             `yaml:"desc,omitempty"`               // description of this metric query
+	Queries           []*Query           `yaml:"query,omitempty"`              // 采集SQL
+	Metrics           []*Column          `yaml:"metrics,omitempty"`            // metric definition list
+	Status            string             `yaml:"status,omitempty"`             // enable/disable status. For the entire collection of indicators 针对整个采集指标
+	TTL               float64            `yaml:"ttl,omitempty"`                // caching ttl in seconds
+	MinTTL            float64            `yaml:"min_ttl,omitempty"`            // lower bound of the adaptive ttl in seconds, 0 means none
+	MaxTTL            float64            `yaml:"max_ttl,omitempty"`            // upper bound of the adaptive ttl in seconds, 0 means none
+	Priority          int                `yaml:"priority,omitempty"`           // 权重,暂时不用
+	Timeout           float64            `yaml:"timeout,omitempty"`            // query execution timeout in seconds
+	Scope             string             `yaml:"scope,omitempty"`              // database: run in every database, cluster: run once per instance
+	Database          string             `yaml:"database,omitempty"`           // run in this database instead of the one of the dsn
+	IncludedDatabases []string           `yaml:"included_databases,omitempty"` // only run in these databases, instead of the exporter's excluded databases
+	ExcludedDatabases []string           `yaml:"excluded_databases,omitempty"` // never run in these databases, instead of the exporter's excluded databases
+	TopN              *TopN              `yaml:"topn,omitempty"`               // only keep the samples of the top N rows
+	MaxSeries         int                `yaml:"max_series,omitempty"`         // max label combinations, instead of the exporter's limit
+	Watermark         *Watermark         `yaml:"watermark,omitempty"`          // only fetch the rows above the highest value seen
+	Role              string             `yaml:"role,omitempty"`               // primary or standby: only run on servers of this role
+	KeepLabels        []string           `yaml:"keep_labels,omitempty"`        // only emit these labels, the samples are summed over the others
+	DropLabels        []string           `yaml:"drop_labels,omitempty"`        // do not emit these labels, the samples are summed over them
+	Extension         string             `yaml:"extension,omitempty"`          // only run in databases where this extension is installed
+	Tier              string             `yaml:"tier,omitempty"`               // critical: also run by the scrapes of the critical tier
+	Groups            []string           `yaml:"groups,omitempty"`             // groups of the query, served on sub-paths of the metrics path
+	Path              string             `yaml:"-"`                            // where am I from ?
+	Columns           map[string]*Column `yaml:"-"`                            // column map
+	ColumnNames       []string           `yaml:"-"`                            // column names in origin orders
+	LabelNames        []string           `yaml:"-"`                            // column (name) that used as label, sequences matters
+	MetricNames       []string           `yaml:"-"`                            // column (name) that used as metric
+	DatnameTag        bool               `yaml:"-"`                            // datname label attached by database scope, not a column
+	DroppedLabels     []string           `yaml:"-"`                            // label columns not emitted, by keep_labels or drop_labels
+	ExprColumns       []*Column          `yaml:"-"`                            // columns computed from an expression over other columns
+	ExtractColumns    []*Column          `yaml:"-"`                            // columns captured from the text of other columns
 }
 
 type Query struct {
@@ -55,6 +74,29 @@ type Query struct {
 	Timeout           float64      `yaml:"timeout,omitempty"` // query execution timeout in seconds
 	TTL               float64      `yaml:"ttl,omitempty"`     // caching ttl in seconds
 	Status            string       `yaml:"status,omitempty"`  // enable/disable status. 状态是否开启,针对特定版本.
+	Exec              *ExecSource  `yaml:"exec,omitempty"`    // run an external command instead of sql
+	HTTP              *HTTPSource  `yaml:"http,omitempty"`    // fetch json from an http endpoint instead of sql
+	Static            StaticSource `yaml:"static,omitempty"`  // rows defined in the config instead of sql
+}
+
+// isSQL returns whether the query is executed on database
+func (q *Query) isSQL() bool {
+	return q.Exec == nil && q.HTTP == nil && q.Static == nil
+}
+
+// sources returns the number of non-SQL sources set on the query
+func (q *Query) sources() int {
+	n := 0
+	if q.Exec != nil {
+		n++
+	}
+	if q.HTTP != nil {
+		n++
+	}
+	if q.Static != nil {
+		n++
+	}
+	return n
 }
 
 // TimeoutDuration Get timeout settings
@@ -77,6 +119,7 @@ func (q *QueryInstance) ToYaml() string {
 
 // Check configuration and handle default values 检查配置并处理默认值
 func (q *QueryInstance) Check() error {
+	var errs ConfigErrors
 	if q.Timeout == 0 {
 		q.Timeout = 0.1
 	}
@@ -86,14 +129,59 @@ func (q *QueryInstance) Check() error {
 	if q.TTL == 0 {
 		q.TTL = 60
 	}
+	if q.MinTTL < 0 {
+		errs.add(q.Name, "", "min_ttl", fmt.Errorf("min_ttl must not be negative"))
+	}
+	if q.MaxTTL < 0 {
+		errs.add(q.Name, "", "max_ttl", fmt.Errorf("max_ttl must not be negative"))
+	}
+	if q.MaxTTL > 0 && q.MinTTL > q.MaxTTL {
+		errs.add(q.Name, "", "min_ttl", fmt.Errorf("min_ttl %v is above max_ttl %v", q.MinTTL, q.MaxTTL))
+	}
 	if status, err := CheckStatus(q.Status); err != nil {
-		return err
+		errs.add(q.Name, "", "status", err)
 	} else {
 		q.Status = status
 	}
+	q.Scope = strings.ToLower(q.Scope)
+	if !QueryScope[q.Scope] {
+		errs.add(q.Name, "", "scope", fmt.Errorf("unsupported scope: %s", q.Scope))
+	}
+	q.Role = strings.ToLower(q.Role)
+	if !ServerRoles[q.Role] {
+		errs.add(q.Name, "", "role", fmt.Errorf("unsupported role: %s", q.Role))
+	}
+	q.Tier = strings.ToLower(q.Tier)
+	if !QueryTiers[q.Tier] {
+		errs.add(q.Name, "", "tier", fmt.Errorf("unsupported tier: %s", q.Tier))
+	}
+	for i, group := range q.Groups {
+		q.Groups[i] = strings.ToLower(group)
+		if err := checkGroup(q.Groups[i]); err != nil {
+			errs.add(q.Name, "", "groups", err)
+		}
+	}
+	if q.MaxSeries < 0 {
+		errs.add(q.Name, "", "max_series", fmt.Errorf("max_series must not be negative"))
+	}
+	if q.Database != "" && q.Scope == scopeDatabase {
+		errs.add(q.Name, "", "database", fmt.Errorf("database scoped query can not be pinned to database %s", q.Database))
+	}
+	if len(q.IncludedDatabases) > 0 && q.ExcludedDatabases != nil {
+		errs.add(q.Name, "", "included_databases", fmt.Errorf("included_databases and excluded_databases are exclusive"))
+	}
+	if q.hasDatabaseLists() && q.Database != "" {
+		errs.add(q.Name, "", "database", fmt.Errorf("query pinned to database %s can not include or exclude databases", q.Database))
+	}
+	if q.hasDatabaseLists() && q.Scope == scopeCluster {
+		errs.add(q.Name, "", "scope", fmt.Errorf("cluster scoped query can not include or exclude databases"))
+	}
 	// parse query column info
 	columns := make(map[string]*Column, len(q.Metrics))
-	for _, query := range q.Queries {
+	for i, query := range q.Queries {
+		field := func(name string) string {
+			return fmt.Sprintf("query[%d].%s", i, name)
+		}
 		if query.Timeout == 0 {
 			query.Timeout = q.Timeout
 		}
@@ -101,26 +189,110 @@ func (q *QueryInstance) Check() error {
 		if query.SupportedVersions == "" {
 			query.SupportedVersions = defaultVersion
 		}
-		query.versionRange = semver.MustParseRange(query.SupportedVersions)
+		if versionRange, err := semver.ParseRange(query.SupportedVersions); err != nil {
+			errs.add(q.Name, "", field("version"), err)
+		} else {
+			query.versionRange = versionRange
+		}
 		if status, err := CheckStatus(query.Status); err != nil {
-			return err
+			errs.add(q.Name, "", field("status"), err)
 		} else {
 			query.Status = status
 		}
 		if query.TTL == 0 {
 			query.TTL = q.TTL
 		}
+		if query.sources() > 1 {
+			errs.add(q.Name, "", fmt.Sprintf("query[%d]", i), fmt.Errorf("exec, http and static are exclusive"))
+		}
+		if !query.isSQL() && q.Scope == scopeDatabase {
+			errs.add(q.Name, "", "scope", fmt.Errorf("only sql can be database scoped"))
+		}
+		if !query.isSQL() && q.Database != "" {
+			errs.add(q.Name, "", "database", fmt.Errorf("only sql can be pinned to a database"))
+		}
+		if query.Exec != nil {
+			if err := query.Exec.Check(); err != nil {
+				errs.add(q.Name, "", field("exec"), err)
+			}
+		}
+		if query.HTTP != nil {
+			if err := query.HTTP.Check(); err != nil {
+				errs.add(q.Name, "", field("http"), err)
+			}
+		}
+		if query.Static != nil {
+			if err := query.Static.Check(); err != nil {
+				errs.add(q.Name, "", field("static"), err)
+			}
+		}
 		query.Name = q.Name
 	}
 
 	var allColumns, labelColumns, metricColumns []string
+	var exprColumns, extractColumns []*Column
 
 	for _, column := range q.Metrics {
 
 		if _, isValid := ColumnUsage[column.Usage]; !isValid {
-			return fmt.Errorf("column %s have unsupported usage: %s", column.Name, column.Desc)
+			errs.add(q.Name, column.Name, "usage", fmt.Errorf("unsupported usage: %s", column.Usage))
 		}
 		column.Usage = strings.ToUpper(column.Usage)
+		column.NullValue = strings.ToLower(column.NullValue)
+		if column.NullValue != "" && !NullValuePolicy[column.NullValue] {
+			errs.add(q.Name, column.Name, "null_value", fmt.Errorf("unsupported null_value: %s", column.NullValue))
+		}
+		column.OutOfRange = strings.ToLower(column.OutOfRange)
+		if column.OutOfRange != "" && !OutOfRangePolicy[column.OutOfRange] {
+			errs.add(q.Name, column.Name, "out_of_range", fmt.Errorf("unsupported out_of_range: %s", column.OutOfRange))
+		}
+		if (column.Min != nil || column.Max != nil) && (column.Usage == LABEL || column.Usage == DISCARD) {
+			errs.add(q.Name, column.Name, "min", fmt.Errorf("only metric columns can have min and max"))
+		}
+		if column.Min != nil && column.Max != nil && *column.Min > *column.Max {
+			errs.add(q.Name, column.Name, "min", fmt.Errorf("min %v is above max %v", *column.Min, *column.Max))
+		}
+		if column.Label != nil {
+			if column.Usage != LABEL {
+				errs.add(q.Name, column.Name, "label", fmt.Errorf("label transform only applies to label columns"))
+			} else if err := column.Label.Check(); err != nil {
+				errs.add(q.Name, column.Name, "label", err)
+			}
+		}
+		column.expression = nil
+		if column.Expr != "" {
+			if column.Usage == LABEL || column.Usage == DISCARD {
+				errs.add(q.Name, column.Name, "expr", fmt.Errorf("only metric columns can be computed by expr"))
+			} else if expr, err := parseExpression(column.Expr); err != nil {
+				errs.add(q.Name, column.Name, "expr", err)
+			} else if Contains(expr.columns, column.Name) {
+				errs.add(q.Name, column.Name, "expr", fmt.Errorf("expr refers to the column itself"))
+			} else {
+				column.expression = expr
+				exprColumns = append(exprColumns, column)
+			}
+		}
+		if column.Extract != nil {
+			if column.Usage == DISCARD {
+				errs.add(q.Name, column.Name, "extract", fmt.Errorf("discarded columns can not be extracted"))
+			} else if column.Expr != "" {
+				errs.add(q.Name, column.Name, "extract", fmt.Errorf("extract and expr are exclusive"))
+			} else if field, err := column.Extract.Check(column.Name); err != nil {
+				errs.add(q.Name, column.Name, "extract."+field, err)
+			} else {
+				extractColumns = append(extractColumns, column)
+			}
+		}
+		if len(column.Alerts) > 0 && (column.Usage == LABEL || column.Usage == DISCARD) {
+			errs.add(q.Name, column.Name, "alerts", fmt.Errorf("only metric columns can have alerts"))
+		}
+		for i, alert := range column.Alerts {
+			if field, err := alert.Check(); err != nil {
+				errs.add(q.Name, column.Name, fmt.Sprintf("alerts[%d].%s", i, field), err)
+			}
+		}
+		// the type is set here once, as the columns are read by concurrent scrapes
+		column.DisCard, column.PrometheusType = false, prometheus.GaugeValue
 		switch column.Usage {
 		case LABEL:
 			labelColumns = append(labelColumns, column.Name)
@@ -131,19 +303,72 @@ func (q *QueryInstance) Check() error {
 			metricColumns = append(metricColumns, column.Name)
 		case COUNTER:
 			metricColumns = append(metricColumns, column.Name)
+			column.PrometheusType = prometheus.CounterValue
 		case HISTOGRAM:
 			column.Histogram = true
+			column.PrometheusType = prometheus.UntypedValue
 			metricColumns = append(metricColumns, column.Name)
 		case MappedMETRIC:
 			metricColumns = append(metricColumns, column.Name)
 		case DURATION:
 			metricColumns = append(metricColumns, column.Name)
+		case DELTA:
+			metricColumns = append(metricColumns, column.Name)
+		case TIMESTAMP:
+			metricColumns = append(metricColumns, column.Name)
+		case BOOL:
+			metricColumns = append(metricColumns, column.Name)
 		}
 		allColumns = append(allColumns, column.Name)
 		columns[column.Name] = column
 	}
+	// the text extracted from must be read from the result
+	for _, column := range extractColumns {
+		if from, ok := columns[column.Extract.From]; ok && (from.expression != nil || from.Extract != nil) {
+			errs.add(q.Name, column.Name, "extract.from", fmt.Errorf("column %s is not read from the result", from.Name))
+		}
+	}
+	// database scoped samples are told apart by datname, unless the query returns it
+	q.DatnameTag = false
+	if _, ok := columns[datnameLabel]; !ok && q.Scope == scopeDatabase {
+		labelColumns = append(labelColumns, datnameLabel)
+		q.DatnameTag = true
+	}
+	if q.TopN != nil {
+		if field, err := q.TopN.Check(columns); err != nil {
+			errs.add(q.Name, "", "topn."+field, err)
+		}
+	}
+	if q.Watermark != nil {
+		if q.TopN != nil {
+			errs.add(q.Name, "", "watermark", fmt.Errorf("watermark and topn are exclusive"))
+		} else if field, err := q.Watermark.Check(columns); err != nil {
+			errs.add(q.Name, "", "watermark."+field, err)
+		}
+	}
+	labelColumns, dropped, field, err := q.filterLabels(labelColumns)
+	if err != nil {
+		errs.add(q.Name, "", field, err)
+	}
 	q.Columns, q.ColumnNames, q.LabelNames, q.MetricNames = columns, allColumns, labelColumns, metricColumns
-	return nil
+	q.ExprColumns, q.ExtractColumns, q.DroppedLabels = exprColumns, extractColumns, dropped
+	return errs.err()
+}
+
+// clone returns a copy of the query whose queries and columns are copied, to be changed and checked again
+func (q *QueryInstance) clone() *QueryInstance {
+	c := *q
+	c.Queries = make([]*Query, len(q.Queries))
+	for i, query := range q.Queries {
+		copied := *query
+		c.Queries[i] = &copied
+	}
+	c.Metrics = make([]*Column, len(q.Metrics))
+	for i, col := range q.Metrics {
+		copied := *col
+		c.Metrics[i] = &copied
+	}
+	return &c
 }
 
 // GetQuerySQL Get query sql according to version
@@ -159,30 +384,51 @@ func (q *QueryInstance) GetQuerySQL(ver semver.Version) *Query {
 	return nil
 }
 
+// getServerQuery returns the query of the version whose tags are all tags of the server
+func (q *QueryInstance) getServerQuery(ver semver.Version, tags []string) *Query {
+	for _, query := range q.Queries {
+		if query.versionRange != nil && !query.versionRange(ver) || !containsAll(tags, query.Tags) {
+			continue
+		}
+		return query
+	}
+	return nil
+}
+
 // GetColumn Get column information
 func (q *QueryInstance) GetColumn(colName string, serverLabels prometheus.Labels) *Column {
-	if col, ok := q.Columns[colName]; ok {
-		switch col.Usage {
-		case LABEL, DISCARD:
-			col.DisCard = true
-		case GAUGE:
-			col.PrometheusType = prometheus.GaugeValue
-			col.PrometheusDesc = prometheus.NewDesc(fmt.Sprintf("%s_%s", q.Name, col.Name), col.Desc, q.LabelNames, serverLabels)
-		case COUNTER:
-			col.PrometheusType = prometheus.CounterValue
-			col.PrometheusDesc = prometheus.NewDesc(fmt.Sprintf("%s_%s", q.Name, col.Name), col.Desc, q.LabelNames, serverLabels)
-		case HISTOGRAM:
-			col.PrometheusType = prometheus.UntypedValue
-			col.PrometheusDesc = prometheus.NewDesc(fmt.Sprintf("%s_%s", q.Name, col.Name), col.Desc, q.LabelNames, serverLabels)
-		case MappedMETRIC:
-			col.PrometheusType = prometheus.GaugeValue
-			col.PrometheusDesc = prometheus.NewDesc(fmt.Sprintf("%s_%s", q.Name, col.Name), col.Desc, q.LabelNames, serverLabels)
-		case DURATION:
-			col.PrometheusType = prometheus.GaugeValue
-			col.PrometheusDesc = prometheus.NewDesc(fmt.Sprintf("%s_%s_milliseconds", q.Name, col.Name), col.Desc, q.LabelNames, serverLabels)
-		}
+	col := q.getColumn(colName)
+	if col != nil && !col.DisCard {
+		col.PrometheusDesc = q.newColumnDesc(col, serverLabels)
+	}
+	return col
+}
 
-		return col
+// getColumn Get column information, its type is set by Check and the desc is not built
+func (q *QueryInstance) getColumn(colName string) *Column {
+	return q.Columns[colName]
+}
+
+// newColumnDesc build prometheus.Desc of column
+func (q *QueryInstance) newColumnDesc(col *Column, serverLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(q.columnMetricName(col), col.help(), q.LabelNames, serverLabels)
+}
+
+// columnMetricName returns the fully-qualified metric name of column
+func (q *QueryInstance) columnMetricName(col *Column) string {
+	if col.Usage == DURATION {
+		return fmt.Sprintf("%s_%s_milliseconds", q.Name, col.Name)
 	}
-	return nil
+	return fmt.Sprintf("%s_%s", q.Name, col.Name)
+}
+
+// metricNames returns the fully-qualified metric names emitted by metric columns
+func (q *QueryInstance) metricNames() []string {
+	names := make([]string, 0, len(q.MetricNames))
+	for _, name := range q.MetricNames {
+		if col, ok := q.Columns[name]; ok {
+			names = append(names, q.columnMetricName(col))
+		}
+	}
+	return names
 }
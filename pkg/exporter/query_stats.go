@@ -0,0 +1,490 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// queryStat hold the execution statistics of one query on one server
+type queryStat struct {
+	peakRows      int           // max rows returned by a single execution
+	peakBytes     int           // max bytes scanned by a single execution
+	totalRows     int           // rows returned by all executions
+	executions    int           // times the query executed on database
+	errors        int           // times the query failed
+	cacheHits     int           // times the query served from cache
+	retries       int           // times the query retried on transient error
+	totalDuration time.Duration // duration of all executions
+	minDuration   time.Duration
+	maxDuration   time.Duration
+	lastRun       time.Time
+	skipped       map[string]int // times the query skipped by reason
+	nulls         map[string]int // NULL values encountered by column
+	parseErrors   map[string]int // values failed to parse by column
+	outOfRange    map[string]int // values out of the min and max of the column, by column
+	denied        bool           // query disabled on permission denied
+	seriesDropped int            // label combinations dropped beyond the series limit
+	counterResets int            // executions whose COUNTER columns went backwards
+	queueWaits    int            // executions queued for the connection of the server
+	queueWait     time.Duration  // time queued for the connection of the server
+	lastRows      map[string]int // rows returned by the last execution, by database
+	lastClass     string         // class of the error of the last execution, errorClassNone if it succeeded
+	lastError     *LastError     // most recent error of the query
+	failures      int            // consecutive failed executions
+	openUntil     time.Time      // the circuit of the query is open until then, after circuitFailures failures
+}
+
+const (
+	// circuitFailures is the consecutive failures opening the circuit of a query, it is skipped for circuitOpenTime.
+	// The next execution closes it on success, or opens it again
+	circuitFailures = 5
+	circuitOpenTime = 5 * time.Minute
+)
+
+// reasons of a skipped query
+const (
+	skipReasonBudget    = "budget"
+	skipReasonCanceled  = "canceled"
+	skipReasonVersion   = "version"      // no sql of the query for the version and tags of the server
+	skipReasonDisabled  = "disabled"     // disabled by status or on the target
+	skipReasonRole      = "role"         // not run on servers of this role
+	skipReasonPredicate = "predicate"    // the scope, databases or extension of the query do not match the server
+	skipReasonCircuit   = "circuit_open" // disabled for insufficient privilege, or failing, see circuitFailures
+)
+
+// QueryProfile is the cost profile of one query on one server, accumulated since start
+type QueryProfile struct {
+	Server       string        `json:"server"`
+	Query        string        `json:"query"`
+	Executions   int           `json:"executions"`
+	AvgDuration  time.Duration `json:"avg_duration"`
+	MinDuration  time.Duration `json:"min_duration"`
+	MaxDuration  time.Duration `json:"max_duration"`
+	AvgRows      float64       `json:"avg_rows"`
+	PeakRows     int           `json:"peak_rows"`
+	ErrorRate    float64       `json:"error_rate"`
+	CacheHitRate float64       `json:"cache_hit_rate"`
+	LastRun      time.Time     `json:"last_run"`
+}
+
+// queryStats hold execution statistics of all queries on a server. nil is safe to use
+type queryStats struct {
+	m     sync.Mutex
+	stats map[string]*queryStat
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		stats: make(map[string]*queryStat),
+	}
+}
+
+// get returns stat of query, must hold lock
+func (q *queryStats) get(name string) *queryStat {
+	stat, ok := q.stats[name]
+	if !ok {
+		stat = &queryStat{}
+		q.stats[name] = stat
+	}
+	return stat
+}
+
+// observeRows record rows and bytes scanned by one execution in database datname
+func (q *queryStats) observeRows(name, datname string, rows, bytes int) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.lastRows == nil {
+		stat.lastRows = make(map[string]int)
+	}
+	stat.lastRows[datname] = rows
+	stat.totalRows += rows
+	if rows > stat.peakRows {
+		stat.peakRows = rows
+	}
+	if bytes > stat.peakBytes {
+		stat.peakBytes = bytes
+	}
+}
+
+// observeExecution record an execution of query on database
+func (q *queryStats) observeExecution(name string, begin time.Time, err error) {
+	if q == nil {
+		return
+	}
+	duration := time.Since(begin)
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	stat.executions++
+	if err != nil {
+		stat.errors++
+	}
+	stat.totalDuration += duration
+	if stat.minDuration == 0 || duration < stat.minDuration {
+		stat.minDuration = duration
+	}
+	if duration > stat.maxDuration {
+		stat.maxDuration = duration
+	}
+	stat.lastRun = begin
+}
+
+// observeSkip record a query skipped for reason
+func (q *queryStats) observeSkip(name, reason string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.skipped == nil {
+		stat.skipped = make(map[string]int)
+	}
+	stat.skipped[reason]++
+}
+
+// observeResult record the result of the last execution of query, err nil if it succeeded
+func (q *queryStats) observeResult(name string, err error, class string) (opened bool) {
+	if q == nil {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	stat.lastClass = class
+	switch class {
+	case errorClassNone:
+		stat.failures, stat.openUntil = 0, time.Time{}
+	case errorClassCanceled, errorClassConversion, errorClassPermission:
+		// abandoned by the client, partial results, or disabled already
+	default:
+		if stat.failures++; stat.failures >= circuitFailures {
+			stat.openUntil = time.Now().Add(circuitOpenTime)
+			opened = true
+		}
+	}
+	if err == nil {
+		if stat.lastError != nil {
+			stat.lastError.Failing = false
+		}
+		return opened
+	}
+	stat.lastError = newLastError(err, class)
+	stat.lastError.Query = name
+	return opened
+}
+
+// lastErrors returns the most recent error of all queries that failed
+func (q *queryStats) lastErrors(server string) []LastError {
+	if q == nil {
+		return nil
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	var result []LastError
+	for _, name := range q.names() {
+		if last := q.stats[name].lastError; last != nil {
+			last := *last
+			last.Server = server
+			result = append(result, last)
+		}
+	}
+	return result
+}
+
+// observeQueueWait record the time an execution waited for the connection of the server
+func (q *queryStats) observeQueueWait(name string, wait time.Duration) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	stat.queueWaits++
+	stat.queueWait += wait
+}
+
+// observeNull record a NULL value encountered in column
+func (q *queryStats) observeNull(name, column string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.nulls == nil {
+		stat.nulls = make(map[string]int)
+	}
+	stat.nulls[column]++
+}
+
+// observeParseError record a value of column failed to parse
+func (q *queryStats) observeParseError(name, column string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.parseErrors == nil {
+		stat.parseErrors = make(map[string]int)
+	}
+	stat.parseErrors[column]++
+}
+
+// observeOutOfRange record a value of column out of its min and max
+func (q *queryStats) observeOutOfRange(name, column string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.outOfRange == nil {
+		stat.outOfRange = make(map[string]int)
+	}
+	stat.outOfRange[column]++
+}
+
+// observeSeriesDropped record label combinations dropped beyond the series limit, returns true the first time
+func (q *queryStats) observeSeriesDropped(name string, n int) bool {
+	if q == nil {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	first := stat.seriesDropped == 0
+	stat.seriesDropped += n
+	return first
+}
+
+// observeCounterReset record an execution of the query whose COUNTER columns decreased, e.g. after pg_stat_reset
+func (q *queryStats) observeCounterReset(name string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.get(name).counterResets++
+}
+
+// observeRetry record a query retried on transient error
+func (q *queryStats) observeRetry(name string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.get(name).retries++
+}
+
+// observePermissionDenied disable the query on permission denied, returns false if already disabled
+func (q *queryStats) observePermissionDenied(name string) bool {
+	if q == nil {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat := q.get(name)
+	if stat.denied {
+		return false
+	}
+	stat.denied = true
+	return true
+}
+
+// permissionDenied returns whether the query disabled on permission denied
+func (q *queryStats) permissionDenied(name string) bool {
+	if q == nil {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat, ok := q.stats[name]
+	return ok && stat.denied
+}
+
+// circuitOpen returns whether the query is skipped at now, disabled on permission denied or failing
+func (q *queryStats) circuitOpen(name string, now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	stat, ok := q.stats[name]
+	return ok && (stat.denied || now.Before(stat.openUntil))
+}
+
+// observeCacheHit record a query served from cache
+func (q *queryStats) observeCacheHit(name string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.get(name).cacheHits++
+}
+
+// profiles returns the cost profile of all queries
+func (q *queryStats) profiles(server string) []QueryProfile {
+	if q == nil {
+		return nil
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	profiles := make([]QueryProfile, 0, len(q.stats))
+	for _, name := range q.names() {
+		stat := q.stats[name]
+		profile := QueryProfile{
+			Server:      server,
+			Query:       name,
+			Executions:  stat.executions,
+			MinDuration: stat.minDuration,
+			MaxDuration: stat.maxDuration,
+			PeakRows:    stat.peakRows,
+			LastRun:     stat.lastRun,
+		}
+		if stat.executions > 0 {
+			profile.AvgDuration = stat.totalDuration / time.Duration(stat.executions)
+			profile.AvgRows = float64(stat.totalRows) / float64(stat.executions)
+			profile.ErrorRate = float64(stat.errors) / float64(stat.executions)
+		}
+		if total := stat.executions + stat.cacheHits; total > 0 {
+			profile.CacheHitRate = float64(stat.cacheHits) / float64(total)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// WriteQueryProfiles write query profiles as a table
+func WriteQueryProfiles(w io.Writer, profiles []QueryProfile) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SERVER\tQUERY\tEXECUTIONS\tAVG\tMIN\tMAX\tAVG_ROWS\tPEAK_ROWS\tERROR_RATE\tCACHE_HIT_RATE\tLAST_RUN")
+	for _, p := range profiles {
+		lastRun := "-"
+		if !p.LastRun.IsZero() {
+			lastRun = p.LastRun.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%.1f\t%d\t%.2f\t%.2f\t%s\n",
+			p.Server, p.Query, p.Executions, p.AvgDuration, p.MinDuration, p.MaxDuration,
+			p.AvgRows, p.PeakRows, p.ErrorRate, p.CacheHitRate, lastRun)
+	}
+	return tw.Flush()
+}
+
+// names returns sorted query names
+func (q *queryStats) names() []string {
+	names := make([]string, 0, len(q.stats))
+	for name := range q.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collect emit query statistics metrics
+func (q *queryStats) collect(ch chan<- prometheus.Metric, namespace string, labels prometheus.Labels) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	peakRowsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_peak_rows"),
+		"Max number of rows returned by a single execution of the query.", []string{"query"}, labels)
+	peakBytesDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_peak_bytes"),
+		"Max number of bytes scanned by a single execution of the query.", []string{"query"}, labels)
+	skippedDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_skipped_total"),
+		"Total number of times the query was skipped, by reason.", []string{"query", "reason"}, labels)
+	retriesDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_retries_total"),
+		"Total number of times the query was retried on transient error.", []string{"query"}, labels)
+	nullsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_null_values_total"),
+		"Total number of NULL values encountered in metric columns of the query.", []string{"query", "column"}, labels)
+	parseErrorsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_parse_errors_total"),
+		"Total number of values failed to parse in metric columns of the query.", []string{"query", "column"}, labels)
+	outOfRangeDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_out_of_range_values_total"),
+		"Total number of values out of the min and max of metric columns of the query, clamped or dropped.", []string{"query", "column"}, labels)
+	deniedDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_permission_denied"),
+		"Whether the query is disabled for insufficient privilege (1 for disabled).", []string{"query"}, labels)
+	executionsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_executions_total"),
+		"Total number of times the query was executed on database.", []string{"query"}, labels)
+	cacheHitsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_cache_hits_total"),
+		"Total number of times the query was served from cache.", []string{"query"}, labels)
+	seriesDroppedDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "series_dropped_total"),
+		"Total number of label combinations of the query dropped beyond the series limit.", []string{"query"}, labels)
+	counterResetsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "counter_resets_total"),
+		"Total number of executions of the query whose counters went backwards, e.g. after a stats reset or a restart.", []string{"query"}, labels)
+	resultRowsDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_result_rows"),
+		"Number of rows returned by the last execution of the query in the database.", []string{"query", "datname"}, labels)
+	queueWaitDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_queue_wait_seconds"),
+		"Time the executions of the query waited for the connection of the server in single connection mode.", []string{"query"}, labels)
+	errorClassDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "query_error_class"),
+		"Class of the error of the last execution of the query: "+errorClassHelp+".", []string{"query"}, labels)
+	for _, name := range q.names() {
+		stat := q.stats[name]
+		if stat.lastClass != "" {
+			ch <- prometheus.MustNewConstMetric(errorClassDesc, prometheus.GaugeValue, errorClassValue(stat.lastClass), name)
+		}
+		if stat.denied {
+			ch <- prometheus.MustNewConstMetric(deniedDesc, prometheus.GaugeValue, 1, name)
+		}
+		if stat.seriesDropped > 0 {
+			ch <- prometheus.MustNewConstMetric(seriesDroppedDesc, prometheus.CounterValue, float64(stat.seriesDropped), name)
+		}
+		for column, count := range stat.parseErrors {
+			ch <- prometheus.MustNewConstMetric(parseErrorsDesc, prometheus.CounterValue, float64(count), name, column)
+		}
+		for column, count := range stat.outOfRange {
+			ch <- prometheus.MustNewConstMetric(outOfRangeDesc, prometheus.CounterValue, float64(count), name, column)
+		}
+		for column, count := range stat.nulls {
+			ch <- prometheus.MustNewConstMetric(nullsDesc, prometheus.CounterValue, float64(count), name, column)
+		}
+		ch <- prometheus.MustNewConstMetric(executionsDesc, prometheus.CounterValue, float64(stat.executions), name)
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stat.cacheHits), name)
+		ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(stat.retries), name)
+		ch <- prometheus.MustNewConstMetric(counterResetsDesc, prometheus.CounterValue, float64(stat.counterResets), name)
+		ch <- prometheus.MustNewConstMetric(peakRowsDesc, prometheus.GaugeValue, float64(stat.peakRows), name)
+		ch <- prometheus.MustNewConstMetric(peakBytesDesc, prometheus.GaugeValue, float64(stat.peakBytes), name)
+		for datname, rows := range stat.lastRows {
+			ch <- prometheus.MustNewConstMetric(resultRowsDesc, prometheus.GaugeValue, float64(rows), name, datname)
+		}
+		if stat.queueWaits > 0 {
+			ch <- prometheus.MustNewConstSummary(queueWaitDesc, uint64(stat.queueWaits), stat.queueWait.Seconds(), nil, name)
+		}
+		for reason, count := range stat.skipped {
+			ch <- prometheus.MustNewConstMetric(skippedDesc, prometheus.CounterValue, float64(count), name, reason)
+		}
+	}
+}
+
+// columnBytes Estimate the memory size of the scanned row
+func columnBytes(columnData []interface{}) int {
+	var size int
+	for _, data := range columnData {
+		switch v := data.(type) {
+		case []byte:
+			size += len(v)
+		case string:
+			size += len(v)
+		case time.Time:
+			size += 24
+		case nil:
+		default:
+			size += 8
+		}
+	}
+	return size
+}
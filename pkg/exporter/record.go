@@ -0,0 +1,100 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// recordFileRegex match the characters not kept in the names of recorded files
+var recordFileRegex = regexp.MustCompile(`[^\w.@-]+`)
+
+// Recorder write the result sets of the queries to fixture files, replayed by LoadMockFixtures.
+// The fixtures of a server are written in <dir>/<server>/<version>, one file per query overwritten on every scrape
+type Recorder struct {
+	dir string
+	mtx sync.Mutex
+}
+
+// NewRecorder returns a Recorder writing to dir
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir}
+}
+
+// ServerWithRecorder record the result sets of the queries with r, nil disables recording
+func ServerWithRecorder(r *Recorder) ServerOpt {
+	return func(s *Server) {
+		s.recorder = r
+	}
+}
+
+// recordDir returns the dir of the fixtures of server, by the version of the server
+func (r *Recorder) recordDir(server *Server) string {
+	version := server.lastShortVersion
+	if version == "" {
+		version = server.lastMapVersion.String()
+	}
+	return filepath.Join(r.dir, recordFileName(server.String()), recordFileName(version))
+}
+
+// record write the fixture to <name>.yaml in the dir of server. The file is replaced atomically
+func (r *Recorder) record(server *Server, name string, fixture *MockFixture) error {
+	content, err := yaml.Marshal([]*MockFixture{fixture})
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("# recorded from %s version %s at %s\n", server, server.lastVersionString, time.Now().Format(time.RFC3339))
+	content = append([]byte(header), content...)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	dir := r.recordDir(server)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".record-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, recordFileName(name)+".yaml"))
+}
+
+// recordFileName returns name with the characters not allowed in file names replaced by _
+func recordFileName(name string) string {
+	if name = recordFileRegex.ReplaceAllString(name, "_"); name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// recordRow returns a copy of the scanned values of a row that can be written to a fixture:
+// bytes are written as strings and times as RFC 3339 strings, replayed as times
+func recordRow(columnData []interface{}) []interface{} {
+	row := make([]interface{}, len(columnData))
+	for i, data := range columnData {
+		switch v := data.(type) {
+		case []byte:
+			row[i] = string(v)
+		case time.Time:
+			row[i] = v.Format(time.RFC3339Nano)
+		default:
+			row[i] = v
+		}
+	}
+	return row
+}
@@ -0,0 +1,87 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	lock := &QueryInstance{
+		Name:    "pg_lock",
+		Queries: []*Query{{SQL: "SELECT datname, count, since FROM pg_locks"}},
+		Metrics: []*Column{{Name: "datname", Usage: LABEL}, {Name: "count", Usage: GAUGE}, {Name: "since", Usage: LABEL}},
+	}
+	assert.NoError(t, lock.Check())
+	queries := map[string]*QueryInstance{"pg_lock": lock}
+	since := time.Date(2021, 9, 30, 14, 29, 4, 0, time.UTC)
+	source := MockResults{normalizeSQL(lock.Queries[0].SQL): {
+		Columns: []string{"datname", "count", "since"},
+		Rows:    [][]interface{}{{[]byte("postgres"), int64(3), since}, {"template1", 1.5, nil}},
+	}}
+	recorder := NewRecorder(dir)
+
+	collect := func(s *Server) []string {
+		metrics, _, err := s.queryMetric("pg_lock", lock)
+		assert.NoError(t, err)
+		var samples []string
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			samples = append(samples, metric.Desc().String()+" "+m.String())
+		}
+		return samples
+	}
+
+	s, err := NewServer("postgres://localhost:5432/postgres", ServerWithMockResults(source), ServerWithRecorder(recorder))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close() // nolint: errcheck
+	s.lastVersionString, s.lastShortVersion = mockVersion, "2.1.0"
+	recorded := collect(s)
+	assert.Len(t, recorded, 2)
+
+	file := filepath.Join(dir, "localhost_5432", "2.1.0", "pg_lock.yaml")
+	content, err := ioutil.ReadFile(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(string(content), "# recorded from localhost:5432 version "+mockVersion+" at "))
+	assert.Contains(t, string(content), "- - postgres\n    - 3\n    - \"2021-09-30T14:29:04Z\"\n")
+
+	// the recorded fixtures replay the same samples
+	results, err := LoadMockFixtures(filepath.Dir(file), queries)
+	if !assert.NoError(t, err) {
+		return
+	}
+	replay, err := NewServer("postgres://localhost:5432/postgres", ServerWithMockResults(results))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer replay.Close() // nolint: errcheck
+	assert.Equal(t, recorded, collect(replay))
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(file), "*"))
+	assert.Equal(t, []string{file}, files)
+}
+
+func Test_recordFileName(t *testing.T) {
+	assert.Equal(t, "localhost_5432", recordFileName("localhost:5432"))
+	assert.Equal(t, "pg_lock@postgres", recordFileName("pg_lock@postgres"))
+	assert.Equal(t, "2.1.0", recordFileName("2.1.0"))
+	assert.Equal(t, "_", recordFileName(""))
+	assert.Equal(t, ".._etc_passwd", recordFileName("../etc/passwd"))
+	assert.Equal(t, "_", recordFileName(".."))
+}
@@ -0,0 +1,129 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+)
+
+// rateRange is the range of the rate of counters recorded for alerts
+const rateRange = "5m"
+
+// alertOps are the comparisons of a value to the threshold of an alert
+var alertOps = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "==": true, "!=": true}
+
+// Alert is an alerting rule on the value of a metric column, the rate of counters is compared to the threshold
+type Alert struct {
+	Name      string  `yaml:"name,omitempty"`     // alert name, the metric name in camel case by default
+	Op        string  `yaml:"op,omitempty"`       // comparison to the threshold: > (default), >=, <, <=, ==, !=
+	Threshold float64 `yaml:"threshold"`          // value the metric is compared to
+	For       string  `yaml:"for,omitempty"`      // how long the condition holds before firing, e.g. 5m
+	Severity  string  `yaml:"severity,omitempty"` // severity label, warning by default
+	Summary   string  `yaml:"summary,omitempty"`  // summary annotation, the condition by default
+}
+
+// Check the alert and set the defaults, returns the invalid field
+func (a *Alert) Check() (string, error) {
+	if a.Op == "" {
+		a.Op = ">"
+	}
+	if !alertOps[a.Op] {
+		return "op", fmt.Errorf("unsupported op: %s", a.Op)
+	}
+	if a.For != "" {
+		if _, err := model.ParseDuration(a.For); err != nil {
+			return "for", err
+		}
+	}
+	if a.Severity == "" {
+		a.Severity = "warning"
+	}
+	return "", nil
+}
+
+// ruleGroups is the content of a Prometheus rules file
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string  `yaml:"name"`
+	Rules []*rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// GenerateRules returns the Prometheus rules file of the alerts of the enabled queries, a group per query.
+// The rate of counters with alerts is recorded as <metric>:rate5m, their alerts compare the recorded rate
+func GenerateRules(queries map[string]*QueryInstance) ([]byte, error) {
+	rules := ruleGroups{Groups: []ruleGroup{}}
+	for _, key := range sortedQueryKeys(queries) {
+		q := queries[key]
+		if q.Status == statusDisable {
+			continue
+		}
+		group := ruleGroup{Name: q.Name}
+		for _, col := range q.Metrics {
+			if len(col.Alerts) == 0 {
+				continue
+			}
+			metric := q.columnMetricName(col)
+			series := metric
+			if col.Usage == COUNTER {
+				series = fmt.Sprintf("%s:rate%s", metric, rateRange)
+				group.Rules = append(group.Rules, &rule{Record: series, Expr: fmt.Sprintf("rate(%s[%s])", metric, rateRange)})
+			}
+			for _, alert := range col.Alerts {
+				group.Rules = append(group.Rules, alertRule(alert, col, series))
+			}
+		}
+		if len(group.Rules) > 0 {
+			rules.Groups = append(rules.Groups, group)
+		}
+	}
+	return yaml.Marshal(&rules)
+}
+
+// alertRule returns the alerting rule of alert on series
+func alertRule(alert *Alert, col *Column, series string) *rule {
+	name := alert.Name
+	if name == "" {
+		name = camelCase(series)
+	}
+	condition := fmt.Sprintf("%s %s %s", series, alert.Op, strconv.FormatFloat(alert.Threshold, 'g', -1, 64))
+	summary := alert.Summary
+	if summary == "" {
+		summary = condition + " on {{ $labels.instance }}"
+	}
+	annotations := map[string]string{"summary": summary}
+	if help := col.help(); help != "" {
+		annotations["description"] = help
+	}
+	return &rule{
+		Alert:       name,
+		Expr:        condition,
+		For:         alert.For,
+		Labels:      map[string]string{"severity": alert.Severity},
+		Annotations: annotations,
+	}
+}
+
+// camelCase returns the metric name in camel case, e.g. pg_lock_count:rate5m to PgLockCountRate5m
+func camelCase(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == ':' }) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenerateRules(t *testing.T) {
+	queries, err := ParseConfig([]byte(`pg_lock:
+  query:
+  - sql: SELECT datname, count, deadlocks FROM pg_locks
+  metrics:
+  - name: datname
+    usage: LABEL
+  - name: count
+    usage: GAUGE
+    description: Number of locks
+    alerts:
+    - threshold: 100
+      for: 5m
+    - name: LocksCritical
+      threshold: 1e3
+      severity: critical
+      summary: Way too many locks
+  - name: deadlocks
+    usage: COUNTER
+    alerts:
+    - op: '>='
+      threshold: 0.5
+pg_none:
+  query:
+  - sql: SELECT 1 AS one
+  metrics:
+  - name: one
+    usage: GAUGE
+`), "rules.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	buf, err := GenerateRules(queries)
+	assert.NoError(t, err)
+	assert.Equal(t, `groups:
+- name: pg_lock
+  rules:
+  - alert: PgLockCount
+    expr: pg_lock_count > 100
+    for: 5m
+    labels:
+      severity: warning
+    annotations:
+      description: Number of locks
+      summary: pg_lock_count > 100 on {{ $labels.instance }}
+  - alert: LocksCritical
+    expr: pg_lock_count > 1000
+    labels:
+      severity: critical
+    annotations:
+      description: Number of locks
+      summary: Way too many locks
+  - record: pg_lock_deadlocks:rate5m
+    expr: rate(pg_lock_deadlocks[5m])
+  - alert: PgLockDeadlocksRate5m
+    expr: pg_lock_deadlocks:rate5m >= 0.5
+    labels:
+      severity: warning
+    annotations:
+      summary: pg_lock_deadlocks:rate5m >= 0.5 on {{ $labels.instance }}
+`, string(buf))
+
+	buf, err = GenerateRules(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "groups: []\n", string(buf))
+}
+
+func TestAlert_Check(t *testing.T) {
+	_, err := ParseConfig([]byte(`pg_lock:
+  query:
+  - sql: SELECT datname, count FROM pg_locks
+  metrics:
+  - name: datname
+    usage: LABEL
+    alerts:
+    - threshold: 1
+  - name: count
+    usage: GAUGE
+    alerts:
+    - op: '=~'
+      threshold: 1
+    - for: soon
+      threshold: 1
+`), "rules.yaml")
+	assert.EqualError(t, err, "rules.yaml:7: query pg_lock: column datname: alerts: only metric columns can have alerts; "+
+		"rules.yaml:12: query pg_lock: column count: alerts[0].op: unsupported op: =~; "+
+		`rules.yaml:14: query pg_lock: column count: alerts[1].for: not a valid duration string: "soon"`)
+}
@@ -0,0 +1,93 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTargetExists is returned by AddTarget for a dsn already scraped
+	ErrTargetExists = errors.New("target already exists")
+	// ErrTargetNotFound is returned by RemoveTarget for a dsn not scraped
+	ErrTargetNotFound = errors.New("target not found")
+)
+
+// WithTargets add targets scraped in addition to the dsn and the targets of the config,
+// e.g. the runtime targets of the exporter replaced by a reload
+func WithTargets(targets []*Target) Opt {
+	return func(e *Exporter) {
+		e.runtimeTargets = append(e.runtimeTargets, targets...)
+	}
+}
+
+// dsnList returns the dsn scraped, the slice must not be modified
+func (e *Exporter) dsnList() []string {
+	e.targetsMtx.RLock()
+	defer e.targetsMtx.RUnlock()
+	return e.dsn
+}
+
+// RuntimeTargets returns the targets added by AddTarget or WithTargets and not removed
+func (e *Exporter) RuntimeTargets() []*Target {
+	e.targetsMtx.RLock()
+	defer e.targetsMtx.RUnlock()
+	return append([]*Target{}, e.runtimeTargets...)
+}
+
+// AddTarget connect to the target and scrape it from the next scrape on, until removed or the exporter is replaced.
+// The target is not added if the connection fails
+func (e *Exporter) AddTarget(target *Target) error {
+	if field, err := target.Check(); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if Contains(e.dsnList(), target.DSN) {
+		return ErrTargetExists
+	}
+	// the targets are not locked while connecting, so scrapes go on
+	e.servers.setTargetOpts(target.DSN, target.serverOpts()...)
+	if _, err := e.servers.GetServer(target.DSN); err != nil {
+		e.servers.remove(target.DSN)
+		return &ErrorConnectToServer{fmt.Sprintf("Error opening connection to database (%s): %s", ShadowDSN(target.DSN), RedactText(err.Error()))}
+	}
+	e.targetsMtx.Lock()
+	defer e.targetsMtx.Unlock()
+	if Contains(e.dsn, target.DSN) {
+		return ErrTargetExists
+	}
+	// a new slice, the previous one may be in use by a scrape
+	e.dsn = append(e.dsn[:len(e.dsn):len(e.dsn)], target.DSN)
+	e.targets = append(e.targets, target)
+	e.runtimeTargets = append(e.runtimeTargets, target)
+	e.log().Infof("Target %s added", ShadowDSN(target.DSN))
+	return nil
+}
+
+// RemoveTarget stop scraping the dsn and close its servers, including the ones of its discovered databases.
+// The dsn given by options, the config or the target file are back on reload
+func (e *Exporter) RemoveTarget(dsn string) error {
+	e.targetsMtx.Lock()
+	defer e.targetsMtx.Unlock()
+	if !Contains(e.dsn, dsn) {
+		return ErrTargetNotFound
+	}
+	e.dsn = removeDSN(e.dsn, dsn)
+	e.targets = removeTarget(e.targets, dsn)
+	e.runtimeTargets = removeTarget(e.runtimeTargets, dsn)
+	e.fileTargets = removeTarget(e.fileTargets, dsn)
+	e.servers.remove(dsn)
+	e.log().Infof("Target %s removed", ShadowDSN(dsn))
+	return nil
+}
+
+// removeTarget returns a copy of targets without the target of dsn
+func removeTarget(targets []*Target, dsn string) []*Target {
+	result := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if target.DSN != dsn {
+			result = append(result, target)
+		}
+	}
+	return result
+}
@@ -0,0 +1,241 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+)
+
+// scope of a query
+const (
+	scopeInstance = ""         // run on every scraped dsn (default)
+	scopeDatabase = "database" // run in every database of the server, with a datname label
+	scopeCluster  = "cluster"  // run only once per instance, on the master dsn
+)
+
+// datnameLabel is the label attached to samples of database scoped queries
+const datnameLabel = "datname"
+
+// QueryScope valid scope values
+var QueryScope = map[string]bool{
+	scopeInstance: true,
+	scopeDatabase: true,
+	scopeCluster:  true,
+}
+
+// ServerWithExcludeDatabases set databases skipped by database scoped queries
+func ServerWithExcludeDatabases(databases []string) ServerOpt {
+	return func(s *Server) {
+		s.excludedDatabases = databases
+	}
+}
+
+// scopeSkipped returns whether the query is not run on the server because of its scope.
+// With auto-discovery, database and cluster scoped queries, as well as queries pinned to a database,
+// run from the master dsn only, the dsn of every discovered database would otherwise report the same samples again
+func (s *Server) scopeSkipped(queryInstance *QueryInstance) bool {
+	if queryInstance.Database != "" {
+		return !s.master
+	}
+	switch queryInstance.Scope {
+	case scopeDatabase, scopeCluster:
+		return !s.master
+	}
+	return false
+}
+
+// hasDatabaseLists returns whether the query includes or excludes databases itself
+func (q *QueryInstance) hasDatabaseLists() bool {
+	return len(q.IncludedDatabases) > 0 || q.ExcludedDatabases != nil
+}
+
+// databaseAllowed returns whether the query runs in database. The databases included or excluded by the query
+// replace excluded, the databases excluded by the exporter
+func (q *QueryInstance) databaseAllowed(database string, excluded []string) bool {
+	switch {
+	case len(q.IncludedDatabases) > 0:
+		return Contains(q.IncludedDatabases, database)
+	case q.ExcludedDatabases != nil:
+		return !Contains(q.ExcludedDatabases, database)
+	}
+	return !Contains(excluded, database)
+}
+
+// databaseSkipped returns whether the instance scoped query is not run on the server,
+// the database of its dsn not being included or being excluded by the query
+func (s *Server) databaseSkipped(queryInstance *QueryInstance) bool {
+	if queryInstance.Scope != scopeInstance || !queryInstance.hasDatabaseLists() {
+		return false
+	}
+	database, ok := s.dsnDatabase()
+	if !ok {
+		return false
+	}
+	return !queryInstance.databaseAllowed(database, nil)
+}
+
+// dsnDatabase returns the database the dsn of the server connects to, false if the dsn is invalid
+func (s *Server) dsnDatabase() (string, bool) {
+	settings, err := parseDsn(s.dsn)
+	if err != nil {
+		return "", false
+	}
+	if database := settings["database"]; database != "" {
+		return database, true
+	}
+	// the database defaults to the user name
+	return settings["user"], true
+}
+
+// queryDatabase returns the database query runs in, datname for a database scoped query, empty if not sql
+func (s *Server) queryDatabase(query *Query, queryInstance *QueryInstance, datname string) string {
+	switch {
+	case datname != "":
+		return datname
+	case !query.isSQL():
+		return ""
+	case queryInstance.Database != "":
+		return queryInstance.Database
+	}
+	database, _ := s.dsnDatabase()
+	return database
+}
+
+// databaseDSN returns the dsn connecting to database of the same server
+func databaseDSN(dsn, database string) (string, error) {
+	settings, err := parseDsn(dsn)
+	if err != nil {
+		return "", err
+	}
+	delete(settings, "dbname")
+	settings["database"] = database
+	return genDSNString(settings), nil
+}
+
+// databaseDB returns the connection pool of database on the server, opened on first use
+func (s *Server) databaseDB(database string) (*sql.DB, error) {
+	s.databasesMtx.Lock()
+	defer s.databasesMtx.Unlock()
+	if s.databasesSeen == nil {
+		s.databasesSeen = make(map[string]int64)
+	}
+	s.databasesSeen[database] = s.currentScrape()
+	if db, ok := s.databases[database]; ok {
+		return db, nil
+	}
+	if s.singleConnection {
+		return nil, errSingleConnection
+	}
+	dsn, err := databaseDSN(s.dsn, database)
+	if err != nil {
+		return nil, err
+	}
+	db, err := s.openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	if s.databases == nil {
+		s.databases = make(map[string]*sql.DB)
+	}
+	s.databases[database] = db
+	s.log().Infof("Established new database connection to %q database %s.", s, database)
+	return db, nil
+}
+
+// pinnedDB returns the connection to the database a query is pinned to,
+// the connection of the server is used if the dsn already connects to it
+func (s *Server) pinnedDB(database string) (*sql.DB, error) {
+	settings, err := parseDsn(s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if settings["database"] == database {
+		return s.db, nil
+	}
+	return s.databaseDB(database)
+}
+
+// closeDatabases close connections of other databases
+func (s *Server) closeDatabases() {
+	s.databasesMtx.Lock()
+	defer s.databasesMtx.Unlock()
+	for name, db := range s.databases {
+		_ = db.Close()
+		delete(s.databases, name)
+		delete(s.databasesSeen, name)
+	}
+}
+
+// scopeDatabases returns the connectable databases of the server the query runs in,
+// the current database uses the connection of the server
+func (s *Server) scopeDatabases(ctx context.Context, queryInstance *QueryInstance) (names []string, dbs map[string]*sql.DB, err error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT datname, datname = current_database() FROM pg_database
+	WHERE datallowconn = true AND datistemplate = false`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error retrieving databases: %w", err)
+	}
+	defer rows.Close() // nolint: errcheck
+	dbs = make(map[string]*sql.DB)
+	for rows.Next() {
+		var (
+			name    string
+			current bool
+		)
+		if err = rows.Scan(&name, &current); err != nil {
+			return nil, nil, fmt.Errorf("Error retrieving databases: %w", err)
+		}
+		if !queryInstance.databaseAllowed(name, s.excludedDatabases) {
+			continue
+		}
+		db := s.db
+		if !current && s.singleConnection {
+			// the other databases need sessions of their own
+			continue
+		}
+		if !current {
+			if db, err = s.databaseDB(name); err != nil {
+				s.log().Errorf("Error opening connection to database %s on %s: %s", name, s, RedactText(err.Error()))
+				continue
+			}
+		}
+		names = append(names, name)
+		dbs[name] = db
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+	return names, dbs, nil
+}
+
+// queryDatabases run a database scoped query in every database, one after another, each within the timeout of the
+// query, as the listing of the databases
+func (s *Server) queryDatabases(ctx context.Context, query *Query, metricName string, queryInstance *QueryInstance) ([]prometheus.Metric, []error, error) {
+	listCtx, cancel := s.queryTimeout(ctx, query)
+	names, dbs, err := s.scopeDatabases(listCtx, queryInstance)
+	cancel()
+	if err != nil {
+		return []prometheus.Metric{}, []error{}, err
+	}
+	metrics := make([]prometheus.Metric, 0)
+	nonfatalErrors := []error{}
+	for _, name := range names {
+		dbCtx, cancel := s.queryTimeout(ctx, query)
+		m, errs, err := s.queryRows(dbCtx, dbs[name], query, metricName, queryInstance, name)
+		cancel()
+		if err != nil {
+			// one broken database does not fail the others
+			nonfatalErrors = append(nonfatalErrors, fmt.Errorf("database %s: %w", name, err))
+			continue
+		}
+		metrics = append(metrics, m...)
+		nonfatalErrors = append(nonfatalErrors, errs...)
+	}
+	return metrics, nonfatalErrors, nil
+}
@@ -0,0 +1,86 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the headers of the age files, binary and armored
+const (
+	ageHeader      = "age-encryption.org/"
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// decryptTimeout is the time the decrypt command is given to decrypt a file, e.g. to reach a KMS
+const decryptTimeout = 30 * time.Second
+
+var (
+	decryptMtx     sync.Mutex
+	decryptCommand []string
+)
+
+// SetDecryptCommand set the command decrypting the encrypted files read by the exporter: the url, config and target
+// files. The command reads the encrypted file on stdin and writes the plaintext to stdout, e.g. `age -d -i key.txt`
+// or a wrapper of a KMS, the plaintext is kept in memory only. Empty to read plain files only
+func SetDecryptCommand(command []string) {
+	decryptMtx.Lock()
+	defer decryptMtx.Unlock()
+	decryptCommand = command
+}
+
+// IsEncryptedSecret returns whether data is encrypted: an age file, armored or not, or binary data such as the
+// ciphertext blob of a KMS
+func IsEncryptedSecret(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte(ageHeader)) || bytes.HasPrefix(trimmed, []byte(ageArmorHeader)) ||
+		!utf8.Valid(data)
+}
+
+// DecryptSecret decrypt data with the decrypt command if it is encrypted, plaintext data is returned as is
+func DecryptSecret(data []byte) ([]byte, error) {
+	if !IsEncryptedSecret(data) {
+		return data, nil
+	}
+	decryptMtx.Lock()
+	command := decryptCommand
+	decryptMtx.Unlock()
+	if len(command) == 0 {
+		return nil, fmt.Errorf("encrypted but no decrypt command is given")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	plaintext, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("decrypt with %s: %w", command[0], ctx.Err())
+		}
+		return nil, fmt.Errorf("decrypt with %s: %s %s", command[0], err, RedactText(strings.TrimSpace(stderr.String())))
+	}
+	return plaintext, nil
+}
+
+// ReadSecretFile read a file, decrypt it in memory if encrypted
+func ReadSecretFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := DecryptSecret(data)
+	if err != nil {
+		return nil, fmt.Errorf("read secret file %s: %s", path, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,56 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServerWithSeparateSelfMetrics do not emit the query statistics and cache metrics of the server with its samples,
+// they are collected by the self collector of the exporter
+func ServerWithSeparateSelfMetrics(b bool) ServerOpt {
+	return func(s *Server) {
+		s.separateSelfMetrics = b
+	}
+}
+
+// collectSelf emit the exporter's own metrics of the server
+func (s *Server) collectSelf(ch chan<- prometheus.Metric) {
+	s.stats.collect(ch, s.namespace, s.labels)
+	s.collectCache(ch)
+	s.collectConnections(ch)
+}
+
+// SelfCollector returns the collector of the exporter's own metrics: scrape duration, scrapes, errors,
+// config file errors, connection errors, loaded queries and query statistics. Collecting it doesn't query the databases.
+// Use with WithSeparateSelfMetrics, so they are not emitted with the database samples too
+func (e *Exporter) SelfCollector() prometheus.Collector {
+	return selfCollector{e: e}
+}
+
+type selfCollector struct {
+	e *Exporter
+}
+
+func (c selfCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c selfCollector) Collect(ch chan<- prometheus.Metric) {
+	c.e.collectSelf(ch)
+	for _, server := range c.e.servers.List() {
+		server.collectSelf(ch)
+	}
+}
+
+// collectSelf emit the exporter level own metrics
+func (e *Exporter) collectSelf(ch chan<- prometheus.Metric) {
+	ch <- e.duration
+	ch <- e.totalScrapes
+	ch <- e.error
+	e.configFileError.Collect(ch)
+	e.connectErrors.Collect(ch)
+	e.collectTargetErrors(ch)
+	e.connBudget.collect(ch, e.namespace, e.constantLabels)
+	e.collectInventory(ch)
+}
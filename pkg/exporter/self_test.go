@@ -0,0 +1,76 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// collectNames returns the fully-qualified names of the metrics collected by c
+func collectNames(c prometheus.Collector) []string {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	var names []string
+	for metric := range ch {
+		desc := metric.Desc().String()
+		name := desc[strings.Index(desc, `fqName: "`)+len(`fqName: "`):]
+		names = append(names, name[:strings.Index(name, `"`)])
+	}
+	return names
+}
+
+func TestExporter_SelfCollector(t *testing.T) {
+	// a connect errors sample by reason
+	var connectErrors []string
+	for range connectErrorReasons {
+		connectErrors = append(connectErrors, "og_exporter_connect_errors_total")
+	}
+	e, err := NewExporter(WithNamespace("og"))
+	assert.NoError(t, err)
+	// a loaded queries sample by file and status, and the reload timestamp
+	var inventoryNames []string
+	for range inventory(e.metricMap) {
+		inventoryNames = append(inventoryNames, "og_exporter_config_queries")
+	}
+	inventoryNames = append(inventoryNames, "og_exporter_config_last_reload_success_timestamp_seconds")
+	selfNames := append(append([]string{"og_exporter_last_scrape_duration_seconds", "og_exporter_scrapes_total",
+		"og_exporter_last_scrape_error"}, connectErrors...), inventoryNames...)
+	assert.Equal(t, append(selfNames, "og_up"), collectNames(e))
+
+	e, err = NewExporter(WithNamespace("og"), WithSeparateSelfMetrics(true))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"og_up"}, collectNames(e))
+	assert.Equal(t, selfNames, collectNames(e.SelfCollector()))
+}
+
+func TestServer_collectConnections(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{namespace: "og", db: db, labels: prometheus.Labels{serverLabelName: "localhost:5432"}}
+	ch := make(chan prometheus.Metric, 10)
+	s.collectConnections(ch)
+	close(ch)
+	values := make(map[string]float64)
+	for metric := range ch {
+		m := &dto.Metric{}
+		assert.NoError(t, metric.Write(m))
+		values[metric.Desc().String()] = m.GetGauge().GetValue()
+	}
+	assert.Len(t, values, 2)
+}
+
+func TestNewProcessCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, registry.Register(NewProcessCollector()))
+	_, err := registry.Gather()
+	assert.NoError(t, err)
+}
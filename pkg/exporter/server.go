@@ -10,8 +10,10 @@ import (
 	"github.com/blang/semver"
 	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	commonlog "github.com/prometheus/common/log"
 	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,7 +27,11 @@ var (
 
 type cachedMetrics struct {
 	metrics        []prometheus.Metric
+	seen           []int64 // number of the last scrape whose execution returned each metric
 	lastScrape     time.Time
+	scrape         int64 // number of the last scrape that refreshed or emitted it
+	refreshed      int64 // number of the last scrape that refreshed it
+	bytes          int   // estimated size of metrics
 	nonFatalErrors []error
 }
 
@@ -41,6 +47,20 @@ func ServerWithLabels(labels prometheus.Labels) ServerOpt {
 	}
 }
 
+// ServerWithTags set the tags of the server, a query with tags only runs on servers holding all of them
+func ServerWithTags(tags []string) ServerOpt {
+	return func(s *Server) {
+		s.tags = tags
+	}
+}
+
+// ServerWithLogger set the logger of the server, the logger of the package if nil
+func ServerWithLogger(logger commonlog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
 // ServerWithNamespace will specify metric namespace, by default is pg or pgbouncer
 func ServerWithNamespace(namespace string) ServerOpt {
 	return func(s *Server) {
@@ -67,24 +87,132 @@ func ServerWithTimeToString(b bool) ServerOpt {
 	}
 }
 
+// ServerWithParallel set the number of queries executed concurrently on a server
+func ServerWithParallel(n int) ServerOpt {
+	return func(s *Server) {
+		s.parallel = n
+	}
+}
+
+// ServerWithMaxRows limit the number of rows converted for a single query. 0 means no limit
+func ServerWithMaxRows(n int) ServerOpt {
+	return func(s *Server) {
+		s.maxRows = n
+	}
+}
+
+// ServerWithMaxSeries limit the number of distinct label combinations of a single query, the excess is dropped. 0 means no limit
+func ServerWithMaxSeries(n int) ServerOpt {
+	return func(s *Server) {
+		s.maxSeries = n
+	}
+}
+
+// ServerWithHooks set hooks invoked around each scrape and each query
+func ServerWithHooks(hooks *Hooks) ServerOpt {
+	return func(s *Server) {
+		s.hooks = hooks
+	}
+}
+
 type Server struct {
 	dsn                    string
 	db                     *sql.DB
 	labels                 prometheus.Labels
 	master                 bool
-	namespace              string // default prometheus namespace from cmd args
+	namespace              string   // default prometheus namespace from cmd args
+	targetNamespace        string   // namespace prefixing every metric family of the target, from the dsn
+	tenant                 string   // tenant label of every metric family of the target, from the dsn
+	tags                   []string // tags of the server, tagged queries only run on servers with all their tags
+	role                   string   // primary or standby hint of the target, detected if empty
+	ttlMultiplier          float64  // multiplier of the ttl of the queries, 0 keeps them
+	disabledCollectors     []string // queries and collectors not run on the server
 	disableSettingsMetrics bool
 	disableCache           bool
 	timeToString           bool
+	maxRows                int // max rows converted for a single query, 0 means no limit
+	maxSeries              int // max label combinations of a single query, 0 means no limit
+	parallel               int // number of queries executed concurrently
 	// Last version used to calculate metric map. If mismatch on scrape,
 	// then maps are recalculated.
 	lastMapVersion semver.Version
+	// Start time of the database, a change means the database restarted
+	postmasterStartTime time.Time
+	restarts            int  // restarts detected since the exporter started
+	inRecovery          bool // whether the database is a standby
+	// Extensions installed in the database and when they were queried
+	extensions         []string
+	extensionsDetected time.Time
+	// Last version string reported by the server, its short version and flavor
+	lastVersionString string
+	lastShortVersion  string
+	flavor            string
 	// Currently active metric map
 	queryInstanceMap map[string]*QueryInstance
-	mappingMtx       sync.RWMutex
+	// Query sql of queryInstanceMap resolved for lastMapVersion
+	querySQLs map[string]resolvedQuery
+	// guards the query maps and the detected version, role, extensions and restarts read by the scrapes
+	mappingMtx sync.RWMutex
+	// serializes the detections of the server, see Exporter.detectServer
+	detectMtx sync.Mutex
 	// Currently cached metrics
 	metricCache map[string]cachedMetrics
 	cacheMtx    sync.Mutex
+	// Execution statistics of queries
+	stats *queryStats
+	// Cached metric desc
+	descs *descCache
+	// Previous values of DELTA columns
+	deltas *deltaTracker
+	// Marks and counters of watermark queries
+	watermarks *watermarkTracker
+	// Hooks invoked around scrapes and queries
+	hooks *Hooks
+	// Notifier of the queries failing, nil if disabled
+	notifier *Notifier
+	// Logger of the server, nil is the logger of the package
+	logger commonlog.Logger
+	// Go-level collectors run on the server
+	collectors []Collector
+	// Leader election among replicas, nil if disabled
+	leader *leaderElection
+	// Connection parameters set on every connection, unless the dsn sets them
+	sessionParams map[string]string
+	// Statements executed on every new connection
+	sessionSetup []string
+	// Name of the driver opening the connections, DefaultDriver if empty
+	driver string
+	// target_session_attrs of the dsn, hosts are resolved again when the server no longer satisfies them
+	sessionAttrs string
+	// Timeouts of new connections, 0 means no limit
+	connectTimeout   time.Duration
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	// Connections of other databases on the server, used by database scoped queries
+	excludedDatabases []string
+	databases         map[string]*sql.DB
+	databasesSeen     map[string]int64 // scrape of the last use, by database
+	databasesMtx      sync.Mutex
+	// Query statistics are collected by the self collector of the exporter
+	separateSelfMetrics bool
+	// Scrapes of the server, state not refreshed within expireAfter scrapes is dropped
+	scrapes     int64
+	expireAfter int64
+	// Fixtures answering the queries instead of the database, see LoadMockFixtures
+	mockResults MockResults
+	// Recorder of the result sets of the queries, see Recorder
+	recorder *Recorder
+	// Statements exceeding their deadline are canceled with pg_cancel_backend from controlDB, opened on first use
+	cancelOnTimeout bool
+	controlDB       *sql.DB
+	controlMtx      sync.Mutex
+	// At most one session is held on the server, the queries are executed one at a time
+	singleConnection bool
+	// Budget of the connections of all servers, nil means no limit
+	connBudget *connBudget
+	// The ttl of the queries follows the interval between the scrapes of each client
+	adaptiveTTL     bool
+	scrapeIntervals scrapeIntervals
 }
 
 // Close disconnects from OpenGauss.
@@ -92,6 +220,9 @@ func (s *Server) Close() error {
 	if s.db == nil {
 		return nil
 	}
+	s.leader.close()
+	s.closeDatabases()
+	s.closeControlConn()
 	return s.db.Close()
 }
 
@@ -99,7 +230,7 @@ func (s *Server) Close() error {
 func (s *Server) Ping() error {
 	if err := s.db.Ping(); err != nil {
 		if closeErr := s.Close(); closeErr != nil {
-			log.Errorf("Error while closing non-pinging DB connection to %q: %v", s, closeErr)
+			s.log().Errorf("Error while closing non-pinging DB connection to %q: %v", s, closeErr)
 		}
 		return err
 	}
@@ -113,141 +244,512 @@ func (s *Server) String() string {
 
 // Scrape loads metrics.
 func (s *Server) Scrape(ch chan<- prometheus.Metric) error {
+	return s.ScrapeContext(context.Background(), ch)
+}
+
+// ScrapeContext loads metrics. If ctx has a deadline, it is divided among the pending queries.
+func (s *Server) ScrapeContext(ctx context.Context, ch chan<- prometheus.Metric) (err error) {
 	s.mappingMtx.RLock()
 	defer s.mappingMtx.RUnlock()
 
-	var err error
+	s.hooks.beforeServerScrape(ctx, s.String())
+	defer func(begin time.Time) {
+		s.hooks.afterServerScrape(ctx, ServerScrapeInfo{
+			Server:   s.String(),
+			Start:    begin,
+			Duration: time.Since(begin),
+			Err:      err,
+		})
+	}(time.Now())
+
+	ctx = s.withScrapeInterval(ctx)
+	// a partial scrape only runs some queries
+	if querySelector(ctx) == nil {
+		defer s.expireState(s.beginScrape())
 
-	if !s.disableSettingsMetrics && s.master {
-		if err = s.querySettings(ch); err != nil {
-			err = fmt.Errorf("error retrieving settings: %s", err)
+		if failed := s.runCollectors(ctx, ch); len(failed) > 0 {
+			err = fmt.Errorf("collectors %s failed", strings.Join(failed, ","))
 		}
 	}
 
-	errMap := s.queryMetrics(ch)
+	errMap := s.queryMetrics(ctx, ch)
 	if len(errMap) > 0 {
 		err = fmt.Errorf("queryMetrics returned %d errors", len(errMap))
 	}
+	if !s.separateSelfMetrics {
+		s.collectSelf(ch)
+	}
 
 	return err
 }
 
 // 查询监控指标. 先判断是否读取缓存. 禁用缓存或者缓存超时,则读取数据库
-func (s *Server) queryMetrics(ch chan<- prometheus.Metric) map[string]error {
+// Queries are started in priority order, at most s.parallel queries run at the same time.
+func (s *Server) queryMetrics(ctx context.Context, ch chan<- prometheus.Metric) map[string]error {
 	metricErrors := make(map[string]error)
+	var errMtx sync.Mutex
 
 	// Start time of collecting metric  采集指标开始时间
 	scrapeStart := time.Now()
-	for metric, queryInstance := range s.queryInstanceMap {
-		log.Debugf("Querying metric : %s", metric)
 
-		querySQL := queryInstance.GetQuerySQL(s.lastMapVersion)
-		if querySQL == nil {
-			log.Errorf("Querying Metric:%s not define querySQL for version %s", metric, s.lastMapVersion.String())
-			continue
+	parallel := s.parallel
+	if parallel < 1 || s.singleConnection {
+		parallel = 1
+	}
+	names := sortQueryInstances(s.queryInstanceMap)
+	if sel := querySelector(ctx); sel != nil {
+		selected := names[:0]
+		for _, metric := range names {
+			if sel(s.queryInstanceMap[metric]) {
+				selected = append(selected, metric)
+			}
 		}
-		if strings.EqualFold(querySQL.Status, statusDisable) {
-			log.Debugf("Querying metric: %s disable. skip", metric)
-			continue
+		names = selected
+	}
+	// number of queries that will be executed on database, the scrape budget is divided among them
+	var pending int
+	for _, metric := range names {
+		if s.isPending(ctx, metric, s.queryInstanceMap[metric], scrapeStart) {
+			pending++
 		}
-		var (
-			scrapeMetric   = false // Whether to collect indicators from the database 是否从数据库里采集指标
-			cachedMetric   cachedMetrics
-			metrics        []prometheus.Metric
-			nonFatalErrors []error
-			err            error
-		)
-		// Determine whether to enable caching and cache expiration 判断是否启用缓存和缓存过期
-		if !s.disableCache {
-			var found bool
-			// Check if the metric is cached
-			s.cacheMtx.Lock()
-			cachedMetric, found = s.metricCache[metric]
-			s.cacheMtx.Unlock()
-			// If found, check if needs refresh from cache
-			if found {
-				if scrapeStart.Sub(cachedMetric.lastScrape).Seconds() > queryInstance.TTL {
-					scrapeMetric = true
+	}
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, metric := range names {
+		queryInstance := s.queryInstanceMap[metric]
+		sem <- struct{}{}
+		queryCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.isPending(ctx, metric, queryInstance, scrapeStart) {
+			// the scrape was abandoned, e.g. its client disconnected
+			if ctx.Err() == context.Canceled {
+				s.log().Debugf("Querying metric: %s skipped, scrape canceled", metric)
+				s.stats.observeSkip(metric, skipReasonCanceled)
+				<-sem
+				continue
+			}
+			budget, ok := queryBudget(ctx, pending, parallel)
+			pending--
+			if !ok {
+				s.log().Warnf("Querying metric: %s skipped, scrape budget exhausted", metric)
+				s.stats.observeSkip(metric, skipReasonBudget)
+				<-sem
+				continue
+			}
+			if budget > 0 {
+				queryCtx, cancel = context.WithTimeout(ctx, budget)
+			}
+		}
+		wg.Add(1)
+		go func(metric string, queryInstance *QueryInstance) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer cancel()
+			// a panic converting the rows of a query fails the query only
+			defer func() {
+				if r := recover(); r != nil {
+					errMtx.Lock()
+					metricErrors[metric] = fmt.Errorf("panic querying %s: %s", metric, RedactText(fmt.Sprint(r)))
+					errMtx.Unlock()
 				}
-			} else {
-				scrapeMetric = true
+			}()
+			if err := s.scrapeQueryInstance(queryCtx, ch, metric, queryInstance, scrapeStart); err != nil {
+				errMtx.Lock()
+				metricErrors[metric] = err
+				errMtx.Unlock()
 			}
+		}(metric, queryInstance)
+	}
+	wg.Wait()
+
+	return metricErrors
+}
+
+// transientRetryBackoff is the wait before retrying a query failed with transient error
+const transientRetryBackoff = 100 * time.Millisecond
+
+// sleepContext wait for d, returns false if ctx is done before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// minQueryBudget is the minimal time left to start a query within the scrape budget
+const minQueryBudget = 10 * time.Millisecond
+
+// queryBudget returns the time a pending query may take, proportional to the remaining scrape budget.
+// 0 means no budget. false means the budget is exhausted and the query should be skipped.
+func queryBudget(ctx context.Context, pending, parallel int) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, true
+	}
+	remaining := time.Until(deadline)
+	if remaining < minQueryBudget {
+		return 0, false
+	}
+	if pending <= parallel {
+		return remaining, true
+	}
+	return remaining * time.Duration(parallel) / time.Duration(pending), true
+}
+
+// lookupCache returns cached metrics and whether they are still fresh
+func (s *Server) lookupCache(ctx context.Context, metric string, queryInstance *QueryInstance, scrapeStart time.Time) (cachedMetrics, bool) {
+	if s.disableCache {
+		return cachedMetrics{}, false
+	}
+	// Check if the metric is cached
+	s.cacheMtx.Lock()
+	cachedMetric, found := s.metricCache[metric]
+	s.cacheMtx.Unlock()
+	// If found, check if needs refresh from cache
+	if !found || scrapeStart.Sub(cachedMetric.lastScrape).Seconds() > s.effectiveTTL(ctx, queryInstance) {
+		return cachedMetric, false
+	}
+	return cachedMetric, true
+}
+
+// isPending returns whether the query will be executed on database in this scrape
+func (s *Server) isPending(ctx context.Context, metric string, queryInstance *QueryInstance, scrapeStart time.Time) bool {
+	querySQL := s.getQuerySQL(metric, queryInstance)
+	if querySQL == nil || strings.EqualFold(querySQL.Status, statusDisable) || s.stats.circuitOpen(metric, scrapeStart) || s.scopeSkipped(queryInstance) ||
+		s.databaseSkipped(queryInstance) || s.targetSkipped(metric, queryInstance) || s.extensionSkipped(queryInstance) {
+		return false
+	}
+	_, fresh := s.lookupCache(ctx, metric, queryInstance, scrapeStart)
+	return !fresh
+}
+
+// scrapeQueryInstance collect one metric from cache or database, and emit into the channel
+func (s *Server) scrapeQueryInstance(ctx context.Context, ch chan<- prometheus.Metric, metric string, queryInstance *QueryInstance, scrapeStart time.Time) error {
+	s.log().Debugf("Querying metric : %s", metric)
+
+	querySQL := s.getQuerySQL(metric, queryInstance)
+	if querySQL == nil {
+		s.log().Errorf("Querying Metric:%s not define querySQL for version %s", metric, s.lastMapVersion.String())
+		s.stats.observeSkip(metric, skipReasonVersion)
+		return nil
+	}
+	if strings.EqualFold(querySQL.Status, statusDisable) {
+		s.log().Debugf("Querying metric: %s disable. skip", metric)
+		s.stats.observeSkip(metric, skipReasonDisabled)
+		return nil
+	}
+	if s.stats.circuitOpen(metric, scrapeStart) {
+		s.log().Debugf("Querying metric: %s disable for permission denied or failures. skip", metric)
+		s.stats.observeSkip(metric, skipReasonCircuit)
+		return nil
+	}
+	if s.scopeSkipped(queryInstance) {
+		s.log().Debugf("Querying metric: %s %s scoped, run on master only. skip", metric, queryInstance.Scope)
+		s.stats.observeSkip(metric, skipReasonPredicate)
+		return nil
+	}
+	if s.databaseSkipped(queryInstance) {
+		s.log().Debugf("Querying metric: %s not run in the database of %s. skip", metric, s)
+		s.stats.observeSkip(metric, skipReasonPredicate)
+		return nil
+	}
+	if s.targetSkipped(metric, queryInstance) {
+		s.log().Debugf("Querying metric: %s disabled on %s or not run on a %s. skip", metric, s, s.serverRole())
+		if Contains(s.disabledCollectors, metric) {
+			s.stats.observeSkip(metric, skipReasonDisabled)
 		} else {
-			scrapeMetric = true
+			s.stats.observeSkip(metric, skipReasonRole)
 		}
+		return nil
+	}
+	if s.extensionSkipped(queryInstance) {
+		s.log().Debugf("Querying metric: %s needs extension %s, not installed in the database of %s. skip", metric, queryInstance.Extension, s)
+		s.stats.observeSkip(metric, skipReasonPredicate)
+		return nil
+	}
+	if err := s.hooks.beforeQuery(ctx, s.String(), metric); err != nil {
+		s.log().Debugf("Querying metric: %s skipped by hook: %s", metric, err)
+		s.stats.observeSkip(metric, skipReasonHook)
+		return nil
+	}
+	var (
+		metrics        []prometheus.Metric
+		nonFatalErrors []error
+		err            error
+		metricErr      error
+	)
+	// Determine whether to enable caching and cache expiration 判断是否启用缓存和缓存过期
+	// Whether to collect indicators from the database 是否从数据库里采集指标
+	cachedMetric, fresh := s.lookupCache(ctx, metric, queryInstance, scrapeStart)
+	scrapeMetric := !fresh
+	begin := time.Now()
+	defer func() {
+		s.hooks.afterQuery(ctx, QueryScrapeInfo{
+			Server:   s.String(),
+			Query:    metric,
+			Start:    begin,
+			Duration: time.Since(begin),
+			Samples:  len(metrics),
+			Cached:   !scrapeMetric,
+			Err:      metricErr,
+		})
 		if scrapeMetric {
-			metrics, nonFatalErrors, err = s.queryMetric(metric, queryInstance)
-		} else {
-			metrics, nonFatalErrors = cachedMetric.metrics, cachedMetric.nonFatalErrors
+			s.notifier.observeQuery(s.String(), metric, metricErr, isPermissionDenied(metricErr))
+			if s.stats.observeResult(metric, metricErr, queryErrorClass(err, nonFatalErrors)) {
+				s.log().Warnf("collect metric %s failed %d times in a row, skip it on server %s for %s", metric, circuitFailures, s, circuitOpenTime)
+			}
 		}
-
-		// Serious error - a namespace disappeared
-		if err != nil {
-			metricErrors[metric] = err
-			log.Errorf("collect metric %s err %s", metric, err)
-		}
-		// Non-serious errors - likely version or parsing problems.
-		if len(nonFatalErrors) > 0 {
-			var errText string
-			for _, err := range nonFatalErrors {
-				log.Errorf("collect metric nonFatalErrors %s err %s", metric, err)
-				errText += err.Error()
+	}()
+	if scrapeMetric {
+		metrics, nonFatalErrors, err = s.queryMetricContext(ctx, metric, queryInstance)
+		// retry once on transient error inside the same scrape
+		if isTransientError(err) && sleepContext(ctx, transientRetryBackoff) {
+			s.log().Warnf("collect metric %s transient err %s, retrying", metric, err)
+			s.stats.observeRetry(metric)
+			metrics, nonFatalErrors, err = s.queryMetricContext(ctx, metric, queryInstance)
+		}
+		s.stats.observeExecution(metric, begin, err)
+		// stop querying on insufficient privilege, it won't succeed next scrape either
+		if isPermissionDenied(err) {
+			metricErr = err
+			if s.stats.observePermissionDenied(metric) {
+				s.log().Warnf("collect metric %s permission denied, disable it on server %s: %s", metric, s, err)
 			}
-			metricErrors[metric] = errors.New(errText)
+			return nil
 		}
+	} else {
+		metrics, nonFatalErrors = cachedMetric.metrics, cachedMetric.nonFatalErrors
+		s.stats.observeCacheHit(metric)
+		s.touchCache(metric)
+	}
 
-		// Emit the metrics into the channel
-		for _, metric := range metrics {
-			ch <- metric
+	// Serious error - a namespace disappeared
+	if err != nil {
+		metricErr = err
+		s.log().Errorf("collect metric %s err %s", metric, err)
+	}
+	// Non-serious errors - likely version or parsing problems.
+	if len(nonFatalErrors) > 0 {
+		var errText string
+		for _, err := range nonFatalErrors {
+			s.log().Errorf("collect metric nonFatalErrors %s err %s", metric, err)
+			errText += err.Error()
 		}
+		metricErr = errors.New(errText)
+	}
 
-		if scrapeMetric {
-			// Only cache if metric is meaningfully cacheable
-			if queryInstance.TTL > 0 {
-				s.cacheMtx.Lock()
-				s.metricCache[metric] = cachedMetrics{
-					metrics:        metrics,
-					lastScrape:     scrapeStart,
-					nonFatalErrors: nonFatalErrors,
-				}
-				s.cacheMtx.Unlock()
+	// Only cache if metric is meaningfully cacheable
+	if scrapeMetric && queryInstance.TTL > 0 {
+		metrics = s.cacheMetrics(metric, metrics, nonFatalErrors, metricErr != nil, scrapeStart)
+	}
+
+	// Emit the metrics into the channel
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	return metricErr
+}
+
+// cacheMetrics cache the metrics of an execution of the query, returns the metrics to emit. When the execution failed,
+// e.g. in one of the databases of the query, the series of the previous execution it did not return are kept until
+// not returned within expireAfter scrapes
+func (s *Server) cacheMetrics(metric string, metrics []prometheus.Metric, nonFatalErrors []error, failed bool, scrapeStart time.Time) []prometheus.Metric {
+	scrape := s.currentScrape()
+	seen := make([]int64, len(metrics))
+	for i := range seen {
+		seen[i] = scrape
+	}
+	s.cacheMtx.Lock()
+	defer s.cacheMtx.Unlock()
+	if previous, ok := s.metricCache[metric]; ok && failed && s.expireAfter > 0 {
+		returned := make(map[string]bool, len(metrics))
+		for _, m := range metrics {
+			returned[seriesKey(m)] = true
+		}
+		for i, m := range previous.metrics {
+			if last := previous.seenAt(i); !returned[seriesKey(m)] && !s.expired(last, scrape) {
+				metrics = append(metrics, m)
+				seen = append(seen, last)
 			}
 		}
 	}
+	s.metricCache[metric] = cachedMetrics{
+		metrics:        metrics,
+		seen:           seen,
+		lastScrape:     scrapeStart,
+		scrape:         scrape,
+		refreshed:      scrape,
+		bytes:          metricsBytes(metrics),
+		nonFatalErrors: nonFatalErrors,
+	}
+	return metrics
+}
 
-	return metricErrors
+// checkRestart detect database restart by the postmaster start time, the cached state is reset on restart
+func (s *Server) checkRestart(startTime time.Time) bool {
+	if s.postmasterStartTime.IsZero() || startTime.Equal(s.postmasterStartTime) {
+		s.postmasterStartTime = startTime
+		return false
+	}
+	s.postmasterStartTime = startTime
+	s.mappingMtx.Lock()
+	s.restarts++
+	s.mappingMtx.Unlock()
+	s.resetState()
+	s.extensionsDetected = time.Time{}
+	return true
+}
+
+// resetState drop the version, resolved query sql, desc and metric cache of the server
+func (s *Server) resetState() {
+	s.mappingMtx.Lock()
+	s.queryInstanceMap = nil
+	s.querySQLs = nil
+	s.lastVersionString = ""
+	s.mappingMtx.Unlock()
+	s.descs.reset()
+	s.cacheMtx.Lock()
+	s.metricCache = make(map[string]cachedMetrics)
+	s.cacheMtx.Unlock()
+}
+
+// prepareQueries prepare the resolved sql of every enabled query, returns the failed queries
+func (s *Server) prepareQueries() []string {
+	s.mappingMtx.RLock()
+	defer s.mappingMtx.RUnlock()
+	var failures []string
+	for _, metric := range sortQueryInstances(s.queryInstanceMap) {
+		querySQL := s.getQuerySQL(metric, s.queryInstanceMap[metric])
+		if querySQL == nil || strings.EqualFold(querySQL.Status, statusDisable) || !querySQL.isSQL() {
+			continue
+		}
+		stmt, err := s.db.Prepare(querySQL.SQL)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("query %s: %s", metric, err))
+			continue
+		}
+		_ = stmt.Close()
+	}
+	return failures
+}
+
+// resolvedQuery is the query sql of a query instance resolved for the server version
+type resolvedQuery struct {
+	queryInstance *QueryInstance
+	query         *Query
+}
+
+// resolveQuerySQLs resolve query sql of every query instance for lastMapVersion, must hold mappingMtx
+func (s *Server) resolveQuerySQLs() {
+	s.querySQLs = make(map[string]resolvedQuery, len(s.queryInstanceMap))
+	for name, queryInstance := range s.queryInstanceMap {
+		s.querySQLs[name] = resolvedQuery{
+			queryInstance: queryInstance,
+			query:         queryInstance.getServerQuery(s.lastMapVersion, s.tags),
+		}
+	}
+}
+
+// getQuerySQL returns the resolved query sql, fallback on matching version if not resolved
+func (s *Server) getQuerySQL(name string, queryInstance *QueryInstance) *Query {
+	if resolved, ok := s.querySQLs[name]; ok && resolved.queryInstance == queryInstance {
+		return resolved.query
+	}
+	return queryInstance.getServerQuery(s.lastMapVersion, s.tags)
+}
+
+// sortQueryInstances returns metric names ordered by priority (smaller first), then by name
+func sortQueryInstances(queryInstanceMap map[string]*QueryInstance) []string {
+	names := make([]string, 0, len(queryInstanceMap))
+	for name := range queryInstanceMap {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := queryInstanceMap[names[i]].Priority, queryInstanceMap[names[j]].Priority
+		if pi != pj {
+			return pi < pj
+		}
+		return names[i] < names[j]
+	})
+	return names
 }
 
 // 连接数据查询监控指标
 func (s *Server) queryMetric(metricName string, queryInstance *QueryInstance) ([]prometheus.Metric, []error, error) {
+	return s.queryMetricContext(context.Background(), metricName, queryInstance)
+}
+
+// queryMetricContext query metric within ctx, the query timeout applies on top of ctx
+func (s *Server) queryMetricContext(ctx context.Context, metricName string, queryInstance *QueryInstance) ([]prometheus.Metric, []error, error) {
 	// 根据版本获取查询sql
-	query := queryInstance.GetQuerySQL(s.lastMapVersion)
+	query := s.getQuerySQL(metricName, queryInstance)
 	if query == nil {
 		// Return success (no pertinent data)
 		return []prometheus.Metric{}, []error{}, nil
 	}
 
+	// the timeout applies to each database of database scoped queries
+	if queryInstance.Scope == scopeDatabase {
+		return s.queryDatabases(ctx, query, metricName, queryInstance)
+	}
+	ctx, cancel := s.queryTimeout(ctx, query)
+	defer cancel()
+	db := s.db
+	if queryInstance.Database != "" {
+		var err error
+		if db, err = s.pinnedDB(queryInstance.Database); err != nil {
+			return []prometheus.Metric{}, []error{}, fmt.Errorf("Error opening connection to database %s on %q: %s", queryInstance.Database, s, RedactText(err.Error()))
+		}
+	}
+	return s.queryRows(ctx, db, query, metricName, queryInstance, "")
+}
+
+// queryTimeout returns ctx limited by the timeout of query, if provided
+func (s *Server) queryTimeout(ctx context.Context, query *Query) (context.Context, context.CancelFunc) {
+	if query.Timeout == 0 {
+		return ctx, func() {}
+	}
+	s.log().Debugf("queryMetric [%s] executing begin with time limit: %v", query.Name, query.TimeoutDuration())
+	return context.WithTimeout(ctx, query.TimeoutDuration())
+}
+
+// queryRows execute query on db and convert the rows into metrics, datname is attached to samples of database scoped query
+func (s *Server) queryRows(ctx context.Context, db *sql.DB, query *Query, metricName string, queryInstance *QueryInstance, datname string) ([]prometheus.Metric, []error, error) {
 	// Don't fail on a bad scrape of one metric
-	var rows *sql.Rows
+	var rows rowSource
 	var err error
-	var ctx context.Context
 
-	if query.Timeout != 0 { // if timeout is provided, use context
-		var cancel context.CancelFunc
-		log.Debugf("queryMetric [%s] executing begin with time limit: %v", query.Name, query.TimeoutDuration())
-		ctx, cancel = context.WithTimeout(context.Background(), query.TimeoutDuration())
-		defer cancel()
-
-	} else {
-		ctx = context.Background()
-		defer ctx.Done()
+	// watermark queries only fetch the rows above the mark of the previous execution
+	var watermark *watermarkState
+	if queryInstance.Watermark != nil && query.isSQL() {
+		watermark = s.watermarks.state(deltaQueryKey(metricName, datname)).next(queryInstance.Watermark.Initial)
+	}
+	var args []interface{}
+	if watermark != nil {
+		s.log().Debugf("queryMetric [%s] executing begin, sql %s, watermark %v", queryInstance.Name, query.SQL, watermark.mark)
+		args = append(args, watermark.mark)
+	} else if query.isSQL() {
+		s.log().Debugf("queryMetric [%s] executing begin, sql %s", queryInstance.Name, query.SQL)
+	}
+	switch {
+	case query.isSQL() && s.singleConnection:
+		// the statement waits for the only connection of the server
+		rows, err = s.queryQueued(ctx, db, metricName, query.SQL, args...)
+	case query.isSQL() && s.cancelable(ctx):
+		// the statement is canceled on the server too if it exceeds its deadline
+		rows, err = s.queryCancelable(ctx, db, query.SQL, args...)
+	case query.isSQL():
+		rows, err = db.QueryContext(ctx, query.SQL, args...)
+	default:
+		rows, err = s.sourceRows(ctx, query)
 	}
-	log.Debugf("queryMetric [%s] executing begin, sql %s", queryInstance.Name, query.SQL)
-
-	rows, err = s.db.QueryContext(ctx, query.SQL)
 	if err != nil {
-		log.Errorf("queryMetric [%s] executing err %s", queryInstance.Name, err)
-		return []prometheus.Metric{}, []error{}, fmt.Errorf("Error running queryMetric on database %q query: %s %v ", s, metricName, err)
+		s.log().Errorf("queryMetric [%s] executing err %s", queryInstance.Name, err)
+		return []prometheus.Metric{}, []error{}, fmt.Errorf("Error running queryMetric on database %q query: %s %w ", s, metricName, err)
 	}
 	defer rows.Close() // nolint: errcheck
 
@@ -262,6 +764,11 @@ func (s *Server) queryMetric(metricName string, queryInstance *QueryInstance) ([
 	for i, n := range columnNames {
 		columnIdx[n] = i
 	}
+	if watermark != nil {
+		if _, ok := columnIdx[queryInstance.Watermark.Column]; !ok {
+			return []prometheus.Metric{}, []error{}, fmt.Errorf("query %s does not return the watermark column %s", metricName, queryInstance.Watermark.Column)
+		}
+	}
 
 	var columnData = make([]interface{}, len(columnNames))
 	var scanArgs = make([]interface{}, len(columnNames))
@@ -273,16 +780,211 @@ func (s *Server) queryMetric(metricName string, queryInstance *QueryInstance) ([
 
 	metrics := make([]prometheus.Metric, 0)
 
+	// the result set of sql queries is recorded as a fixture of the query
+	var recorded *MockFixture
+	if s.recorder != nil && query.isSQL() {
+		recorded = &MockFixture{SQL: query.SQL, Columns: columnNames, Rows: [][]interface{}{}}
+	}
+
+	// Rows are converted to metrics as they are scanned, the raw data of each row is not retained.
+	var rowCount, rowBytes int
+	defer func() {
+		s.stats.observeRows(metricName, s.queryDatabase(query, queryInstance, datname), rowCount, rowBytes)
+	}()
+
+	// DELTA and COUNTER columns are compared with the values of the previous execution
+	deltaKey := deltaQueryKey(metricName, datname)
+	previousValues := s.deltas.previous(deltaKey)
+	currentValues := make(map[string]float64)
+	// droppedValues are the values of the dropped labels of the row, telling apart the series of delta columns
+	var droppedValues []string
+	// COUNTER columns lower than on the previous execution were reset
+	var counterReset bool
+
+	// columnMetric convert the value of a metric column into a sample, nil if no sample is emitted
+	columnMetric := func(col *Column, columnName string, data interface{}, labels []string) prometheus.Metric {
+		if data == nil {
+			s.stats.observeNull(metricName, columnName)
+			if col.NullValue == NullSkip {
+				return nil
+			}
+		}
+		var value float64
+		var ok bool
+		switch col.Usage {
+		case TIMESTAMP:
+			value, ok = dbToTimestamp(data)
+		case BOOL:
+			value, ok = dbToBool(data)
+		default:
+			value, ok = dbToFloat64(data)
+		}
+		if data == nil && col.NullValue == NullZero {
+			value = 0
+		}
+		if !ok {
+			// a bad value only drops the sample, the rest of the query is kept
+			s.log().Debugf("Unexpected error parsing column: %s %s %v", metricName, columnName, data)
+			s.stats.observeParseError(metricName, columnName)
+			return nil
+		}
+		// a value out of the sane bounds of the column, e.g. after a view bug, is clamped or dropped
+		if bounded, out := col.bound(value); out {
+			s.stats.observeOutOfRange(metricName, columnName)
+			if col.OutOfRange == OutOfRangeDrop {
+				return nil
+			}
+			value = bounded
+		}
+		if col.Usage == DELTA {
+			seriesKey := deltaSeriesKey(columnName, append(labels[:len(labels):len(labels)], droppedValues...))
+			currentValues[seriesKey] = value
+			previous, ok := previousValues[seriesKey]
+			if !ok {
+				// nothing to compare with on the first scrape of the series
+				return nil
+			}
+			value = delta(previous, value)
+		}
+		if watermark != nil {
+			// counted by the state, emitted once the rows are scanned
+			watermark.add(columnName, labels, value)
+			return nil
+		}
+		if col.Usage == COUNTER && !math.IsNaN(value) {
+			// the sample is kept as is, a decrease is a counter reset to Prometheus
+			seriesKey := deltaSeriesKey(columnName, append(labels[:len(labels):len(labels)], droppedValues...))
+			currentValues[seriesKey] = value
+			if previous, ok := previousValues[seriesKey]; ok && value < previous {
+				counterReset = true
+			}
+		}
+		// Generate the metric
+		desc := s.descs.getOrCreate(descCacheKey(metricName, columnName, queryInstance.LabelNames), func() *prometheus.Desc {
+			return queryInstance.newColumnDesc(col, s.labels)
+		})
+		return prometheus.MustNewConstMetric(desc, col.PrometheusType, value, labels...)
+	}
+
+	// computed columns need the columns they refer to
+	exprColumns := make([]*Column, 0, len(queryInstance.ExprColumns))
+	for _, col := range queryInstance.ExprColumns {
+		missing := false
+		for _, name := range col.expression.columns {
+			if _, ok := columnIdx[name]; !ok {
+				nonfatalErrors = append(nonfatalErrors, fmt.Errorf("query %s: column %s refers to missing column %s", metricName, col.Name, name))
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			exprColumns = append(exprColumns, queryInstance.getColumn(col.Name))
+		}
+	}
+	// extracted columns need the text columns they capture from
+	extractColumns := make([]*Column, 0, len(queryInstance.ExtractColumns))
+	for _, col := range queryInstance.ExtractColumns {
+		if _, ok := columnIdx[col.Extract.From]; !ok {
+			nonfatalErrors = append(nonfatalErrors, fmt.Errorf("query %s: column %s extracts from missing column %s", metricName, col.Name, col.Extract.From))
+			continue
+		}
+		extractColumns = append(extractColumns, queryInstance.getColumn(col.Name))
+	}
+	extractColumn := func(col *Column) (string, bool) {
+		idx, ok := columnIdx[col.Extract.From]
+		if !ok || columnData[idx] == nil {
+			return "", false
+		}
+		text, _ := dbToString(columnData[idx], s.timeToString)
+		return col.Extract.apply(text)
+	}
+	lookupColumn := func(name string) (float64, bool) {
+		data := columnData[columnIdx[name]]
+		if data == nil {
+			return 0, false
+		}
+		return dbToFloat64(data)
+	}
+
+	// label combinations beyond the series limit are dropped, top-N queries are already bounded
+	seriesLimit := s.maxSeries
+	if queryInstance.MaxSeries > 0 {
+		seriesLimit = queryInstance.MaxSeries
+	}
+	var series map[string]bool // label combinations of the rows, false if dropped
+	var seriesAdmitted, seriesDropped int
+	if seriesLimit > 0 && queryInstance.TopN == nil {
+		series = make(map[string]bool)
+	}
+
+	// rows of top-N queries are ranked once all are read
+	var topRows []topnRow
+	topN := queryInstance.TopN
+	if topN != nil {
+		if _, ok := columnIdx[topN.By]; !ok {
+			nonfatalErrors = append(nonfatalErrors, fmt.Errorf("query %s: topn column %s is missing, rows are not ranked", metricName, topN.By))
+		}
+	}
+
 	for rows.Next() {
+		if s.maxRows > 0 && rowCount >= s.maxRows {
+			nonfatalErrors = append(nonfatalErrors, fmt.Errorf("query %s returned more than %d rows, the remaining rows are discarded", metricName, s.maxRows))
+			break
+		}
 		err = rows.Scan(scanArgs...)
 		if err != nil {
 			return []prometheus.Metric{}, []error{}, errors.New(fmt.Sprintln("Error retrieving rows:", metricName, err))
 		}
+		rowCount++
+		rowBytes += columnBytes(columnData)
+		rowStart := len(metrics)
+		if recorded != nil {
+			recorded.Rows = append(recorded.Rows, recordRow(columnData))
+		}
 
 		// Get the label values for this row.
-		labels := make([]string, len(queryInstance.LabelNames))
-		for idx, label := range queryInstance.LabelNames {
-			labels[idx], _ = dbToString(columnData[columnIdx[label]], s.timeToString)
+		rowLabels := func(names []string) []string {
+			values := make([]string, len(names))
+			for idx, label := range names {
+				if queryInstance.DatnameTag && label == datnameLabel {
+					values[idx] = datname
+					continue
+				}
+				col := queryInstance.Columns[label]
+				if col != nil && col.Extract != nil {
+					// no match gives an empty label
+					values[idx], _ = extractColumn(col)
+				} else {
+					values[idx], _ = dbToString(columnData[columnIdx[label]], s.timeToString)
+				}
+				var transform *LabelTransform
+				if col != nil {
+					transform = col.Label
+				}
+				values[idx] = transform.apply(values[idx])
+			}
+			return values
+		}
+		labels := rowLabels(queryInstance.LabelNames)
+		droppedValues = rowLabels(queryInstance.DroppedLabels)
+		if watermark != nil {
+			watermark.advance(columnData[columnIdx[queryInstance.Watermark.Column]])
+		}
+		if series != nil {
+			key := strings.Join(labels, "\xff")
+			admitted, seen := series[key]
+			if !seen {
+				admitted = seriesAdmitted < seriesLimit
+				series[key] = admitted
+				if admitted {
+					seriesAdmitted++
+				} else {
+					seriesDropped++
+				}
+			}
+			if !admitted {
+				continue
+			}
 		}
 
 		// Loop over column names, and match to scan data. Unknown columns
@@ -290,9 +992,10 @@ func (s *Server) queryMetric(metricName string, queryInstance *QueryInstance) ([
 		// converted to float64s. NULLs are allowed and treated as NaN.
 		for idx, columnName := range columnNames {
 			var metric prometheus.Metric
-			col := queryInstance.GetColumn(columnName, s.labels)
+			col := queryInstance.getColumn(columnName)
 			if col != nil {
-				if col.DisCard {
+				// computed columns are not read from the result
+				if col.DisCard || col.expression != nil || col.Extract != nil {
 					continue
 				}
 				/*
@@ -329,41 +1032,136 @@ func (s *Server) queryMetric(metricName string, queryInstance *QueryInstance) ([
 				} else if strings.EqualFold(col.Usage, MappedMETRIC) {
 
 				} else {
-					value, ok := dbToFloat64(columnData[idx])
-					if !ok {
-						nonfatalErrors = append(nonfatalErrors, errors.New(fmt.Sprintln("Unexpected error parsing column: ", metricName, columnName, columnData[idx])))
+					if metric = columnMetric(col, columnName, columnData[idx], labels); metric == nil {
 						continue
 					}
-					// Generate the metric
-					metric = prometheus.MustNewConstMetric(col.PrometheusDesc, col.PrometheusType, value, labels...)
 				}
 
+			} else if watermark != nil {
+				// the rows of watermark queries are only counted by their COUNTER columns
+				continue
 			} else {
 				// Unknown metric. Report as untyped if scan to float64 works, else note an error too.
-				metricLabel := fmt.Sprintf("%s_%s", metricName, columnName)
-				desc := prometheus.NewDesc(metricLabel, fmt.Sprintf("Unknown metric from %s", metricName), queryInstance.LabelNames, s.labels)
+				desc := s.descs.getOrCreate(descCacheKey(metricName, columnName, queryInstance.LabelNames), func() *prometheus.Desc {
+					metricLabel := fmt.Sprintf("%s_%s", metricName, columnName)
+					return prometheus.NewDesc(metricLabel, fmt.Sprintf("Unknown metric from %s", metricName), queryInstance.LabelNames, s.labels)
+				})
 
 				// Its not an error to fail here, since the values are
 				// unexpected anyway.
+				if columnData[idx] == nil {
+					s.stats.observeNull(metricName, columnName)
+				}
 				value, ok := dbToFloat64(columnData[idx])
 				if !ok {
-					nonfatalErrors = append(nonfatalErrors, errors.New(fmt.Sprintln("Unparseable column type - discarding: ", metricName, columnName, err)))
+					s.log().Debugf("Unparseable column type - discarding: %s %s %v", metricName, columnName, columnData[idx])
+					s.stats.observeParseError(metricName, columnName)
 					continue
 				}
 				metric = prometheus.MustNewConstMetric(desc, prometheus.UntypedValue, value, labels...)
 			}
 			metrics = append(metrics, metric)
 		}
+
+		for _, col := range exprColumns {
+			var data interface{}
+			if value, ok := col.expression.eval(lookupColumn); ok {
+				data = value
+			}
+			if metric := columnMetric(col, col.Name, data, labels); metric != nil {
+				metrics = append(metrics, metric)
+			}
+		}
+		for _, col := range extractColumns {
+			if col.Usage == LABEL {
+				continue
+			}
+			// no match is a NULL value
+			var data interface{}
+			if text, ok := extractColumn(col); ok {
+				data = text
+			}
+			if metric := columnMetric(col, col.Name, data, labels); metric != nil {
+				metrics = append(metrics, metric)
+			}
+		}
+		if topN != nil {
+			row := topnRow{by: math.NaN(), metrics: metrics[rowStart:len(metrics):len(metrics)]}
+			if _, ok := columnIdx[topN.By]; ok {
+				if value, ok := lookupColumn(topN.By); ok {
+					row.by = value
+				}
+			}
+			topRows = append(topRows, row)
+		}
 	}
 	if err = rows.Err(); err != nil {
-		log.Debugf("queryMetric [%s] rows error %s", metricName, err)
+		s.log().Debugf("queryMetric [%s] rows error %s", metricName, err)
 		return []prometheus.Metric{}, []error{}, err
 	}
+	if len(currentValues) > 0 || len(previousValues) > 0 {
+		s.deltas.update(deltaKey, currentValues, s.currentScrape())
+	}
+	if counterReset {
+		s.log().Infof("Counters of query %s on %s went backwards, stats were reset", metricName, s)
+		s.stats.observeCounterReset(metricName)
+	}
+	if seriesDropped > 0 && s.stats.observeSeriesDropped(metricName, seriesDropped) {
+		s.log().Warnf("query %s on %s produced more than %d series, the excess is dropped", metricName, s, seriesLimit)
+	}
+	if topN != nil {
+		otherLabels := make([]string, len(queryInstance.LabelNames))
+		for idx, label := range queryInstance.LabelNames {
+			otherLabels[idx] = topnOtherLabel
+			if queryInstance.DatnameTag && label == datnameLabel {
+				otherLabels[idx] = datname
+			}
+		}
+		metrics = topN.apply(topRows, otherLabels)
+	}
+	if watermark != nil {
+		s.watermarks.update(deltaQueryKey(metricName, datname), watermark, s.currentScrape())
+		metrics = append(metrics, watermark.metrics(func(column string) *prometheus.Desc {
+			return s.descs.getOrCreate(descCacheKey(metricName, column, queryInstance.LabelNames), func() *prometheus.Desc {
+				return queryInstance.newColumnDesc(queryInstance.getColumn(column), s.labels)
+			})
+		})...)
+	}
+	if len(queryInstance.DroppedLabels) > 0 {
+		metrics = sumSeries(metrics, queryInstance.LabelNames)
+	}
+	if recorded != nil {
+		name := metricName
+		if datname != "" {
+			name += "@" + datname
+		}
+		if err := s.recorder.record(s, name, recorded); err != nil {
+			s.log().Warnf("Error recording query %s on %s: %s", metricName, s, err)
+		}
+	}
 	return metrics, nonfatalErrors, nil
 }
 
+// sourceRows fetch rows of a non-SQL query
+func (s *Server) sourceRows(ctx context.Context, query *Query) (rowSource, error) {
+	if query.Static != nil {
+		return query.Static.rows(), nil
+	}
+	if query.HTTP != nil {
+		s.log().Debugf("queryMetric [%s] executing begin, http %s", query.Name, query.HTTP.URL)
+		return query.HTTP.rows(ctx)
+	}
+	s.log().Debugf("queryMetric [%s] executing begin, exec %v", query.Name, query.Exec.Command)
+	return query.Exec.rows(ctx)
+}
+
 func (s *Server) QueryDatabases() ([]string, error) {
-	rows, err := s.db.Query(`SELECT datname FROM pg_database
+	return s.queryDatabaseNames(context.Background())
+}
+
+// queryDatabaseNames returns the databases of the server but its own, canceled with ctx
+func (s *Server) queryDatabaseNames(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT datname FROM pg_database
 	WHERE datallowconn = true
 	AND datistemplate = false
 	AND datname != current_database()`) // nolint: safesql
@@ -392,37 +1190,74 @@ func NewServer(dsn string, opts ...ServerOpt) (*Server, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-
-	log.Infof("Established new database connection to %q.", fingerprint)
-
 	s := &Server{
-		db:     db,
 		dsn:    dsn,
 		master: false,
 		labels: prometheus.Labels{
 			serverLabelName: fingerprint,
 		},
 		metricCache: make(map[string]cachedMetrics),
+		stats:       newQueryStats(),
+		descs:       newDescCache(),
+		deltas:      newDeltaTracker(),
+		watermarks:  newWatermarkTracker(),
+		collectors:  getRegisteredCollectors(),
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.leader != nil {
+		s.leader.logger = s.logger
+	}
+
+	if s.targetNamespace, s.tenant, err = parseTarget(dsn); err != nil {
+		return nil, err
+	}
+	if _, s.sessionAttrs, err = hostDSNs(dsn); err != nil {
+		return nil, err
+	}
+	db, err := s.openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+
+	s.log().Infof("Established new database connection to %q.", fingerprint)
+
+	db.SetMaxOpenConns(s.maxConns())
+	db.SetMaxIdleConns(s.maxConns())
 
 	return s, nil
 }
 
+// maxConns returns the size of the connection pool: one connection per concurrent query
+func (s *Server) maxConns() int {
+	if s.singleConnection {
+		return 1
+	}
+	maxConns := s.parallel
+	if maxConns < 1 {
+		maxConns = 1
+	}
+	// the advisory lock holds a connection
+	if s.leader != nil {
+		maxConns++
+	}
+	return maxConns
+}
+
 // Servers contains a collection of servers to OpenGauss.
 type Servers struct {
 	m       sync.Mutex
 	servers map[string]*Server
+	missing map[string]int // consecutive scrapes a dsn was not active
 	opts    []ServerOpt
+	targets map[string][]ServerOpt // options of the servers of a target, applied after opts
+	parents map[string]string      // dsn a derived dsn was derived from, by derived dsn
+	backoff *connectBackoff        // connection failures of the targets, nil if retries are not backed off
+
+	connecting map[string]*sync.Mutex // serializes the connections to a dsn, by dsn
 }
 
 // NewServers creates a collection of servers to OpenGauss.
@@ -430,54 +1265,184 @@ func NewServers(opts ...ServerOpt) *Servers {
 	return &Servers{
 		servers: make(map[string]*Server),
 		opts:    opts,
+		targets: make(map[string][]ServerOpt),
+		parents: make(map[string]string),
+
+		connecting: make(map[string]*sync.Mutex),
 	}
 }
 
-// GetServer returns established connection from a collection.
-func (s *Servers) GetServer(dsn string) (*Server, error) {
+// setTargetOpts set the options of the server of dsn, applied after the options of every server
+func (s *Servers) setTargetOpts(dsn string, opts ...ServerOpt) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.targets[dsn] = opts
+}
+
+// addDerived record derived as a dsn derived from dsn, e.g. of a discovered database.
+// Its server has the options of the target of dsn and is closed with it
+func (s *Servers) addDerived(derived, dsn string) {
+	if derived == dsn {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.parents[derived]; !ok {
+		s.parents[derived] = dsn
+	}
+}
+
+// serverOpts returns the options of the server of dsn, must hold m
+func (s *Servers) serverOpts(dsn string) []ServerOpt {
+	targetOpts, ok := s.targets[dsn]
+	if parent, derived := s.parents[dsn]; !ok && derived {
+		targetOpts = s.targets[parent]
+	}
+	opts := make([]ServerOpt, 0, len(s.opts)+len(targetOpts))
+	return append(append(opts, s.opts...), targetOpts...)
+}
+
+// remove close the server of dsn and the servers derived from it, and forget their options
+func (s *Servers) remove(dsn string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closeTarget(dsn)
+	delete(s.targets, dsn)
+	s.backoff.reset(dsn)
+}
+
+// resetTarget close the server of dsn and the servers derived from it, they are reopened with opts
+func (s *Servers) resetTarget(dsn string, opts ...ServerOpt) {
 	s.m.Lock()
 	defer s.m.Unlock()
+	s.closeTarget(dsn)
+	s.targets[dsn] = opts
+	s.backoff.reset(dsn)
+}
+
+// closeTarget close the server of dsn and the servers derived from it, must hold m
+func (s *Servers) closeTarget(dsn string) {
+	for derived, parent := range s.parents {
+		if parent == dsn {
+			s.closeServer(derived)
+			delete(s.parents, derived)
+		}
+	}
+	s.closeServer(dsn)
+}
+
+// closeServer close and forget the server of dsn if any, must hold m
+func (s *Servers) closeServer(dsn string) {
+	server, ok := s.servers[dsn]
+	if !ok {
+		return
+	}
+	server.log().Infof("Close connection to %q, target removed or changed", server)
+	if err := server.Close(); err != nil {
+		server.log().Errorf("failed to close connection to %q: %v", server, err)
+	}
+	delete(s.servers, dsn)
+	delete(s.missing, dsn)
+}
+
+// GetServer returns established connection from a collection.
+func (s *Servers) GetServer(dsn string) (*Server, error) {
+	// connections to a dsn are serialized without holding the lock of all servers, a hung target does not stall the others
+	connecting := s.connectLock(dsn)
+	connecting.Lock()
+	defer connecting.Unlock()
 	var err error
-	var ok bool
 	errCount := 0 // start at zero because we increment before doing work
 	retries := 3
-	var server *Server
+	var labels prometheus.Labels
+	s.m.Lock()
+	server, ok := s.servers[dsn]
+	if !ok {
+		// a target failing to connect is not retried before its backoff delay
+		err = s.backoff.check(dsn)
+	}
+	opts := s.serverOpts(dsn)
+	s.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
 	for {
 		if errCount++; errCount > retries {
+			s.m.Lock()
+			s.backoff.fail(dsn, labels, err)
+			s.m.Unlock()
 			return nil, err
 		}
-		server, ok = s.servers[dsn]
-		if !ok {
-			server, err = NewServer(dsn, s.opts...)
+		if server == nil {
+			server, err = NewServer(dsn, opts...)
 			if err != nil {
 				time.Sleep(time.Duration(errCount) * time.Second)
 				continue
 			}
+			labels = server.labels
+			s.m.Lock()
 			s.servers[dsn] = server
+			s.m.Unlock()
 		}
 		if err = server.Ping(); err != nil {
+			labels = server.labels
+			s.m.Lock()
 			delete(s.servers, dsn)
+			s.m.Unlock()
+			server = nil
 			time.Sleep(time.Duration(errCount) * time.Second)
 			continue
 		}
 		break
 	}
+	s.m.Lock()
+	s.backoff.reset(dsn)
+	s.m.Unlock()
 	return server, nil
 }
 
+// connectLock returns the lock serializing the connections to dsn
+func (s *Servers) connectLock(dsn string) *sync.Mutex {
+	s.m.Lock()
+	defer s.m.Unlock()
+	lock, ok := s.connecting[dsn]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.connecting[dsn] = lock
+	}
+	return lock
+}
+
+// List returns all known servers ordered by fingerprint, the servers of the same fingerprint by dsn.
+func (s *Servers) List() []*Server {
+	s.m.Lock()
+	defer s.m.Unlock()
+	servers := make([]*Server, 0, len(s.servers))
+	for _, server := range s.servers {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].String() != servers[j].String() {
+			return servers[i].String() < servers[j].String()
+		}
+		return servers[i].dsn < servers[j].dsn
+	})
+	return servers
+}
+
 // Close disconnects from all known servers.
 func (s *Servers) Close() {
 	s.m.Lock()
 	defer s.m.Unlock()
 	for _, server := range s.servers {
 		if err := server.Close(); err != nil {
-			log.Errorf("failed to close connection to %q: %v", server, err)
+			server.log().Errorf("failed to close connection to %q: %v", server, err)
 		}
 	}
 }
 
 // Convert database.sql types to float64s for Prometheus consumption. Null types are mapped to NaN. string and []byte
-// types are mapped as NaN and !ok
+// types are parsed by parseNumericString, otherwise mapped as NaN and !ok, logged by the caller
 func dbToFloat64(t interface{}) (float64, bool) {
 	switch v := t.(type) {
 	case int64:
@@ -488,17 +1453,14 @@ func dbToFloat64(t interface{}) (float64, bool) {
 		return float64(v.Unix()), true
 	case []byte:
 		// Try and convert to string and then parse to a float64
-		strV := string(v)
-		result, err := strconv.ParseFloat(strV, 64)
-		if err != nil {
-			log.Infoln("Could not parse []byte:", err)
+		result, ok := parseNumericString(string(v))
+		if !ok {
 			return math.NaN(), false
 		}
 		return result, true
 	case string:
-		result, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			log.Infoln("Could not parse string:", err)
+		result, ok := parseNumericString(v)
+		if !ok {
 			return math.NaN(), false
 		}
 		return result, true
@@ -514,6 +1476,112 @@ func dbToFloat64(t interface{}) (float64, bool) {
 	}
 }
 
+// dbToTimestamp convert a timestamp into Unix seconds, with the fraction of the second. Textual timestamps are parsed,
+// numbers are taken as Unix seconds already
+func dbToTimestamp(t interface{}) (float64, bool) {
+	switch v := t.(type) {
+	case time.Time:
+		return float64(v.Unix()) + float64(v.Nanosecond())/1e9, true
+	case []byte:
+		return dbToTimestamp(string(v))
+	case string:
+		for _, layout := range timestampLayouts {
+			if ts, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return dbToTimestamp(ts)
+			}
+		}
+	}
+	return dbToFloat64(t)
+}
+
+// dbToBool convert a boolean into 1 or 0. Only booleans, 0 and 1 and their textual forms (t/f, true/false, on/off,
+// yes/no) are accepted, any other value fails
+func dbToBool(t interface{}) (float64, bool) {
+	switch v := t.(type) {
+	case bool:
+		return dbToFloat64(v)
+	case int64:
+		if v == 0 || v == 1 {
+			return float64(v), true
+		}
+	case []byte:
+		return dbToBool(string(v))
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "t", "true", "on", "yes", "1":
+			return 1.0, true
+		case "f", "false", "off", "no", "0":
+			return 0.0, true
+		}
+	case nil:
+		return math.NaN(), true
+	}
+	return math.NaN(), false
+}
+
+var (
+	// number with unit, e.g. "16 MB", "8kB", "100 ms"
+	numberWithUnitRegex = regexp.MustCompile(`^([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)\s*([a-zA-Z]+)$`)
+	// unit multipliers, bytes units are converted to bytes and time units to seconds
+	unitMultipliers = map[string]float64{
+		"b":     1,
+		"byte":  1,
+		"bytes": 1,
+		"kb":    math.Pow(2, 10),
+		"mb":    math.Pow(2, 20),
+		"gb":    math.Pow(2, 30),
+		"tb":    math.Pow(2, 40),
+		"pb":    math.Pow(2, 50),
+		"us":    1e-6,
+		"ms":    1e-3,
+		"s":     1,
+		"sec":   1,
+		"min":   60,
+		"h":     60 * 60,
+		"d":     60 * 60 * 24,
+	}
+	// textual timestamp layouts, converted to Unix seconds
+	timestampLayouts = []string{
+		"2006-01-02 15:04:05.999999999-07",
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		time.RFC3339Nano,
+	}
+)
+
+// parseNumericString parse textual result into float64. Besides numbers (bigint returned as string included),
+// it accepts booleans (t/f, true/false, on/off, yes/no), numbers with units ("16 MB"), intervals (in seconds)
+// and timestamps.
+func parseNumericString(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	if result, err := strconv.ParseFloat(v, 64); err == nil {
+		return result, true
+	}
+	switch strings.ToLower(v) {
+	case "t", "true", "on", "yes":
+		return 1.0, true
+	case "f", "false", "off", "no":
+		return 0.0, true
+	}
+	if subMatches := numberWithUnitRegex.FindStringSubmatch(v); len(subMatches) == 3 {
+		if multiplier, ok := unitMultipliers[strings.ToLower(subMatches[2])]; ok {
+			result, err := strconv.ParseFloat(subMatches[1], 64)
+			if err == nil {
+				return result * multiplier, true
+			}
+		}
+	}
+	if result, ok := parseInterval(v); ok {
+		return result, true
+	}
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, v); err == nil {
+			return float64(ts.Unix()), true
+		}
+	}
+	return math.NaN(), false
+}
+
 // Convert database.sql to string for Prometheus labels. Null types are mapped to empty strings.
 func dbToString(t interface{}, time2string bool) (string, bool) {
 	switch v := t.(type) {
@@ -548,15 +1616,26 @@ func parseFingerprint(url string) (string, error) {
 	if err != nil {
 		dsn = url
 	}
+	// pq doesn't parse urls with multiple hosts
+	if settings, err := parseURLSettings(url); err == nil && isMultiHost(settings) {
+		dsn = genDSNString(settings)
+	}
 
-	pairs := strings.Split(dsn, " ")
-	kv := make(map[string]string, len(pairs))
-	for _, pair := range pairs {
-		split := strings.SplitN(pair, "=", 2)
-		if len(split) != 2 {
-			return "", fmt.Errorf("malformed dsn %q", dsn)
+	kv, err := parseDSNSettings(dsn)
+	if err != nil {
+		return "", fmt.Errorf("malformed dsn %q", RedactText(dsn))
+	}
+
+	if isMultiHost(kv) {
+		hosts, err := splitHosts(kv)
+		if err != nil {
+			return "", err
+		}
+		fingerprints := make([]string, len(hosts))
+		for i, h := range hosts {
+			fingerprints[i] = h.String()
 		}
-		kv[split[0]] = split[1]
+		return strings.Join(fingerprints, ","), nil
 	}
 
 	var fingerprint string
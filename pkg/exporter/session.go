@@ -0,0 +1,154 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"time"
+)
+
+// DefaultApplicationName is the application_name of exporter connections, unless the dsn sets one
+const DefaultApplicationName = "opengauss_exporter"
+
+// SessionParams returns the connection parameters identifying and bounding exporter sessions server-side.
+// A zero timeout is not set
+func SessionParams(applicationName string, statementTimeout, idleInTransactionTimeout time.Duration) map[string]string {
+	params := make(map[string]string)
+	if applicationName != "" {
+		params["application_name"] = applicationName
+	}
+	if statementTimeout > 0 {
+		params["statement_timeout"] = fmt.Sprintf("%dms", statementTimeout.Milliseconds())
+	}
+	if idleInTransactionTimeout > 0 {
+		params["idle_in_transaction_session_timeout"] = fmt.Sprintf("%dms", idleInTransactionTimeout.Milliseconds())
+	}
+	return params
+}
+
+// ServerWithSessionParams set connection parameters, e.g. application_name or GUCs, on every connection of the server.
+// Parameters set by the dsn take precedence
+func ServerWithSessionParams(params map[string]string) ServerOpt {
+	return func(s *Server) {
+		s.sessionParams = params
+	}
+}
+
+// ServerWithConnectTimeout set connect_timeout of the dsn unless it sets one, rounded up to seconds
+func ServerWithConnectTimeout(timeout time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.connectTimeout = timeout
+	}
+}
+
+// ServerWithSessionSetup execute statements on every new connection of the server, e.g. SET ROLE monitor,
+// SET search_path or SELECT set_config(...). A connection is discarded if one of them fails
+func ServerWithSessionSetup(statements []string) ServerOpt {
+	return func(s *Server) {
+		s.sessionSetup = statements
+	}
+}
+
+// sessionConnector run the setup statements after connecting
+type sessionConnector struct {
+	driver.Connector
+	setup []string
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("driver does not support session setup statements")
+	}
+	for _, statement := range c.setup {
+		if _, err := execer.ExecContext(ctx, statement, nil); err != nil {
+			conn.Close() // nolint: errcheck
+			return nil, fmt.Errorf("session setup %q: %s", statement, err)
+		}
+	}
+	return conn, nil
+}
+
+// openDB open the connection pool of dsn, the setup statements are executed on every new connection
+func (s *Server) openDB(dsn string) (*sql.DB, error) {
+	return s.openDBWith(dsn, s.openPool)
+}
+
+// openDBWith open the connection pool of dsn like openDB, the pool of its connector is opened by openPool
+func (s *Server) openDBWith(dsn string, openPool func(driver.Connector) *sql.DB) (*sql.DB, error) {
+	if s.mockResults != nil {
+		return sql.OpenDB(&mockConnector{results: s.mockResults}), nil
+	}
+	dsn, err := sessionDSN(dsn, s.connParams())
+	if err != nil {
+		return nil, err
+	}
+	if s.driver != "" && s.driver != DefaultDriver {
+		connector, err := s.driverConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		if len(s.sessionSetup) > 0 {
+			connector = &sessionConnector{Connector: connector, setup: s.sessionSetup}
+		}
+		return openPool(connector), nil
+	}
+	dsns, attrs, err := hostDSNs(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if len(dsns) == 1 && attrs == "" && dsns[0] == dsn && len(s.sessionSetup) == 0 && s.dialTimeout <= 0 && s.handshakeTimeout <= 0 &&
+		s.connBudget == nil && !s.cancelOnTimeout {
+		return sql.Open("postgres", dsn)
+	}
+	for _, dsn := range dsns {
+		if _, err := pq.NewConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	var connector driver.Connector = &dialConnector{dsn: dsn, dialTimeout: s.dialTimeout, handshakeTimeout: s.handshakeTimeout,
+		logger: s.logger}
+	if len(s.sessionSetup) > 0 {
+		connector = &sessionConnector{Connector: connector, setup: s.sessionSetup}
+	}
+	return openPool(connector), nil
+}
+
+// connParams returns the session params and connect_timeout
+func (s *Server) connParams() map[string]string {
+	if s.connectTimeout <= 0 {
+		return s.sessionParams
+	}
+	params := map[string]string{"connect_timeout": fmt.Sprint(int64(math.Ceil(s.connectTimeout.Seconds())))}
+	for k, v := range s.sessionParams {
+		params[k] = v
+	}
+	return params
+}
+
+// sessionDSN returns the dsn with the session params it doesn't set
+func sessionDSN(dsn string, params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return dsn, nil
+	}
+	settings, err := parseDsn(dsn)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range params {
+		if _, ok := settings[k]; !ok {
+			settings[k] = v
+		}
+	}
+	return genDSNString(settings), nil
+}
@@ -0,0 +1,91 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionParams(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"application_name":                    "opengauss_exporter",
+		"statement_timeout":                   "30000ms",
+		"idle_in_transaction_session_timeout": "60000ms",
+	}, SessionParams(DefaultApplicationName, 30*time.Second, time.Minute))
+	assert.Equal(t, map[string]string{}, SessionParams("", 0, 0))
+}
+
+func Test_sessionDSN(t *testing.T) {
+	params := SessionParams(DefaultApplicationName, 30*time.Second, 0)
+	got, err := sessionDSN("host=localhost user=gaussdb dbname=postgres", params)
+	assert.NoError(t, err)
+	assert.Equal(t, "application_name=opengauss_exporter database=postgres host=localhost statement_timeout=30000ms user=gaussdb", got)
+
+	// the dsn takes precedence
+	got, err = sessionDSN("postgresql://gaussdb@localhost:5432/postgres?application_name=monitor", params)
+	assert.NoError(t, err)
+	assert.Contains(t, got, "application_name=monitor")
+	assert.Contains(t, got, "statement_timeout=30000ms")
+
+	got, err = sessionDSN("host=localhost", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "host=localhost", got)
+}
+
+// dsnConnector open connections of a driver by dsn
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+func Test_sessionConnector(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("session_setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	mock.ExpectExec("SET ROLE monitor").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET search_path TO monitor").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"c"}).AddRow(1))
+
+	db := sql.OpenDB(&sessionConnector{
+		Connector: dsnConnector{dsn: "session_setup", drv: mockDB.Driver()},
+		setup:     []string{"SET ROLE monitor", "SET search_path TO monitor"},
+	})
+	var c int
+	assert.NoError(t, db.QueryRow("SELECT 1").Scan(&c))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_sessionConnector_error(t *testing.T) {
+	mockDB, mock, err := sqlmock.NewWithDSN("session_setup_error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+	mock.ExpectExec("SET ROLE monitor").WillReturnError(fmt.Errorf("permission denied to set role \"monitor\""))
+	mock.ExpectClose()
+
+	db := sql.OpenDB(&sessionConnector{
+		Connector: dsnConnector{dsn: "session_setup_error", drv: mockDB.Driver()},
+		setup:     []string{"SET ROLE monitor"},
+	})
+	err = db.Ping()
+	assert.EqualError(t, err, `session setup "SET ROLE monitor": permission denied to set role "monitor"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
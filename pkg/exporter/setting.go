@@ -3,17 +3,37 @@
 package exporter
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"math"
 	"strconv"
 	"strings"
 )
 
-// QueryInstance the pg_settings view containing runtime variables
-func (s *Server) querySettings(ch chan<- prometheus.Metric) error {
-	log.Debugf("Querying pg_setting view on %q", s.String())
+// settingsCollectorName is the name of the built-in pg_settings collector
+const settingsCollectorName = "pg_settings"
+
+func init() {
+	RegisterCollector(settingsCollector{})
+}
+
+// settingsCollector collect the pg_settings view containing runtime variables
+type settingsCollector struct{}
+
+func (settingsCollector) Name() string {
+	return settingsCollectorName
+}
+
+// Enabled settings are server level metrics
+func (settingsCollector) Enabled(info ServerInfo) bool {
+	return info.Master
+}
+
+// Collect the pg_settings view containing runtime variables
+func (settingsCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	loggerOr(info.Logger).Debugf("Querying pg_setting view on %q", info.Server)
 
 	// pg_settings docs: https://www.postgresql.org/docs/current/static/view-pg-settings.html
 	//
@@ -21,9 +41,9 @@ func (s *Server) querySettings(ch chan<- prometheus.Metric) error {
 	// types in normaliseUnit() below
 	query := "SELECT name, setting, COALESCE(unit, ''), short_desc, vartype FROM pg_settings WHERE vartype IN ('bool', 'integer', 'real','string');"
 
-	rows, err := s.db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		return fmt.Errorf("Error running query on database %q: %s %s ", s.String(), s.namespace, err)
+		return fmt.Errorf("Error running query on database %q: %s %s ", info.Server, info.Namespace, err)
 	}
 	defer rows.Close() // nolint: errcheck
 
@@ -32,13 +52,13 @@ func (s *Server) querySettings(ch chan<- prometheus.Metric) error {
 		var unit *string
 		err = rows.Scan(&pgSetting.name, &pgSetting.setting, &unit, &pgSetting.shortDesc, &pgSetting.varType)
 		if err != nil {
-			return fmt.Errorf("Error retrieving rows on %q: %s %v ", s.String(), s.namespace, err)
+			return fmt.Errorf("Error retrieving rows on %q: %s %v ", info.Server, info.Namespace, err)
 		}
 		if unit != nil {
 			pgSetting.unit = *unit
 		}
 
-		ch <- pgSetting.metric(s.namespace, s.labels)
+		ch <- pgSetting.metric(info.Namespace, info.Labels)
 	}
 
 	return nil
@@ -0,0 +1,102 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"sync"
+)
+
+// settingsChangesCollectorName is the name of the built-in collector of the changes of pg_settings
+const settingsChangesCollectorName = "pg_settings_changes"
+
+func init() {
+	RegisterCollector(newSettingsChangesCollector())
+}
+
+// settingsChangesCollector collect the parameters changed since the first scrape of a server by the exporter, e.g. by a
+// reload of the configuration or ALTER SYSTEM, and the parameters differing from their reset_val
+type settingsChangesCollector struct {
+	m         sync.Mutex
+	snapshots map[string]map[string]string // settings of the first scrape by name, by server
+}
+
+func newSettingsChangesCollector() *settingsChangesCollector {
+	return &settingsChangesCollector{snapshots: make(map[string]map[string]string)}
+}
+
+func (c *settingsChangesCollector) Name() string {
+	return settingsChangesCollectorName
+}
+
+// Enabled settings are server level metrics
+func (c *settingsChangesCollector) Enabled(info ServerInfo) bool {
+	return info.Master
+}
+
+func (c *settingsChangesCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	rows, err := db.QueryContext(ctx, "SELECT name, coalesce(setting, ''), coalesce(reset_val, '') FROM pg_settings")
+	if err != nil {
+		return fmt.Errorf("Error retrieving settings on %q: %s", info.Server, err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	settings := make(map[string]string)
+	var differingFromReset int
+	for rows.Next() {
+		var name, setting, resetVal string
+		if err := rows.Scan(&name, &setting, &resetVal); err != nil {
+			return fmt.Errorf("Error retrieving settings on %q: %s", info.Server, err)
+		}
+		settings[name] = setting
+		if setting != resetVal {
+			differingFromReset++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	changedDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "settings", "changed"),
+		"Whether the parameter changed since the first scrape of the server by the exporter (1 for changed)", []string{"name"}, info.Labels)
+	changedCountDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "settings", "changed_count"),
+		"Number of parameters changed since the first scrape of the server by the exporter", nil, info.Labels)
+	resetCountDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "settings", "differing_from_reset_count"),
+		"Number of parameters whose setting differs from their reset_val", nil, info.Labels)
+	changed := c.changed(info.Server, settings)
+	for _, name := range changed {
+		ch <- prometheus.MustNewConstMetric(changedDesc, prometheus.GaugeValue, 1, name)
+	}
+	ch <- prometheus.MustNewConstMetric(changedCountDesc, prometheus.GaugeValue, float64(len(changed)))
+	ch <- prometheus.MustNewConstMetric(resetCountDesc, prometheus.GaugeValue, float64(differingFromReset))
+	return nil
+}
+
+// changed returns the sorted names of the parameters whose setting differs from the snapshot of server, taken from
+// settings on the first call. The parameters added or removed since the snapshot, e.g. by an upgrade, are changed too
+func (c *settingsChangesCollector) changed(server string, settings map[string]string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	snapshot, ok := c.snapshots[server]
+	if !ok {
+		c.snapshots[server] = settings
+		return nil
+	}
+	var names []string
+	for name, setting := range settings {
+		if old, ok := snapshot[name]; !ok || old != setting {
+			names = append(names, name)
+		}
+	}
+	for name := range snapshot {
+		if _, ok := settings[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,59 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_settingsChangesCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newSettingsChangesCollector()
+	info := ServerInfo{Server: "localhost:5432", Namespace: "og", Master: true}
+	assert.True(t, c.Enabled(info))
+	assert.False(t, c.Enabled(ServerInfo{}))
+
+	collect := func(rows *sqlmock.Rows) map[string]float64 {
+		mock.ExpectQuery("FROM pg_settings").WillReturnRows(rows)
+		ch := make(chan prometheus.Metric, 10)
+		assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+		close(ch)
+		values := make(map[string]float64)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			name := metric.Desc().String()
+			for _, l := range m.GetLabel() {
+				name = l.GetValue()
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+		return values
+	}
+	columns := []string{"name", "setting", "reset_val"}
+	got := collect(sqlmock.NewRows(columns).
+		AddRow("work_mem", "64MB", "64MB").
+		AddRow("statement_timeout", "30000", "0").
+		AddRow("max_connections", "200", "200"))
+	assert.Len(t, got, 2, "no parameter changed at the snapshot")
+
+	got = collect(sqlmock.NewRows(columns).
+		AddRow("work_mem", "128MB", "128MB").
+		AddRow("statement_timeout", "30000", "0").
+		AddRow("max_connections", "200", "200").
+		AddRow("enable_thread_pool", "on", "on"))
+	assert.Equal(t, 1.0, got["work_mem"])
+	assert.Equal(t, 1.0, got["enable_thread_pool"])
+	assert.NotContains(t, got, "max_connections")
+	assert.Len(t, got, 4)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
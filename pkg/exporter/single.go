@@ -0,0 +1,58 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// errSingleConnection is returned instead of opening a second session on a server in single connection mode
+var errSingleConnection = errors.New("single connection mode, no other session is opened on the server")
+
+// WithSingleConnection hold at most one session per server: the queries are executed one at a time on a pool of one
+// connection, the connections to the other databases and the control connection are not opened
+func WithSingleConnection(b bool) Opt {
+	return func(e *Exporter) {
+		e.singleConn = b
+	}
+}
+
+// ServerWithSingleConnection hold at most one session on the server, see WithSingleConnection
+func ServerWithSingleConnection(b bool) ServerOpt {
+	return func(s *Server) {
+		s.singleConnection = b
+	}
+}
+
+// queuedRows are the rows of a statement executed on the connection of a server in single connection mode,
+// closing them returns the connection to the pool
+type queuedRows struct {
+	*sql.Rows
+	conn *sql.Conn
+}
+
+func (r *queuedRows) Close() error {
+	err := r.Rows.Close()
+	_ = r.conn.Close()
+	return err
+}
+
+// queryQueued execute query on the connection of db once it is released by the other statements of the server,
+// the time waited for it is recorded as the queue wait of metricName
+func (s *Server) queryQueued(ctx context.Context, db *sql.DB, metricName, query string, args ...interface{}) (rowSource, error) {
+	begin := time.Now()
+	conn, err := db.Conn(ctx)
+	s.stats.observeQueueWait(metricName, time.Since(begin))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &queuedRows{Rows: rows, conn: conn}, nil
+}
@@ -0,0 +1,53 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestServer_singleConnection(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &Server{db: db, dsn: "host=localhost dbname=postgres", singleConnection: true, cancelOnTimeout: true, parallel: 4,
+		leader: &leaderElection{}, stats: newQueryStats(), labels: prometheus.Labels{serverLabelName: "localhost:5432"}}
+	assert.Equal(t, 1, s.maxConns())
+	db.SetMaxOpenConns(s.maxConns())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.False(t, s.cancelable(ctx), "no control connection")
+	_, err = s.databaseDB("other")
+	assert.Equal(t, errSingleConnection, err)
+
+	// the second statement waits for the rows of the first one to be closed
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	mock.ExpectQuery("SELECT 2").WillReturnRows(sqlmock.NewRows([]string{"two"}).AddRow(2))
+	first, err := s.queryQueued(ctx, db, "q1", "SELECT 1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = first.Close()
+	}()
+	second, err := s.queryQueued(ctx, db, "q2", "SELECT 2")
+	if assert.NoError(t, err) {
+		assert.NoError(t, second.Close())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, s.stats.stats["q2"].queueWaits)
+	assert.GreaterOrEqual(t, int64(s.stats.stats["q2"].queueWait), int64(40*time.Millisecond))
+	assert.Less(t, int64(s.stats.stats["q1"].queueWait), int64(40*time.Millisecond))
+
+	_, err = NewExporter(WithSingleConnection(true), WithLeaderElection(42))
+	assert.Error(t, err)
+}
@@ -0,0 +1,335 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// output formats of non-SQL sources
+const (
+	sourceFormatRegex = "regex"
+	sourceFormatJSON  = "json"
+)
+
+// ExecSource run an external command instead of sql, e.g. gs_ctl query or cm_ctl query.
+// The stdout is parsed into rows by regex named groups (one row per matched line) or by json mappings
+type ExecSource struct {
+	Command     []string `yaml:"command,omitempty"` // command and its arguments, no shell is involved
+	Format      string   `yaml:"format,omitempty"`  // regex (default) or json
+	Regex       string   `yaml:"regex,omitempty"`   // named groups are the columns
+	JSONMapping `yaml:",inline"`
+	regex       *regexp.Regexp
+}
+
+// JSONMapping map json documents into rows
+type JSONMapping struct {
+	Path   string            `yaml:"path,omitempty"`   // dotted path to the array (or object) of rows, the document itself by default
+	Fields map[string]string `yaml:"fields,omitempty"` // column -> dotted path inside a row, all top-level keys by default
+}
+
+// Check the exec source
+func (e *ExecSource) Check() error {
+	if len(e.Command) == 0 {
+		return fmt.Errorf("exec command is empty")
+	}
+	e.Format = strings.ToLower(e.Format)
+	switch e.Format {
+	case "", sourceFormatRegex:
+		e.Format = sourceFormatRegex
+		regex, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("exec regex %q: %s", e.Regex, err)
+		}
+		if len(regexColumns(regex)) == 0 {
+			return fmt.Errorf("exec regex %q has no named group", e.Regex)
+		}
+		e.regex = regex
+	case sourceFormatJSON:
+	default:
+		return fmt.Errorf("exec format %s not supported", e.Format)
+	}
+	return nil
+}
+
+// rows run the command and parse its stdout
+func (e *ExecSource) rows(ctx context.Context) (rowSource, error) {
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("exec %s: %w", e.Command[0], ctx.Err())
+		}
+		return nil, fmt.Errorf("exec %s: %s %s", e.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if e.Format == sourceFormatJSON {
+		return e.JSONMapping.rows(out)
+	}
+	return regexRows(e.regex, out), nil
+}
+
+// HTTPSource fetch a json document from an http endpoint instead of sql, e.g. an operator or cm_server REST API
+type HTTPSource struct {
+	URL         string            `yaml:"url,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	JSONMapping `yaml:",inline"`
+}
+
+// Check the http source
+func (h *HTTPSource) Check() error {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return fmt.Errorf("http url %q: %s", h.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("http url %q: scheme must be http or https", h.URL)
+	}
+	return nil
+}
+
+// rows get the url and parse the json response
+func (h *HTTPSource) rows(ctx context.Context) (rowSource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get %s: %w", RedactText(h.URL), err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("http get %s: %w", RedactText(h.URL), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http get %s: unexpected status %s", RedactText(h.URL), resp.Status)
+	}
+	return h.JSONMapping.rows(body)
+}
+
+// StaticSource are rows defined in the config instead of sql, e.g. deployment metadata like tier or SLA class.
+// Each row maps column names to values
+type StaticSource []map[string]string
+
+// Check the static source
+func (st StaticSource) Check() error {
+	if len(st) == 0 {
+		return fmt.Errorf("static source has no row")
+	}
+	return nil
+}
+
+// rows returns the configured rows, columns are sorted by name and missing values are NULL
+func (st StaticSource) rows() rowSource {
+	keys := make(map[string]bool)
+	rows := &memRows{}
+	for _, row := range st {
+		for column := range row {
+			if !keys[column] {
+				keys[column] = true
+				rows.columns = append(rows.columns, column)
+			}
+		}
+	}
+	sort.Strings(rows.columns)
+	for _, row := range st {
+		data := make([]interface{}, len(rows.columns))
+		for i, column := range rows.columns {
+			if v, ok := row[column]; ok {
+				data[i] = v
+			}
+		}
+		rows.data = append(rows.data, data)
+	}
+	return rows
+}
+
+// rowSource is the rows of a query, satisfied by *sql.Rows
+type rowSource interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+var _ rowSource = (*sql.Rows)(nil)
+
+// memRows are rows parsed from non-SQL sources
+type memRows struct {
+	columns []string
+	data    [][]interface{}
+	cursor  int
+}
+
+func (r *memRows) Columns() ([]string, error) {
+	return r.columns, nil
+}
+
+func (r *memRows) Next() bool {
+	if r.cursor >= len(r.data) {
+		return false
+	}
+	r.cursor++
+	return true
+}
+
+// Scan only supports *interface{} dest
+func (r *memRows) Scan(dest ...interface{}) error {
+	row := r.data[r.cursor-1]
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	for i := range dest {
+		d, ok := dest[i].(*interface{})
+		if !ok {
+			return fmt.Errorf("unsupported Scan destination %T", dest[i])
+		}
+		*d = row[i]
+	}
+	return nil
+}
+
+func (r *memRows) Err() error {
+	return nil
+}
+
+func (r *memRows) Close() error {
+	return nil
+}
+
+func regexColumns(regex *regexp.Regexp) []string {
+	var columns []string
+	for _, name := range regex.SubexpNames() {
+		if name != "" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// regexRows parse every line matching regex into a row of the named groups
+func regexRows(regex *regexp.Regexp, out []byte) *memRows {
+	rows := &memRows{columns: regexColumns(regex)}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		subMatches := regex.FindStringSubmatch(scanner.Text())
+		if subMatches == nil {
+			continue
+		}
+		row := make([]interface{}, 0, len(rows.columns))
+		for i, name := range regex.SubexpNames() {
+			if name != "" {
+				row = append(row, subMatches[i])
+			}
+		}
+		rows.data = append(rows.data, row)
+	}
+	return rows
+}
+
+// jsonLookup walk the dotted path, e.g. "data.nodes"
+func jsonLookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" || path == "." {
+		return v, true
+	}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonValue convert json value into column data, nested values are kept as json text
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, string, bool:
+		return t
+	case json.Number:
+		return string(t)
+	default:
+		buf, _ := json.Marshal(t)
+		return string(buf)
+	}
+}
+
+// rows parse a json document into rows
+func (m *JSONMapping) rows(data []byte) (*memRows, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("malformed json: %s", err)
+	}
+	v, ok := jsonLookup(doc, m.Path)
+	if !ok {
+		return nil, fmt.Errorf("json path %s not found", m.Path)
+	}
+	var items []interface{}
+	switch t := v.(type) {
+	case []interface{}:
+		items = t
+	case nil:
+	default:
+		items = []interface{}{t}
+	}
+	rows := &memRows{}
+	if len(m.Fields) > 0 {
+		for column := range m.Fields {
+			rows.columns = append(rows.columns, column)
+		}
+	} else {
+		// all top-level keys of rows
+		keys := make(map[string]bool)
+		for _, item := range items {
+			if obj, ok := item.(map[string]interface{}); ok {
+				for key := range obj {
+					if !keys[key] {
+						keys[key] = true
+						rows.columns = append(rows.columns, key)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(rows.columns)
+	for _, item := range items {
+		row := make([]interface{}, len(rows.columns))
+		for i, column := range rows.columns {
+			path := column
+			if len(m.Fields) > 0 {
+				path = m.Fields[column]
+			}
+			if value, ok := jsonLookup(item, path); ok {
+				row[i] = jsonValue(value)
+			}
+		}
+		rows.data = append(rows.data, row)
+	}
+	return rows, nil
+}
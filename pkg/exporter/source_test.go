@@ -0,0 +1,176 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func Test_regexRows(t *testing.T) {
+	out := []byte(`node      state
+node1     Normal 1
+node2     Down 0
+`)
+	rows := regexRows(regexp.MustCompile(`^(?P<node>node\d+)\s+(?P<state>\w+)\s+(?P<up>\d)$`), out)
+	assert.Equal(t, []string{"node", "state", "up"}, rows.columns)
+	assert.Equal(t, [][]interface{}{{"node1", "Normal", "1"}, {"node2", "Down", "0"}}, rows.data)
+}
+
+func TestJSONMapping_rows(t *testing.T) {
+	data := []byte(`{"data": {"nodes": [{"name": "node1", "status": {"up": true, "lag": 1.5}}, {"name": "node2", "status": {"up": false}}]}}`)
+	m := &JSONMapping{
+		Path:   "data.nodes",
+		Fields: map[string]string{"node": "name", "up": "status.up", "lag": "status.lag"},
+	}
+	rows, err := m.rows(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lag", "node", "up"}, rows.columns)
+	assert.Equal(t, [][]interface{}{{"1.5", "node1", true}, {nil, "node2", false}}, rows.data)
+
+	// top-level keys
+	rows, err = (&JSONMapping{}).rows([]byte(`{"name": "node1", "up": 1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name", "up"}, rows.columns)
+	assert.Equal(t, [][]interface{}{{"node1", "1"}}, rows.data)
+
+	_, err = (&JSONMapping{Path: "missing"}).rows(data)
+	assert.Error(t, err)
+	_, err = (&JSONMapping{}).rows([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestExecSource_Check(t *testing.T) {
+	assert.Error(t, (&ExecSource{}).Check())
+	assert.Error(t, (&ExecSource{Command: []string{"true"}, Regex: `\w+`}).Check())
+	assert.Error(t, (&ExecSource{Command: []string{"true"}, Format: "xml"}).Check())
+	assert.NoError(t, (&ExecSource{Command: []string{"true"}, Format: "JSON"}).Check())
+}
+
+func Test_Server_queryMetric_exec(t *testing.T) {
+	var q QueryInstance
+	err := yaml.Unmarshal([]byte(`
+name: cm_node
+query:
+  - exec:
+      command: ["sh", "-c", "printf 'node1 Normal 1\nnode2 Down 0\n'"]
+      regex: '^(?P<node>\S+)\s+(?P<state>\S+)\s+(?P<up>\d+)$'
+    timeout: 1
+metrics:
+  - name: node
+    usage: LABEL
+  - name: state
+    usage: LABEL
+  - name: up
+    usage: GAUGE
+`), &q)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, q.Check()) {
+		return
+	}
+	s := &Server{
+		labels: prometheus.Labels{"server": "localhost:5432"},
+	}
+	metrics, errs, err := s.queryMetric("cm_node", &q)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Len(t, metrics, 2)
+
+	q.Queries[0].Exec.Command = []string{"sh", "-c", "exit 1"}
+	_, _, err = s.queryMetric("cm_node", &q)
+	assert.Error(t, err)
+}
+
+func TestHTTPSource_Check(t *testing.T) {
+	assert.Error(t, (&HTTPSource{}).Check())
+	assert.Error(t, (&HTTPSource{URL: "ftp://cm_server/nodes"}).Check())
+	assert.NoError(t, (&HTTPSource{URL: "http://cm_server:8080/nodes"}).Check())
+}
+
+func Test_Server_queryMetric_http(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"nodes": [{"name": "node1", "up": 1}, {"name": "node2", "up": 0}]}`))
+	}))
+	defer srv.Close()
+	q := &QueryInstance{
+		Name: "cluster_node",
+		Queries: []*Query{{HTTP: &HTTPSource{
+			URL:         srv.URL,
+			Headers:     map[string]string{"Authorization": "Bearer token"},
+			JSONMapping: JSONMapping{Path: "nodes"},
+		}, Timeout: 1}},
+		Metrics: []*Column{
+			{Name: "name", Usage: LABEL},
+			{Name: "up", Usage: GAUGE},
+		},
+	}
+	if !assert.NoError(t, q.Check()) {
+		return
+	}
+	s := &Server{
+		labels: prometheus.Labels{"server": "localhost:5432"},
+	}
+	metrics, errs, err := s.queryMetric("cluster_node", q)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Len(t, metrics, 2)
+
+	q.Queries[0].HTTP.Headers = nil
+	_, _, err = s.queryMetric("cluster_node", q)
+	assert.Error(t, err)
+}
+
+func Test_Server_queryMetric_static(t *testing.T) {
+	var queries map[string]*QueryInstance
+	err := yaml.Unmarshal([]byte(`
+deployment_info:
+  name: deployment_info
+  query:
+    - name: deployment_info
+      static:
+        - tier: gold
+          sla_class: critical
+          value: 1
+        - tier: silver
+          value: 1
+  metrics:
+    - name: tier
+      usage: LABEL
+    - name: sla_class
+      usage: LABEL
+    - name: value
+      usage: GAUGE
+`), &queries)
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := queries["deployment_info"]
+	if !assert.NoError(t, q.Check()) {
+		return
+	}
+	assert.False(t, q.Queries[0].isSQL())
+	s := &Server{
+		labels: prometheus.Labels{"server": "localhost:5432"},
+	}
+	metrics, errs, err := s.queryMetric("deployment_info", q)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Len(t, metrics, 2)
+
+	q.Queries[0].Exec = &ExecSource{Command: []string{"true"}}
+	assert.Error(t, q.Check())
+	q.Queries[0].Exec = nil
+	q.Queries[0].Static = StaticSource{}
+	assert.Error(t, q.Check())
+}
@@ -0,0 +1,399 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statementHistoryCollectorName is the name of the statement history collector
+const statementHistoryCollectorName = "statement_history"
+
+const (
+	// DefaultStatementHistoryTop is the default number of fingerprints exposed by the statement history collector
+	DefaultStatementHistoryTop = 20
+	// statementHistoryRowLimit is the max number of new statements read by a scrape, the next scrape reads the rest
+	statementHistoryRowLimit = 10000
+	// statementHistoryExpiry is how long the counters of a fingerprint are kept after its last statement
+	statementHistoryExpiry = time.Hour
+	// statementHistoryQueryLength is the max length of the normalized sql in the query label, in bytes
+	statementHistoryQueryLength = 256
+)
+
+// statement_history is recorded since openGauss 2.0.0
+var statementHistoryMinVersion = semver.MustParse("2.0.0")
+
+// NewStatementHistoryCollector returns the collector of the statements of statement_history, aggregated by the
+// fingerprint of their normalized sql. The top fingerprints by execution time since the last scrape are exposed
+func NewStatementHistoryCollector(top int) Collector {
+	if top <= 0 {
+		top = DefaultStatementHistoryTop
+	}
+	return &statementHistoryCollector{
+		top:    top,
+		states: make(map[string]*statementHistoryState),
+		now:    time.Now,
+	}
+}
+
+// statementHistoryCollector read the statements finished since the last scrape from dbe_perf.statement_history,
+// or pg_catalog.statement_history on the versions without the view, and accumulate their executions, time and rows
+// by database and fingerprint. The literals of the sql are stripped, so the executions of a statement with different
+// values are counted together
+type statementHistoryCollector struct {
+	m      sync.Mutex // guards states
+	top    int
+	states map[string]*statementHistoryState // by server
+	now    func() time.Time
+}
+
+// statementHistoryState is the collection state of a server
+type statementHistoryState struct {
+	m        sync.Mutex
+	relation string                               // statement_history relation of the server
+	mark     time.Time                            // highest finish_time read so far
+	atMark   map[string]int                       // statements read finished at mark, by statementIdentity
+	stats    map[string]*statementFingerprintStat // by database and fingerprint
+}
+
+// statementFingerprintStat hold the counters of the statements of a fingerprint in a database
+type statementFingerprintStat struct {
+	datname     string
+	fingerprint string
+	query       string // normalized sql, truncated
+	calls       int
+	seconds     float64
+	rows        float64
+	lastSeen    time.Time
+	window      float64 // seconds of the statements of the current scrape
+	windowCalls int
+	windowMax   float64 // max seconds of a statement of the current scrape
+}
+
+func (c *statementHistoryCollector) Name() string {
+	return statementHistoryCollectorName
+}
+
+// Enabled statement_history is instance level
+func (c *statementHistoryCollector) Enabled(info ServerInfo) bool {
+	return info.Master && info.Version.GTE(statementHistoryMinVersion)
+}
+
+func (c *statementHistoryCollector) Collect(ctx context.Context, db *sql.DB, info ServerInfo, ch chan<- prometheus.Metric) error {
+	c.m.Lock()
+	state, ok := c.states[info.Server]
+	c.m.Unlock()
+	if !ok {
+		relation, err := statementHistoryRelation(ctx, db)
+		if err != nil {
+			return fmt.Errorf("Error retrieving statement_history on %q: %s", info.Server, err)
+		}
+		if relation == "" {
+			loggerOr(info.Logger).Debugf("statement_history is not visible on %q, skip statement history", info.Server)
+			return nil
+		}
+		// the statements finished before the first scrape are not counted
+		state = &statementHistoryState{relation: relation, stats: make(map[string]*statementFingerprintStat)}
+		if err := db.QueryRowContext(ctx, "SELECT now()").Scan(&state.mark); err != nil {
+			return fmt.Errorf("Error retrieving the time of %q: %s", info.Server, err)
+		}
+		c.m.Lock()
+		c.states[info.Server] = state
+		c.m.Unlock()
+		return nil
+	}
+	state.m.Lock()
+	defer state.m.Unlock()
+	if err := c.read(ctx, db, state); err != nil {
+		return fmt.Errorf("Error retrieving statement_history on %q: %s", info.Server, err)
+	}
+	c.collect(state, info, ch)
+	return nil
+}
+
+// statementHistoryRelation returns dbe_perf.statement_history, else pg_catalog.statement_history, empty if none is
+// visible
+func statementHistoryRelation(ctx context.Context, db *sql.DB) (string, error) {
+	var relation string
+	err := db.QueryRowContext(ctx, `SELECT n.nspname || '.statement_history' FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relname = 'statement_history' AND n.nspname IN ('dbe_perf', 'pg_catalog')
+ORDER BY n.nspname = 'dbe_perf' DESC LIMIT 1`).Scan(&relation)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return relation, err
+}
+
+// statementIdentity identifies a statement among the statements finished at the same time
+func statementIdentity(datname, query string, seconds float64) string {
+	return fmt.Sprintf("%s\x00%s\x00%g", datname, query, seconds)
+}
+
+// read accumulate the statements finished since the mark of state. The statements finished at the mark are read
+// again, as more may finish at the same time after the scrape or beyond the row limit, the ones already read are
+// skipped
+func (c *statementHistoryCollector) read(ctx context.Context, db *sql.DB, state *statementHistoryState) error {
+	seen := make(map[string]int, len(state.atMark))
+	limit := statementHistoryRowLimit
+	for identity, n := range state.atMark {
+		seen[identity] = n
+		limit += n
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT coalesce(db_name, ''), coalesce(query, ''), finish_time,
+	coalesce(extract(epoch FROM finish_time - start_time), 0)::float, coalesce(n_returned_rows, 0)::float
+FROM %s WHERE finish_time >= $1 ORDER BY finish_time LIMIT $2`, state.relation), state.mark, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+	from := state.mark
+	if state.atMark == nil {
+		state.atMark = make(map[string]int)
+	}
+
+	now := c.now()
+	for _, stat := range state.stats {
+		stat.window, stat.windowCalls, stat.windowMax = 0, 0, 0
+	}
+	for rows.Next() {
+		var (
+			datname, query   string
+			finishTime       time.Time
+			seconds, results float64
+		)
+		if err := rows.Scan(&datname, &query, &finishTime, &seconds, &results); err != nil {
+			return err
+		}
+		identity := statementIdentity(datname, query, seconds)
+		if finishTime.Equal(from) && seen[identity] > 0 {
+			seen[identity]--
+			continue
+		}
+		if finishTime.After(state.mark) {
+			state.mark, state.atMark = finishTime, make(map[string]int)
+		}
+		if finishTime.Equal(state.mark) {
+			state.atMark[identity]++
+		}
+		normalized := normalizeStatement(query)
+		fingerprint := statementFingerprint(normalized)
+		key := datname + "\x00" + fingerprint
+		stat, ok := state.stats[key]
+		if !ok {
+			stat = &statementFingerprintStat{
+				datname:     datname,
+				fingerprint: fingerprint,
+				query:       truncateStatement(RedactText(normalized)),
+			}
+			state.stats[key] = stat
+		}
+		stat.calls++
+		stat.seconds += seconds
+		stat.rows += results
+		stat.lastSeen = now
+		stat.windowCalls++
+		stat.window += seconds
+		if seconds > stat.windowMax {
+			stat.windowMax = seconds
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for key, stat := range state.stats {
+		if now.Sub(stat.lastSeen) > statementHistoryExpiry {
+			delete(state.stats, key)
+		}
+	}
+	return nil
+}
+
+// collect emit the counters of the top fingerprints by execution time of the statements read by the scrape
+func (c *statementHistoryCollector) collect(state *statementHistoryState, info ServerInfo, ch chan<- prometheus.Metric) {
+	var top []*statementFingerprintStat
+	for _, stat := range state.stats {
+		if stat.windowCalls > 0 {
+			top = append(top, stat)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].window != top[j].window {
+			return top[i].window > top[j].window
+		}
+		return top[i].windowCalls > top[j].windowCalls
+	})
+	if len(top) > c.top {
+		top = top[:c.top]
+	}
+
+	labels := []string{"datname", "fingerprint", "query"}
+	callsDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "statement_history", "calls_total"),
+		"Number of statements of the fingerprint finished since the exporter tracks it", labels, info.Labels)
+	secondsDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "statement_history", "seconds_total"),
+		"Execution time of the statements of the fingerprint since the exporter tracks it", labels, info.Labels)
+	rowsDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "statement_history", "rows_total"),
+		"Rows returned by the statements of the fingerprint since the exporter tracks it", labels, info.Labels)
+	maxDesc := prometheus.NewDesc(prometheus.BuildFQName(info.Namespace, "statement_history", "max_seconds"),
+		"Max execution time of a statement of the fingerprint finished since the last scrape", labels, info.Labels)
+	for _, stat := range top {
+		ch <- prometheus.MustNewConstMetric(callsDesc, prometheus.CounterValue, float64(stat.calls), stat.datname, stat.fingerprint, stat.query)
+		ch <- prometheus.MustNewConstMetric(secondsDesc, prometheus.CounterValue, stat.seconds, stat.datname, stat.fingerprint, stat.query)
+		ch <- prometheus.MustNewConstMetric(rowsDesc, prometheus.CounterValue, stat.rows, stat.datname, stat.fingerprint, stat.query)
+		ch <- prometheus.MustNewConstMetric(maxDesc, prometheus.GaugeValue, stat.windowMax, stat.datname, stat.fingerprint, stat.query)
+	}
+}
+
+var (
+	// sqlValueLists match a list of literals, e.g. the values of IN
+	sqlValueLists = regexp.MustCompile(`\?(\s*,\s*\?)+`)
+	// sqlRowLists match a list of rows of literals, e.g. the rows of VALUES
+	sqlRowLists = regexp.MustCompile(`\(\?\)(\s*,\s*\(\?\))+`)
+)
+
+// normalizeStatement returns sql without its literals, comments and trailing semicolon: strings, numbers and parameters are replaced by ?,
+// lists of them by a single one, white spaces are collapsed and unquoted identifiers and keywords lower cased
+func normalizeStatement(sql string) string {
+	var b strings.Builder
+	space := false // a white space precedes the next token
+	write := func(token string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(token)
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			space = true
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(sql)
+			}
+			space = true
+		case c == '\'':
+			i = skipSQLQuoted(sql, i, false)
+			write("?")
+		case c == '"':
+			j := skipSQLQuoted(sql, i, false)
+			write(sql[i:j])
+			i = j
+		case c == '$':
+			j := i + 1
+			for j < len(sql) && isSQLIdentChar(sql[j]) && sql[j] != '$' {
+				j++
+			}
+			if j < len(sql) && sql[j] == '$' && (j == i+1 || !isSQLDigit(sql[i+1])) {
+				// dollar quoted string
+				tag := sql[i : j+1]
+				if end := strings.Index(sql[j+1:], tag); end >= 0 {
+					i = j + 1 + end + len(tag)
+				} else {
+					i = len(sql)
+				}
+				write("?")
+				continue
+			}
+			// parameter
+			j = i + 1
+			for j < len(sql) && isSQLDigit(sql[j]) {
+				j++
+			}
+			if j == i+1 {
+				write("$")
+			} else {
+				write("?")
+			}
+			i = j
+		case isSQLDigit(c) || c == '.' && i+1 < len(sql) && isSQLDigit(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && (isSQLDigit(sql[j]) || sql[j] == '.' || sql[j] == 'e' || sql[j] == 'E' ||
+				(sql[j] == '+' || sql[j] == '-') && (sql[j-1] == 'e' || sql[j-1] == 'E')) {
+				j++
+			}
+			write("?")
+			i = j
+		case isSQLIdentChar(c):
+			j := i + 1
+			for j < len(sql) && isSQLIdentChar(sql[j]) {
+				j++
+			}
+			// E'...', B'...', X'...' and N'...' strings
+			if j == i+1 && j < len(sql) && sql[j] == '\'' && strings.ContainsRune("eEbBxXnN", rune(c)) {
+				i = skipSQLQuoted(sql, j, c == 'e' || c == 'E')
+				write("?")
+				continue
+			}
+			write(strings.ToLower(sql[i:j]))
+			i = j
+		default:
+			write(sql[i : i+1])
+			i++
+		}
+	}
+	normalized := sqlValueLists.ReplaceAllString(strings.TrimSuffix(b.String(), ";"), "?")
+	return sqlRowLists.ReplaceAllString(normalized, "(?)")
+}
+
+// skipSQLQuoted returns the index after the quoted string or identifier starting at i. Doubled quotes are escaped
+// quotes, so are backslash escaped characters of escape strings
+func skipSQLQuoted(sql string, i int, escape bool) int {
+	quote := sql[i]
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case escape && sql[j] == '\\':
+			j++
+		case sql[j] == quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isSQLIdentChar returns whether c is part of an unquoted identifier, the bytes of multibyte characters are
+func isSQLIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isSQLDigit(c) || c == '_' || c == '$' || c >= 0x80
+}
+
+// statementFingerprint returns the fingerprint of a normalized sql
+func statementFingerprint(normalized string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalized))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// truncateStatement returns query truncated to statementHistoryQueryLength
+func truncateStatement(query string) string {
+	if len(query) <= statementHistoryQueryLength {
+		return query
+	}
+	return strings.ToValidUTF8(query[:statementHistoryQueryLength], "") + "..."
+}
@@ -0,0 +1,115 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_normalizeStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM t WHERE id = 42", "select * from t where id = ?"},
+		{"select *  from t\n where id=7;", "select * from t where id=?"},
+		{"SELECT name FROM t1 WHERE name = 'it''s' AND x = -1.5e3", "select name from t1 where name = ? and x = -?"},
+		{"SELECT 1 FROM t WHERE id IN (1, 2, 3)", "select ? from t where id in (?)"},
+		{"INSERT INTO t VALUES (1, 'a'), (2, 'b')", "insert into t values (?)"},
+		{`SELECT "Name" FROM "My Table" WHERE a = $1 -- comment`, `select "Name" from "My Table" where a = ?`},
+		{"SELECT /* hint */ E'a\\'b', $$x'y$$, $tag$z$tag$ FROM t", "select ? from t"},
+		{"SELECT x$1 FROM t", "select x$1 from t"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, normalizeStatement(tt.sql), tt.sql)
+	}
+	assert.Equal(t, statementFingerprint(normalizeStatement("SELECT 1 FROM t WHERE id = 1")),
+		statementFingerprint(normalizeStatement("select 1 from t where id = 20")))
+	assert.Len(t, truncateStatement(strings.Repeat("x", 2*statementHistoryQueryLength)), statementHistoryQueryLength+3)
+}
+
+func Test_statementHistoryCollector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewStatementHistoryCollector(1).(*statementHistoryCollector)
+	info := ServerInfo{Server: "localhost:5432", Namespace: "og", Master: true, Version: semver.MustParse("3.0.0")}
+	assert.True(t, c.Enabled(info))
+	assert.False(t, c.Enabled(ServerInfo{Master: true, Version: semver.MustParse("1.1.0")}))
+
+	start := time.Unix(1600000000, 0)
+	mock.ExpectQuery("FROM pg_class").WillReturnRows(sqlmock.NewRows([]string{"relation"}).AddRow("dbe_perf.statement_history"))
+	mock.ExpectQuery("SELECT now()").WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(start))
+	ch := make(chan prometheus.Metric, 10)
+	assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+	assert.Len(t, ch, 0, "the statements finished before the first scrape are not counted")
+
+	collect := func(limit int, rows *sqlmock.Rows) map[string]float64 {
+		mock.ExpectQuery("FROM dbe_perf.statement_history WHERE finish_time >= \\$1 ORDER BY finish_time LIMIT \\$2").
+			WithArgs(c.states["localhost:5432"].mark, limit).WillReturnRows(rows)
+		ch := make(chan prometheus.Metric, 10)
+		assert.NoError(t, c.Collect(context.Background(), db, info, ch))
+		close(ch)
+		values := make(map[string]float64)
+		for metric := range ch {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			name := strings.Split(strings.TrimPrefix(metric.Desc().String(), `Desc{fqName: "og_statement_history_`), `"`)[0]
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "query" {
+					name += " " + label.GetValue()
+				}
+			}
+			values[name] = m.GetCounter().GetValue() + m.GetGauge().GetValue()
+		}
+		return values
+	}
+	columns := []string{"db_name", "query", "finish_time", "seconds", "rows"}
+	values := collect(statementHistoryRowLimit, sqlmock.NewRows(columns).
+		AddRow("postgres", "SELECT * FROM t WHERE id = 1", start.Add(time.Second), 2.0, 1.0).
+		AddRow("postgres", "SELECT * FROM t WHERE id = 2", start.Add(2*time.Second), 3.0, 1.0).
+		AddRow("postgres", "SELECT 1", start.Add(3*time.Second), 0.5, 1.0))
+	assert.Equal(t, map[string]float64{
+		"calls_total select * from t where id = ?":   2,
+		"seconds_total select * from t where id = ?": 5,
+		"rows_total select * from t where id = ?":    2,
+		"max_seconds select * from t where id = ?":   3,
+	}, values)
+	assert.Equal(t, start.Add(3*time.Second), c.states["localhost:5432"].mark)
+
+	// the top fingerprint of the next scrape, the counters are accumulated since the first statement. The statement
+	// finished at the mark is read again, not counted twice
+	values = collect(statementHistoryRowLimit+1, sqlmock.NewRows(columns).
+		AddRow("postgres", "SELECT 1", start.Add(3*time.Second), 0.5, 1.0).
+		AddRow("postgres", "SELECT 2", start.Add(4*time.Second), 1.0, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0))
+	assert.Equal(t, 4.0, values["calls_total select ?"])
+	assert.Equal(t, 1.0, values["max_seconds select ?"])
+
+	// the statements finished at the mark beyond the row limit or after the scrape are read by the next one
+	values = collect(statementHistoryRowLimit+2, sqlmock.NewRows(columns).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0))
+	assert.Equal(t, 5.0, values["calls_total select ?"])
+	assert.Equal(t, map[string]int{statementIdentity("postgres", "SELECT 3", 0.25): 3}, c.states["localhost:5432"].atMark)
+
+	// nothing new
+	values = collect(statementHistoryRowLimit+3, sqlmock.NewRows(columns).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0).
+		AddRow("postgres", "SELECT 3", start.Add(5*time.Second), 0.25, 1.0))
+	assert.Empty(t, values)
+	assert.Equal(t, start.Add(5*time.Second), c.states["localhost:5432"].mark)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
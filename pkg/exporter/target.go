@@ -0,0 +1,320 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+)
+
+const (
+	// targetNamespaceKey is the dsn setting prefixing every metric family of the target with a namespace
+	targetNamespaceKey = "exporter_namespace"
+	// targetTenantKey is the dsn setting adding the tenant label to every metric family of the target
+	targetTenantKey = "exporter_tenant"
+	tenantLabelName = "tenant"
+	// configTargetsKey is the top level key of a config file listing targets, it is not a query
+	configTargetsKey = "targets"
+	// roles of a server, queries with a role only run on servers of this role
+	rolePrimary = "primary"
+	roleStandby = "standby"
+)
+
+// ServerRoles valid role values
+var ServerRoles = map[string]bool{
+	"":          true,
+	rolePrimary: true,
+	roleStandby: true,
+}
+
+// exporterSettings are the dsn settings read by the exporter, they are removed from the dsn of the connections
+var exporterSettings = []string{targetNamespaceKey, targetTenantKey}
+
+// parseTarget returns the namespace and the tenant of the target of dsn, empty if not set
+func parseTarget(dsn string) (namespace, tenant string, err error) {
+	settings, err := parseDsn(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	namespace, tenant = settings[targetNamespaceKey], settings[targetTenantKey]
+	if namespace != "" && !model.LabelName(namespace).IsValid() {
+		return "", "", fmt.Errorf("invalid %s %q, must match %s", targetNamespaceKey, namespace, model.LabelNameRE)
+	}
+	return namespace, tenant, nil
+}
+
+// removeExporterSettings delete the settings read by the exporter from settings, returns whether any was set
+func removeExporterSettings(settings map[string]string) bool {
+	var found bool
+	for _, key := range exporterSettings {
+		if _, ok := settings[key]; ok {
+			delete(settings, key)
+			found = true
+		}
+	}
+	return found
+}
+
+// ConnectionDSN returns dsn without the settings read by the exporter, as the driver accepts it
+func ConnectionDSN(dsn string) (string, error) {
+	settings, err := parseDsn(dsn)
+	if err != nil {
+		return "", err
+	}
+	if !removeExporterSettings(settings) {
+		return dsn, nil
+	}
+	return genDSNString(settings), nil
+}
+
+// collectTarget run collect, the metrics it sends are prefixed with the namespace of the target
+// and labelled with its tenant before being sent to ch
+func (s *Server) collectTarget(ch chan<- prometheus.Metric, collect func(chan<- prometheus.Metric) error) error {
+	if s.targetNamespace == "" && s.tenant == "" {
+		return collect(ch)
+	}
+	var labels prometheus.Labels
+	if s.tenant != "" {
+		labels = prometheus.Labels{tenantLabelName: s.tenant}
+	}
+	var prefix string
+	if s.targetNamespace != "" {
+		prefix = s.targetNamespace + "_"
+	}
+	metrics := make(chan prometheus.Metric)
+	var r collectorRegisterer
+	prometheus.WrapRegistererWith(labels, prometheus.WrapRegistererWithPrefix(prefix, &r)).MustRegister(channelCollector(metrics))
+
+	done := make(chan struct{})
+	go func() {
+		r.wrapped.Collect(ch)
+		close(done)
+	}()
+	// collect may panic, the metrics are forwarded until then
+	defer func() {
+		close(metrics)
+		<-done
+	}()
+	return collect(metrics)
+}
+
+// channelCollector collect the metrics received on the channel until it is closed
+type channelCollector <-chan prometheus.Metric
+
+// Describe implement prometheus.Collector, the metrics are unchecked
+func (c channelCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implement prometheus.Collector
+func (c channelCollector) Collect(ch chan<- prometheus.Metric) {
+	for m := range c {
+		ch <- m
+	}
+}
+
+// collectorRegisterer keep the wrapping of the registered collector by prometheus.WrapRegistererWith,
+// to rename and label metrics the way a wrapped registry does
+type collectorRegisterer struct {
+	wrapped prometheus.Collector
+}
+
+func (r *collectorRegisterer) Register(c prometheus.Collector) error {
+	r.wrapped = c
+	return nil
+}
+
+func (r *collectorRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		_ = r.Register(c)
+	}
+}
+
+func (r *collectorRegisterer) Unregister(prometheus.Collector) bool {
+	return false
+}
+
+// Target is a target defined in the config, scraped in addition to the dsn given by flags or environment
+type Target struct {
+	DSN                string            `yaml:"dsn"`                           // dsn of the target, exporter_* settings allowed
+	Alias              string            `yaml:"alias,omitempty"`               // server label of the target, instead of host:port
+	Labels             map[string]string `yaml:"labels,omitempty"`              // constant labels of the metrics of the target
+	Tags               []string          `yaml:"tags,omitempty"`                // tags of the target, instead of the exporter's tags
+	ExcludedDatabases  []string          `yaml:"excluded_databases,omitempty"`  // instead of the exporter's excluded databases
+	TTLMultiplier      float64           `yaml:"ttl_multiplier,omitempty"`      // multiply the ttl of the queries, e.g. 4 for a lightly loaded replica
+	DisabledCollectors []string          `yaml:"disabled_collectors,omitempty"` // queries and collectors not run on the target
+	Role               string            `yaml:"role,omitempty"`                // primary or standby, instead of the detected role
+}
+
+// configTargets are the targets of a config file
+type configTargets struct {
+	Targets []*Target `yaml:"targets"`
+}
+
+// Check returns the field of the target with an error
+func (t *Target) Check() (string, error) {
+	if t.DSN == "" {
+		return "dsn", fmt.Errorf("dsn is required")
+	}
+	if _, _, err := parseTarget(t.DSN); err != nil {
+		return "dsn", fmt.Errorf("invalid dsn %s: %s", ShadowDSN(t.DSN), RedactText(err.Error()))
+	}
+	if t.TTLMultiplier < 0 {
+		return "ttl_multiplier", fmt.Errorf("ttl_multiplier must not be negative")
+	}
+	t.Role = strings.ToLower(t.Role)
+	if !ServerRoles[t.Role] {
+		return "role", fmt.Errorf("unsupported role: %s", t.Role)
+	}
+	for name := range t.Labels {
+		if name == serverLabelName {
+			return "labels", fmt.Errorf("label %s is set by alias", serverLabelName)
+		}
+		if !model.LabelName(name).IsValid() {
+			return "labels", fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return "", nil
+}
+
+// serverOpts returns the options of the servers of the target, applied after the exporter's ones
+func (t *Target) serverOpts() []ServerOpt {
+	var opts []ServerOpt
+	labels := make(prometheus.Labels, len(t.Labels)+1)
+	for name, value := range t.Labels {
+		labels[name] = value
+	}
+	if t.Alias != "" {
+		labels[serverLabelName] = t.Alias
+	}
+	if len(labels) > 0 {
+		opts = append(opts, ServerWithLabels(labels))
+	}
+	if t.Tags != nil {
+		opts = append(opts, ServerWithTags(t.Tags))
+	}
+	if t.ExcludedDatabases != nil {
+		opts = append(opts, ServerWithExcludeDatabases(t.ExcludedDatabases))
+	}
+	if t.TTLMultiplier > 0 {
+		opts = append(opts, ServerWithTTLMultiplier(t.TTLMultiplier))
+	}
+	if len(t.DisabledCollectors) > 0 {
+		opts = append(opts, ServerWithDisabledCollectors(t.DisabledCollectors))
+	}
+	if t.Role != "" {
+		opts = append(opts, ServerWithRole(t.Role))
+	}
+	return opts
+}
+
+// ServerWithTTLMultiplier multiply the ttl of the queries run on the server, 0 keeps them
+func ServerWithTTLMultiplier(multiplier float64) ServerOpt {
+	return func(s *Server) {
+		s.ttlMultiplier = multiplier
+	}
+}
+
+// ServerWithDisabledCollectors set the queries and collectors not run on the server, by name
+func ServerWithDisabledCollectors(names []string) ServerOpt {
+	return func(s *Server) {
+		s.disabledCollectors = names
+	}
+}
+
+// ServerWithRole set the role of the server instead of detecting it, primary or standby
+func ServerWithRole(role string) ServerOpt {
+	return func(s *Server) {
+		s.role = role
+	}
+}
+
+// queryTTL returns the ttl of the query on the server, in seconds
+func (s *Server) queryTTL(queryInstance *QueryInstance) float64 {
+	if s.ttlMultiplier > 0 {
+		return queryInstance.TTL * s.ttlMultiplier
+	}
+	return queryInstance.TTL
+}
+
+// serverRole returns the role of the server, the detected one unless set
+func (s *Server) serverRole() string {
+	switch {
+	case s.role != "":
+		return s.role
+	case s.inRecovery:
+		return roleStandby
+	}
+	return rolePrimary
+}
+
+// targetSkipped returns whether the query is not run on the server, disabled or of another role
+func (s *Server) targetSkipped(metric string, queryInstance *QueryInstance) bool {
+	if Contains(s.disabledCollectors, metric) {
+		return true
+	}
+	return queryInstance.Role != "" && queryInstance.Role != s.serverRole()
+}
+
+// ParseTargets returns the targets of config content, the errors are located by path and line
+func ParseTargets(content []byte, path string) ([]*Target, error) {
+	var t configTargets
+	if err := yaml.Unmarshal(content, &t); err != nil {
+		return nil, yamlErrors(path, err)
+	}
+	var errs ConfigErrors
+	seen := make(map[string]int, len(t.Targets))
+	for i, target := range t.Targets {
+		if field, err := target.Check(); err != nil {
+			errs.add("", "", fmt.Sprintf("%s[%d].%s", configTargetsKey, i, field), err)
+			continue
+		}
+		if first, ok := seen[target.DSN]; ok {
+			errs.add("", "", fmt.Sprintf("%s[%d].dsn", configTargetsKey, i), fmt.Errorf("dsn of targets[%d] repeated", first))
+			continue
+		}
+		seen[target.DSN] = i
+	}
+	if len(errs) > 0 {
+		errs.locate(path, content)
+		return nil, errs
+	}
+	return t.Targets, nil
+}
+
+// LoadTargets returns the targets of the config file, or of the config files of the dir, in alphabetic order
+func LoadTargets(configPath string) ([]*Target, error) {
+	stat, err := os.Stat(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config path: %s: %w", configPath, err)
+	}
+	if !stat.IsDir() {
+		content, err := ReadSecretFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("fail reading config file %s: %w", configPath, err)
+		}
+		return ParseTargets(content, configPath)
+	}
+	files, err := configFiles(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var targets []*Target
+	dsns := make(map[string]string)
+	for _, file := range files {
+		fileTargets, err := LoadTargets(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range fileTargets {
+			if other, ok := dsns[target.DSN]; ok {
+				return nil, fmt.Errorf("%s: target %s already defined in %s", file, ShadowDSN(target.DSN), other)
+			}
+			dsns[target.DSN] = file
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
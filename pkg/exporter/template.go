@@ -0,0 +1,62 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+)
+
+// configTemplatesKey is the top level key of a config file defining column templates, it is not a query
+const configTemplatesKey = "templates"
+
+// configTemplates are the column blocks defined once in a config file, e.g. common label columns,
+// and referenced from the metrics of several queries by {template: name}
+type configTemplates struct {
+	Templates map[string][]*Column `yaml:"templates"`
+}
+
+// parseTemplates returns the column templates of config content
+func parseTemplates(content []byte) (map[string][]*Column, error) {
+	var t configTemplates
+	if err := yaml.Unmarshal(content, &t); err != nil {
+		return nil, err
+	}
+	var errs ConfigErrors
+	for name, columns := range t.Templates {
+		for _, column := range columns {
+			if column.Template != "" {
+				errs.add("", "", configTemplatesKey, fmt.Errorf("template %s: nested template %s", name, column.Template))
+			}
+		}
+	}
+	return t.Templates, errs.err()
+}
+
+// expandTemplates replace the template references in the metrics of query by copies of the template columns
+func expandTemplates(query *QueryInstance, templates map[string][]*Column) error {
+	var errs ConfigErrors
+	metrics := make([]*Column, 0, len(query.Metrics))
+	for _, column := range query.Metrics {
+		if column.Template == "" {
+			metrics = append(metrics, column)
+			continue
+		}
+		if column.Name != "" {
+			errs.add(query.Name, column.Name, "template",
+				fmt.Errorf("template %s referenced, a template reference has no other field", column.Template))
+			continue
+		}
+		columns, ok := templates[column.Template]
+		if !ok {
+			errs.add(query.Name, "", "metrics", fmt.Errorf("undefined template %s", column.Template))
+			continue
+		}
+		for _, c := range columns {
+			copied := *c
+			metrics = append(metrics, &copied)
+		}
+	}
+	query.Metrics = metrics
+	return errs.err()
+}
@@ -0,0 +1,90 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// tiers of a query
+const (
+	tierFull     = ""         // run by the full scrapes only (default)
+	TierCritical = "critical" // cheap and high-priority, run by the critical scrapes too
+)
+
+// QueryTiers valid tier values
+var QueryTiers = map[string]bool{
+	tierFull:     true,
+	TierCritical: true,
+}
+
+// QuerySelector selects the queries run by a partial scrape
+type QuerySelector func(q *QueryInstance) bool
+
+// CriticalQueries selects the queries of the critical tier
+func CriticalQueries(q *QueryInstance) bool {
+	return q.Tier == TierCritical
+}
+
+// groupNameRegex matches the names of the groups, a segment of the path serving them
+var groupNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// reservedGroups are the names of the tiers, served on the same paths as the groups
+var reservedGroups = map[string]bool{
+	"full":       true,
+	TierCritical: true,
+}
+
+// checkGroup returns an error if group can not name a group of queries
+func checkGroup(group string) error {
+	switch {
+	case !groupNameRegex.MatchString(group):
+		return fmt.Errorf("invalid group %s, lower case letters, digits, _ and - only", group)
+	case reservedGroups[group]:
+		return fmt.Errorf("group %s is reserved for a tier", group)
+	}
+	return nil
+}
+
+// GroupQueries selects the queries of group
+func GroupQueries(group string) QuerySelector {
+	return func(q *QueryInstance) bool {
+		return Contains(q.Groups, group)
+	}
+}
+
+// QueryGroups returns the sorted groups of the enabled queries
+func (e *Exporter) QueryGroups() []string {
+	seen := make(map[string]bool)
+	var groups []string
+	for _, q := range e.metricMap {
+		if q.Status == statusDisable {
+			continue
+		}
+		for _, group := range q.Groups {
+			if !seen[group] {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+type querySelectorKey struct{}
+
+// WithQuerySelector returns a context whose scrapes only run the queries selected by sel, e.g. CriticalQueries.
+// Partial scrapes do not run the Go collectors and do not count toward the expiry of the state of the queries
+func WithQuerySelector(ctx context.Context, sel QuerySelector) context.Context {
+	return context.WithValue(ctx, querySelectorKey{}, sel)
+}
+
+// querySelector returns the selector of the scrape of ctx, nil for a full scrape
+func querySelector(ctx context.Context) QuerySelector {
+	sel, _ := ctx.Value(querySelectorKey{}).(QuerySelector)
+	return sel
+}
@@ -0,0 +1,70 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServer_ScrapeContext_critical(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	activity := &QueryInstance{
+		Name:    "pg_stat_activity",
+		Tier:    "Critical",
+		Queries: []*Query{{SQL: "SELECT activity"}},
+		Metrics: []*Column{{Name: "count", Usage: GAUGE}},
+	}
+	lock := &QueryInstance{
+		Name:    "pg_lock",
+		Queries: []*Query{{SQL: "SELECT lock"}},
+		Metrics: []*Column{{Name: "count", Usage: GAUGE}},
+	}
+	assert.NoError(t, activity.Check())
+	assert.NoError(t, lock.Check())
+	assert.True(t, CriticalQueries(activity))
+	assert.False(t, CriticalQueries(lock))
+	assert.Error(t, (&QueryInstance{Name: "q", Tier: "cheap"}).Check())
+
+	collector := &testCollector{name: "collector"}
+	s := &Server{
+		db:                  db,
+		labels:              prometheus.Labels{"server": "localhost:5432"},
+		disableCache:        true,
+		queryInstanceMap:    map[string]*QueryInstance{"pg_stat_activity": activity, "pg_lock": lock},
+		metricCache:         map[string]cachedMetrics{},
+		stats:               newQueryStats(),
+		collectors:          []Collector{collector},
+		separateSelfMetrics: true,
+	}
+	mock.ExpectQuery("SELECT activity").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	ch := make(chan prometheus.Metric, 10)
+	assert.NoError(t, s.ScrapeContext(WithQuerySelector(context.Background(), CriticalQueries), ch))
+	assert.Len(t, ch, 1)
+	assert.Equal(t, 0, collector.collects, "partial scrapes do not run the collectors")
+	assert.Equal(t, int64(0), s.currentScrape(), "partial scrapes do not count toward expiry")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExporter_QueryGroups(t *testing.T) {
+	lock := &QueryInstance{Name: "pg_lock", Groups: []string{"Locks"}}
+	waits := &QueryInstance{Name: "pg_thread_wait_status", Groups: []string{"locks", "waits"}}
+	disabled := &QueryInstance{Name: "og_sql_history", Groups: []string{"statements"}, Status: statusDisable}
+	for _, q := range []*QueryInstance{lock, waits, disabled} {
+		assert.NoError(t, q.Check())
+	}
+	e := &Exporter{metricMap: map[string]*QueryInstance{"pg_lock": lock, "pg_thread_wait_status": waits, "og_sql_history": disabled}}
+	assert.Equal(t, []string{"locks", "waits"}, e.QueryGroups())
+	assert.True(t, GroupQueries("locks")(lock))
+	assert.False(t, GroupQueries("waits")(lock))
+
+	assert.Error(t, (&QueryInstance{Name: "q", Groups: []string{"critical"}}).Check(), "reserved")
+	assert.Error(t, (&QueryInstance{Name: "q", Groups: []string{"a/b"}}).Check())
+}
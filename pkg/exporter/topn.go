@@ -0,0 +1,109 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"sort"
+)
+
+// topnOtherLabel is the value of the labels of the series aggregating the rows beyond the top N
+const topnOtherLabel = "__other__"
+
+// TopN keep the samples of the N rows with the highest value of a metric column, controlling the cardinality
+// of per-table or per-statement queries. With rollup, the other rows are summed into a single series
+type TopN struct {
+	By     string `yaml:"by"`               // metric column ranking the rows, highest first
+	N      int    `yaml:"n"`                // rows kept
+	Rollup bool   `yaml:"rollup,omitempty"` // aggregate the other rows into a series with __other__ labels
+}
+
+// Check returns the field of the top-N with an error, columns are the columns of the query
+func (t *TopN) Check(columns map[string]*Column) (string, error) {
+	col, ok := columns[t.By]
+	switch {
+	case t.By == "":
+		return "by", fmt.Errorf("by is required")
+	case !ok:
+		return "by", fmt.Errorf("undefined column %s", t.By)
+	case col.Usage == LABEL || col.Usage == DISCARD || col.Usage == HISTOGRAM || col.expression != nil || col.Extract != nil:
+		return "by", fmt.Errorf("column %s is not a metric column selected by the query", t.By)
+	case t.N <= 0:
+		return "n", fmt.Errorf("n must be positive")
+	}
+	return "", nil
+}
+
+// topnRow is the samples of a row of a top-N query and its rank
+type topnRow struct {
+	by      float64
+	metrics []prometheus.Metric
+}
+
+// apply returns the samples of the N rows with the highest rank, rows without rank come last.
+// With rollup, the samples of the other rows are summed by metric into a sample with otherLabels, NaN are ignored
+func (t *TopN) apply(rows []topnRow, otherLabels []string) []prometheus.Metric {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].by > rows[j].by || !math.IsNaN(rows[i].by) && math.IsNaN(rows[j].by)
+	})
+	metrics := make([]prometheus.Metric, 0)
+	for i := 0; i < len(rows) && i < t.N; i++ {
+		metrics = append(metrics, rows[i].metrics...)
+	}
+	if !t.Rollup || len(rows) <= t.N {
+		return metrics
+	}
+	type rollup struct {
+		valueType prometheus.ValueType
+		sum       float64
+	}
+	var descs []*prometheus.Desc
+	sums := make(map[*prometheus.Desc]*rollup)
+	for _, row := range rows[t.N:] {
+		for _, metric := range row.metrics {
+			value, valueType, ok := metricValue(metric)
+			if !ok {
+				continue
+			}
+			desc := metric.Desc()
+			r, ok := sums[desc]
+			if !ok {
+				r = &rollup{valueType: valueType}
+				sums[desc] = r
+				descs = append(descs, desc)
+			}
+			if !math.IsNaN(value) {
+				r.sum += value
+			}
+		}
+	}
+	for _, desc := range descs {
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, sums[desc].valueType, sums[desc].sum, otherLabels...))
+	}
+	return metrics
+}
+
+// metricValue returns the value and the type of a gauge, counter or untyped sample
+func metricValue(metric prometheus.Metric) (float64, prometheus.ValueType, bool) {
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		return 0, 0, false
+	}
+	return sampleValue(m)
+}
+
+// sampleValue returns the value and the type of a written gauge, counter or untyped sample
+func sampleValue(m *dto.Metric) (float64, prometheus.ValueType, bool) {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), prometheus.GaugeValue, true
+	case m.Counter != nil:
+		return m.Counter.GetValue(), prometheus.CounterValue, true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), prometheus.UntypedValue, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,132 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig_topn(t *testing.T) {
+	queries, err := ParseConfig([]byte(`pg_stat_user_tables:
+  query:
+  - sql: SELECT relname, seq_scan, n_live_tup FROM pg_stat_user_tables
+  topn:
+    by: n_live_tup
+    n: 20
+    rollup: true
+  metrics:
+  - name: relname
+    usage: LABEL
+  - name: seq_scan
+    usage: COUNTER
+  - name: n_live_tup
+    usage: GAUGE
+`), "tables.yaml")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &TopN{By: "n_live_tup", N: 20, Rollup: true}, queries["pg_stat_user_tables"].TopN)
+	}
+
+	_, err = ParseConfig([]byte(`pg_stat_user_tables:
+  query:
+  - sql: SELECT relname, seq_scan FROM pg_stat_user_tables
+  topn:
+    n: 20
+    by: relname
+  metrics:
+  - name: relname
+    usage: LABEL
+  - name: seq_scan
+    usage: COUNTER
+`), "tables.yaml")
+	assert.EqualError(t, err, "tables.yaml:6: query pg_stat_user_tables: topn.by: column relname is not a metric column selected by the query")
+
+	tests := []struct {
+		topn *TopN
+		want string
+	}{
+		{topn: &TopN{N: 20}, want: "by is required"},
+		{topn: &TopN{By: "missing", N: 20}, want: "undefined column missing"},
+		{topn: &TopN{By: "seq_scan"}, want: "n must be positive"},
+	}
+	for _, tt := range tests {
+		field, err := tt.topn.Check(map[string]*Column{"seq_scan": {Name: "seq_scan", Usage: COUNTER}})
+		assert.EqualError(t, err, tt.want)
+		assert.NotEmpty(t, field)
+	}
+}
+
+func Test_Server_queryMetric_topn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	q := &QueryInstance{
+		Name:    "pg_stat_user_tables",
+		Queries: []*Query{{SQL: "SELECT relname, seq_scan, n_live_tup FROM pg_stat_user_tables"}},
+		TopN:    &TopN{By: "n_live_tup", N: 2, Rollup: true},
+		Metrics: []*Column{
+			{Name: "relname", Usage: LABEL},
+			{Name: "seq_scan", Usage: COUNTER},
+			{Name: "n_live_tup", Usage: GAUGE},
+		},
+	}
+	assert.NoError(t, q.Check())
+	s := &Server{
+		db:     db,
+		labels: prometheus.Labels{"server": "localhost:5432"},
+		stats:  newQueryStats(),
+		descs:  newDescCache(),
+		deltas: newDeltaTracker(),
+	}
+	samples := func() []string {
+		metrics, errs, err := s.queryMetric("pg_stat_user_tables", q)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		var samples []string
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			var value float64
+			if m.Gauge != nil {
+				value = m.Gauge.GetValue()
+			} else {
+				value = m.Counter.GetValue()
+			}
+			name := strings.Split(metric.Desc().String(), `"`)[1]
+			samples = append(samples, name+"{"+m.Label[0].GetValue()+"} "+strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		sort.Strings(samples)
+		return samples
+	}
+
+	mock.ExpectQuery("SELECT relname").WillReturnRows(sqlmock.NewRows([]string{"relname", "seq_scan", "n_live_tup"}).
+		AddRow("small", 1, 10).AddRow("big", 2, 1000).AddRow("empty", 3, nil).AddRow("medium", 4, 100).AddRow("tiny", 5, 1))
+	assert.Equal(t, []string{
+		"pg_stat_user_tables_n_live_tup{__other__} 11",
+		"pg_stat_user_tables_n_live_tup{big} 1000",
+		"pg_stat_user_tables_n_live_tup{medium} 100",
+		"pg_stat_user_tables_seq_scan{__other__} 9",
+		"pg_stat_user_tables_seq_scan{big} 2",
+		"pg_stat_user_tables_seq_scan{medium} 4",
+	}, samples())
+
+	// without rollup, the other rows are dropped
+	q.TopN.Rollup = false
+	mock.ExpectQuery("SELECT relname").WillReturnRows(sqlmock.NewRows([]string{"relname", "seq_scan", "n_live_tup"}).
+		AddRow("small", 1, 10).AddRow("big", 2, 1000).AddRow("medium", 4, 100))
+	assert.Equal(t, []string{
+		"pg_stat_user_tables_n_live_tup{big} 1000",
+		"pg_stat_user_tables_n_live_tup{medium} 100",
+		"pg_stat_user_tables_seq_scan{big} 2",
+		"pg_stat_user_tables_seq_scan{medium} 4",
+	}, samples())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
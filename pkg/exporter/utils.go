@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	commonlog "github.com/prometheus/common/log"
 	"net/url"
 	"regexp"
 	"strings"
@@ -15,13 +15,20 @@ import (
 
 // ShadowDSN will hide password part of dsn
 func ShadowDSN(dsn string) string {
+	if !strings.Contains(dsn, "://") {
+		return RedactText(dsn)
+	}
 	pDSN, err := url.Parse(dsn)
 	if err != nil {
 		return ""
 	}
 	// Blank user info if not nil
 	if pDSN.User != nil {
-		pDSN.User = url.UserPassword(pDSN.User.Username(), "******")
+		pDSN.User = url.UserPassword(pDSN.User.Username(), redactedPassword)
+	}
+	if q := pDSN.Query(); q.Get("password") != "" {
+		q.Set("password", redactedPassword)
+		pDSN.RawQuery = q.Encode()
 	}
 	return pDSN.String()
 }
@@ -35,8 +42,18 @@ func Contains(a []string, x string) bool {
 	return false
 }
 
-// parseConstLabels turn param string into prometheus.Labels
-func parseConstLabels(s string) prometheus.Labels {
+// containsAll returns whether every element of x is in a
+func containsAll(a []string, x []string) bool {
+	for _, n := range x {
+		if !Contains(a, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseConstLabels turn param string into prometheus.Labels, the malformed labels are logged with logger
+func parseConstLabels(s string, logger commonlog.Logger) prometheus.Labels {
 	labels := make(prometheus.Labels)
 	s = strings.TrimSpace(s)
 	if len(s) == 0 {
@@ -47,7 +64,7 @@ func parseConstLabels(s string) prometheus.Labels {
 	for _, p := range parts {
 		keyValue := strings.Split(strings.TrimSpace(p), "=")
 		if len(keyValue) != 2 {
-			log.Errorf(`malformed labels format %q, should be "key=value"`, p)
+			logger.Errorf(`malformed labels format %q, should be "key=value"`, p)
 			continue
 		}
 		key := strings.TrimSpace(keyValue[0])
@@ -92,10 +109,12 @@ func parseVersionSem(versionString string) (semver.Version, error) {
 	return semver.Version{},
 		errors.New(fmt.Sprintln("Could not find a openGauss version in string:", versionString))
 }
+
+// var versionRegex = regexp.MustCompile(`^(\(\w+|\w+)\s+((\d+)(\.\d+)?(\.\d+)?)`)
+var versionRegex = regexp.MustCompile(`openGauss\s+((\d+)(\.\d+)?(\.\d+))`)
+
 func parseVersion(versionString string) string {
 	versionString = strings.TrimSpace(versionString)
-	// var versionRegex = regexp.MustCompile(`^(\(\w+|\w+)\s+((\d+)(\.\d+)?(\.\d+)?)`)
-	var versionRegex = regexp.MustCompile(`openGauss\s+((\d+)(\.\d+)?(\.\d+))`)
 	subMatches := versionRegex.FindStringSubmatch(versionString)
 	if len(subMatches) > 2 {
 		return subMatches[1]
@@ -3,8 +3,10 @@
 package exporter
 
 import (
+	"bytes"
 	"github.com/blang/semver"
 	"github.com/prometheus/client_golang/prometheus"
+	commonlog "github.com/prometheus/common/log"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
@@ -54,10 +56,18 @@ func Test_parseConstLabels(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseConstLabels(tt.args.s)
+			got := parseConstLabels(tt.args.s, log)
 			assert.Equal(t, tt.want, got)
 		})
 	}
+
+	// the malformed labels are logged with the logger of the exporter, whatever the order of the options
+	var logs bytes.Buffer
+	e, err := NewExporter(WithConstLabels("a=b, xyz"), WithLogger(commonlog.NewLogger(&logs)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, prometheus.Labels{"a": "b"}, e.constantLabels)
+		assert.Contains(t, logs.String(), `malformed labels format \" xyz\"`)
+	}
 }
 
 func TestShadowDSN(t *testing.T) {
@@ -95,7 +105,7 @@ func TestShadowDSN(t *testing.T) {
 			args: args{
 				dsn: "user=xxx password=xxx host=127.0.0.1 port=5432 dbname=postgres sslmode=disable",
 			},
-			want: "user=xxx%20password=xxx%20host=127.0.0.1%20port=5432%20dbname=postgres%20sslmode=disable",
+			want: "user=xxx password=****** host=127.0.0.1 port=5432 dbname=postgres sslmode=disable",
 		},
 		{
 			name: "localhost:1234",
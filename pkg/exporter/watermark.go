@@ -0,0 +1,164 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"sync"
+	"time"
+)
+
+// Watermark collect event-style sources incrementally, e.g. statement_history or audit records: the sql of the
+// query receives the highest value of column seen so far as $1 and only returns the rows above it.
+// The values of the COUNTER columns of these rows are added to counters kept by the exporter
+type Watermark struct {
+	Column  string `yaml:"column"`            // column of the rows ordering them, a timestamp or an id
+	Initial string `yaml:"initial,omitempty"` // $1 of the first execution, NULL if empty
+}
+
+// Check returns the field of the watermark with an error, columns are the columns of the query
+func (w *Watermark) Check(columns map[string]*Column) (string, error) {
+	if w.Column == "" {
+		return "column", fmt.Errorf("column is required")
+	}
+	if _, ok := columns[w.Column]; !ok {
+		return "column", fmt.Errorf("undefined column %s", w.Column)
+	}
+	for _, col := range columns {
+		if col.Usage != LABEL && col.Usage != DISCARD && col.Usage != COUNTER {
+			return "column", fmt.Errorf("column %s of a watermark query must be a COUNTER, LABEL or DISCARD column", col.Name)
+		}
+	}
+	return "", nil
+}
+
+// watermarkSeries is a counter of a watermark query
+type watermarkSeries struct {
+	column string
+	labels []string
+	value  float64
+}
+
+// watermarkState is the collection state of a watermark query
+type watermarkState struct {
+	mark    interface{}                 // highest value of the watermark column, or the initial value
+	scanned bool                        // mark was scanned from a row, not the initial value
+	series  map[string]*watermarkSeries // counters by deltaSeriesKey
+	seen    int64                       // scrape of the last update
+}
+
+// next returns a copy of the state, updated by an execution. nil state is the state before the first execution
+func (state *watermarkState) next(initial string) *watermarkState {
+	next := &watermarkState{series: make(map[string]*watermarkSeries)}
+	if state == nil {
+		if initial != "" {
+			next.mark = initial
+		}
+		return next
+	}
+	next.mark, next.scanned = state.mark, state.scanned
+	for key, series := range state.series {
+		s := *series
+		next.series[key] = &s
+	}
+	return next
+}
+
+// add increase the counter of column and labels by value, NaN are ignored
+func (state *watermarkState) add(column string, labels []string, value float64) {
+	key := deltaSeriesKey(column, labels)
+	series, ok := state.series[key]
+	if !ok {
+		series = &watermarkSeries{column: column, labels: append([]string{}, labels...)}
+		state.series[key] = series
+	}
+	if !math.IsNaN(value) {
+		series.value += value
+	}
+}
+
+// advance raise the mark to the scanned value of the watermark column, if higher.
+// The rows are above the initial value, it is replaced by the first one
+func (state *watermarkState) advance(data interface{}) {
+	switch v := data.(type) {
+	case nil:
+		return
+	case []byte:
+		data = string(v)
+	}
+	if !state.scanned || watermarkLess(state.mark, data) {
+		state.mark, state.scanned = data, true
+	}
+}
+
+// watermarkLess compare the values of a watermark column: times, numbers, else their text
+func watermarkLess(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Before(tb)
+		}
+	}
+	if fa, ok := dbToFloat64(a); ok {
+		if fb, ok := dbToFloat64(b); ok {
+			return fa < fb
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// metrics returns the counters of the state, desc returns the desc of a column
+func (state *watermarkState) metrics(desc func(column string) *prometheus.Desc) []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(state.series))
+	for _, series := range state.series {
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc(series.column), prometheus.CounterValue, series.value, series.labels...))
+	}
+	return metrics
+}
+
+// watermarkTracker hold the state of the watermark queries, by deltaQueryKey.
+// Queries not executed any more are dropped by expire
+type watermarkTracker struct {
+	m      sync.Mutex
+	states map[string]*watermarkState
+}
+
+func newWatermarkTracker() *watermarkTracker {
+	return &watermarkTracker{states: make(map[string]*watermarkState)}
+}
+
+// state returns the state of the query, nil before the first execution. The state must not be modified
+func (t *watermarkTracker) state(queryKey string) *watermarkState {
+	if t == nil {
+		return nil
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.states[queryKey]
+}
+
+// update replace the state of the query with the one of the current execution of scrape
+func (t *watermarkTracker) update(queryKey string, state *watermarkState, scrape int64) {
+	if t == nil {
+		return
+	}
+	state.seen = scrape
+	t.m.Lock()
+	t.states[queryKey] = state
+	t.m.Unlock()
+}
+
+// expire drop the state of queries whose last update is expired
+func (t *watermarkTracker) expire(expired func(seen int64) bool) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for queryKey, state := range t.states {
+		if expired(state.seen) {
+			delete(t.states, queryKey)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © 2021 Bin Liu <bin.liu@enmotech.com>
+
+package exporter
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseConfig_watermark(t *testing.T) {
+	queries, err := ParseConfig([]byte(`og_statement_history:
+  query:
+  - sql: SELECT unique_query_id, db_name, finish_time, 1 AS executions FROM statement_history WHERE finish_time > coalesce($1::timestamptz, now())
+  watermark:
+    column: finish_time
+  metrics:
+  - name: unique_query_id
+    usage: DISCARD
+  - name: db_name
+    usage: LABEL
+  - name: finish_time
+    usage: DISCARD
+  - name: executions
+    usage: COUNTER
+`), "history.yaml")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Watermark{Column: "finish_time"}, queries["og_statement_history"].Watermark)
+	}
+
+	_, err = ParseConfig([]byte(`og_statement_history:
+  query:
+  - sql: SELECT db_name, finish_time, n_returned_rows FROM statement_history WHERE finish_time > $1
+  watermark:
+    column: finish_time
+  metrics:
+  - name: db_name
+    usage: LABEL
+  - name: finish_time
+    usage: DISCARD
+  - name: n_returned_rows
+    usage: GAUGE
+`), "history.yaml")
+	assert.EqualError(t, err, "history.yaml:5: query og_statement_history: watermark.column: column n_returned_rows of a watermark query must be a COUNTER, LABEL or DISCARD column")
+
+	field, err := (&Watermark{Column: "missing"}).Check(map[string]*Column{})
+	assert.Equal(t, "column", field)
+	assert.EqualError(t, err, "undefined column missing")
+}
+
+func Test_watermarkLess(t *testing.T) {
+	now := time.Now()
+	assert.True(t, watermarkLess(now, now.Add(time.Millisecond)))
+	assert.True(t, watermarkLess(int64(9), int64(10)))
+	assert.True(t, watermarkLess("9", "10"))
+	assert.True(t, watermarkLess("a", "b"))
+	assert.False(t, watermarkLess(int64(10), int64(10)))
+}
+
+func Test_Server_queryMetric_watermark(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	q := &QueryInstance{
+		Name:      "og_audit",
+		Queries:   []*Query{{SQL: "SELECT type, id, 1 AS events FROM pg_query_audit WHERE id > coalesce($1, 0)"}},
+		Watermark: &Watermark{Column: "id", Initial: "100"},
+		Metrics: []*Column{
+			{Name: "type", Usage: LABEL},
+			{Name: "id", Usage: DISCARD},
+			{Name: "events", Usage: COUNTER},
+		},
+	}
+	assert.NoError(t, q.Check())
+	s := &Server{
+		db:         db,
+		labels:     prometheus.Labels{"server": "localhost:5432"},
+		stats:      newQueryStats(),
+		descs:      newDescCache(),
+		deltas:     newDeltaTracker(),
+		watermarks: newWatermarkTracker(),
+	}
+	samples := func() []string {
+		metrics, errs, err := s.queryMetric("og_audit", q)
+		assert.NoError(t, err)
+		assert.Empty(t, errs)
+		var samples []string
+		for _, metric := range metrics {
+			m := &dto.Metric{}
+			_ = metric.Write(m)
+			for _, label := range m.Label {
+				if label.GetName() == "type" {
+					samples = append(samples, label.GetValue()+" "+strconv.FormatFloat(m.GetCounter().GetValue(), 'g', -1, 64))
+				}
+			}
+		}
+		sort.Strings(samples)
+		return samples
+	}
+	columns := []string{"type", "id", "events"}
+
+	mock.ExpectQuery("SELECT type").WithArgs("100").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("login_success", 101, 1).AddRow("login_failed", 103, 1).AddRow("login_success", 102, 1))
+	assert.Equal(t, []string{"login_failed 1", "login_success 2"}, samples())
+
+	// only the rows above the highest id are fetched, the counters are kept
+	mock.ExpectQuery("SELECT type").WithArgs(int64(103)).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("login_success", 104, 1))
+	assert.Equal(t, []string{"login_failed 1", "login_success 3"}, samples())
+
+	mock.ExpectQuery("SELECT type").WithArgs(int64(104)).WillReturnRows(sqlmock.NewRows(columns))
+	assert.Equal(t, []string{"login_failed 1", "login_success 3"}, samples())
+
+	// a result without the watermark column fails the query and keeps the state
+	mock.ExpectQuery("SELECT type").WithArgs(int64(104)).WillReturnRows(sqlmock.NewRows([]string{"type", "events"}).AddRow("login_success", 1))
+	_, _, err = s.queryMetric("og_audit", q)
+	assert.EqualError(t, err, "query og_audit does not return the watermark column id")
+	mock.ExpectQuery("SELECT type").WithArgs(int64(104)).WillReturnRows(sqlmock.NewRows(columns))
+	assert.Equal(t, []string{"login_failed 1", "login_success 3"}, samples())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}